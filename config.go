@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/robsonalvesdevbr/webpconvert/converter"
+)
+
+// runConfig implements "webpconvert config print [convert flags] [dir]"
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			printConfigUsage(os.Stdout)
+			return exitOK
+		}
+		printConfigUsage(os.Stderr)
+		return exitUsage
+	}
+	return convertCommand("config print", args[1:], modePrint)
+}
+
+// printConfigUsage writes the help of the config command
+func printConfigUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: webpconvert config print [convert flags] [dir]\n\n")
+	fmt.Fprintf(w, "Prints the settings a conversion of dir would use, merged in order from\n")
+	fmt.Fprintf(w, "the user configuration (webpconvert/config.json or config.toml under\n")
+	fmt.Fprintf(w, "$XDG_CONFIG_HOME), the webpconvert.json or webpconvert.toml files of dir\n")
+	fmt.Fprintf(w, "and its parents, the -profile section of each file and the flags.\n")
+	fmt.Fprintf(w, "Files in subdirectories are merged during the conversion.\n")
+}
+
+// printedConfig is the output of "config print"
+type printedConfig struct {
+	Dir      string             `json:"dir"`
+	Profile  string             `json:"profile,omitempty"`
+	Files    []string           `json:"files"`
+	Settings converter.Settings `json:"settings"`
+}
+
+// printSettings writes the merged settings of a conversion of dir as JSON
+func printSettings(w io.Writer, dir string, files []string, options converter.ProcessOptions) int {
+	if files == nil {
+		files = []string{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(printedConfig{
+		Dir:      dir,
+		Profile:  options.Profile,
+		Files:    files,
+		Settings: options.Settings(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/converter"
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// runConvert implements "webpconvert convert [flags] [dir]", also used when no subcommand is given
+func runConvert(args []string) int {
+	return convertCommand("convert", args, modeConvert)
+}
+
+// commandMode selects what convertCommand does with the conversion options
+type commandMode int
+
+const (
+	modeConvert commandMode = iota // Convert the directory once
+	modePrint                      // Print the options ("config print")
+	modeWatch                      // Convert files as they appear ("watch")
+)
+
+// convertCommand parses the convert flags and configuration files into the
+// conversion options, then runs mode with them
+func convertCommand(name string, args []string, mode commandMode) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	// Define command line flags
+	dirPtr := fs.String("dir", ".", "Directory to process (default: current directory)")
+	qualityPtr := fs.Int("quality", 100, "JPEG quality for static WebP (1-100, default: 100)")
+	formatPtr := fs.String("format", "", "Output format for every file: "+strings.Join(native.EncoderNames(), ", ")+" (default: gif for animated, jpeg for static; animations keep the default if the format has no animation)")
+	autoFormatPtr := fs.Bool("auto-format", false, "Route files by the built-in rules: lossless or transparent to PNG, photos to JPEG, short animations to GIF, long ones or those of over 256 colors to APNG")
+	analyzePtr := fs.Bool("analyze", true, "Decode files to classify them by content: animations that never change become static images and unused alpha is ignored (-analyze=false: headers only)")
+	var ruleSpecs repeatedFlag
+	fs.Var(&ruleSpecs, "rule", "Output format rule conditions=format, e.g. 'static,alpha=png' or 'animated,duration>10s=apng' (repeatable, first match wins)")
+	workersPtr := fs.Int("workers", runtime.NumCPU(), "Number of parallel workers (default: CPU count)")
+	keepOriginalPtr := fs.Bool("keep-original", false, "Keep original WebP files after conversion (default: false)")
+	failFastPtr := fs.Bool("fail-fast", false, "Stop converting after the first failed file")
+	isolatePtr := fs.Bool("isolate", false, "Convert each file in a child process, so a crash in a native library only fails that file")
+	isolateMemoryPtr := fs.String("isolate-memory", "", "Address-space limit of each -isolate child process, e.g. 2G (default: none)")
+	timeoutPtr := fs.Duration("timeout", 0, "Maximum conversion time per file, e.g. 30s (default: none)")
+	maxMemoryPtr := fs.String("max-memory", "", "Estimated memory budget for files converting at once, e.g. 2G (default: unlimited)")
+
+	// Decoding limits for untrusted input (-1: unlimited)
+	maxPixelsPtr := fs.Int64("max-pixels", native.DefaultLimits.MaxCanvasPixels, "Maximum canvas pixels (width × height), -1 for unlimited")
+	maxFramesPtr := fs.Int("max-frames", native.DefaultLimits.MaxFrames, "Maximum animation frames, -1 for unlimited")
+	maxTotalPixelsPtr := fs.Int64("max-total-pixels", native.DefaultLimits.MaxTotalPixels, "Maximum decoded pixels over all frames, -1 for unlimited")
+	maxInputSizePtr := fs.String("max-input-size", "", fmt.Sprintf("Maximum input file size, e.g. 1G, -1 for unlimited (default: %dM)", native.DefaultLimits.MaxInputBytes>>20))
+	reportPtr := fs.String("report", "", "Write a machine-readable report of every file and the batch summary to this path")
+	reportFormatPtr := fs.String("report-format", "", "Report format: json, ndjson or csv (default: from the report file extension, else json)")
+	outputPtr := fs.String("output", "text", "Progress output: text, bar (progress bar with ETA on a terminal) or none (json is a deprecated alias of -output none -log-format json -log-level info on stdout)")
+	logFlags := addLogFlags(fs)
+	versionPtr := fs.Bool("version", false, "Print version and exit")
+
+	// Directory walk filters
+	var includePatterns, excludePatterns, skipDirs stringListFlag
+	fs.Var(&includePatterns, "include", "Glob pattern files must match (repeatable, comma-separated)")
+	fs.Var(&excludePatterns, "exclude", "Glob pattern for files and directories to skip (repeatable, comma-separated)")
+	fs.Var(&skipDirs, "skip-dir", "Directory name to skip, e.g. node_modules (repeatable, comma-separated)")
+	maxDepthPtr := fs.Int("max-depth", 0, "Maximum directory depth, 1 = only the given directory (default: unlimited)")
+	minSizePtr := fs.String("min-size", "", "Skip files smaller than this size, e.g. 10K")
+	maxSizePtr := fs.String("max-size", "", "Skip files larger than this size, e.g. 50M")
+	modifiedAfterPtr := fs.String("modified-after", "", "Only files modified after this time (RFC 3339, YYYY-MM-DD or duration like 24h)")
+	modifiedBeforePtr := fs.String("modified-before", "", "Only files modified before this time (RFC 3339, YYYY-MM-DD or duration like 24h)")
+	followSymlinksPtr := fs.Bool("follow-symlinks", false, "Follow symbolic links (loops are detected and skipped)")
+	skipHiddenPtr := fs.Bool("skip-hidden", false, "Skip hidden files and directories")
+	detectPtr := fs.String("detect", "extension", "How to recognize WebP files: extension or content (sniff every file)")
+	noIgnoreFilesPtr := fs.Bool("no-ignore-files", false, "Do not honor "+converter.IgnoreFileName+" files")
+
+	// Configuration files
+	profilePtr := fs.String("profile", "", "Apply this profile section of the configuration files, e.g. web or archive")
+	noConfigPtr := fs.Bool("no-config", false, "Ignore configuration files ("+strings.Join(converter.ConfigFileNames, ", ")+" and the user configuration)")
+
+	// Watch mode
+	var watchOptions converter.WatchOptions
+	if mode == modeWatch {
+		fs.DurationVar(&watchOptions.Settle, "settle", converter.DefaultSettle, "Time a file must go unchanged after its last write before it is converted")
+		fs.DurationVar(&watchOptions.Interval, "interval", converter.DefaultPollInterval, "Scan interval when polling for changes")
+		fs.BoolVar(&watchOptions.Poll, "poll", false, "Poll for changes instead of using inotify (always on outside Linux and with -follow-symlinks)")
+		fs.BoolVar(&watchOptions.Initial, "initial", false, "Also convert the WebP files present when watching starts")
+	}
+	fs.Usage = func() {
+		switch mode {
+		case modePrint:
+			fmt.Fprintf(fs.Output(), "Usage: webpconvert config print [convert flags] [dir]\n\n")
+			fmt.Fprintf(fs.Output(), "Prints the settings a conversion of dir would use, merged from the\n")
+			fmt.Fprintf(fs.Output(), "configuration files, the profile and the flags, as JSON.\n\nFlags:\n")
+		case modeWatch:
+			fmt.Fprintf(fs.Output(), "Usage: webpconvert watch [flags] [dir]\n\n")
+			fmt.Fprintf(fs.Output(), "Watches dir (default: current directory) and converts WebP files as\n")
+			fmt.Fprintf(fs.Output(), "they are created or changed, once their writes have settled, until\n")
+			fmt.Fprintf(fs.Output(), "interrupted.\n\nFlags:\n")
+		default:
+			fmt.Fprintf(fs.Output(), "Usage: webpconvert convert [flags] [dir]\n\n")
+			fmt.Fprintf(fs.Output(), "Converts animated WebP files to GIF and static WebP files to JPEG,\n")
+			fmt.Fprintf(fs.Output(), "recursively under dir (default: current directory).\n\nFlags:\n")
+		}
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	// A positional directory replaces -dir
+	if len(positional) > 1 {
+		fmt.Fprintf(os.Stderr, "Error: too many arguments: %v\n", positional)
+		return exitUsage
+	}
+	if len(positional) == 1 {
+		if isFlagSet(fs, "dir") {
+			fmt.Fprintf(os.Stderr, "Error: directory given both as argument and with -dir\n")
+			return exitUsage
+		}
+		*dirPtr = positional[0]
+	}
+
+	// Handle version flag
+	if *versionPtr {
+		fmt.Printf("webpconvert version %s\n", version)
+		return exitOK
+	}
+
+	// Validate quality
+	if *qualityPtr < 1 || *qualityPtr > 100 {
+		fmt.Fprintf(os.Stderr, "Error: quality must be between 1 and 100\n")
+		return exitUsage
+	}
+
+	// Validate output format
+	if *formatPtr != "" {
+		if _, ok := native.LookupEncoder(*formatPtr); !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown format %q (use %s)\n", *formatPtr, strings.Join(native.EncoderNames(), ", "))
+			return exitUsage
+		}
+	}
+
+	rules, err := converter.ParseRules(ruleSpecs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if *autoFormatPtr {
+		rules = append(rules, converter.AutoRules()...)
+	}
+
+	// Validate workers
+	if *workersPtr < 1 {
+		fmt.Fprintf(os.Stderr, "Error: workers must be at least 1\n")
+		return exitUsage
+	}
+
+	// Validate walk filters
+	if *maxDepthPtr < 0 {
+		fmt.Fprintf(os.Stderr, "Error: max-depth must not be negative\n")
+		return exitUsage
+	}
+
+	minSize, err := converter.ParseSize(*minSizePtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: min-size: %v\n", err)
+		return exitUsage
+	}
+
+	maxSize, err := converter.ParseSize(*maxSizePtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: max-size: %v\n", err)
+		return exitUsage
+	}
+
+	maxMemory, err := converter.ParseSize(*maxMemoryPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: max-memory: %v\n", err)
+		return exitUsage
+	}
+
+	isolateMemory, err := converter.ParseSize(*isolateMemoryPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: isolate-memory: %v\n", err)
+		return exitUsage
+	}
+	if *timeoutPtr < 0 {
+		fmt.Fprintf(os.Stderr, "Error: timeout must not be negative\n")
+		return exitUsage
+	}
+	if watchOptions.Settle < 0 || watchOptions.Interval < 0 {
+		fmt.Fprintf(os.Stderr, "Error: settle and interval must not be negative\n")
+		return exitUsage
+	}
+
+	limits := native.Limits{
+		MaxCanvasPixels: *maxPixelsPtr,
+		MaxFrames:       *maxFramesPtr,
+		MaxTotalPixels:  *maxTotalPixelsPtr,
+		MaxInputBytes:   native.DefaultLimits.MaxInputBytes,
+	}
+	switch *maxInputSizePtr {
+	case "":
+	case "-1":
+		limits.MaxInputBytes = -1
+	default:
+		if limits.MaxInputBytes, err = converter.ParseSize(*maxInputSizePtr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: max-input-size: %v\n", err)
+			return exitUsage
+		}
+	}
+	if limits.MaxCanvasPixels == 0 || limits.MaxFrames == 0 || limits.MaxTotalPixels == 0 || limits.MaxInputBytes == 0 {
+		fmt.Fprintf(os.Stderr, "Error: limits must be positive, or -1 for unlimited\n")
+		return exitUsage
+	}
+
+	detectMode, err := converter.ParseDetectMode(*detectPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	reportFormat := converter.ReportFormatForPath(*reportPtr)
+	if *reportFormatPtr != "" {
+		if reportFormat, err = converter.ParseReportFormat(*reportFormatPtr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+
+	output := strings.ToLower(*outputPtr)
+	logOutput := io.Writer(os.Stderr)
+	switch output {
+	case "text", "bar", "none":
+	case "json":
+		// Deprecated: the JSON events became the structured log records,
+		// written to stdout where the events used to go
+		fmt.Fprintln(os.Stderr, "Warning: -output json is deprecated; use -output none -log-format json -log-level info")
+		output = "none"
+		logOutput = os.Stdout
+		*logFlags.format = "json"
+		if !isFlagSet(fs, "log-level") {
+			*logFlags.level = "info"
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown output %q (use text, bar or none)\n", *outputPtr)
+		return exitUsage
+	}
+	// The bar needs a terminal and a known number of files; fall back to
+	// plain lines otherwise
+	if output == "bar" && (!isTerminal(os.Stderr) || mode == modeWatch) {
+		output = "text"
+	}
+
+	logger, err := logFlags.newLogger(logOutput)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	now := time.Now()
+	modifiedAfter, err := converter.ParseTime(*modifiedAfterPtr, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: modified-after: %v\n", err)
+		return exitUsage
+	}
+
+	modifiedBefore, err := converter.ParseTime(*modifiedBeforePtr, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: modified-before: %v\n", err)
+		return exitUsage
+	}
+
+	// Get absolute path
+	absPath, err := filepath.Abs(*dirPtr)
+	if err != nil {
+		logger.Error("cannot resolve path", "path", *dirPtr, "error", err)
+		return exitFailure
+	}
+
+	// Check if directory exists
+	info, err := os.Stat(absPath)
+	if err != nil {
+		logger.Error("cannot access directory", "path", absPath, "error", err)
+		return exitFailure
+	}
+
+	if !info.IsDir() {
+		logger.Error("path is not a directory", "path", absPath)
+		return exitFailure
+	}
+
+	// Process all WebP files in directory with options
+	options := converter.ProcessOptions{
+		JPEGQuality:  *qualityPtr,
+		NumWorkers:   *workersPtr,
+		KeepOriginal: *keepOriginalPtr,
+		FailFast:     *failFastPtr,
+		MaxMemory:    maxMemory,
+		Limits:       limits,
+		Format:       *formatPtr,
+		Rules:        rules,
+		Analyze:      *analyzePtr,
+
+		Isolate:       *isolatePtr,
+		IsolateMemory: isolateMemory,
+		FileTimeout:   *timeoutPtr,
+
+		Include:        includePatterns,
+		Exclude:        excludePatterns,
+		MaxDepth:       *maxDepthPtr,
+		MinSize:        minSize,
+		MaxSize:        maxSize,
+		ModifiedAfter:  modifiedAfter,
+		ModifiedBefore: modifiedBefore,
+		FollowSymlinks: *followSymlinksPtr,
+		SkipHidden:     *skipHiddenPtr,
+		SkipDirs:       skipDirs,
+		NoIgnoreFiles:  *noIgnoreFilesPtr,
+
+		Detect: detectMode,
+		Logger: logger,
+
+		Profile:       *profilePtr,
+		Overrides:     flagOverrides(fs, ruleSpecs, *autoFormatPtr, now),
+		NoConfigFiles: *noConfigPtr,
+	}
+
+	// Configuration files sit between the flag defaults and the explicit flags
+	var configFiles []string
+	if !*noConfigPtr {
+		if configFiles, err = converter.FindConfigs(absPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+	}
+	configs, err := converter.LoadConfigs(configFiles)
+	if err == nil {
+		err = converter.ApplyConfigs(&options, configs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	if mode == modePrint {
+		return printSettings(os.Stdout, absPath, configFiles, options)
+	}
+
+	// Human-readable outputs get the settings header
+	if output != "none" {
+		if mode == modeWatch {
+			fmt.Printf("Watching WebP files in: %s\n", absPath)
+		} else {
+			fmt.Printf("Processing WebP files in: %s\n", absPath)
+		}
+		if options.Profile != "" {
+			fmt.Printf("Profile: %s\n", options.Profile)
+		}
+		for _, path := range configFiles {
+			fmt.Printf("Config File: %s\n", path)
+		}
+		fmt.Printf("JPEG Quality: %d\n", options.JPEGQuality)
+		if options.Format != "" {
+			fmt.Printf("Output Format: %s\n", options.Format)
+		}
+		for _, rule := range options.Rules {
+			fmt.Printf("Format Rule: %s\n", rule)
+		}
+		fmt.Printf("Parallel Workers: %d\n", options.NumWorkers)
+		fmt.Printf("Keep Original: %v\n", options.KeepOriginal)
+		fmt.Printf("Detection: %s\n\n", options.Detect)
+	}
+
+	var observers []converter.Observer
+	switch output {
+	case "text":
+		observers = append(observers, converter.NewTextObserver(os.Stdout, options))
+	case "bar":
+		observers = append(observers, newProgressBar(os.Stderr, converter.NewTextObserver(os.Stdout, options)))
+	}
+
+	// Open the report before converting, so a bad path fails early
+	var report *converter.ReportWriter
+	if *reportPtr != "" {
+		reportFile, err := os.Create(*reportPtr)
+		if err != nil {
+			logger.Error("cannot create report", "path", *reportPtr, "error", err)
+			return exitFailure
+		}
+		defer reportFile.Close()
+
+		report = converter.NewReportWriter(reportFile, reportFormat, options)
+		observers = append(observers, report)
+	}
+	options.Observer = converter.MultiObserver(observers...)
+
+	// SIGINT/SIGTERM stop the batch gracefully; a second signal kills the
+	// process. The signal received picks the exit code.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received os.Signal
+	done := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case received = <-sigs:
+			signal.Stop(sigs)
+			cancel()
+			if mode == modeWatch {
+				logger.Info("stopping watch, finishing files in progress (signal again to abort)")
+			} else {
+				logger.Warn("interrupted, finishing files in progress (signal again to abort)")
+			}
+		case <-done:
+		}
+	}()
+
+	// Use parallel processing if more than 1 worker is specified
+	switch {
+	case mode == modeWatch:
+		err = converter.Watch(ctx, absPath, options, watchOptions)
+	case options.NumWorkers > 1:
+		err = converter.ProcessDirectoryParallelContext(ctx, absPath, options)
+	default:
+		err = converter.ProcessDirectoryContext(ctx, absPath, options)
+	}
+	close(done)
+	<-watcherDone
+
+	if report != nil {
+		if reportErr := report.Close(); reportErr != nil {
+			logger.Error("cannot write report", "path", *reportPtr, "error", reportErr)
+			if err == nil {
+				return exitFailure
+			}
+		}
+	}
+
+	if err != nil {
+		return reportProcessError(logger, err, received)
+	}
+
+	if output != "none" {
+		if mode == modeWatch {
+			fmt.Println("\nWatch stopped")
+		} else {
+			fmt.Println("\nConversion completed!")
+		}
+	}
+	return exitOK
+}
+
+// reportProcessError logs a failed batch and returns its exit code. Each
+// failed file has already been logged by the converter; sig is the signal
+// that interrupted the batch, if any.
+func reportProcessError(logger *slog.Logger, err error, sig os.Signal) int {
+	var batchErr *converter.BatchError
+	if !errors.As(err, &batchErr) {
+		logger.Error("cannot process directory", "error", err)
+		return exitFailure
+	}
+
+	if batchErr.Interrupted != nil {
+		logger.Warn("conversion interrupted",
+			"converted", batchErr.Stats.TotalProcessed,
+			"failed", batchErr.Stats.ErrorCount,
+			"not_processed", batchErr.Stats.SkippedCount,
+		)
+		if sig == syscall.SIGTERM {
+			return exitTerminated
+		}
+		return exitInterrupted
+	}
+
+	causes := make(map[string]int)
+	for _, failure := range batchErr.Failures {
+		causes[converter.ErrorCause(failure.Err)]++
+	}
+
+	// Group failures by cause, most frequent first
+	names := make([]string, 0, len(causes))
+	for name := range causes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if causes[names[i]] != causes[names[j]] {
+			return causes[names[i]] > causes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.Int(name, causes[name]))
+	}
+
+	logger.Error("conversion finished with errors",
+		"failed", len(batchErr.Failures),
+		"attempted", batchErr.Attempted(),
+		"skipped", batchErr.Stats.SkippedCount,
+		slog.Group("causes", attrs...),
+	)
+
+	return outcomeExitCode(batchErr.Stats.ErrorCount, batchErr.Attempted())
+}
+
+// outcomeExitCode maps the number of failed files out of those attempted to
+// an exit code. Files never attempted, such as those skipped after
+// -fail-fast stopped the batch, count neither way.
+func outcomeExitCode(failed, attempted int) int {
+	switch {
+	case failed == 0:
+		return exitOK
+	case failed < attempted:
+		return exitPartial
+	default:
+		return exitFailure
+	}
+}
+
+// flagOverrides returns the settings of the flags given explicitly on the
+// command line, which win over the configuration files. The flags have been
+// validated; sizes and times are parsed again from their values, durations
+// back from now.
+func flagOverrides(fs *flag.FlagSet, ruleSpecs []string, autoFormat bool, now time.Time) converter.Settings {
+	var s converter.Settings
+	fs.Visit(func(f *flag.Flag) {
+		// The list flags are read directly, the others through flag.Getter
+		value := func() any { return f.Value.(flag.Getter).Get() }
+		switch f.Name {
+		case "quality":
+			s.Quality = ptr(value().(int))
+		case "format":
+			s.Format = ptr(value().(string))
+		case "rule", "auto-format":
+			// Both flags build the rule list
+			s.Rules = append([]string{}, ruleSpecs...)
+			if autoFormat {
+				s.Rules = append(s.Rules, converter.AutoRulesName)
+			}
+		case "analyze":
+			s.Analyze = ptr(value().(bool))
+		case "keep-original":
+			s.KeepOriginal = ptr(value().(bool))
+		case "timeout":
+			s.Timeout = ptr(converter.Duration(value().(time.Duration)))
+		case "max-pixels":
+			s.MaxPixels = ptr(value().(int64))
+		case "max-frames":
+			s.MaxFrames = ptr(value().(int))
+		case "max-total-pixels":
+			s.MaxTotalPixels = ptr(value().(int64))
+		case "max-input-size":
+			s.MaxInputSize = sizeOverride(value().(string))
+		case "workers":
+			s.Workers = ptr(value().(int))
+		case "fail-fast":
+			s.FailFast = ptr(value().(bool))
+		case "max-memory":
+			s.MaxMemory = sizeOverride(value().(string))
+		case "isolate":
+			s.Isolate = ptr(value().(bool))
+		case "isolate-memory":
+			s.IsolateMemory = sizeOverride(value().(string))
+		case "detect":
+			s.Detect = ptr(value().(string))
+		case "include":
+			s.Include = append([]string{}, *f.Value.(*stringListFlag)...)
+		case "exclude":
+			s.Exclude = append([]string{}, *f.Value.(*stringListFlag)...)
+		case "skip-dir":
+			s.SkipDirs = append([]string{}, *f.Value.(*stringListFlag)...)
+		case "max-depth":
+			s.MaxDepth = ptr(value().(int))
+		case "min-size":
+			s.MinSize = sizeOverride(value().(string))
+		case "max-size":
+			s.MaxSize = sizeOverride(value().(string))
+		case "modified-after":
+			s.ModifiedAfter = timeOverride(value().(string), now)
+		case "modified-before":
+			s.ModifiedBefore = timeOverride(value().(string), now)
+		case "skip-hidden":
+			s.SkipHidden = ptr(value().(bool))
+		case "follow-symlinks":
+			s.FollowSymlinks = ptr(value().(bool))
+		case "no-ignore-files":
+			s.NoIgnoreFiles = ptr(value().(bool))
+		}
+	})
+	return s
+}
+
+// sizeOverride parses a validated size flag; "-1" is unlimited
+func sizeOverride(value string) *converter.Size {
+	if value == "-1" {
+		return ptr(converter.Size(-1))
+	}
+	n, _ := converter.ParseSize(value)
+	return ptr(converter.Size(n))
+}
+
+// timeOverride parses a validated time flag
+func timeOverride(value string, now time.Time) *converter.Time {
+	t, _ := converter.ParseTime(value, now)
+	return ptr(converter.Time(t))
+}
+
+// ptr returns a pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
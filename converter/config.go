@@ -0,0 +1,560 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// ConfigFileNames are the names of configuration files in the processed
+// tree, in JSON or TOML. A directory may hold only one of them.
+var ConfigFileNames = []string{"webpconvert.json", "webpconvert.toml"}
+
+// UserConfigNames are the names of the user configuration file in the
+// webpconvert directory of $XDG_CONFIG_HOME (default ~/.config)
+var UserConfigNames = []string{"config.json", "config.toml"}
+
+// AutoRulesName is the entry of a rules list that stands for AutoRules
+const AutoRulesName = "auto"
+
+// Settings is the part of ProcessOptions a configuration file sets. Nil
+// fields leave the option unchanged. Fields tagged config:"batch" apply to
+// the whole batch and are only read from the user and top-level files, not
+// from the files merged during the walk.
+type Settings struct {
+	// Per-file settings
+	Quality        *int      `json:"quality,omitempty"`
+	Format         *string   `json:"format,omitempty"`
+	Rules          []string  `json:"rules,omitempty"` // See ParseRule; "auto" expands to AutoRules
+	Analyze        *bool     `json:"analyze,omitempty"`
+	KeepOriginal   *bool     `json:"keep_original,omitempty"`
+	Timeout        *Duration `json:"timeout,omitempty"`
+	MaxPixels      *int64    `json:"max_pixels,omitempty"`
+	MaxFrames      *int      `json:"max_frames,omitempty"`
+	MaxTotalPixels *int64    `json:"max_total_pixels,omitempty"`
+	MaxInputSize   *Size     `json:"max_input_size,omitempty"`
+
+	// Batch settings
+	Workers        *int     `json:"workers,omitempty" config:"batch"`
+	FailFast       *bool    `json:"fail_fast,omitempty" config:"batch"`
+	MaxMemory      *Size    `json:"max_memory,omitempty" config:"batch"`
+	Isolate        *bool    `json:"isolate,omitempty" config:"batch"`
+	IsolateMemory  *Size    `json:"isolate_memory,omitempty" config:"batch"`
+	Detect         *string  `json:"detect,omitempty" config:"batch"`
+	Include        []string `json:"include,omitempty" config:"batch"`
+	Exclude        []string `json:"exclude,omitempty" config:"batch"`
+	SkipDirs       []string `json:"skip_dirs,omitempty" config:"batch"`
+	MaxDepth       *int     `json:"max_depth,omitempty" config:"batch"`
+	MinSize        *Size    `json:"min_size,omitempty" config:"batch"`
+	MaxSize        *Size    `json:"max_size,omitempty" config:"batch"`
+	ModifiedAfter  *Time    `json:"modified_after,omitempty" config:"batch"`
+	ModifiedBefore *Time    `json:"modified_before,omitempty" config:"batch"`
+	SkipHidden     *bool    `json:"skip_hidden,omitempty" config:"batch"`
+	FollowSymlinks *bool    `json:"follow_symlinks,omitempty" config:"batch"`
+	NoIgnoreFiles  *bool    `json:"no_ignore_files,omitempty" config:"batch"`
+}
+
+// Config is a configuration file: top-level settings and named profiles,
+// e.g. in TOML
+//
+//	quality = 90
+//	rules = ["static,alpha=png", "auto"]
+//
+//	[profiles.web]
+//	quality = 80
+//	max_pixels = 4000000
+type Config struct {
+	Settings
+	Profiles map[string]Settings `json:"profiles,omitempty"`
+
+	Path string `json:"-"` // File the configuration was read from
+}
+
+// Size is a byte count written as a number or as a string such as "10K" or
+// "2G", see ParseSize
+type Size int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("invalid size %s", data)
+	}
+	if strings.TrimSpace(str) == "-1" {
+		*s = -1
+		return nil
+	}
+	n, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = Size(n)
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "30s"
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("invalid duration %s (use a string such as \"30s\")", data)
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", str)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Time is a point in time written as a string, see ParseTime. A duration is
+// taken back from when the file is read.
+type Time time.Time
+
+// MarshalJSON implements json.Marshaler
+func (t Time) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).Format(time.RFC3339))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("invalid time %s (use a string such as \"2024-01-31\" or \"24h\")", data)
+	}
+	v, err := ParseTime(str, time.Now())
+	if err != nil {
+		return err
+	}
+	*t = Time(v)
+	return nil
+}
+
+// LoadConfig reads a configuration file, in TOML if its extension is .toml
+// and in JSON otherwise. Unknown keys are an error.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		// The TOML tables map onto the same keys as the JSON objects
+		doc, err := parseTOML(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	cfg := &Config{Path: path}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadConfigs reads configuration files in order, see LoadConfig
+func LoadConfigs(paths []string) ([]*Config, error) {
+	configs := make([]*Config, 0, len(paths))
+	for _, path := range paths {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// FindConfigs returns the configuration files of a batch on dir in merge
+// order: the user file, then the tree files from the outermost ancestor of
+// dir down to dir itself. Files in subdirectories of dir are merged during
+// the walk instead.
+func FindConfigs(dir string) ([]string, error) {
+	var paths []string
+	if configDir, err := os.UserConfigDir(); err == nil {
+		path, err := findConfigFile(filepath.Join(configDir, "webpconvert"), UserConfigNames)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	var tree []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		path, err := findConfigFile(d, ConfigFileNames)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			tree = append(tree, path)
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	for i := len(tree) - 1; i >= 0; i-- {
+		paths = append(paths, tree[i])
+	}
+	return paths, nil
+}
+
+// findConfigFile returns the path of the file in dir with one of names, or
+// "" if there is none
+func findConfigFile(dir string, names []string) (string, error) {
+	found := ""
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return "", fmt.Errorf("failed to read configuration: %w", err)
+		}
+		if found != "" {
+			return "", fmt.Errorf("%s: both %s and %s present", dir, filepath.Base(found), name)
+		}
+		found = path
+	}
+	return found, nil
+}
+
+// ApplyConfigs merges configuration files into options in order, each with
+// its top-level settings first and then its section for options.Profile,
+// so a later file wins over an earlier one. options.Overrides is applied
+// last. It is an error if options.Profile is set and no file defines it.
+func ApplyConfigs(options *ProcessOptions, configs []*Config) error {
+	found := options.Profile == ""
+	for _, cfg := range configs {
+		if err := options.applyConfig(cfg); err != nil {
+			return err
+		}
+		_, ok := cfg.Profiles[options.Profile]
+		found = found || ok
+	}
+	if !found {
+		return fmt.Errorf("profile %q is not defined in any configuration file", options.Profile)
+	}
+	return options.Overrides.Apply(options)
+}
+
+// applyConfig applies the top-level settings of cfg and its section for
+// o.Profile, if any
+func (o *ProcessOptions) applyConfig(cfg *Config) error {
+	if err := cfg.Settings.Apply(o); err != nil {
+		return fmt.Errorf("%s: %w", cfg.Path, err)
+	}
+	if profile, ok := cfg.Profiles[o.Profile]; ok && o.Profile != "" {
+		if err := profile.Apply(o); err != nil {
+			return fmt.Errorf("%s: profile %q: %w", cfg.Path, o.Profile, err)
+		}
+	}
+	return nil
+}
+
+// dirOptions returns the options for the files of dir: options merged with
+// the configuration file of dir, or options itself if dir has none. The
+// file may only hold per-file settings.
+func dirOptions(dir string, options *ProcessOptions) (*ProcessOptions, error) {
+	path, err := findConfigFile(dir, ConfigFileNames)
+	if err != nil || path == "" {
+		return options, err
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if keys := cfg.batchKeys(); len(keys) > 0 {
+		return nil, fmt.Errorf("%s: %s cannot change per directory", path, strings.Join(keys, ", "))
+	}
+
+	merged := *options
+	if err := merged.applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := merged.Overrides.Apply(&merged); err != nil {
+		return nil, err
+	}
+	options.logger().Debug("merged directory configuration", "path", path)
+	return &merged, nil
+}
+
+// batchKeys returns the batch settings set in cfg or its profiles
+func (c *Config) batchKeys() []string {
+	keys := c.Settings.batchKeys("")
+	for name, profile := range c.Profiles {
+		keys = append(keys, profile.batchKeys("profiles."+name+".")...)
+	}
+	return keys
+}
+
+// batchKeys returns the keys of the batch settings set in s, with prefix
+func (s *Settings) batchKeys(prefix string) []string {
+	var keys []string
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("config") != "batch" || v.Field(i).IsNil() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		keys = append(keys, prefix+name)
+	}
+	return keys
+}
+
+// Apply sets the options that s sets, after validating them
+func (s *Settings) Apply(options *ProcessOptions) error {
+	if s.Quality != nil && (*s.Quality < 1 || *s.Quality > 100) {
+		return fmt.Errorf("quality must be between 1 and 100, got %d", *s.Quality)
+	}
+	if s.Format != nil && *s.Format != "" {
+		if _, ok := native.LookupEncoder(*s.Format); !ok {
+			return fmt.Errorf("unknown format %q (use %s)", *s.Format, strings.Join(native.EncoderNames(), ", "))
+		}
+	}
+	if s.Workers != nil && *s.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+	if s.MaxDepth != nil && *s.MaxDepth < 0 {
+		return fmt.Errorf("max_depth must not be negative")
+	}
+	if s.Timeout != nil && *s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	for name, size := range map[string]*Size{"max_memory": s.MaxMemory, "isolate_memory": s.IsolateMemory, "min_size": s.MinSize, "max_size": s.MaxSize} {
+		if size != nil && *size < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if (s.MaxPixels != nil && *s.MaxPixels == 0) || (s.MaxFrames != nil && *s.MaxFrames == 0) ||
+		(s.MaxTotalPixels != nil && *s.MaxTotalPixels == 0) || (s.MaxInputSize != nil && *s.MaxInputSize == 0) {
+		return fmt.Errorf("limits must be positive, or -1 for unlimited")
+	}
+
+	if s.Rules != nil {
+		rules, err := parseRuleList(s.Rules)
+		if err != nil {
+			return err
+		}
+		options.Rules = rules
+	}
+	if s.Detect != nil {
+		mode, err := ParseDetectMode(*s.Detect)
+		if err != nil {
+			return err
+		}
+		options.Detect = mode
+	}
+
+	set(&options.JPEGQuality, s.Quality)
+	set(&options.Format, s.Format)
+	set(&options.Analyze, s.Analyze)
+	set(&options.KeepOriginal, s.KeepOriginal)
+	setAs(&options.FileTimeout, s.Timeout)
+	set(&options.Limits.MaxCanvasPixels, s.MaxPixels)
+	set(&options.Limits.MaxFrames, s.MaxFrames)
+	set(&options.Limits.MaxTotalPixels, s.MaxTotalPixels)
+	setAs(&options.Limits.MaxInputBytes, s.MaxInputSize)
+
+	set(&options.NumWorkers, s.Workers)
+	set(&options.FailFast, s.FailFast)
+	setAs(&options.MaxMemory, s.MaxMemory)
+	set(&options.Isolate, s.Isolate)
+	setAs(&options.IsolateMemory, s.IsolateMemory)
+	if s.Include != nil {
+		options.Include = s.Include
+	}
+	if s.Exclude != nil {
+		options.Exclude = s.Exclude
+	}
+	if s.SkipDirs != nil {
+		options.SkipDirs = s.SkipDirs
+	}
+	set(&options.MaxDepth, s.MaxDepth)
+	setAs(&options.MinSize, s.MinSize)
+	setAs(&options.MaxSize, s.MaxSize)
+	if s.ModifiedAfter != nil {
+		options.ModifiedAfter = time.Time(*s.ModifiedAfter)
+	}
+	if s.ModifiedBefore != nil {
+		options.ModifiedBefore = time.Time(*s.ModifiedBefore)
+	}
+	set(&options.SkipHidden, s.SkipHidden)
+	set(&options.FollowSymlinks, s.FollowSymlinks)
+	set(&options.NoIgnoreFiles, s.NoIgnoreFiles)
+	return nil
+}
+
+// set stores *v in dst if v is not nil
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// setAs is set for a configuration type with another underlying type
+func setAs[T, V ~int64](dst *T, v *V) {
+	if v != nil {
+		*dst = T(*v)
+	}
+}
+
+// Settings returns every setting of o that a configuration file can hold
+func (o ProcessOptions) Settings() Settings {
+	// Zero limits are the defaults
+	limits := o.Limits
+	if limits.MaxCanvasPixels == 0 {
+		limits.MaxCanvasPixels = native.DefaultLimits.MaxCanvasPixels
+	}
+	if limits.MaxFrames == 0 {
+		limits.MaxFrames = native.DefaultLimits.MaxFrames
+	}
+	if limits.MaxTotalPixels == 0 {
+		limits.MaxTotalPixels = native.DefaultLimits.MaxTotalPixels
+	}
+	if limits.MaxInputBytes == 0 {
+		limits.MaxInputBytes = native.DefaultLimits.MaxInputBytes
+	}
+	timeout := Duration(o.FileTimeout)
+	detect := o.Detect.String()
+
+	// Zero times are no limit and left out
+	var modifiedAfter, modifiedBefore *Time
+	if !o.ModifiedAfter.IsZero() {
+		modifiedAfter = (*Time)(&o.ModifiedAfter)
+	}
+	if !o.ModifiedBefore.IsZero() {
+		modifiedBefore = (*Time)(&o.ModifiedBefore)
+	}
+	return Settings{
+		Quality:        &o.JPEGQuality,
+		Format:         &o.Format,
+		Rules:          ruleStrings(o.Rules),
+		Analyze:        &o.Analyze,
+		KeepOriginal:   &o.KeepOriginal,
+		Timeout:        &timeout,
+		MaxPixels:      &limits.MaxCanvasPixels,
+		MaxFrames:      &limits.MaxFrames,
+		MaxTotalPixels: &limits.MaxTotalPixels,
+		MaxInputSize:   (*Size)(&limits.MaxInputBytes),
+
+		Workers:        &o.NumWorkers,
+		FailFast:       &o.FailFast,
+		MaxMemory:      (*Size)(&o.MaxMemory),
+		Isolate:        &o.Isolate,
+		IsolateMemory:  (*Size)(&o.IsolateMemory),
+		Detect:         &detect,
+		Include:        o.Include,
+		Exclude:        o.Exclude,
+		SkipDirs:       o.SkipDirs,
+		MaxDepth:       &o.MaxDepth,
+		MinSize:        (*Size)(&o.MinSize),
+		MaxSize:        (*Size)(&o.MaxSize),
+		ModifiedAfter:  modifiedAfter,
+		ModifiedBefore: modifiedBefore,
+		SkipHidden:     &o.SkipHidden,
+		FollowSymlinks: &o.FollowSymlinks,
+		NoIgnoreFiles:  &o.NoIgnoreFiles,
+	}
+}
+
+// parseRuleList parses the rules of a configuration file, expanding
+// AutoRulesName to AutoRules
+func parseRuleList(specs []string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == AutoRulesName {
+			rules = append(rules, AutoRules()...)
+			continue
+		}
+		rule, err := ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ParseTime parses an absolute time (RFC 3339 or YYYY-MM-DD, at local
+// midnight) or a duration relative to now, such as "24h" meaning 24 hours
+// ago. An empty string is the zero time.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD or a duration like 24h)", value)
+}
+
+// ParseSize parses a byte size such as "512", "10K", "5MB" or "1GiB" (binary units).
+// An empty string is 0.
+func ParseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	if s == "" {
+		return 0, nil
+	}
+
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(s, "T"):
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return int64(n * float64(multiplier)), nil
+}
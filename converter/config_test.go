@@ -0,0 +1,236 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration file below root
+func writeConfig(t *testing.T, root, name, content string) string {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfig tests that JSON and TOML files read the same and that
+// mistakes are reported
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := writeConfig(t, dir, "webpconvert.json", `{
+		"quality": 90,
+		"rules": ["static,alpha=png", "auto"],
+		"max_memory": "2G",
+		"timeout": "30s",
+		"modified_after": "2024-01-31",
+		"profiles": {"web": {"quality": 80, "max_pixels": 4000000, "skip_dirs": ["raw"]}}
+	}`)
+	tomlPath := writeConfig(t, dir, "other/webpconvert.toml", `
+# Team defaults
+quality = 90
+rules = [
+  "static,alpha=png", # keep transparency
+  'auto',
+]
+max_memory = "2G"
+timeout = "30s"
+modified_after = "2024-01-31"
+
+[profiles.web]
+quality = 80
+max_pixels = 4_000_000
+skip_dirs = ["raw"]
+`)
+
+	fromJSON, err := LoadConfig(jsonPath)
+	if err != nil {
+		t.Fatalf("LoadConfig(json) failed: %v", err)
+	}
+	fromTOML, err := LoadConfig(tomlPath)
+	if err != nil {
+		t.Fatalf("LoadConfig(toml) failed: %v", err)
+	}
+	fromJSON.Path, fromTOML.Path = "", ""
+	if !reflect.DeepEqual(fromJSON, fromTOML) {
+		t.Errorf("JSON %+v and TOML %+v differ", fromJSON, fromTOML)
+	}
+	if *fromTOML.MaxMemory != 2<<30 || *fromTOML.Timeout != Duration(30*time.Second) {
+		t.Errorf("max_memory %d, timeout %v", *fromTOML.MaxMemory, *fromTOML.Timeout)
+	}
+	if after := time.Time(*fromTOML.ModifiedAfter); !after.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("modified_after %v", after)
+	}
+
+	// A duration is taken back from when the file is read
+	relative, err := LoadConfig(writeConfig(t, t.TempDir(), "webpconvert.toml", "modified_before = \"24h\"\n"))
+	if err != nil {
+		t.Fatalf("LoadConfig(relative) failed: %v", err)
+	}
+	if ago := time.Since(time.Time(*relative.ModifiedBefore)); ago < 24*time.Hour || ago > 25*time.Hour {
+		t.Errorf("modified_before 24h is %v ago", ago)
+	}
+
+	bad := []struct {
+		name, content, want string
+	}{
+		{"webpconvert.json", `{"qualty": 90}`, "unknown field"},
+		{"webpconvert.toml", "quality = 90\nformat = png\n", "line 2"},
+		{"webpconvert.toml", "rules = [\"a=gif\"\n", "unterminated array"},
+		{"webpconvert.toml", "max_size = \"lots\"\n", "invalid size"},
+		{"webpconvert.toml", "modified_after = \"yesterday\"\n", "invalid time"},
+		{"webpconvert.json", `{"modified_after": 20240131}`, "invalid time"},
+	}
+	for _, tt := range bad {
+		path := writeConfig(t, t.TempDir(), tt.name, tt.content)
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
+
+// TestApplyConfigs tests the merge order of files, profiles and overrides
+func TestApplyConfigs(t *testing.T) {
+	dir := t.TempDir()
+	user := writeConfig(t, dir, "user.toml", `
+quality = 90
+workers = 2
+
+[profiles.web]
+quality = 70
+format = "jpeg"
+`)
+	tree := writeConfig(t, dir, "tree/webpconvert.json", `{"quality": 85, "keep_original": true, "profiles": {"web": {"max_frames": 10}}}`)
+	configs, err := LoadConfigs([]string{user, tree})
+	if err != nil {
+		t.Fatalf("LoadConfigs failed: %v", err)
+	}
+
+	workers := 4
+	options := DefaultProcessOptions()
+	options.Profile = "web"
+	options.Overrides = Settings{Workers: &workers}
+	if err := ApplyConfigs(&options, configs); err != nil {
+		t.Fatalf("ApplyConfigs failed: %v", err)
+	}
+
+	// The tree file wins over the user file, its base settings over the
+	// user profile, and the overrides over everything
+	if options.JPEGQuality != 85 || options.Format != "jpeg" || !options.KeepOriginal ||
+		options.Limits.MaxFrames != 10 || options.NumWorkers != 4 {
+		t.Errorf("merged options: quality %d, format %q, keep %v, max frames %d, workers %d",
+			options.JPEGQuality, options.Format, options.KeepOriginal, options.Limits.MaxFrames, options.NumWorkers)
+	}
+
+	options = DefaultProcessOptions()
+	options.Profile = "print"
+	if err := ApplyConfigs(&options, configs); err == nil || !strings.Contains(err.Error(), "not defined") {
+		t.Errorf("undefined profile: err = %v", err)
+	}
+
+	quality := 0
+	options = DefaultProcessOptions()
+	options.Overrides = Settings{Quality: &quality}
+	if err := ApplyConfigs(&options, nil); err == nil {
+		t.Error("quality 0: expected error")
+	}
+}
+
+// TestFindConfigs tests the user file and the files of the ancestors
+func TestFindConfigs(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "xdg"))
+	user := writeConfig(t, root, "xdg/webpconvert/config.toml", "")
+	outer := writeConfig(t, root, "webpconvert.json", "{}")
+	inner := writeConfig(t, root, "photos/webpconvert.toml", "")
+	writeConfig(t, root, "photos/2024/raw/webpconvert.json", "{}")
+
+	got, err := FindConfigs(filepath.Join(root, "photos", "2024"))
+	if err != nil {
+		t.Fatalf("FindConfigs failed: %v", err)
+	}
+	if want := []string{user, outer, inner}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindConfigs = %v, want %v", got, want)
+	}
+
+	writeConfig(t, root, "photos/webpconvert.json", "{}")
+	if _, err := FindConfigs(filepath.Join(root, "photos")); err == nil {
+		t.Error("two files in one directory: expected error")
+	}
+}
+
+// TestWalkDirectoryConfig tests that the files of subdirectories apply to
+// the files below them in both pipelines
+func TestWalkDirectoryConfig(t *testing.T) {
+	for _, workers := range []int{1, 2} {
+		root := t.TempDir()
+		for _, name := range []string{"a.webp", "png/b.webp", "png/jpeg/c.webp"} {
+			createTree(t, root, name)
+			if err := os.WriteFile(filepath.Join(root, name), []byte(staticWebP), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeConfig(t, root, "png/webpconvert.toml", "format = \"png\"\n[profiles.web]\nquality = 50\n")
+		writeConfig(t, root, "png/jpeg/webpconvert.json", `{"format": "jpeg"}`)
+
+		options := DefaultProcessOptions()
+		options.NumWorkers = workers
+		options.KeepOriginal = true
+		options.Profile = "web"
+		var mu sync.Mutex
+		qualities := map[string]int{}
+		options.Observer = ObserverFunc(func(event Event) {
+			if event.Type == EventFileFinished {
+				mu.Lock()
+				qualities[filepath.Base(event.Result.Path)] = event.Result.Quality
+				mu.Unlock()
+			}
+		})
+		var err error
+		if workers > 1 {
+			err = ProcessDirectoryParallel(root, options)
+		} else {
+			err = ProcessDirectory(root, options)
+		}
+		if err != nil {
+			t.Fatalf("workers %d: %v", workers, err)
+		}
+
+		for _, want := range []string{"a_converted.jpg", "png/b_converted.png", "png/jpeg/c_converted.jpg"} {
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(want))); err != nil {
+				t.Errorf("workers %d: missing %s", workers, want)
+			}
+		}
+		// The quality of a result is the one its directory used
+		if want := map[string]int{"a.webp": 100, "b.webp": 0, "c.webp": 50}; !reflect.DeepEqual(qualities, want) {
+			t.Errorf("workers %d: qualities %v, want %v", workers, qualities, want)
+		}
+		options.Observer = nil
+
+		options.NoConfigFiles = true
+		var paths []string
+		err = walkWebPFiles(root, options, func(path string, info os.FileInfo, fileOptions *ProcessOptions) error {
+			if fileOptions.Format != "" {
+				paths = append(paths, path)
+			}
+			return nil
+		}, nil)
+		if err != nil || paths != nil {
+			t.Errorf("NoConfigFiles: merged for %v, err %v", paths, err)
+		}
+
+		writeConfig(t, root, "png/webpconvert.toml", "workers = 2\n")
+		if err := ProcessDirectory(root, DefaultProcessOptions()); err == nil || !strings.Contains(err.Error(), "cannot change per directory") {
+			t.Errorf("batch setting in directory file: err = %v", err)
+		}
+	}
+}
@@ -1,21 +1,69 @@
 package converter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/robsonalvesdevbr/webpconvert/native"
 )
 
 // ProcessOptions configures the conversion behavior
 type ProcessOptions struct {
-	JPEGQuality  int  // 1-100, default 100
-	NumWorkers   int  // Number of parallel workers (default: runtime.NumCPU())
-	KeepOriginal bool // Keep original WebP files (default: false)
+	JPEGQuality  int           // 1-100, default 100
+	NumWorkers   int           // Number of parallel workers (default: runtime.NumCPU())
+	KeepOriginal bool          // Keep original WebP files (default: false)
+	FailFast     bool          // Stop scheduling files after the first failure (default: false)
+	MaxMemory    int64         // Estimated memory budget in bytes for files converting at once (0: unlimited)
+	Limits       native.Limits // Decoding limits for untrusted input (zero fields: native.DefaultLimits)
+	Format       string        // Output encoder name for every file, see native.Encoders (empty: gif for animated, jpeg for static)
+	Rules        []Rule        // Output format routing, first match wins; files no rule matches fall back to Format (default: none)
+	Analyze      bool          // Decode files to classify them by content before routing, see native.ContentInfo (default: true)
+
+	// Process isolation for untrusted input
+	Isolate       bool          // Convert each file in a child process, see ServeIsolateWorker (default: false)
+	IsolateMemory int64         // Address-space limit of each child process in bytes (0: none)
+	FileTimeout   time.Duration // Maximum conversion time per file (0: no limit); a timed-out file frees its worker for the next one
+
+	// Directory walk filters
+	Include        []string  // Glob patterns files must match (empty: all files)
+	Exclude        []string  // Glob patterns for files and directories to skip
+	MaxDepth       int       // Maximum walk depth, 1 = only rootPath itself (0: unlimited)
+	MinSize        int64     // Minimum file size in bytes (0: no minimum)
+	MaxSize        int64     // Maximum file size in bytes (0: no maximum)
+	ModifiedAfter  time.Time // Only files modified after this time (zero: no limit)
+	ModifiedBefore time.Time // Only files modified before this time (zero: no limit)
+	FollowSymlinks bool      // Follow symbolic links, skipping loops (default: false)
+	SkipHidden     bool      // Skip hidden files and directories (default: false)
+	SkipDirs       []string  // Directory names to skip entirely, e.g. "node_modules"
+	NoIgnoreFiles  bool      // Do not honor .webpconvertignore files (default: false)
+
+	Detect DetectMode // How WebP files are recognized (default: by extension)
+
+	// Configuration files, see Config. The files of the root and its
+	// ancestors are merged by the caller with ApplyConfigs; those in
+	// subdirectories are merged during the walk for the files below them.
+	Profile       string   // Profile section applied from each configuration file (empty: none)
+	Overrides     Settings // Applied after every configuration file, e.g. the explicit command-line flags
+	NoConfigFiles bool     // Do not merge configuration files of subdirectories (default: false)
+
+	Observer Observer     // Receives progress events (nil: no output)
+	Logger   *slog.Logger // Receives diagnostics and per-file records (nil: discarded)
+}
+
+// logger returns the configured logger, or one that discards everything
+func (o ProcessOptions) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return o.Logger
 }
 
 // DefaultProcessOptions returns default configuration
@@ -24,6 +72,7 @@ func DefaultProcessOptions() ProcessOptions {
 		JPEGQuality:  100,
 		NumWorkers:   1, // Sequential by default
 		KeepOriginal: false,
+		Analyze:      true,
 	}
 }
 
@@ -31,15 +80,32 @@ func DefaultProcessOptions() ProcessOptions {
 type ConversionJob struct {
 	Path     string
 	FileInfo os.FileInfo
+
+	options *ProcessOptions // Options merged for the file's directory (nil: the batch options)
 }
 
+// jobQueueFactor is the number of queued jobs per worker in the parallel pipeline
+const jobQueueFactor = 2
+
 // ConversionResult represents the result of a conversion
 type ConversionResult struct {
 	Path     string
 	Success  bool
+	Skipped  bool // Not attempted because the batch stopped early
 	Type     native.WebPType
 	Error    error
 	FilePath string // Output file path
+	Format   string // Name of the output encoder
+	Quality  int    // JPEG quality the file was encoded with (0: other formats)
+
+	// Details for reports, filled in as far as the conversion got
+	Width        int // Canvas size from the container header
+	Height       int
+	FrameCount   int
+	AnimDuration time.Duration // Total animation duration (animated files)
+	BytesIn      int64         // Input file size
+	BytesOut     int64         // Output file size
+	Elapsed      time.Duration // Time spent converting the file
 }
 
 // ProcessStats aggregates conversion statistics
@@ -48,53 +114,169 @@ type ProcessStats struct {
 	StaticCount    int
 	AnimatedCount  int
 	ErrorCount     int
+	SkippedCount   int            // Files not attempted because the batch stopped early (fail-fast or cancellation)
+	TimeoutCount   int            // Failed files that exceeded FileTimeout (included in ErrorCount)
+	MismatchCount  int            // Files whose extension disagrees with their content
+	FormatCounts   map[string]int // Converted files per output format
 }
 
+// testHookConvert is called by convertFile as the native conversion starts,
+// under the timeout of the file; an error replaces the conversion
+var testHookConvert func(path string) error
+
+// testHookAfterRename is called by convertFile between writing the output
+// and removing the original
+var testHookAfterRename func(outputPath string)
+
 // convertSingleFile processes a single WebP file
 func convertSingleFile(path string, options ProcessOptions) ConversionResult {
-	result := ConversionResult{
+	return convertFile(context.Background(), path, options, nil, "")
+}
+
+// convertFile processes a single WebP file. In a batch, the conversion waits
+// for a worker slot and until its estimated memory fits in the budget; if
+// ctx is done first, the file is reported as skipped. Panics and timeouts
+// (options.FileTimeout) become the error of the file. The output is written
+// to a temp file named by tag (empty: a new tag) and renamed once complete.
+func convertFile(ctx context.Context, path string, options ProcessOptions, b *batch, tag string) (result ConversionResult) {
+	result = ConversionResult{
 		Path:    path,
 		Success: false,
 	}
 
-	// Detect WebP type using native implementation
-	webpType, err := native.DetectWebPType(path)
+	start := time.Now()
+	defer func() {
+		// A panic outside the native conversion, e.g. in header parsing
+		if v := recover(); v != nil {
+			result.Success = false
+			result.Error = newPanicError(v)
+		}
+		result.Elapsed = time.Since(start)
+	}()
+
+	if fi, err := os.Stat(path); err == nil {
+		result.BytesIn = fi.Size()
+	}
+
+	// Detect WebP type from the container headers
+	info, err := native.GetWebPInfo(path)
 	if err != nil {
 		result.Error = fmt.Errorf("failed to detect type: %w", err)
 		return result
 	}
 
+	webpType := info.Type
 	result.Type = webpType
+	result.Width, result.Height = info.Width, info.Height
+	result.FrameCount = info.FrameCount
+	result.AnimDuration = time.Duration(info.Duration) * time.Millisecond
 
-	// Create temp output path with appropriate suffix
-	baseWithoutExt := strings.TrimSuffix(path, filepath.Ext(path))
-	var outputPath string
-	var tempPath string
-	var suffix string
+	// Refuse oversized files before waiting for memory or decoding
+	if err := options.Limits.Check(info, result.BytesIn); err != nil {
+		result.Error = err
+		return result
+	}
 
-	// Add "_converted" suffix if keeping original
-	if options.KeepOriginal {
-		suffix = "_converted"
+	release := func() {}
+	if b != nil {
+		if release, err = b.acquire(ctx, EstimateMemory(info, result.BytesIn)); err != nil {
+			result.Skipped = true
+			return result
+		}
 	}
+	// A timed-out conversion keeps its memory until it actually returns
+	abandoned := false
+	defer func() {
+		if !abandoned {
+			release()
+		}
+	}()
 
-	// Route to appropriate converter
-	switch webpType {
-	case native.WebPTypeAnimated:
-		outputPath = baseWithoutExt + suffix + ".gif"
-		tempPath = outputPath + ".tmp"
-		err = native.ConvertWebPToGIF(path, tempPath)
+	// The child process repeats the steps below; a crash only fails this file
+	if options.Isolate {
+		return convertIsolated(path, options, result)
+	}
 
-	case native.WebPTypeStatic:
-		outputPath = baseWithoutExt + suffix + ".jpg"
-		tempPath = outputPath + ".tmp"
-		err = native.ConvertWebPToJPEG(path, tempPath, options.JPEGQuality)
+	// Decoding the content counts against the timeout of the file
+	deadline := time.Now().Add(options.FileTimeout)
+	var content *native.ContentInfo
+	if need := needsAnalysis(info, options); need != analyzeNone {
+		analyze := func() (err error) {
+			if need == analyzeStill {
+				var still bool
+				still, err = native.IsStillWebPFile(path, options.Limits)
+				content = stillContent(info, still)
+				return err
+			}
+			content, err = native.AnalyzeWebPFile(path, options.Limits)
+			return err
+		}
+		abandon := func() func() { return b.abandon(release) }
+		if err := runGuarded(options.FileTimeout, analyze, abandon); err != nil {
+			abandoned = errors.Is(err, ErrTimeout)
+			result.Error = fmt.Errorf("failed to analyze content: %w", err)
+			return result
+		}
+	}
+	facts := Classify(info, content)
+	if facts.Type != webpType {
+		options.logger().Debug("classified by content", "path", path, "header_type", webpType, "type", facts.Type)
+	}
+	result.Type = facts.Type
 
-	default:
-		result.Error = fmt.Errorf("unknown WebP type")
+	// Route to the output encoder
+	enc, err := selectEncoder(facts, options)
+	if err != nil {
+		result.Error = err
 		return result
 	}
+	result.Format = enc.Name()
+	if result.Format == "jpeg" {
+		result.Quality = options.JPEGQuality
+	}
+
+	// Write to a temp file next to the output, renamed once complete. A
+	// disguised WebP is named after its content, so its output name may
+	// belong to an unrelated file, which is never replaced.
+	outputPath := outputPathFor(path, enc, options)
+	replace := hasWebPExt(path) || outputPath == path
+	if !replace {
+		if _, err := os.Lstat(outputPath); err == nil {
+			result.Error = fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+			return result
+		}
+	}
+	if tag == "" {
+		tag = newTempTag()
+	}
+	tempPath := tempPathFor(outputPath, tag)
+
+	convert := func() error {
+		if testHookConvert != nil {
+			if err := testHookConvert(path); err != nil {
+				return err
+			}
+		}
+		return native.ConvertFile(path, tempPath, enc, encodeOptions(options))
+	}
+	timeout := options.FileTimeout
+	if timeout > 0 {
+		timeout = max(time.Until(deadline), time.Millisecond)
+	}
+	err = runGuarded(timeout, convert, func() func() {
+		b.trackAbandoned(tempPath)
+		returned := b.abandon(release)
+		return func() {
+			b.untrackAbandoned(tempPath)
+			returned()
+		}
+	})
 
 	if err != nil {
+		if errors.Is(err, ErrTimeout) {
+			abandoned = true
+			err = fmt.Errorf("%w after %v", ErrTimeout, options.FileTimeout)
+		}
 		os.Remove(tempPath)
 		result.Error = err
 		return result
@@ -106,234 +288,301 @@ func convertSingleFile(path string, options ProcessOptions) ConversionResult {
 		return result
 	}
 
-	// Remove original WebP only if not keeping original
-	if !options.KeepOriginal {
+	// Rename temp file to final name before touching the original, so an
+	// interruption leaves at least one of them
+	if err := placeOutput(tempPath, outputPath, replace); err != nil {
+		os.Remove(tempPath)
+		if !errors.Is(err, ErrOutputExists) {
+			err = fmt.Errorf("failed to rename temp file: %w", err)
+		}
+		result.Error = err
+		return result
+	}
+	if testHookAfterRename != nil {
+		testHookAfterRename(outputPath)
+	}
+
+	// Remove original WebP only if not keeping original (a disguised WebP
+	// may have been replaced in place)
+	if !options.KeepOriginal && outputPath != path {
 		if err := os.Remove(path); err != nil {
-			os.Remove(tempPath)
 			result.Error = fmt.Errorf("failed to remove original file: %w", err)
 			return result
 		}
 	}
 
-	// Rename temp file to final name
-	if err := os.Rename(tempPath, outputPath); err != nil {
-		result.Error = fmt.Errorf("failed to rename temp file: %w", err)
-		return result
-	}
-
 	result.Success = true
 	result.FilePath = outputPath
+	if fi, err := os.Stat(outputPath); err == nil {
+		result.BytesOut = fi.Size()
+	}
 	return result
 }
 
-// worker processes jobs from the jobs channel
-func worker(id int, jobs <-chan ConversionJob, results chan<- ConversionResult, options ProcessOptions, wg *sync.WaitGroup) {
+// placeOutput moves a finished temp file to outputPath. Unless replace is
+// set, an existing outputPath fails with ErrOutputExists: the temp file is
+// hard-linked, which cannot replace a file created since it was checked, or
+// renamed after a last check on filesystems without hard links.
+func placeOutput(tempPath, outputPath string, replace bool) error {
+	if replace {
+		return os.Rename(tempPath, outputPath)
+	}
+	err := os.Link(tempPath, outputPath)
+	if err == nil {
+		os.Remove(tempPath)
+		return nil
+	}
+	if _, statErr := os.Lstat(outputPath); statErr == nil || errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+	}
+	return os.Rename(tempPath, outputPath)
+}
+
+// worker processes jobs from the jobs channel. Once ctx is done, remaining
+// jobs are reported as skipped instead of converted. stop is called on every
+// failure, before the next job is taken.
+func worker(ctx context.Context, id int, jobs <-chan ConversionJob, results chan<- ConversionResult, options ProcessOptions, b *batch, stop func(), notify *notifier, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for job := range jobs {
-		result := convertSingleFile(job.Path, options)
+		if ctx.Err() != nil {
+			results <- ConversionResult{Path: job.Path, Skipped: true}
+			continue
+		}
+		notify.emit(Event{Type: EventFileStarted, Path: job.Path})
+		fileOptions := options
+		if job.options != nil {
+			fileOptions = *job.options
+		}
+		result := convertFile(ctx, job.Path, fileOptions, b, "")
+		if !result.Success && !result.Skipped {
+			stop()
+		}
 		results <- result
 	}
 }
 
-// collectStats aggregates results from the results channel
-func collectStats(results <-chan ConversionResult, total int, verbose bool, keepOriginal bool) ProcessStats {
+// collectStats aggregates results from the results channel, reports each
+// one as EventFileFinished and returns the failures in the order they arrived.
+// total returns the number of files discovered so far.
+func collectStats(results <-chan ConversionResult, total func() int, notify *notifier) (ProcessStats, []*FileError) {
 	stats := ProcessStats{}
-	processed := 0
+	var failures []*FileError
+	finished := 0
 
 	for result := range results {
-		processed++
+		finished++
+		failures = stats.add(result, failures)
+		notify.emit(Event{Type: EventFileFinished, Path: result.Path, Result: &result, Index: finished, Total: total()})
+	}
 
-		if verbose {
-			fmt.Printf("Processing [%d/%d]: %s\n", processed, total, result.Path)
-		}
+	return stats, failures
+}
 
-		if result.Success {
-			stats.TotalProcessed++
-			switch result.Type {
-			case native.WebPTypeAnimated:
-				stats.AnimatedCount++
-				if verbose {
-					if keepOriginal {
-						fmt.Printf("  Type: Animated → Converted to GIF (original preserved)\n")
-					} else {
-						fmt.Printf("  Type: Animated → Converted to GIF\n")
-					}
-				}
-			case native.WebPTypeStatic:
-				stats.StaticCount++
-				if verbose {
-					if keepOriginal {
-						fmt.Printf("  Type: Static → Converted to JPEG (original preserved)\n")
-					} else {
-						fmt.Printf("  Type: Static → Converted to JPEG\n")
-					}
-				}
-			}
-			if verbose {
-				fmt.Printf("  Successfully converted\n")
-			}
-		} else {
-			stats.ErrorCount++
-			if verbose && result.Error != nil {
-				fmt.Printf("  Error: %v\n", result.Error)
+// add counts a result and appends it to failures if it failed
+func (s *ProcessStats) add(result ConversionResult, failures []*FileError) []*FileError {
+	switch {
+	case result.Skipped:
+		s.SkippedCount++
+	case result.Success:
+		s.TotalProcessed++
+		switch result.Type {
+		case native.WebPTypeAnimated:
+			s.AnimatedCount++
+		case native.WebPTypeStatic:
+			s.StaticCount++
+		}
+		if result.Format != "" {
+			if s.FormatCounts == nil {
+				s.FormatCounts = make(map[string]int)
 			}
+			s.FormatCounts[result.Format]++
+		}
+	default:
+		err := resultError(result)
+		s.ErrorCount++
+		if errors.Is(err, ErrTimeout) {
+			s.TimeoutCount++
 		}
+		failures = append(failures, &FileError{Path: result.Path, Err: err})
 	}
+	return failures
+}
 
-	return stats
+// resultError returns the error of a failed result, never nil
+func resultError(result ConversionResult) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	return fmt.Errorf("conversion failed")
 }
 
-// ProcessDirectoryParallel recursively processes all WebP files in a directory using parallel workers
+// ProcessDirectoryParallel recursively processes all WebP files in a directory using parallel workers.
+// Files are selected by the walk filters in options. If any file fails, the
+// returned error is a *BatchError listing every failure. Progress is reported
+// to options.Observer.
 func ProcessDirectoryParallel(rootPath string, options ProcessOptions) error {
-	// Phase 1: Scan - Collect all WebP files
-	var webpFiles []ConversionJob
-
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".webp" {
-			webpFiles = append(webpFiles, ConversionJob{
-				Path:     path,
-				FileInfo: info,
-			})
-		}
-
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("error scanning directory: %w", err)
-	}
+	return ProcessDirectoryParallelContext(context.Background(), rootPath, options)
+}
 
-	if len(webpFiles) == 0 {
-		fmt.Println("No WebP files found")
-		return nil
-	}
+// ProcessDirectoryParallelContext is ProcessDirectoryParallel with cancellation.
+// Once ctx is done the walk stops and no new file is started: files already
+// converting finish (their output is written to a temp file and renamed, so
+// none is left half written) and those already queued are reported as
+// skipped. The returned *BatchError then has Interrupted set and carries the
+// partial stats.
+//
+// The directory is walked concurrently with the conversion: workers start on
+// the first file found and the walk is throttled by bounded queues, so memory
+// use does not grow with the size of the tree. Until EventScanFinished the
+// Total of events is the number of files discovered so far.
+func ProcessDirectoryParallelContext(parent context.Context, rootPath string, options ProcessOptions) (err error) {
+	notify := newNotifier(rootPath, options)
+	var stats ProcessStats
+	var discovered atomic.Int64
+	defer func() {
+		notify.emit(Event{Type: EventBatchFinished, Stats: &stats, Total: int(discovered.Load()), Err: err})
+	}()
 
 	// Determine number of workers
 	numWorkers := options.NumWorkers
 	if numWorkers <= 0 {
 		numWorkers = runtime.NumCPU()
 	}
-	// Limit workers to number of files if fewer files than workers
-	if numWorkers > len(webpFiles) {
-		numWorkers = len(webpFiles)
+	b := newBatch(numWorkers, options.MaxMemory)
+	defer b.removeAbandoned()
+
+	// With fail-fast, the first failure cancels ctx and the workers skip the rest
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	stop := func() {}
+	if options.FailFast {
+		stop = cancel
 	}
 
-	fmt.Printf("Found %d WebP file(s), using %d worker(s)\n\n", len(webpFiles), numWorkers)
+	// Bounded queues keep the walk just ahead of the workers
+	jobs := make(chan ConversionJob, numWorkers*jobQueueFactor)
+	results := make(chan ConversionResult, numWorkers)
 
-	// Phase 2: Create channels and worker pool
-	jobs := make(chan ConversionJob, len(webpFiles))
-	results := make(chan ConversionResult, len(webpFiles))
-
-	// Phase 3: Start workers
+	// Phase 1: Walk the tree, queueing files as they are found. The walker
+	// counts in wg, so results stays open for the file it may have to skip.
 	var wg sync.WaitGroup
+	notify.emit(Event{Type: EventScanStarted})
+	mismatches := 0
+	walkDone := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		err := walkWebPFiles(rootPath, options, func(path string, info os.FileInfo, fileOptions *ProcessOptions) error {
+			if ctx.Err() != nil {
+				return errWalkStopped
+			}
+			n := discovered.Add(1)
+			notify.emit(Event{Type: EventFileDiscovered, Path: path, Total: int(n)})
+			select {
+			case jobs <- ConversionJob{Path: path, FileInfo: info, options: fileOptions}:
+				return nil
+			case <-ctx.Done():
+				// Announced but never queued: finish it as the workers would
+				results <- ConversionResult{Path: path, Skipped: true}
+				return errWalkStopped
+			}
+		}, func(path string, format string) {
+			mismatches++
+			notify.emit(Event{Type: EventExtensionMismatch, Path: path, Format: format})
+		})
+		if err == nil || errors.Is(err, errWalkStopped) {
+			notify.emit(Event{Type: EventScanFinished, Total: int(discovered.Load()), Workers: numWorkers})
+		}
+		walkDone <- err
+	}()
+
+	// Phase 2: Start workers, admitted by the memory budget
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
-		go worker(i, jobs, results, options, &wg)
+		go worker(ctx, i, jobs, results, options, b, stop, notify, &wg)
 	}
-
-	// Start stats collector in a separate goroutine
-	var statsWg sync.WaitGroup
-	statsWg.Add(1)
-	var stats ProcessStats
 	go func() {
-		defer statsWg.Done()
-		stats = collectStats(results, len(webpFiles), true, options.KeepOriginal)
+		wg.Wait()
+		close(results)
 	}()
 
-	// Dispatch jobs
-	for _, file := range webpFiles {
-		jobs <- file
-	}
-	close(jobs)
+	// Phase 3: Collect results until the walk and the workers are done
+	var failures []*FileError
+	stats, failures = collectStats(results, func() int { return int(discovered.Load()) }, notify)
 
-	// Wait for all workers to finish
-	wg.Wait()
-	close(results)
+	walkErr := <-walkDone
+	stats.MismatchCount = mismatches
+	walkStopped := errors.Is(walkErr, errWalkStopped)
+	if walkErr != nil && !walkStopped {
+		return fmt.Errorf("error scanning directory: %w", walkErr)
+	}
 
-	// Wait for stats collection to finish
-	statsWg.Wait()
+	return batchError(failures, stats, interruption(parent, stats, walkStopped))
+}
 
-	// Phase 4: Display summary
-	fmt.Printf("\nSummary:\n")
-	fmt.Printf("  Total converted: %d files\n", stats.TotalProcessed)
-	fmt.Printf("  Static → JPEG: %d\n", stats.StaticCount)
-	fmt.Printf("  Animated → GIF: %d\n", stats.AnimatedCount)
-	fmt.Printf("  Errors: %d\n", stats.ErrorCount)
+// errWalkStopped is returned by a walk callback to end the walk once the
+// batch has stopped; it is not a scan error
+var errWalkStopped = errors.New("walk stopped")
 
-	return nil
+// interruption returns the context error of a batch stopped early by ctx, or
+// nil if it ran to completion. walkStopped reports whether the walk ended
+// before the whole tree was seen.
+func interruption(ctx context.Context, stats ProcessStats, walkStopped bool) error {
+	if stats.SkippedCount == 0 && !walkStopped {
+		return nil
+	}
+	return ctx.Err()
 }
 
-// ProcessDirectory recursively processes all WebP files in a directory
+// ProcessDirectory recursively processes all WebP files in a directory.
+// Files are selected by the walk filters in options. If any file fails, the
+// returned error is a *BatchError listing every failure. Progress is reported
+// to options.Observer.
 func ProcessDirectory(rootPath string, options ProcessOptions) error {
-	var processedCount int
-	var errorCount int
-	var staticCount int
-	var animatedCount int
-
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Check if file is WebP
-		if strings.ToLower(filepath.Ext(path)) != ".webp" {
-			return nil
-		}
-
-		fmt.Printf("Processing: %s\n", path)
-
-		// Convert the file
-		result := convertSingleFile(path, options)
+	return ProcessDirectoryContext(context.Background(), rootPath, options)
+}
 
-		// Handle result
-		if !result.Success {
-			if result.Error != nil {
-				fmt.Printf("  Error: %v\n", result.Error)
-			}
-			errorCount++
-			return nil // Continue processing other files
-		}
+// ProcessDirectoryContext is ProcessDirectory with cancellation. Once ctx is
+// done the file being converted finishes and the walk stops; the returned
+// *BatchError then has Interrupted set. With options.FailFast the walk stops
+// after the first failure.
+func ProcessDirectoryContext(ctx context.Context, rootPath string, options ProcessOptions) (err error) {
+	b := newBatch(1, options.MaxMemory)
+	defer b.removeAbandoned()
+	notify := newNotifier(rootPath, options)
+	var stats ProcessStats
+	var failures []*FileError
+	stopped := false
+	total := 0
+	defer func() {
+		notify.emit(Event{Type: EventBatchFinished, Stats: &stats, Total: total, Err: err})
+	}()
 
-		// Update counters based on type
-		switch result.Type {
-		case native.WebPTypeAnimated:
-			if options.KeepOriginal {
-				fmt.Printf("  Type: Animated → Converted to GIF (original preserved)\n")
-			} else {
-				fmt.Printf("  Type: Animated → Converted to GIF\n")
-			}
-			animatedCount++
-		case native.WebPTypeStatic:
-			if options.KeepOriginal {
-				fmt.Printf("  Type: Static → Converted to JPEG (quality %d, original preserved)\n", options.JPEGQuality)
-			} else {
-				fmt.Printf("  Type: Static → Converted to JPEG (quality %d)\n", options.JPEGQuality)
-			}
-			staticCount++
+	notify.emit(Event{Type: EventScanStarted})
+	err = walkWebPFiles(rootPath, options, func(path string, info os.FileInfo, fileOptions *ProcessOptions) error {
+		if stopped || ctx.Err() != nil {
+			return errWalkStopped
 		}
-
-		processedCount++
-		fmt.Printf("  Successfully converted\n")
-		return nil
+		total++
+		notify.emit(Event{Type: EventFileDiscovered, Path: path, Total: total})
+		notify.emit(Event{Type: EventFileStarted, Path: path})
+		result := convertFile(ctx, path, *fileOptions, b, "")
+		stopped = !result.Success && options.FailFast
+
+		failures = stats.add(result, failures)
+		notify.emit(Event{Type: EventFileFinished, Path: path, Result: &result, Index: total, Total: total})
+		return nil // Continue processing other files
+	}, func(path string, format string) {
+		stats.MismatchCount++
+		notify.emit(Event{Type: EventExtensionMismatch, Path: path, Format: format})
 	})
-	if err != nil {
+	walkStopped := errors.Is(err, errWalkStopped)
+	if err != nil && !walkStopped {
 		return fmt.Errorf("error walking directory: %w", err)
 	}
+	notify.emit(Event{Type: EventScanFinished, Total: total})
 
-	fmt.Printf("\nSummary:\n")
-	fmt.Printf("  Total converted: %d files\n", processedCount)
-	fmt.Printf("  Static → JPEG: %d\n", staticCount)
-	fmt.Printf("  Animated → GIF: %d\n", animatedCount)
-	fmt.Printf("  Errors: %d\n", errorCount)
-
-	return nil
+	return batchError(failures, stats, interruption(ctx, stats, walkStopped))
 }
@@ -1,6 +1,9 @@
 package converter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
@@ -121,6 +124,58 @@ func TestConvertWebPToGIF(t *testing.T) {
 	}
 }
 
+// TestConvertDisguisedOutput tests that a disguised WebP never replaces an
+// unrelated file named like its output, but may replace itself
+func TestConvertDisguisedOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"a.png": staticWebP, // Would become a.jpg, which exists
+		"a.jpg": "real jpeg",
+		"b.png": staticWebP,
+		"c.jpg": staticWebP, // Replaced in place
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := DefaultProcessOptions()
+	options.Detect = DetectContent
+
+	result := convertSingleFile(filepath.Join(tmpDir, "a.png"), options)
+	if !errors.Is(result.Error, ErrOutputExists) || ErrorCause(result.Error) != "output_exists" {
+		t.Errorf("a.png: expected ErrOutputExists, got %v", result.Error)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "a.jpg")); string(data) != "real jpeg" {
+		t.Error("a.jpg was replaced")
+	}
+	if !exist(filepath.Join(tmpDir, "a.png")) {
+		t.Error("a.png was removed")
+	}
+
+	for _, name := range []string{"b.png", "c.jpg"} {
+		if result := convertSingleFile(filepath.Join(tmpDir, name), options); !result.Success {
+			t.Errorf("%s: %v", name, result.Error)
+		}
+	}
+	if !exist(filepath.Join(tmpDir, "b.jpg"), filepath.Join(tmpDir, "c.jpg")) || exist(filepath.Join(tmpDir, "b.png")) {
+		t.Error("b.png or c.jpg not converted")
+	}
+
+	// A file appearing after the check is not replaced either
+	temp := filepath.Join(tmpDir, "d.tmp")
+	if err := os.WriteFile(temp, []byte("output"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := placeOutput(temp, filepath.Join(tmpDir, "a.jpg"), false); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("placeOutput over a.jpg: %v", err)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(tmpDir, "*.jpg.*.tmp")); len(matches) > 0 {
+		t.Errorf("temp files left: %v", matches)
+	}
+}
+
 // TestProcessDirectory tests directory processing
 func TestProcessDirectory(t *testing.T) {
 	// Skip if ffmpeg is not available
@@ -298,3 +353,213 @@ func TestQualityValidation(t *testing.T) {
 		t.Error("Expected error for quality=101, got nil")
 	}
 }
+
+// TestProcessDirectory_BatchError tests that failed files are aggregated into a BatchError
+func TestProcessDirectory_BatchError(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.webp", "b.webp", "c.webp"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("not a webp file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		process      func(string, ProcessOptions) error
+		failFast     bool
+		wantFailures int
+		wantSkipped  int
+	}{
+		{"sequential", ProcessDirectory, false, 3, 0},
+		{"sequential fail-fast", ProcessDirectory, true, 1, 0},
+		{"parallel", ProcessDirectoryParallel, false, 3, 0},
+		{"parallel fail-fast", ProcessDirectoryParallel, true, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := DefaultProcessOptions()
+			options.KeepOriginal = true
+			options.FailFast = tt.failFast
+
+			err := tt.process(tmpDir, options)
+
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) {
+				t.Fatalf("expected *BatchError, got %v", err)
+			}
+			if len(batchErr.Failures) != tt.wantFailures || batchErr.Stats.ErrorCount != tt.wantFailures {
+				t.Errorf("failures = %d (ErrorCount %d), want %d", len(batchErr.Failures), batchErr.Stats.ErrorCount, tt.wantFailures)
+			}
+			if batchErr.Stats.SkippedCount != tt.wantSkipped {
+				t.Errorf("SkippedCount = %d, want %d", batchErr.Stats.SkippedCount, tt.wantSkipped)
+			}
+			if !batchErr.Total() {
+				t.Error("expected a total failure")
+			}
+
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) || filepath.Dir(fileErr.Path) != tmpDir {
+				t.Errorf("expected a *FileError for a file in %s, got %v", tmpDir, fileErr)
+			}
+		})
+	}
+}
+
+// TestProcessDirectory_FailFastPartial tests that a batch stopped by FailFast
+// after a file converted is a partial failure
+func TestProcessDirectory_FailFastPartial(t *testing.T) {
+	for _, process := range []func(string, ProcessOptions) error{ProcessDirectory, ProcessDirectoryParallel} {
+		tmpDir := t.TempDir()
+		for name, data := range map[string]string{"a.webp": staticWebP, "b.webp": "not a webp file", "c.webp": staticWebP} {
+			if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		options := DefaultProcessOptions()
+		options.NumWorkers = 1
+		options.FailFast = true
+
+		var batchErr *BatchError
+		if err := process(tmpDir, options); !errors.As(err, &batchErr) {
+			t.Fatalf("expected *BatchError, got %v", err)
+		}
+		if batchErr.Stats.TotalProcessed < 1 || batchErr.Stats.ErrorCount != 1 || batchErr.Attempted() != batchErr.Stats.TotalProcessed+1 {
+			t.Errorf("stats = %+v, attempted %d", batchErr.Stats, batchErr.Attempted())
+		}
+		if batchErr.Total() {
+			t.Error("failure after a converted file reported as total")
+		}
+	}
+}
+
+// TestProcessDirectoryContext tests that a cancelled context converts no file and returns an interrupted batch
+func TestProcessDirectoryContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.webp", "b.webp", "c.webp"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("not a webp file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		process     func(context.Context, string, ProcessOptions) error
+		wantSkipped int
+	}{
+		{"sequential", ProcessDirectoryContext, 0},
+		{"parallel", ProcessDirectoryParallelContext, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := DefaultProcessOptions()
+			options.NumWorkers = 2
+
+			err := tt.process(ctx, tmpDir, options)
+
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) {
+				t.Fatalf("expected *BatchError, got %v", err)
+			}
+			if !errors.Is(err, context.Canceled) || batchErr.Interrupted == nil {
+				t.Errorf("expected an interrupted batch, got %v", err)
+			}
+			if batchErr.Stats.SkippedCount != tt.wantSkipped || len(batchErr.Failures) != 0 {
+				t.Errorf("SkippedCount = %d, failures = %d, want %d and 0", batchErr.Stats.SkippedCount, len(batchErr.Failures), tt.wantSkipped)
+			}
+
+			// Skipped files are left untouched
+			matches, _ := filepath.Glob(filepath.Join(tmpDir, "*"))
+			if len(matches) != 3 {
+				t.Errorf("directory has %d files after cancellation, want 3: %v", len(matches), matches)
+			}
+		})
+	}
+}
+
+// TestProcessDirectoryParallelContext_StopsWalk tests that cancelling a batch
+// stops the walk and that only the files it queued are reported
+func TestProcessDirectoryParallelContext_StopsWalk(t *testing.T) {
+	tmpDir := t.TempDir()
+	const numFiles = 50
+	for i := 0; i < numFiles; i++ {
+		if err := os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%02d.webp", i)), []byte("not a webp file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	options := DefaultProcessOptions()
+	options.NumWorkers = 1
+	var discovered, finished, total int
+	options.Observer = ObserverFunc(func(e Event) {
+		switch e.Type {
+		case EventFileDiscovered:
+			discovered++
+		case EventFileFinished:
+			finished++
+			cancel()
+		case EventBatchFinished:
+			total = e.Total
+		}
+	})
+
+	err := ProcessDirectoryParallelContext(ctx, tmpDir, options)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Interrupted == nil {
+		t.Fatalf("expected an interrupted batch, got %v", err)
+	}
+	if discovered >= numFiles {
+		t.Errorf("walk discovered all %d files after cancellation", discovered)
+	}
+	stats := batchErr.Stats
+	if finished != discovered || total != discovered || stats.ErrorCount+stats.SkippedCount != discovered {
+		t.Errorf("discovered %d, finished %d, total %d, failed %d + skipped %d", discovered, finished, total, stats.ErrorCount, stats.SkippedCount)
+	}
+}
+
+// TestProcessDirectoryParallel_Streaming tests the pipeline with more files than its queues hold
+func TestProcessDirectoryParallel_Streaming(t *testing.T) {
+	tmpDir := t.TempDir()
+	const numFiles = 50
+	for i := 0; i < numFiles; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("d%d", i%5), fmt.Sprintf("f%02d.webp", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("not a webp file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	options := DefaultProcessOptions()
+	options.NumWorkers = 2
+	lastIndex, scanTotal := 0, 0
+	options.Observer = ObserverFunc(func(e Event) {
+		switch e.Type {
+		case EventFileFinished:
+			if e.Index != lastIndex+1 || e.Total < e.Index {
+				t.Errorf("file finished %d/%d after %d", e.Index, e.Total, lastIndex)
+			}
+			lastIndex = e.Index
+		case EventScanFinished:
+			scanTotal = e.Total
+		}
+	})
+
+	err := ProcessDirectoryParallel(tmpDir, options)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Stats.ErrorCount != numFiles {
+		t.Fatalf("expected %d failures, got %v", numFiles, err)
+	}
+	if scanTotal != numFiles || lastIndex != numFiles {
+		t.Errorf("scan total = %d, last index = %d, want %d", scanTotal, lastIndex, numFiles)
+	}
+}
@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Failures of the conversion machinery rather than of the file contents
+var (
+	ErrTimeout       = errors.New("conversion timed out")
+	ErrWorkerCrashed = errors.New("worker crashed")
+	ErrOutputExists  = errors.New("output file already exists")
+)
+
+// ErrorCause returns a short name for the category of err, for grouping
+// failures in reports and logs. It adds "panic", "timeout", "crash" and
+// "output_exists" to the causes of native.ErrorCause.
+func ErrorCause(err error) string {
+	var remote *remoteError
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &remote):
+		return remote.cause
+	case errors.As(err, &panicErr):
+		return "panic"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrWorkerCrashed):
+		return "crash"
+	case errors.Is(err, ErrOutputExists):
+		return "output_exists"
+	}
+	return native.ErrorCause(err)
+}
+
+// FileError is a conversion failure of a single file
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by the Process functions when one or more files
+// failed or the batch was interrupted. Files that converted successfully are
+// kept; Stats describes the whole batch.
+type BatchError struct {
+	Failures    []*FileError // In the order the failures were observed
+	Stats       ProcessStats
+	Interrupted error // Context error that stopped the batch early, if any
+}
+
+func (e *BatchError) Error() string {
+	attempted := e.Attempted()
+	if e.Interrupted != nil {
+		return fmt.Sprintf("interrupted: %d of %d file(s) failed (%d not processed): %v",
+			len(e.Failures), attempted, e.Stats.SkippedCount, e.Interrupted)
+	}
+	msg := fmt.Sprintf("%d of %d file(s) failed", len(e.Failures), attempted)
+	if e.Stats.SkippedCount > 0 {
+		msg += fmt.Sprintf(", stopped after first error (%d not processed)", e.Stats.SkippedCount)
+	}
+	return msg
+}
+
+// Unwrap returns the interruption cause and the per-file errors, so errors.Is
+// and errors.As look through them
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures)+1)
+	if e.Interrupted != nil {
+		errs = append(errs, e.Interrupted)
+	}
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// Attempted returns the number of files converted or failed. Files skipped
+// after the batch stopped early are not counted.
+func (e *BatchError) Attempted() int {
+	return e.Stats.TotalProcessed + e.Stats.ErrorCount
+}
+
+// Total reports whether every attempted file failed. A batch stopped by
+// FailFast after some files converted is a partial failure.
+func (e *BatchError) Total() bool {
+	return e.Stats.ErrorCount > 0 && e.Stats.ErrorCount == e.Attempted()
+}
+
+// batchError returns the aggregate error for a finished batch, or nil if
+// nothing failed and the batch was not interrupted
+func batchError(failures []*FileError, stats ProcessStats, interrupted error) error {
+	if len(failures) == 0 && interrupted == nil {
+		return nil
+	}
+	return &BatchError{Failures: failures, Stats: stats, Interrupted: interrupted}
+}
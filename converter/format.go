@@ -0,0 +1,176 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Output formats used when ProcessOptions.Format is empty
+const (
+	DefaultStaticFormat   = "jpeg"
+	DefaultAnimatedFormat = "gif"
+)
+
+// analysis is what classifying a file must find out by decoding it
+type analysis int
+
+const (
+	analyzeNone    analysis = iota // The header facts decide the output format
+	analyzeStill                   // Only whether an animation changes, see native.IsStillWebPFile
+	analyzeContent                 // Everything of native.ContentInfo
+)
+
+// needsAnalysis returns what must be decoded to route a file: whether its
+// alpha is used, if its header flags alpha, whether an animation changes and
+// how many colors it has. A fact is only looked for if it can change the
+// output format.
+func needsAnalysis(info *native.WebPInfo, options ProcessOptions) analysis {
+	if !options.Analyze {
+		return analyzeNone
+	}
+	facts := Classify(info, nil)
+	animated := facts.Type == native.WebPTypeAnimated
+	route := func(webpType native.WebPType, alpha bool, colors int) string {
+		f := *facts
+		f.Type, f.HasAlpha, f.Colors = webpType, alpha, colors
+		enc, err := selectEncoder(&f, options)
+		if err != nil {
+			return ""
+		}
+		return enc.Name()
+	}
+
+	if facts.HasAlpha {
+		if route(facts.Type, true, 0) != route(facts.Type, false, 0) ||
+			animated && route(native.WebPTypeStatic, true, 0) != route(native.WebPTypeStatic, false, 0) {
+			return analyzeContent
+		}
+	}
+	// Rules on colors only match counted colors
+	for _, webpType := range []native.WebPType{facts.Type, native.WebPTypeStatic} {
+		if route(webpType, facts.HasAlpha, 1) != route(webpType, facts.HasAlpha, native.MaxPaletteColors+1) {
+			return analyzeContent
+		}
+	}
+	if animated && route(native.WebPTypeAnimated, facts.HasAlpha, 0) != route(native.WebPTypeStatic, facts.HasAlpha, 0) {
+		return analyzeStill
+	}
+	return analyzeNone
+}
+
+// stillContent is the content of a file of which decoding only found out
+// whether it is still; the header flags stand for the rest
+func stillContent(info *native.WebPInfo, still bool) *native.ContentInfo {
+	content := &native.ContentInfo{FrameCount: info.FrameCount, DistinctFrames: 2, UsesAlpha: info.HasAlpha}
+	if still {
+		content.DistinctFrames = 1
+	}
+	return content
+}
+
+// Classify returns the facts the converter routes a file by: its header
+// info, with the decoded content, if any, overriding the header flags and
+// giving the color count. An animation that displays as a single picture is
+// classified as static, which a one-frame animation is from its header alone.
+func Classify(info *native.WebPInfo, content *native.ContentInfo) *native.WebPInfo {
+	facts := *info
+	if facts.Type == native.WebPTypeAnimated && facts.FrameCount == 1 {
+		facts.Type = native.WebPTypeStatic
+	}
+	if content != nil {
+		if content.Still() {
+			facts.Type = native.WebPTypeStatic
+		}
+		facts.HasAlpha = content.UsesAlpha
+		facts.Colors = content.Colors
+	}
+	return &facts
+}
+
+// selectEncoder returns the encoder for a WebP file described by info: the
+// format of the first matching rule of options.Rules, else options.Format if
+// set, else the default format for its type. For an animation, rules and a
+// Format whose encoder cannot write animations are passed over, so
+// "-format jpeg" converts the still images of a mixed tree and leaves the
+// animations to the default.
+func selectEncoder(info *native.WebPInfo, options ProcessOptions) (native.Encoder, error) {
+	for i := range options.Rules {
+		if !options.Rules[i].Match(info) {
+			continue
+		}
+		enc, err := lookupEncoder(options.Rules[i].Format)
+		if err != nil {
+			return nil, err
+		}
+		if canEncode(enc, info) {
+			return enc, nil
+		}
+	}
+
+	if options.Format != "" {
+		enc, err := lookupEncoder(options.Format)
+		if err != nil {
+			return nil, err
+		}
+		if canEncode(enc, info) {
+			return enc, nil
+		}
+	}
+
+	name := defaultFormat(info.Type)
+	if name == "" {
+		return nil, fmt.Errorf("unknown WebP type")
+	}
+	return lookupEncoder(name)
+}
+
+// lookupEncoder returns the registered encoder of an output format
+func lookupEncoder(name string) (native.Encoder, error) {
+	enc, ok := native.LookupEncoder(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q (available: %s)", name, strings.Join(native.EncoderNames(), ", "))
+	}
+	return enc, nil
+}
+
+// canEncode reports whether enc keeps what a file displays: every frame of
+// an animation
+func canEncode(enc native.Encoder, info *native.WebPInfo) bool {
+	return info.Type != native.WebPTypeAnimated || enc.SupportsAnimation()
+}
+
+// defaultFormat returns the output format of a WebP type when
+// ProcessOptions.Format is empty ("" for an unknown type)
+func defaultFormat(webpType native.WebPType) string {
+	switch webpType {
+	case native.WebPTypeAnimated:
+		return DefaultAnimatedFormat
+	case native.WebPTypeStatic:
+		return DefaultStaticFormat
+	}
+	return ""
+}
+
+// encodeOptions returns the encoder settings of options
+func encodeOptions(options ProcessOptions) native.EncodeOptions {
+	return native.EncodeOptions{Quality: options.JPEGQuality, Limits: options.Limits}
+}
+
+// outputPathFor returns the output path of a WebP file written by enc: the
+// same name with the encoder's extension, plus a "_converted" suffix when
+// the original is kept
+func outputPathFor(path string, enc native.Encoder, options ProcessOptions) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if options.KeepOriginal {
+		base += "_converted"
+	}
+	return base + enc.Extensions()[0]
+}
+
+// formatLabel returns the name of an output format for text output, e.g. "JPEG"
+func formatLabel(name string) string {
+	return strings.ToUpper(name)
+}
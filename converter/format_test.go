@@ -0,0 +1,162 @@
+package converter
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// TestSelectEncoder tests the default routing and the Format override,
+// which animations only follow to an encoder that keeps their frames
+func TestSelectEncoder(t *testing.T) {
+	static := &native.WebPInfo{Type: native.WebPTypeStatic}
+	animated := &native.WebPInfo{Type: native.WebPTypeAnimated}
+
+	tests := []struct {
+		info    *native.WebPInfo
+		format  string
+		want    string
+		wantErr string
+	}{
+		{static, "", "jpeg", ""},
+		{animated, "", "gif", ""},
+		{static, "gif", "gif", ""},
+		{animated, "jpeg", "gif", ""},
+		{animated, "apng", "apng", ""},
+		{static, "tiff", "", "unknown output format"},
+		{&native.WebPInfo{}, "", "", "unknown WebP type"},
+	}
+	for _, tt := range tests {
+		options := DefaultProcessOptions()
+		options.Format = tt.format
+		enc, err := selectEncoder(tt.info, options)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%v/%q: err = %v, want %q", tt.info.Type, tt.format, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || enc.Name() != tt.want {
+			t.Errorf("%v/%q: got %v, %v, want %s", tt.info.Type, tt.format, enc, err, tt.want)
+		}
+	}
+}
+
+// TestConvertSingleFileFormat tests a static file converted with Format set
+func TestConvertSingleFileFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "still.webp")
+	if err := os.WriteFile(path, []byte(staticWebP), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultProcessOptions()
+	options.Format = "gif"
+	options.KeepOriginal = true
+	result := convertSingleFile(path, options)
+	if !result.Success {
+		t.Fatalf("conversion failed: %v", result.Error)
+	}
+	if want := filepath.Join(dir, "still_converted.gif"); result.FilePath != want || result.Format != "gif" {
+		t.Errorf("output %s (%s), want %s (gif)", result.FilePath, result.Format, want)
+	}
+}
+
+// stillAnimations returns a one-frame animation and an animation whose two
+// frames are the same, built from animatedWebP
+func stillAnimations() (single, repeated []byte) {
+	const anmfSize = 8 + 0x24
+	frames := len(animatedWebP) - 2*anmfSize
+	first := animatedWebP[frames : frames+anmfSize]
+
+	single = []byte(animatedWebP[:frames] + first)
+	binary.LittleEndian.PutUint32(single[4:], uint32(len(single)-8))
+	repeated = []byte(animatedWebP[:frames] + first + first)
+	return single, repeated
+}
+
+// TestConvertSingleFileClassified tests animations that display a single
+// picture, converted as static images
+func TestConvertSingleFileClassified(t *testing.T) {
+	single, repeated := stillAnimations()
+	tests := []struct {
+		name    string
+		data    []byte
+		analyze bool
+		want    native.WebPType
+		ext     string
+	}{
+		{"one frame", single, false, native.WebPTypeStatic, ".jpg"},
+		{"identical frames", repeated, true, native.WebPTypeStatic, ".jpg"},
+		{"identical frames, headers only", repeated, false, native.WebPTypeAnimated, ".gif"},
+		{"changing frames", []byte(animatedWebP), true, native.WebPTypeAnimated, ".gif"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "anim.webp")
+		if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		options := DefaultProcessOptions()
+		options.Analyze = tt.analyze
+		result := convertSingleFile(path, options)
+		if !result.Success {
+			t.Errorf("%s: conversion failed: %v", tt.name, result.Error)
+			continue
+		}
+		if result.Type != tt.want || filepath.Ext(result.FilePath) != tt.ext {
+			t.Errorf("%s: %v to %s, want %v to %s", tt.name, result.Type, result.FilePath, tt.want, tt.ext)
+		}
+	}
+}
+
+// TestClassify tests header facts overridden by content
+func TestClassify(t *testing.T) {
+	info := &native.WebPInfo{Type: native.WebPTypeAnimated, FrameCount: 4, HasAlpha: true}
+	still := &native.WebPInfo{Type: native.WebPTypeStatic, FrameCount: 1, HasAlpha: true}
+	opaque := &native.WebPInfo{Type: native.WebPTypeAnimated, FrameCount: 4, Duration: 400}
+	long := &native.WebPInfo{Type: native.WebPTypeAnimated, FrameCount: 4, Duration: 20000}
+	options := DefaultProcessOptions()
+	auto := DefaultProcessOptions()
+	auto.Rules = AutoRules()
+	off := DefaultProcessOptions()
+	off.Analyze = false
+	gif := DefaultProcessOptions()
+	gif.Format = "gif"
+
+	tests := []struct {
+		name    string
+		info    *native.WebPInfo
+		options ProcessOptions
+		want    analysis
+	}{
+		{"animation", info, options, analyzeStill},
+		{"animation with alpha rules", info, auto, analyzeContent},
+		{"still with alpha", still, options, analyzeNone},
+		{"still with alpha rules", still, auto, analyzeContent},
+		{"animation with color rules", opaque, auto, analyzeContent},
+		{"long animation with color rules", long, auto, analyzeStill},
+		{"single format", info, gif, analyzeNone},
+		{"analyze off", info, off, analyzeNone},
+	}
+	for _, tt := range tests {
+		if got := needsAnalysis(tt.info, tt.options); got != tt.want {
+			t.Errorf("%s: needsAnalysis = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	facts := Classify(info, &native.ContentInfo{FrameCount: 4, DistinctFrames: 1, Colors: 12})
+	if facts.Type != native.WebPTypeStatic || facts.HasAlpha || facts.Colors != 12 {
+		t.Errorf("classify = %v, alpha %v, %d colors, want static without alpha, 12 colors", facts.Type, facts.HasAlpha, facts.Colors)
+	}
+	if info.Type != native.WebPTypeAnimated || !info.HasAlpha {
+		t.Error("classify modified the header info")
+	}
+	if facts := Classify(info, nil); facts.Type != native.WebPTypeAnimated || !facts.HasAlpha {
+		t.Errorf("classify without content = %v, alpha %v, want the header facts", facts.Type, facts.HasAlpha)
+	}
+}
@@ -0,0 +1,184 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PanicError is a panic recovered while converting a file
+type PanicError struct {
+	Value any    // Value passed to panic
+	Stack []byte // Stack trace of the panicking goroutine
+}
+
+func newPanicError(v any) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error, e.g. a runtime.Error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// callRecovered calls fn, turning a panic into a *PanicError
+func callRecovered(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = newPanicError(v)
+		}
+	}()
+	return fn()
+}
+
+// batch holds what the conversions of one Process call share: the worker
+// slots and memory budget they run in, and the temp files of the conversions
+// abandoned after their timeout. An abandoned conversion hands its slot and
+// memory to the next file while fewer than one per worker are abandoned;
+// beyond that it keeps them until it actually returns, so hung native calls
+// never exceed twice the workers.
+type batch struct {
+	slots      chan struct{}    // One per worker
+	overcommit chan struct{}    // One per abandoned conversion running without a slot
+	sched      *memoryScheduler // nil: no memory budget
+
+	mu        sync.Mutex
+	abandoned map[string]struct{} // Temp files of abandoned conversions still running
+}
+
+// newBatch returns the state of a batch run by workers with a memory
+// budget of maxMemory bytes (0: unlimited)
+func newBatch(workers int, maxMemory int64) *batch {
+	return &batch{
+		slots:      make(chan struct{}, max(workers, 1)),
+		overcommit: make(chan struct{}, max(workers, 1)),
+		sched:      newMemoryScheduler(maxMemory),
+		abandoned:  make(map[string]struct{}),
+	}
+}
+
+// acquire waits for a worker slot and for cost bytes of the memory budget,
+// or returns the error of ctx once it is done. release returns both.
+func (b *batch) acquire(ctx context.Context, cost int64) (release func(), err error) {
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.sched != nil {
+		if err := b.sched.acquire(ctx, cost); err != nil {
+			<-b.slots
+			return nil, err
+		}
+	}
+	return func() {
+		if b.sched != nil {
+			b.sched.release(cost)
+		}
+		<-b.slots
+	}, nil
+}
+
+// abandon is called when a conversion holding release is abandoned. While
+// the overcommit allows, it releases the slot and memory for the next file
+// at once; otherwise they stay held. The returned function is called once
+// the abandoned conversion returns.
+func (b *batch) abandon(release func()) (returned func()) {
+	if b == nil {
+		return release
+	}
+	select {
+	case b.overcommit <- struct{}{}:
+		release()
+		return func() { <-b.overcommit }
+	default:
+		return release
+	}
+}
+
+// trackAbandoned records the temp file of an abandoned conversion. Temp
+// files are named per attempt, so a retry of the same file is not confused
+// with it.
+func (b *batch) trackAbandoned(path string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.abandoned[path] = struct{}{}
+}
+
+// untrackAbandoned removes the temp file of an abandoned conversion that returned
+func (b *batch) untrackAbandoned(path string) {
+	if b != nil {
+		b.mu.Lock()
+		delete(b.abandoned, path)
+		b.mu.Unlock()
+	}
+	os.Remove(path)
+}
+
+// removeAbandoned removes the temp files of the abandoned conversions still
+// running. The Process functions call it before returning, since the program
+// may exit before the conversions do.
+func (b *batch) removeAbandoned() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for path := range b.abandoned {
+		os.Remove(path)
+	}
+}
+
+// tempSeq numbers the conversion attempts of the process
+var tempSeq atomic.Uint64
+
+// newTempTag returns a tag naming the temp file of a conversion attempt,
+// unique among the attempts of running processes
+func newTempTag() string {
+	return fmt.Sprintf("%d-%d", os.Getpid(), tempSeq.Add(1))
+}
+
+// tempPathFor returns the temp file an attempt writes outputPath to
+func tempPathFor(outputPath, tag string) string {
+	return outputPath + "." + tag + ".tmp"
+}
+
+// runGuarded calls fn, turning a panic into a *PanicError. With a positive
+// timeout, fn runs in its own goroutine and is abandoned once the timeout
+// expires: runGuarded then calls abandon, returns an ErrTimeout error, and
+// calls the function abandon returned after fn eventually returns. Native
+// code cannot be interrupted, so an abandoned call keeps running (and holding
+// its memory) until it returns.
+func runGuarded(timeout time.Duration, fn func() error, abandon func() (returned func())) error {
+	if timeout <= 0 {
+		return callRecovered(fn)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- callRecovered(fn)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		returned := abandon()
+		go func() {
+			<-done
+			returned()
+		}()
+		return fmt.Errorf("%w after %v", ErrTimeout, timeout)
+	}
+}
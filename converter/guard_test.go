@@ -0,0 +1,182 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunGuarded tests panic recovery and abandonment on timeout
+func TestRunGuarded(t *testing.T) {
+	// A panic becomes a *PanicError with the stack of the panic
+	err := runGuarded(0, func() error {
+		var s []int
+		_ = s[3]
+		return nil
+	}, nil)
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || ErrorCause(err) != "panic" {
+		t.Fatalf("got %v, want *PanicError", err)
+	}
+	var runtimeErr runtime.Error
+	if !errors.As(err, &runtimeErr) {
+		t.Errorf("panic value %v not unwrapped", panicErr.Value)
+	}
+	if !strings.Contains(string(panicErr.Stack), "TestRunGuarded") {
+		t.Errorf("stack does not show the panicking function:\n%s", panicErr.Stack)
+	}
+
+	// Also with a timeout, where fn runs in its own goroutine
+	err = runGuarded(time.Second, func() error { panic("boom") }, nil)
+	if !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("got %v, want *PanicError", err)
+	}
+
+	// A call that outlives its timeout is abandoned, and cleaned up once it returns
+	unblock := make(chan struct{})
+	abandoned := make(chan struct{})
+	err = runGuarded(10*time.Millisecond, func() error {
+		<-unblock
+		return nil
+	}, func() func() { return func() { close(abandoned) } })
+	if !errors.Is(err, ErrTimeout) || ErrorCause(err) != "timeout" {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	select {
+	case <-abandoned:
+		t.Fatal("abandon called before the call returned")
+	default:
+	}
+	close(unblock)
+	<-abandoned
+
+	// Timeouts are counted in the stats
+	var stats ProcessStats
+	stats.add(ConversionResult{Path: "a.webp", Error: err}, nil)
+	if stats.ErrorCount != 1 || stats.TimeoutCount != 1 {
+		t.Errorf("stats = %+v, want 1 error and 1 timeout", stats)
+	}
+}
+
+// TestBatchAbandoned tests that an abandoned conversion hands its worker
+// slot on within the overcommit, keeps it beyond, and that each batch cleans
+// up only its own temp files
+func TestBatchAbandoned(t *testing.T) {
+	b := newBatch(1, 0)
+	unblock := make(chan struct{})
+	var returned []chan struct{}
+	hang := func() {
+		release, err := b.acquire(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		returned = append(returned, done)
+		err = runGuarded(10*time.Millisecond, func() error {
+			<-unblock
+			return nil
+		}, func() func() {
+			finish := b.abandon(release)
+			return func() {
+				finish()
+				close(done)
+			}
+		})
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("got %v, want ErrTimeout", err)
+		}
+	}
+
+	// The first abandoned call frees its slot, the second keeps it
+	hang()
+	hang()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.acquire(ctx, 0); err == nil {
+		t.Error("slot given out beyond the overcommit")
+	}
+	close(unblock)
+	for _, done := range returned {
+		<-done
+	}
+	if release, err := b.acquire(context.Background(), 0); err != nil {
+		t.Errorf("slot not returned: %v", err)
+	} else {
+		release()
+	}
+	if len(b.overcommit) != 0 {
+		t.Errorf("overcommit not returned: %d", len(b.overcommit))
+	}
+
+	// Attempts at the same output write different temp files
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a.jpg")
+	first, retry := tempPathFor(output, newTempTag()), tempPathFor(output, newTempTag())
+	if first == retry {
+		t.Fatalf("two attempts share the temp file %s", first)
+	}
+	other := newBatch(1, 0)
+	for _, path := range []string{first, retry} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.trackAbandoned(first)
+	other.trackAbandoned(retry)
+	b.removeAbandoned()
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("abandoned temp file left: %v", err)
+	}
+	if _, err := os.Stat(retry); err != nil {
+		t.Errorf("temp file of another batch removed: %v", err)
+	}
+}
+
+// TestHungConversion tests that a conversion hung past its timeout does not
+// block the next file of a sequential batch
+func TestHungConversion(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.webp", "b.webp"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(staticWebP), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unblock := make(chan struct{})
+	returned := make(chan struct{})
+	testHookConvert = func(path string) error {
+		if filepath.Base(path) != "a.webp" {
+			return nil
+		}
+		defer close(returned)
+		<-unblock
+		return errors.New("hung")
+	}
+	defer func() {
+		close(unblock)
+		<-returned
+		testHookConvert = nil
+	}()
+
+	options := DefaultProcessOptions()
+	options.FileTimeout = 50 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- ProcessDirectory(tmpDir, options) }()
+
+	select {
+	case err := <-done:
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || batchErr.Stats.TimeoutCount != 1 || batchErr.Stats.TotalProcessed != 1 {
+			t.Errorf("expected one timeout and one converted file, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hung conversion blocked the batch")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "b.jpg")); err != nil {
+		t.Errorf("next file not converted: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// IsolateWorkerEnv is set in the environment of isolate mode child processes.
+// Programs using ProcessOptions.Isolate must call ServeIsolateWorker first
+// thing in main when IsIsolateWorker reports true.
+const IsolateWorkerEnv = "WEBPCONVERT_ISOLATE_WORKER"
+
+// isolateResponseEnv tells a child process the file descriptor of the pipe
+// its response goes to, kept apart from stdout where native libraries and
+// stray prints may write
+const isolateResponseEnv = "WEBPCONVERT_ISOLATE_RESPONSE_FD"
+
+// isolateResponseFD is the descriptor of the response pipe: the first of
+// exec.Cmd.ExtraFiles, which Windows does not support
+const isolateResponseFD = 3
+
+// maxWorkerStderr bounds the child output kept for crash reports
+const maxWorkerStderr = 64 << 10
+
+// isolateRequest is sent to a child process on its stdin
+type isolateRequest struct {
+	Path         string        `json:"path"`
+	JPEGQuality  int           `json:"jpeg_quality"`
+	Format       string        `json:"format"`
+	Rules        []Rule        `json:"rules"`
+	Analyze      bool          `json:"analyze"`
+	KeepOriginal bool          `json:"keep_original"`
+	Limits       native.Limits `json:"limits"`
+	MaxMemory    int64         `json:"max_memory"`  // RLIMIT_AS in bytes (0: none)
+	CPUSeconds   int64         `json:"cpu_seconds"` // RLIMIT_CPU (0: none)
+	TempTag      string        `json:"temp_tag"`    // Names the temp file, so the parent can remove it after a crash
+}
+
+// isolateResponse is written by a child process on its response pipe, or
+// on its stdout where there is none
+type isolateResponse struct {
+	Success      bool            `json:"success"`
+	Type         native.WebPType `json:"type"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	FrameCount   int             `json:"frame_count"`
+	AnimDuration time.Duration   `json:"anim_duration"`
+	FilePath     string          `json:"file_path"`
+	Format       string          `json:"format"`
+	Quality      int             `json:"quality"`
+	BytesOut     int64           `json:"bytes_out"`
+	Error        string          `json:"error,omitempty"`
+	Cause        string          `json:"cause,omitempty"`
+}
+
+// IsIsolateWorker reports whether this process was started as an isolate mode child
+func IsIsolateWorker() bool {
+	return os.Getenv(IsolateWorkerEnv) == "1"
+}
+
+// ServeIsolateWorker converts the single file requested on r and writes the
+// result to w, or to the response pipe opened by the parent where the
+// platform supports one, so that output of native code on stdout cannot
+// corrupt it. It applies the resource limits of the request to the current
+// process first, and ignores SIGINT and SIGTERM so that an interrupted batch
+// lets the file finish.
+func ServeIsolateWorker(r io.Reader, w io.Writer) error {
+	signal.Ignore(os.Interrupt, syscall.SIGTERM)
+	if os.Getenv(isolateResponseEnv) == strconv.Itoa(isolateResponseFD) {
+		pipe := os.NewFile(isolateResponseFD, "isolate-response")
+		defer pipe.Close()
+		w = pipe
+	}
+
+	var req isolateRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return fmt.Errorf("reading isolate request: %w", err)
+	}
+	if err := setWorkerLimits(req.MaxMemory, req.CPUSeconds); err != nil {
+		return fmt.Errorf("setting resource limits: %w", err)
+	}
+
+	options := DefaultProcessOptions()
+	options.JPEGQuality = req.JPEGQuality
+	options.Format = req.Format
+	options.Rules = req.Rules
+	options.Analyze = req.Analyze
+	options.KeepOriginal = req.KeepOriginal
+	options.Limits = req.Limits
+	result := convertFile(context.Background(), req.Path, options, nil, req.TempTag)
+
+	resp := isolateResponse{
+		Success:      result.Success,
+		Type:         result.Type,
+		Width:        result.Width,
+		Height:       result.Height,
+		FrameCount:   result.FrameCount,
+		AnimDuration: result.AnimDuration,
+		FilePath:     result.FilePath,
+		Format:       result.Format,
+		Quality:      result.Quality,
+		BytesOut:     result.BytesOut,
+	}
+	if !result.Success {
+		err := resultError(result)
+		resp.Error = err.Error()
+		resp.Cause = ErrorCause(err)
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// convertIsolated converts a file in a child process running this executable.
+// result holds what the parent already read from the headers; crashes and
+// timeouts of the child become the error of the file.
+func convertIsolated(path string, options ProcessOptions, result ConversionResult) ConversionResult {
+	exe, err := os.Executable()
+	if err != nil {
+		result.Error = fmt.Errorf("isolate: %w", err)
+		return result
+	}
+
+	req := isolateRequest{
+		Path:         path,
+		JPEGQuality:  options.JPEGQuality,
+		Format:       options.Format,
+		Rules:        options.Rules,
+		Analyze:      options.Analyze,
+		KeepOriginal: options.KeepOriginal,
+		Limits:       options.Limits,
+		MaxMemory:    options.IsolateMemory,
+		TempTag:      newTempTag(),
+	}
+	if options.FileTimeout > 0 {
+		// CPU time backs up the wall-clock timeout should the parent die
+		req.CPUSeconds = int64(options.FileTimeout.Round(time.Second)/time.Second) + 1
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		result.Error = fmt.Errorf("isolate: %w", err)
+		return result
+	}
+
+	// The batch context is not used: cancellation lets in-flight files finish
+	ctx := context.Background()
+	if options.FileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.FileTimeout)
+		defer cancel()
+	}
+
+	var response bytes.Buffer
+	stdout := &limitedBuffer{max: maxWorkerStderr}
+	stderr := &limitedBuffer{max: maxWorkerStderr}
+	cmd := exec.CommandContext(ctx, exe)
+	cmd.Env = append(os.Environ(), IsolateWorkerEnv+"=1")
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &response
+	cmd.Stderr = stderr
+
+	// The response comes on its own pipe where there is one; stdout is then
+	// only kept for the debug log
+	var pipe *os.File
+	if runtime.GOOS != "windows" {
+		r, w, err := os.Pipe()
+		if err != nil {
+			result.Error = fmt.Errorf("isolate: %w", err)
+			return result
+		}
+		defer r.Close()
+		pipe = r
+		cmd.ExtraFiles = []*os.File{w}
+		cmd.Env = append(cmd.Env, isolateResponseEnv+"="+strconv.Itoa(isolateResponseFD))
+		cmd.Stdout = stdout
+	}
+
+	runErr := cmd.Start()
+	if pipe != nil {
+		// Only the child holds the write end, so reading ends when it exits
+		cmd.ExtraFiles[0].Close()
+		if runErr == nil {
+			io.Copy(&response, pipe)
+		}
+	}
+	if runErr == nil {
+		runErr = cmd.Wait()
+	}
+
+	var resp isolateResponse
+	if runErr == nil {
+		if err := json.Unmarshal(response.Bytes(), &resp); err != nil {
+			runErr = fmt.Errorf("invalid worker response: %w", err)
+		}
+	}
+	if runErr != nil {
+		// A killed child may leave its temp file behind, for whichever
+		// encoder it chose. Once the original is gone, the child renamed
+		// its output and nothing is left to clean up.
+		if _, err := os.Stat(path); err == nil {
+			for _, enc := range native.Encoders() {
+				os.Remove(tempPathFor(outputPathFor(path, enc, options), req.TempTag))
+			}
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Error = fmt.Errorf("%w after %v", ErrTimeout, options.FileTimeout)
+		} else {
+			result.Error = &WorkerCrashError{Err: runErr, Output: stderr.firstLine()}
+		}
+		options.logger().Debug("isolated worker failed", "path", path, "error", runErr, "stdout", stdout.String(), "stderr", stderr.String())
+		return result
+	}
+
+	result.Success = resp.Success
+	result.Type = resp.Type
+	result.Width, result.Height = resp.Width, resp.Height
+	result.FrameCount = resp.FrameCount
+	result.AnimDuration = resp.AnimDuration
+	result.FilePath = resp.FilePath
+	result.Format = resp.Format
+	result.Quality = resp.Quality
+	result.BytesOut = resp.BytesOut
+	if !resp.Success {
+		result.Error = &remoteError{msg: resp.Error, cause: resp.Cause}
+	}
+	return result
+}
+
+// remoteError is an error reported by a child process. It keeps the cause
+// computed by the child and matches the sentinel of that cause, if any.
+type remoteError struct {
+	msg   string
+	cause string
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return causeErrors[e.cause]
+}
+
+// causeErrors maps the causes reported by ErrorCause back to their sentinels
+var causeErrors = map[string]error{
+	"limit_exceeded":      native.ErrLimitExceeded,
+	"out_of_memory":       native.ErrOutOfMemory,
+	"corrupt_bitstream":   native.ErrCorruptBitstream,
+	"truncated":           native.ErrTruncated,
+	"unsupported_feature": native.ErrUnsupportedFeature,
+	"encode":              native.ErrEncode,
+	"timeout":             ErrTimeout,
+	"crash":               ErrWorkerCrashed,
+	"output_exists":       ErrOutputExists,
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// firstLine returns the first non-empty line written, e.g. the fatal error of a crash
+func (b *limitedBuffer) firstLine() string {
+	scanner := bufio.NewScanner(bytes.NewReader(b.Bytes()))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// WorkerCrashError reports an isolate mode child process that exited
+// without a result, e.g. after a segmentation fault in a native library
+type WorkerCrashError struct {
+	Err    error  // Exit status of the child
+	Output string // First line the child wrote to stderr, if any
+}
+
+func (e *WorkerCrashError) Error() string {
+	msg := fmt.Sprintf("worker crashed: %v", e.Err)
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *WorkerCrashError) Unwrap() []error {
+	return []error{ErrWorkerCrashed, e.Err}
+}
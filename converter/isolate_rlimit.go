@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package converter
+
+import "syscall"
+
+// setWorkerLimits applies the address-space and CPU-time limits of an
+// isolate mode child to the current process. Zero values leave a limit unset.
+func setWorkerLimits(maxMemory, cpuSeconds int64) error {
+	if maxMemory > 0 {
+		limit := &syscall.Rlimit{Cur: uint64(maxMemory), Max: uint64(maxMemory)}
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, limit); err != nil {
+			return err
+		}
+	}
+	if cpuSeconds > 0 {
+		// The soft limit sends SIGXCPU, the hard limit a second later SIGKILL
+		limit := &syscall.Rlimit{Cur: uint64(cpuSeconds), Max: uint64(cpuSeconds) + 1}
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
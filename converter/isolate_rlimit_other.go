@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package converter
+
+// setWorkerLimits is a no-op where resource limits are not supported; the
+// parent still enforces the per-file timeout
+func setWorkerLimits(maxMemory, cpuSeconds int64) error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package converter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// crashWorkerEnv makes the isolate mode child of the test binary crash:
+// "1" at start, "after-rename" once its output is in place, before the
+// original is removed
+const crashWorkerEnv = "WEBPCONVERT_TEST_CRASH_WORKER"
+
+// noisyWorkerEnv makes the isolate mode child of the test binary write to
+// stdout, as native libraries may
+const noisyWorkerEnv = "WEBPCONVERT_TEST_NOISY_WORKER"
+
+// TestMain lets the test binary serve as the isolate mode child
+func TestMain(m *testing.M) {
+	if IsIsolateWorker() {
+		switch os.Getenv(crashWorkerEnv) {
+		case "1":
+			panic("simulated crash")
+		case "after-rename":
+			testHookAfterRename = func(string) {
+				self, _ := os.FindProcess(os.Getpid())
+				self.Kill()
+			}
+		}
+		if os.Getenv(noisyWorkerEnv) == "1" {
+			fmt.Println(`{"success": false, "error": "stray output"}`)
+		}
+		if err := ServeIsolateWorker(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// corruptVP8 returns a lossy WebP with valid headers whose first partition
+// is larger than the file, so it fails only once decoding starts
+func corruptVP8() []byte {
+	payload := make([]byte, 32)
+	payload[0], payload[1], payload[2] = 0xf0, 0xff, 0x00 // Key frame, partition size 2047
+	payload[3], payload[4], payload[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(payload[6:], 16)
+	binary.LittleEndian.PutUint16(payload[8:], 16)
+
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8 \x20\x00\x00\x00")
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8+len(payload)))
+	return append(data, payload...)
+}
+
+// TestConvertIsolated tests errors reported by and about isolate mode children
+func TestConvertIsolated(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "corrupt.webp")
+	if err := os.WriteFile(path, corruptVP8(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultProcessOptions()
+	options.Isolate = true
+
+	// The decoding error of the child keeps its cause
+	result := convertSingleFile(path, options)
+	if result.Success || !errors.Is(result.Error, native.ErrCorruptBitstream) || ErrorCause(result.Error) != "corrupt_bitstream" {
+		t.Errorf("corrupt file: success=%v err=%v cause=%s", result.Success, result.Error, ErrorCause(result.Error))
+	}
+	if result.Type != native.WebPTypeStatic || result.Width != 16 {
+		t.Errorf("header facts lost: %+v", result)
+	}
+
+	// A crashing child fails only its file
+	t.Setenv(crashWorkerEnv, "1")
+	result = convertSingleFile(path, options)
+	var crashErr *WorkerCrashError
+	if !errors.As(result.Error, &crashErr) || ErrorCause(result.Error) != "crash" {
+		t.Fatalf("crashing worker: err = %v, want *WorkerCrashError", result.Error)
+	}
+	if crashErr.Output != "panic: simulated crash" {
+		t.Errorf("crash output = %q", crashErr.Output)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("original removed after a failed conversion: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(tmpDir, "*.tmp")); len(matches) > 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+}
+
+// TestConvertIsolatedKilledAfterRename tests that a child killed between
+// writing its output and removing the original loses neither
+func TestConvertIsolatedKilledAfterRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "still.webp")
+	if err := os.WriteFile(path, []byte(staticWebP), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultProcessOptions()
+	options.Isolate = true
+	t.Setenv(crashWorkerEnv, "after-rename")
+	result := convertSingleFile(path, options)
+	if result.Success || ErrorCause(result.Error) != "crash" {
+		t.Fatalf("killed worker: success=%v err=%v", result.Success, result.Error)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("original lost: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "still.jpg")); err != nil {
+		t.Errorf("output lost: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(tmpDir, "*.tmp")); len(matches) > 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+}
+
+// TestConvertIsolatedStrayOutput tests that output of the child on stdout
+// does not corrupt its response
+func TestConvertIsolatedStrayOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the response shares stdout on Windows")
+	}
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "still.webp")
+	if err := os.WriteFile(path, []byte(staticWebP), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultProcessOptions()
+	options.Isolate = true
+	t.Setenv(noisyWorkerEnv, "1")
+	if result := convertSingleFile(path, options); !result.Success || result.FilePath != filepath.Join(tmpDir, "still.jpg") {
+		t.Errorf("noisy worker: success=%v err=%v output=%s", result.Success, result.Error, result.FilePath)
+	}
+}
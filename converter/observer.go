@@ -0,0 +1,339 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// EventType identifies a batch event
+type EventType int
+
+const (
+	// EventScanStarted is sent once before the walk starts (Root)
+	EventScanStarted EventType = iota
+	// EventFileDiscovered is sent for every file selected by the walk (Path, Total so far)
+	EventFileDiscovered
+	// EventExtensionMismatch is sent in content detection mode for files whose
+	// extension disagrees with their content (Path, Format)
+	EventExtensionMismatch
+	// EventScanFinished is sent when the walk is done (Total, Workers)
+	EventScanFinished
+	// EventFileStarted is sent when a file starts converting (Path)
+	EventFileStarted
+	// EventFileFinished is sent for every discovered file, including skipped
+	// ones (Path, Result, Index, Total)
+	EventFileFinished
+	// EventBatchFinished is sent last, also when the batch fails (Stats, Err)
+	EventBatchFinished
+)
+
+var eventTypeNames = [...]string{
+	"scan_started", "file_discovered", "extension_mismatch", "scan_finished",
+	"file_started", "file_finished", "batch_finished",
+}
+
+func (t EventType) String() string {
+	if t >= 0 && int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return fmt.Sprintf("event(%d)", int(t))
+}
+
+// MarshalText encodes the event type by name
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Event describes progress of a batch. Only the fields listed for its Type are set.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Root    string            // Directory being processed
+	Path    string            // File the event is about
+	Format  string            // Detected content format (EventExtensionMismatch)
+	Index   int               // Number of files finished so far, this one included (EventFileFinished)
+	Total   int               // Files discovered so far; final from EventScanFinished on
+	Workers int               // Number of workers (EventScanFinished)
+	Result  *ConversionResult // EventFileFinished
+	Stats   *ProcessStats     // EventBatchFinished
+	Err     error             // EventBatchFinished: the error returned by the Process function
+}
+
+// Observer receives batch events. The Process functions never call OnEvent
+// concurrently, so implementations need no locking for a single batch.
+type Observer interface {
+	OnEvent(event Event)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// MultiObserver returns an observer that forwards events to all observers in order
+func MultiObserver(observers ...Observer) Observer {
+	return ObserverFunc(func(event Event) {
+		for _, o := range observers {
+			if o != nil {
+				o.OnEvent(event)
+			}
+		}
+	})
+}
+
+// notifier serializes events from the walk, the workers and the collector,
+// and logs each of them to the batch logger
+type notifier struct {
+	mu       sync.Mutex
+	observer Observer
+	log      *LogObserver
+	root     string
+}
+
+func newNotifier(root string, options ProcessOptions) *notifier {
+	return &notifier{observer: options.Observer, log: NewLogObserver(options.logger()), root: root}
+}
+
+func (n *notifier) emit(event Event) {
+	event.Time = time.Now()
+	event.Root = n.root
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.log.OnEvent(event)
+	if n.observer != nil {
+		n.observer.OnEvent(event)
+	}
+}
+
+// TextObserver prints human-readable progress lines and the batch summary
+type TextObserver struct {
+	w        io.Writer
+	options  ProcessOptions
+	scanDone bool
+	finished int // Files finished before the scan was done
+}
+
+// NewTextObserver returns the CLI's text output, written to w
+func NewTextObserver(w io.Writer, options ProcessOptions) *TextObserver {
+	return &TextObserver{w: w, options: options}
+}
+
+func (o *TextObserver) OnEvent(event Event) {
+	switch event.Type {
+	case EventExtensionMismatch:
+		if event.Format == native.FormatWebP {
+			fmt.Fprintf(o.w, "Extension mismatch: %s contains WebP data, converting\n", event.Path)
+		} else {
+			fmt.Fprintf(o.w, "Extension mismatch: %s contains %s data, not WebP, skipping\n", event.Path, event.Format)
+		}
+
+	case EventScanFinished:
+		o.scanDone = true
+		switch {
+		case event.Total == 0 || event.Workers == 0:
+		case o.finished == 0:
+			fmt.Fprintf(o.w, "Found %d WebP file(s), using %d worker(s)\n\n", event.Total, event.Workers)
+		default:
+			// The parallel pipeline converts while scanning
+			fmt.Fprintf(o.w, "Scan finished: %d WebP file(s) found, %d done\n", event.Total, o.finished)
+		}
+
+	case EventFileFinished:
+		if !o.scanDone {
+			o.finished++
+		}
+		o.printResult(event)
+
+	case EventBatchFinished:
+		// Errors other than failed files are reported by the caller
+		var batchErr *BatchError
+		if event.Stats == nil || (event.Err != nil && !errors.As(event.Err, &batchErr)) {
+			return
+		}
+		if event.Total == 0 {
+			fmt.Fprintln(o.w, "No WebP files found")
+			if event.Stats.MismatchCount > 0 {
+				fmt.Fprintf(o.w, "  Extension mismatches: %d\n", event.Stats.MismatchCount)
+			}
+			return
+		}
+		WriteSummary(o.w, *event.Stats, o.options)
+	}
+}
+
+// printResult prints the outcome of one file
+func (o *TextObserver) printResult(event Event) {
+	result := event.Result
+	if result.Skipped {
+		return
+	}
+
+	if o.scanDone {
+		fmt.Fprintf(o.w, "Processing [%d/%d]: %s\n", event.Index, event.Total, result.Path)
+	} else {
+		fmt.Fprintf(o.w, "Processing: %s\n", result.Path)
+	}
+
+	if !result.Success {
+		fmt.Fprintf(o.w, "  Error: %v\n", resultError(*result))
+		return
+	}
+
+	var notes []string
+	if result.Quality > 0 {
+		notes = append(notes, fmt.Sprintf("quality %d", result.Quality))
+	}
+	if o.options.KeepOriginal {
+		notes = append(notes, "original preserved")
+	}
+	detail := ""
+	if len(notes) > 0 {
+		detail = " (" + strings.Join(notes, ", ") + ")"
+	}
+
+	switch result.Type {
+	case native.WebPTypeAnimated:
+		fmt.Fprintf(o.w, "  Type: Animated → Converted to %s%s\n", formatLabel(result.Format), detail)
+	case native.WebPTypeStatic:
+		fmt.Fprintf(o.w, "  Type: Static → Converted to %s%s\n", formatLabel(result.Format), detail)
+	}
+	fmt.Fprintf(o.w, "  Successfully converted\n")
+}
+
+// WriteSummary writes the statistics of a finished batch
+func WriteSummary(w io.Writer, stats ProcessStats, options ProcessOptions) {
+	fmt.Fprintf(w, "\nSummary:\n")
+	fmt.Fprintf(w, "  Total converted: %d files\n", stats.TotalProcessed)
+	if len(options.Rules) > 0 {
+		// Rules may send files of one type to several formats
+		fmt.Fprintf(w, "  Static: %d\n", stats.StaticCount)
+		fmt.Fprintf(w, "  Animated: %d\n", stats.AnimatedCount)
+		formats := make([]string, 0, len(stats.FormatCounts))
+		for format := range stats.FormatCounts {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+		for _, format := range formats {
+			fmt.Fprintf(w, "  → %s: %d\n", formatLabel(format), stats.FormatCounts[format])
+		}
+	} else {
+		staticFormat, animatedFormat := DefaultStaticFormat, DefaultAnimatedFormat
+		if options.Format != "" {
+			staticFormat, animatedFormat = options.Format, options.Format
+		}
+		fmt.Fprintf(w, "  Static → %s: %d\n", formatLabel(staticFormat), stats.StaticCount)
+		fmt.Fprintf(w, "  Animated → %s: %d\n", formatLabel(animatedFormat), stats.AnimatedCount)
+	}
+	fmt.Fprintf(w, "  Errors: %d\n", stats.ErrorCount)
+	if stats.SkippedCount > 0 {
+		fmt.Fprintf(w, "  Not processed: %d\n", stats.SkippedCount)
+	}
+	if stats.TimeoutCount > 0 {
+		fmt.Fprintf(w, "  Timed out: %d\n", stats.TimeoutCount)
+	}
+	if options.Detect == DetectContent {
+		fmt.Fprintf(w, "  Extension mismatches: %d\n", stats.MismatchCount)
+	}
+}
+
+// LogObserver logs batch events as structured records. The Process functions
+// already log to ProcessOptions.Logger; use it directly to send events to
+// another logger.
+type LogObserver struct {
+	logger *slog.Logger
+}
+
+// NewLogObserver returns an observer logging events to logger
+func NewLogObserver(logger *slog.Logger) *LogObserver {
+	return &LogObserver{logger: logger}
+}
+
+func (o *LogObserver) OnEvent(event Event) {
+	ctx := context.Background()
+	log := func(level slog.Level, msg string, attrs ...slog.Attr) {
+		o.logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+
+	switch event.Type {
+	case EventScanStarted:
+		log(slog.LevelDebug, "scan started", slog.String("root", event.Root))
+	case EventFileDiscovered:
+		log(slog.LevelDebug, "file discovered", slog.String("path", event.Path))
+	case EventExtensionMismatch:
+		log(slog.LevelWarn, "extension mismatch", slog.String("path", event.Path), slog.String("format", event.Format))
+	case EventScanFinished:
+		log(slog.LevelDebug, "scan finished", slog.Int("files", event.Total), slog.Int("workers", event.Workers))
+	case EventFileStarted:
+		log(slog.LevelDebug, "file started", slog.String("path", event.Path))
+	case EventFileFinished:
+		o.logResult(ctx, event.Result)
+	case EventBatchFinished:
+		var batchErr *BatchError
+		if event.Err != nil && !errors.As(event.Err, &batchErr) {
+			log(slog.LevelError, "batch failed", slog.String("root", event.Root), slog.Any("error", event.Err))
+			return
+		}
+		// Failed files were logged as they finished
+		stats := event.Stats
+		log(slog.LevelInfo, "batch finished",
+			slog.String("root", event.Root),
+			slog.Int("files", event.Total),
+			slog.Int("converted", stats.TotalProcessed),
+			slog.Int("static", stats.StaticCount),
+			slog.Int("animated", stats.AnimatedCount),
+			slog.Int("failed", stats.ErrorCount),
+			slog.Int("skipped", stats.SkippedCount),
+			slog.Int("timed_out", stats.TimeoutCount),
+			slog.Int("mismatches", stats.MismatchCount),
+		)
+	}
+}
+
+// logResult logs the outcome of one file with its per-file attributes
+func (o *LogObserver) logResult(ctx context.Context, result *ConversionResult) {
+	attrs := []slog.Attr{
+		slog.String("path", result.Path),
+		slog.String("type", result.Type.String()),
+	}
+
+	if result.Skipped {
+		o.logger.LogAttrs(ctx, slog.LevelInfo, "file skipped", attrs...)
+		return
+	}
+
+	attrs = append(attrs,
+		slog.Int64("duration_ms", result.Elapsed.Milliseconds()),
+		slog.Int64("bytes_in", result.BytesIn),
+		slog.Int64("bytes_out", result.BytesOut),
+	)
+
+	if !result.Success {
+		err := resultError(*result)
+		attrs = append(attrs, slog.String("cause", ErrorCause(err)), slog.Any("error", err))
+		// Failures are shown by the progress output and summed up at the
+		// end of the batch; only a panic's stack is news at the error level
+		level := slog.LevelInfo
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			attrs = append(attrs, slog.String("stack", string(panicErr.Stack)))
+			level = slog.LevelError
+		}
+		o.logger.LogAttrs(ctx, level, "file failed", attrs...)
+		return
+	}
+
+	attrs = append(attrs, slog.String("output", result.FilePath))
+	o.logger.LogAttrs(ctx, slog.LevelInfo, "file converted", attrs...)
+}
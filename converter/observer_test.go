@@ -0,0 +1,138 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestObserverEvents tests the event sequence of both process functions
+func TestObserverEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.webp", "b.webp", "sub/c.webp"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("not a webp file"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, process := range map[string]func(string, ProcessOptions) error{
+		"sequential": ProcessDirectory,
+		"parallel":   ProcessDirectoryParallel,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var events []Event
+			options := DefaultProcessOptions()
+			options.NumWorkers = 2
+			options.Observer = ObserverFunc(func(e Event) {
+				events = append(events, e)
+			})
+
+			if err := process(tmpDir, options); err == nil {
+				t.Fatal("expected an error for invalid files")
+			}
+
+			counts := make(map[EventType]int)
+			for _, e := range events {
+				counts[e.Type]++
+			}
+			want := map[EventType]int{
+				EventScanStarted:    1,
+				EventFileDiscovered: 3,
+				EventScanFinished:   1,
+				EventFileStarted:    3,
+				EventFileFinished:   3,
+				EventBatchFinished:  1,
+			}
+			for typ, n := range want {
+				if counts[typ] != n {
+					t.Errorf("%v events = %d, want %d", typ, counts[typ], n)
+				}
+			}
+
+			if first := events[0]; first.Type != EventScanStarted || first.Root != tmpDir {
+				t.Errorf("first event = %+v, want scan_started for %s", first, tmpDir)
+			}
+			last := events[len(events)-1]
+			if last.Type != EventBatchFinished || last.Stats == nil || last.Stats.ErrorCount != 3 || last.Err == nil {
+				t.Errorf("last event = %+v, want batch_finished with 3 errors", last)
+			}
+		})
+	}
+}
+
+// TestTextObserver tests the CLI text output of a failed batch
+func TestTextObserver(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "bad.webp"), []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	options := DefaultProcessOptions()
+	options.Observer = NewTextObserver(&buf, options)
+	ProcessDirectory(tmpDir, options)
+
+	out := buf.String()
+	for _, want := range []string{"Processing: " + filepath.Join(tmpDir, "bad.webp"), "  Error: ", "Summary:", "  Errors: 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	// Without an observer nothing is printed
+	options.Observer = nil
+	if err := ProcessDirectory(t.TempDir(), options); err != nil {
+		t.Errorf("empty directory: %v", err)
+	}
+}
+
+// TestLogObserver tests the structured records logged for a batch
+func TestLogObserver(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "bad.webp"), []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	options := DefaultProcessOptions()
+	options.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	ProcessDirectory(tmpDir, options)
+
+	records := make(map[string]map[string]any)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records[record["msg"].(string)] = record
+	}
+
+	failed, ok := records["file failed"]
+	if !ok {
+		t.Fatalf("no file failed record: %v", records)
+	}
+	if failed["level"] != "INFO" || failed["path"] != filepath.Join(tmpDir, "bad.webp") || failed["bytes_in"] != float64(4) {
+		t.Errorf("file failed record = %v", failed)
+	}
+	for _, key := range []string{"type", "duration_ms", "bytes_out", "cause", "error"} {
+		if _, ok := failed[key]; !ok {
+			t.Errorf("file failed record missing %q: %v", key, failed)
+		}
+	}
+
+	if batch, ok := records["batch finished"]; !ok || batch["failed"] != float64(1) {
+		t.Errorf("batch finished record = %v", batch)
+	}
+	if _, ok := records["file started"]; ok {
+		t.Error("debug record logged at info level")
+	}
+}
@@ -0,0 +1,391 @@
+package converter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// ReportFormat selects the encoding of a conversion report
+type ReportFormat int
+
+const (
+	// ReportJSON writes a single JSON document with all files and the summary.
+	// Files are written as they finish, the summary on Close.
+	ReportJSON ReportFormat = iota
+	// ReportNDJSON writes one JSON object per line, files first, summary last
+	ReportNDJSON
+	// ReportCSV writes one row per file followed by summary rows
+	ReportCSV
+)
+
+func (f ReportFormat) String() string {
+	switch f {
+	case ReportNDJSON:
+		return "ndjson"
+	case ReportCSV:
+		return "csv"
+	default:
+		return "json"
+	}
+}
+
+// ParseReportFormat parses a report format name ("json", "ndjson" or "csv")
+func ParseReportFormat(s string) (ReportFormat, error) {
+	switch strings.ToLower(s) {
+	case "json", "":
+		return ReportJSON, nil
+	case "ndjson", "jsonl":
+		return ReportNDJSON, nil
+	case "csv":
+		return ReportCSV, nil
+	default:
+		return ReportJSON, fmt.Errorf("unknown report format %q (use json, ndjson or csv)", s)
+	}
+}
+
+// ReportFormatForPath guesses the report format from a file extension, defaulting to JSON
+func ReportFormatForPath(path string) ReportFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		return ReportNDJSON
+	case ".csv":
+		return ReportCSV
+	default:
+		return ReportJSON
+	}
+}
+
+// Report record statuses
+const (
+	StatusConverted = "converted"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// ReportRecord is the report entry of one file
+type ReportRecord struct {
+	Kind         string `json:"kind"` // Always "file"
+	Input        string `json:"input"`
+	Output       string `json:"output,omitempty"`
+	Status       string `json:"status"`
+	Type         string `json:"type"` // Detected WebP type
+	OutputFormat string `json:"output_format,omitempty"`
+	Quality      int    `json:"quality,omitempty"` // JPEG quality used
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	FrameCount   int    `json:"frame_count,omitempty"`
+	AnimDuration int64  `json:"anim_duration_ms,omitempty"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	ElapsedMS    int64  `json:"elapsed_ms"`
+	Cause        string `json:"cause,omitempty"` // Error class, see ErrorCause
+	Error        string `json:"error,omitempty"`
+}
+
+// ReportOptions records the options a batch ran with
+type ReportOptions struct {
+	JPEGQuality  int      `json:"jpeg_quality"`
+	NumWorkers   int      `json:"workers"`
+	KeepOriginal bool     `json:"keep_original"`
+	FailFast     bool     `json:"fail_fast"`
+	Detect       string   `json:"detect"`
+	Format       string   `json:"format,omitempty"`
+	Rules        []string `json:"rules,omitempty"`
+	Analyze      bool     `json:"analyze"`
+
+	// Walk filters on modification time, durations such as 24h resolved
+	ModifiedAfter  *time.Time `json:"modified_after,omitempty"`
+	ModifiedBefore *time.Time `json:"modified_before,omitempty"`
+}
+
+// ReportSummary is the batch summary at the end of a report
+type ReportSummary struct {
+	Kind      string         `json:"kind"` // Always "summary"
+	Started   time.Time      `json:"started"`
+	Finished  time.Time      `json:"finished"`
+	ElapsedMS int64          `json:"elapsed_ms"`
+	Files     int            `json:"files"`
+	Converted int            `json:"converted"`
+	Failed    int            `json:"failed"`
+	Skipped   int            `json:"skipped"`
+	Static    int            `json:"static"`
+	Animated  int            `json:"animated"`
+	BytesIn   int64          `json:"bytes_in"`  // Of converted files
+	BytesOut  int64          `json:"bytes_out"` // Of converted files
+	Causes    map[string]int `json:"causes,omitempty"`
+	Options   ReportOptions  `json:"options"`
+}
+
+// reportCSVHeader lists the CSV columns. Summary rows use kind "summary",
+// with status "converted", "failed", "skipped" or "total" and the number of
+// files in the files column; failed rows are also broken down by cause.
+var reportCSVHeader = []string{
+	"kind", "input", "output", "status", "type", "output_format", "quality",
+	"width", "height", "frame_count", "anim_duration_ms", "bytes_in", "bytes_out",
+	"elapsed_ms", "cause", "error", "files",
+}
+
+// ReportWriter writes a machine-readable conversion report. It is an Observer:
+// add it to a batch through ProcessOptions.Observer and call Close once the
+// batch is done. It is safe for concurrent use.
+type ReportWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	format  ReportFormat
+	options ProcessOptions
+	csv     *csv.Writer
+	records int // JSON only: file records written so far
+	summary ReportSummary
+	err     error // First write error
+}
+
+// NewReportWriter returns a report writer for a batch run with options
+func NewReportWriter(w io.Writer, format ReportFormat, options ProcessOptions) *ReportWriter {
+	r := &ReportWriter{
+		w:       w,
+		format:  format,
+		options: options,
+		summary: ReportSummary{
+			Kind:    "summary",
+			Started: time.Now(),
+			Causes:  make(map[string]int),
+			Options: ReportOptions{
+				JPEGQuality:  options.JPEGQuality,
+				NumWorkers:   options.NumWorkers,
+				KeepOriginal: options.KeepOriginal,
+				FailFast:     options.FailFast,
+				Detect:       options.Detect.String(),
+				Format:       options.Format,
+				Rules:        ruleStrings(options.Rules),
+				Analyze:      options.Analyze,
+
+				ModifiedAfter:  optionalTime(options.ModifiedAfter),
+				ModifiedBefore: optionalTime(options.ModifiedBefore),
+			},
+		},
+	}
+	switch format {
+	case ReportCSV:
+		r.csv = csv.NewWriter(w)
+		r.err = r.csv.Write(reportCSVHeader)
+	case ReportJSON:
+		_, r.err = io.WriteString(w, "{\n  \"files\": [")
+	}
+	return r
+}
+
+// OnEvent records every finished file
+func (r *ReportWriter) OnEvent(event Event) {
+	if event.Type == EventFileFinished && event.Result != nil {
+		r.Add(*event.Result)
+	}
+}
+
+// Add records the result of one file
+func (r *ReportWriter) Add(result ConversionResult) {
+	record := r.newRecord(result)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.count(record, result.Type)
+	if r.err != nil {
+		return
+	}
+
+	switch r.format {
+	case ReportNDJSON:
+		r.err = json.NewEncoder(r.w).Encode(record)
+	case ReportCSV:
+		r.err = r.csv.Write(record.csvRow(1))
+	default:
+		r.err = r.writeJSON(record)
+	}
+}
+
+// writeJSON writes a file record as the next element of the files array,
+// indented as in the whole document
+func (r *ReportWriter) writeJSON(record ReportRecord) error {
+	data, err := json.MarshalIndent(record, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n    "
+	if r.records == 0 {
+		sep = "\n    "
+	}
+	r.records++
+	_, err = io.WriteString(r.w, sep+string(data))
+	return err
+}
+
+// newRecord builds the report record of a result
+func (r *ReportWriter) newRecord(result ConversionResult) ReportRecord {
+	record := ReportRecord{
+		Kind:         "file",
+		Input:        result.Path,
+		Output:       result.FilePath,
+		Type:         result.Type.String(),
+		Width:        result.Width,
+		Height:       result.Height,
+		FrameCount:   result.FrameCount,
+		AnimDuration: result.AnimDuration.Milliseconds(),
+		BytesIn:      result.BytesIn,
+		BytesOut:     result.BytesOut,
+		ElapsedMS:    result.Elapsed.Milliseconds(),
+	}
+
+	// Results that did not get as far as choosing an encoder get the default
+	// format, but no quality
+	record.OutputFormat = result.Format
+	if record.OutputFormat == "" && r.options.Format == "" {
+		record.OutputFormat = defaultFormat(result.Type)
+	}
+	record.Quality = result.Quality
+
+	switch {
+	case result.Skipped:
+		record.Status = StatusSkipped
+	case result.Success:
+		record.Status = StatusConverted
+	default:
+		err := resultError(result)
+		record.Status = StatusFailed
+		record.Cause = ErrorCause(err)
+		record.Error = err.Error()
+	}
+
+	return record
+}
+
+// count adds a record to the summary
+func (r *ReportWriter) count(record ReportRecord, webpType native.WebPType) {
+	s := &r.summary
+	s.Files++
+	switch record.Status {
+	case StatusConverted:
+		s.Converted++
+		s.BytesIn += record.BytesIn
+		s.BytesOut += record.BytesOut
+		switch webpType {
+		case native.WebPTypeAnimated:
+			s.Animated++
+		case native.WebPTypeStatic:
+			s.Static++
+		}
+	case StatusFailed:
+		s.Failed++
+		s.Causes[record.Cause]++
+	case StatusSkipped:
+		s.Skipped++
+	}
+}
+
+// Close writes the batch summary (and, for JSON, closes the document) and
+// returns the first error encountered while writing the report
+func (r *ReportWriter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summary.Finished = time.Now()
+	r.summary.ElapsedMS = r.summary.Finished.Sub(r.summary.Started).Milliseconds()
+	if r.err != nil {
+		return r.err
+	}
+
+	switch r.format {
+	case ReportNDJSON:
+		r.err = json.NewEncoder(r.w).Encode(r.summary)
+	case ReportCSV:
+		for _, row := range r.summary.csvRows() {
+			if r.err = r.csv.Write(row); r.err != nil {
+				return r.err
+			}
+		}
+		r.csv.Flush()
+		r.err = r.csv.Error()
+	default:
+		end := "\n  ],\n  \"summary\": "
+		if r.records == 0 {
+			end = "],\n  \"summary\": "
+		}
+		data, err := json.MarshalIndent(r.summary, "  ", "  ")
+		if err != nil {
+			r.err = err
+			return r.err
+		}
+		_, r.err = io.WriteString(r.w, end+string(data)+"\n}\n")
+	}
+	return r.err
+}
+
+// Summary returns the summary of the records added so far
+func (r *ReportWriter) Summary() ReportSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.summary
+}
+
+// csvRow formats a record as a CSV row covering files files
+func (rec ReportRecord) csvRow(files int) []string {
+	return []string{
+		rec.Kind, rec.Input, rec.Output, rec.Status, rec.Type, rec.OutputFormat,
+		optionalInt(int64(rec.Quality)), optionalInt(int64(rec.Width)), optionalInt(int64(rec.Height)),
+		optionalInt(int64(rec.FrameCount)), optionalInt(rec.AnimDuration),
+		strconv.FormatInt(rec.BytesIn, 10), strconv.FormatInt(rec.BytesOut, 10),
+		strconv.FormatInt(rec.ElapsedMS, 10), rec.Cause, rec.Error, strconv.Itoa(files),
+	}
+}
+
+// csvRows formats the summary as CSV rows
+func (s ReportSummary) csvRows() [][]string {
+	row := func(status, cause string, files int, bytesIn, bytesOut, elapsed int64) []string {
+		rec := ReportRecord{Kind: "summary", Status: status, Cause: cause, BytesIn: bytesIn, BytesOut: bytesOut, ElapsedMS: elapsed}
+		return rec.csvRow(files)
+	}
+
+	rows := [][]string{
+		row(StatusConverted, "", s.Converted, s.BytesIn, s.BytesOut, 0),
+		row(StatusFailed, "", s.Failed, 0, 0, 0),
+	}
+
+	causes := make([]string, 0, len(s.Causes))
+	for cause := range s.Causes {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		rows = append(rows, row(StatusFailed, cause, s.Causes[cause], 0, 0, 0))
+	}
+
+	return append(rows,
+		row(StatusSkipped, "", s.Skipped, 0, 0, 0),
+		row("total", "", s.Files, s.BytesIn, s.BytesOut, s.ElapsedMS),
+	)
+}
+
+// optionalInt formats v, leaving zero values empty
+func optionalInt(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// optionalTime returns a pointer to t, or nil for the zero time
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,148 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// reportResults returns one converted, one failed and one skipped result
+func reportResults() []ConversionResult {
+	return []ConversionResult{
+		{
+			Path: "a.webp", FilePath: "a.jpg", Success: true, Type: native.WebPTypeStatic, Format: "jpeg", Quality: 85,
+			Width: 64, Height: 48, FrameCount: 1, BytesIn: 1000, BytesOut: 3000, Elapsed: 12 * time.Millisecond,
+		},
+		{Path: "b.webp", Error: fmt.Errorf("failed to detect type: %w", native.ErrTruncated), BytesIn: 5},
+		{Path: "c.webp", Skipped: true},
+	}
+}
+
+// TestReportWriter tests the three report formats
+func TestReportWriter(t *testing.T) {
+	options := DefaultProcessOptions()
+	after := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	options.ModifiedAfter = after
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		report := NewReportWriter(&buf, ReportJSON, options)
+		for _, result := range reportResults() {
+			report.Add(result)
+		}
+		if !strings.Contains(buf.String(), `"input": "c.webp"`) {
+			t.Errorf("records not written before Close:\n%s", buf.String())
+		}
+		if err := report.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		var doc struct {
+			Files   []ReportRecord `json:"files"`
+			Summary ReportSummary  `json:"summary"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+		}
+		if len(doc.Files) != 3 {
+			t.Fatalf("got %d records, want 3", len(doc.Files))
+		}
+		if rec := doc.Files[0]; rec.Status != StatusConverted || rec.OutputFormat != "jpeg" || rec.Quality != 85 || rec.Width != 64 || rec.ElapsedMS != 12 {
+			t.Errorf("unexpected converted record: %+v", rec)
+		}
+		if rec := doc.Files[1]; rec.Status != StatusFailed || rec.Cause != "truncated" || rec.Error == "" || rec.Quality != 0 {
+			t.Errorf("unexpected failed record: %+v", rec)
+		}
+		s := doc.Summary
+		if s.Files != 3 || s.Converted != 1 || s.Failed != 1 || s.Skipped != 1 || s.Static != 1 || s.BytesOut != 3000 || s.Causes["truncated"] != 1 {
+			t.Errorf("unexpected summary: %+v", s)
+		}
+		if s.Options.JPEGQuality != 100 || s.Options.Detect != "extension" ||
+			s.Options.ModifiedAfter == nil || !s.Options.ModifiedAfter.Equal(after) || s.Options.ModifiedBefore != nil {
+			t.Errorf("unexpected options: %+v", s.Options)
+		}
+	})
+
+	t.Run("json empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := NewReportWriter(&buf, ReportJSON, options).Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		var doc struct {
+			Files   []ReportRecord `json:"files"`
+			Summary ReportSummary  `json:"summary"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil || doc.Files == nil || len(doc.Files) != 0 {
+			t.Errorf("invalid empty report (%v):\n%s", err, buf.String())
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		var buf bytes.Buffer
+		report := NewReportWriter(&buf, ReportNDJSON, options)
+		for _, result := range reportResults() {
+			report.Add(result)
+		}
+		if err := report.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 4 {
+			t.Fatalf("got %d lines, want 4:\n%s", len(lines), buf.String())
+		}
+		var last struct {
+			Kind      string `json:"kind"`
+			Converted int    `json:"converted"`
+		}
+		if err := json.Unmarshal([]byte(lines[3]), &last); err != nil || last.Kind != "summary" || last.Converted != 1 {
+			t.Errorf("unexpected summary line %s (%v)", lines[3], err)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		report := NewReportWriter(&buf, ReportCSV, options)
+		for _, result := range reportResults() {
+			report.Add(result)
+		}
+		if err := report.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("invalid CSV: %v", err)
+		}
+		// Header, 3 files, converted, failed, failed/truncated, skipped, total
+		if len(rows) != 9 {
+			t.Fatalf("got %d rows, want 9: %v", len(rows), rows)
+		}
+		if rows[1][0] != "file" || rows[1][3] != StatusConverted || rows[1][6] != "85" {
+			t.Errorf("unexpected file row: %v", rows[1])
+		}
+		total := rows[len(rows)-1]
+		if total[0] != "summary" || total[3] != "total" || total[len(total)-1] != "3" {
+			t.Errorf("unexpected total row: %v", total)
+		}
+	})
+}
+
+// TestParseReportFormat tests format names and extension guessing
+func TestParseReportFormat(t *testing.T) {
+	if f, err := ParseReportFormat("CSV"); err != nil || f != ReportCSV {
+		t.Errorf("ParseReportFormat(CSV) = %v, %v", f, err)
+	}
+	if _, err := ParseReportFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if f := ReportFormatForPath("out/report.jsonl"); f != ReportNDJSON {
+		t.Errorf("ReportFormatForPath = %v, want ndjson", f)
+	}
+}
@@ -0,0 +1,247 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Rule routes the files matching all of its conditions to an output format.
+// Rules are written as "conditions=format", conditions separated by commas:
+//
+//	static,alpha=png
+//	animated,duration>10s=apng
+//	*=jpeg
+//
+// Flag conditions are static, animated, lossless (every frame VP8L), lossy,
+// alpha and opaque. Comparisons use <, <=, > or >= on frames, width,
+// height, pixels (canvas area), duration (a Go duration such as 1.5s) or
+// colors (distinct displayed colors, counted up to 257). "*" matches every
+// file. Rules decide on header facts; with ProcessOptions.Analyze, the type
+// and alpha facts come from the decoded content instead, so an animation
+// that never changes is static and an alpha channel without transparent
+// pixels is opaque. Colors are only known from the decoded content, so
+// without Analyze no colors comparison matches.
+type Rule struct {
+	Conditions []Condition
+	Format     string // Encoder name, see native.Encoders
+}
+
+// Condition is one test of a Rule on the header facts of a file
+type Condition struct {
+	Fact  string // static, animated, lossless, lossy, alpha, opaque, frames, width, height, pixels, duration or colors
+	Op    string // Comparison operator; empty for flag facts
+	Value int64  // Compared value; milliseconds for duration
+}
+
+// ruleFlags are the facts without a value
+var ruleFlags = map[string]func(info *native.WebPInfo) bool{
+	"static":   func(info *native.WebPInfo) bool { return info.Type == native.WebPTypeStatic },
+	"animated": func(info *native.WebPInfo) bool { return info.Type == native.WebPTypeAnimated },
+	"lossless": func(info *native.WebPInfo) bool { return info.Lossless },
+	"lossy":    func(info *native.WebPInfo) bool { return !info.Lossless },
+	"alpha":    func(info *native.WebPInfo) bool { return info.HasAlpha },
+	"opaque":   func(info *native.WebPInfo) bool { return !info.HasAlpha },
+}
+
+// ruleValues are the facts compared with a value
+var ruleValues = map[string]func(info *native.WebPInfo) int64{
+	"frames":   func(info *native.WebPInfo) int64 { return int64(info.FrameCount) },
+	"width":    func(info *native.WebPInfo) int64 { return int64(info.Width) },
+	"height":   func(info *native.WebPInfo) int64 { return int64(info.Height) },
+	"pixels":   func(info *native.WebPInfo) int64 { return int64(info.Width) * int64(info.Height) },
+	"duration": func(info *native.WebPInfo) int64 { return int64(info.Duration) },
+	"colors":   func(info *native.WebPInfo) int64 { return int64(info.Colors) },
+}
+
+// ruleOps lists the comparison operators, two-character ones first so that
+// ">=" is not read as ">"
+var ruleOps = []string{"<=", ">=", "<", ">"}
+
+// AutoRules returns the built-in routing: lossless and transparent still
+// images to PNG, other still images to JPEG, long animations (over 10
+// seconds or 300 frames) and those with more colors than a GIF palette
+// holds, which it would band, to APNG, and other animations to GIF.
+// Lossy compression alone says little about the colors: a short clip of a
+// few flat colors stays a GIF.
+func AutoRules() []Rule {
+	return mustParseRules(
+		"static,lossless=png",
+		"static,alpha=png",
+		"static=jpeg",
+		"animated,duration>10s=apng",
+		"animated,frames>300=apng",
+		"animated,colors>256=apng",
+		"animated=gif",
+	)
+}
+
+// ParseRule parses a rule written as "conditions=format". The format must be
+// a registered encoder.
+func ParseRule(s string) (Rule, error) {
+	// The format follows the last "=", conditions may contain ">="
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return Rule{}, fmt.Errorf("invalid rule %q: want conditions=format", s)
+	}
+
+	rule := Rule{Format: strings.TrimSpace(s[i+1:])}
+	if _, ok := native.LookupEncoder(rule.Format); !ok {
+		return Rule{}, fmt.Errorf("invalid rule %q: unknown format %q (use %s)", s, rule.Format, strings.Join(native.EncoderNames(), ", "))
+	}
+
+	for _, term := range strings.Split(s[:i], ",") {
+		term = strings.TrimSpace(term)
+		if term == "*" {
+			continue
+		}
+		cond, err := parseCondition(term)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid rule %q: %w", s, err)
+		}
+		rule.Conditions = append(rule.Conditions, cond)
+	}
+	return rule, nil
+}
+
+// ParseRules parses a list of rules, see ParseRule
+func ParseRules(specs []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mustParseRules is ParseRules for the built-in rules
+func mustParseRules(specs ...string) []Rule {
+	rules, err := ParseRules(specs)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// parseCondition parses a flag fact or a comparison
+func parseCondition(term string) (Condition, error) {
+	if _, ok := ruleFlags[term]; ok {
+		return Condition{Fact: term}, nil
+	}
+
+	for _, op := range ruleOps {
+		fact, value, found := strings.Cut(term, op)
+		if !found {
+			continue
+		}
+		fact, value = strings.TrimSpace(fact), strings.TrimSpace(value)
+		if _, ok := ruleValues[fact]; !ok {
+			return Condition{}, fmt.Errorf("unknown fact %q", fact)
+		}
+
+		cond := Condition{Fact: fact, Op: op}
+		if fact == "duration" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Condition{}, fmt.Errorf("invalid duration %q", value)
+			}
+			cond.Value = d.Milliseconds()
+		} else {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return Condition{}, fmt.Errorf("invalid %s %q", fact, value)
+			}
+			cond.Value = n
+		}
+		return cond, nil
+	}
+	return Condition{}, fmt.Errorf("unknown condition %q", term)
+}
+
+// Match reports whether the file described by info meets every condition
+func (r Rule) Match(info *native.WebPInfo) bool {
+	for _, cond := range r.Conditions {
+		if !cond.Match(info) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the rule in the form read by ParseRule
+func (r Rule) String() string {
+	if len(r.Conditions) == 0 {
+		return "*=" + r.Format
+	}
+	terms := make([]string, len(r.Conditions))
+	for i, cond := range r.Conditions {
+		terms[i] = cond.String()
+	}
+	return strings.Join(terms, ",") + "=" + r.Format
+}
+
+// MarshalText implements encoding.TextMarshaler so rules serialize as strings
+func (r Rule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (r *Rule) UnmarshalText(text []byte) error {
+	rule, err := ParseRule(string(text))
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+// Match reports whether the file described by info meets the condition
+func (c Condition) Match(info *native.WebPInfo) bool {
+	if c.Op == "" {
+		flag, ok := ruleFlags[c.Fact]
+		return ok && flag(info)
+	}
+
+	value, ok := ruleValues[c.Fact]
+	if !ok || c.Fact == "colors" && info.Colors == 0 {
+		return false
+	}
+	v := value(info)
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	}
+	return false
+}
+
+// String returns the condition in the form read by ParseRule
+func (c Condition) String() string {
+	if c.Op == "" {
+		return c.Fact
+	}
+	if c.Fact == "duration" {
+		return c.Fact + c.Op + (time.Duration(c.Value) * time.Millisecond).String()
+	}
+	return c.Fact + c.Op + strconv.FormatInt(c.Value, 10)
+}
+
+// ruleStrings returns rules in the form read by ParseRule
+func ruleStrings(rules []Rule) []string {
+	var specs []string
+	for _, rule := range rules {
+		specs = append(specs, rule.String())
+	}
+	return specs
+}
@@ -0,0 +1,92 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// TestParseRule tests rule syntax, round trips and errors
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr string
+	}{
+		{"static,alpha=png", "static,alpha=png", ""},
+		{" animated , duration>=1.5s = apng", "animated,duration>=1.5s=apng", ""},
+		{"frames<=1=jpeg", "frames<=1=jpeg", ""},
+		{"*=gif", "*=gif", ""},
+		{"pixels>=1000000", "", "unknown format"},
+		{"static", "", "want conditions=format"},
+		{"colors > 256=apng", "colors>256=apng", ""},
+		{"bytes>256=gif", "", "unknown fact"},
+		{"shiny=png", "", "unknown condition"},
+		{"width>big=png", "", "invalid width"},
+		{"duration<soon=gif", "", "invalid duration"},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseRule(%q) err = %v, want %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || rule.String() != tt.want {
+			t.Errorf("ParseRule(%q) = %q, %v, want %q", tt.spec, rule, err, tt.want)
+		}
+	}
+}
+
+// TestAutoRules tests the built-in routing on header and content facts
+func TestAutoRules(t *testing.T) {
+	rules := AutoRules()
+	animation := func(frames, duration int, lossless bool, colors int) *native.WebPInfo {
+		return &native.WebPInfo{Type: native.WebPTypeAnimated, FrameCount: frames, Duration: duration, Lossless: lossless, Colors: colors}
+	}
+
+	tests := []struct {
+		name string
+		info *native.WebPInfo
+		want string
+	}{
+		{"lossless still", &native.WebPInfo{Type: native.WebPTypeStatic, Lossless: true}, "png"},
+		{"transparent photo", &native.WebPInfo{Type: native.WebPTypeStatic, HasAlpha: true}, "png"},
+		{"opaque photo", &native.WebPInfo{Type: native.WebPTypeStatic}, "jpeg"},
+		{"short lossless animation", animation(20, 2000, true, 40), "gif"},
+		{"long animation", animation(20, 10001, true, 40), "apng"},
+		{"many frames", animation(301, 3000, true, 40), "apng"},
+		{"lossy animation of few colors", animation(20, 2000, false, 256), "gif"},
+		{"lossy animation of many colors", animation(20, 2000, false, 257), "apng"},
+		{"lossless animation of many colors", animation(20, 2000, true, 257), "apng"},
+		{"colors not counted", animation(20, 2000, false, 0), "gif"},
+	}
+	for _, tt := range tests {
+		options := DefaultProcessOptions()
+		options.Rules = rules
+		enc, err := selectEncoder(tt.info, options)
+		if err != nil || enc.Name() != tt.want {
+			t.Errorf("%s: got %v, %v, want %s", tt.name, enc, err, tt.want)
+		}
+	}
+
+	// Files no rule matches fall back to Format, then to the type default
+	options := DefaultProcessOptions()
+	options.Rules = mustParseRules("lossless=png")
+	if enc, err := selectEncoder(&native.WebPInfo{Type: native.WebPTypeAnimated}, options); err != nil || enc.Name() != "gif" {
+		t.Errorf("no match: got %v, %v, want gif", enc, err)
+	}
+	options.Format = "apng"
+	if enc, err := selectEncoder(&native.WebPInfo{Type: native.WebPTypeAnimated}, options); err != nil || enc.Name() != "apng" {
+		t.Errorf("no match with Format: got %v, %v, want apng", enc, err)
+	}
+
+	// A rule whose format cannot keep the frames passes animations on
+	options = DefaultProcessOptions()
+	options.Rules = mustParseRules("pixels>100=jpeg", "animated=apng")
+	if enc, err := selectEncoder(&native.WebPInfo{Type: native.WebPTypeAnimated, Width: 20, Height: 20}, options); err != nil || enc.Name() != "apng" {
+		t.Errorf("still-only rule: got %v, %v, want apng", enc, err)
+	}
+}
@@ -0,0 +1,95 @@
+package converter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Working memory of the conversion code paths, per canvas pixel
+const (
+	staticBytesPerPixel   = 16 // Decoded RGBA and its Go copy, RGB buffer and its C copy, JPEG output
+	animatedBytesPerPixel = 12 // Frame RGBA, RGB and indexed frame, color cache
+	histogramEntryBytes   = 24 // Global palette histogram, per distinct color
+	maxHistogramColors    = 1 << 24
+)
+
+// EstimateMemory estimates the peak memory in bytes needed to convert a
+// file, from its header facts and size. It is what ProcessOptions.MaxMemory
+// is checked against.
+func EstimateMemory(info *native.WebPInfo, fileSize int64) int64 {
+	pixels := int64(info.Width) * int64(info.Height)
+
+	switch info.Type {
+	case native.WebPTypeStatic:
+		return fileSize + pixels*staticBytesPerPixel
+	case native.WebPTypeAnimated:
+		// The palette pass counts colors over every frame
+		colors := pixels * int64(max(info.FrameCount, 1))
+		colors = min(colors, maxHistogramColors)
+		return 2*fileSize + pixels*animatedBytesPerPixel + colors*histogramEntryBytes
+	default:
+		return fileSize
+	}
+}
+
+// memoryScheduler admits conversions while their estimated memory fits in a
+// budget. Jobs are admitted in arrival order, so a large file is not starved
+// by smaller ones; a file larger than the whole budget runs alone.
+type memoryScheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	budget  int64
+	used    int64
+	next    uint64 // Ticket of the next caller
+	serving uint64 // Ticket allowed to be admitted
+}
+
+// newMemoryScheduler returns a scheduler for budget bytes, or nil if budget
+// is not positive (no limit)
+func newMemoryScheduler(budget int64) *memoryScheduler {
+	if budget <= 0 {
+		return nil
+	}
+	s := &memoryScheduler{budget: budget}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// acquire blocks until n bytes fit in the budget, or returns the error of
+// ctx once it is done. Admitted callers must call release(n).
+func (s *memoryScheduler) acquire(ctx context.Context, n int64) error {
+	wake := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer wake()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket := s.next
+	s.next++
+	for ticket != s.serving || (s.used > 0 && s.used+n > s.budget) {
+		// After cancellation the queue is abandoned: every waiter returns
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.cond.Wait()
+	}
+
+	s.serving++
+	s.used += n
+	s.cond.Broadcast()
+	return nil
+}
+
+// release returns n bytes to the budget
+func (s *memoryScheduler) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	s.cond.Broadcast()
+}
@@ -0,0 +1,70 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// TestEstimateMemory tests that estimates grow with dimensions and frames
+func TestEstimateMemory(t *testing.T) {
+	small := EstimateMemory(&native.WebPInfo{Type: native.WebPTypeStatic, Width: 100, Height: 100}, 1000)
+	large := EstimateMemory(&native.WebPInfo{Type: native.WebPTypeStatic, Width: 16000, Height: 16000}, 1000)
+	if small <= 1000 || large < 16000*16000*4 {
+		t.Errorf("static estimates = %d and %d, want at least the decoded RGBA size", small, large)
+	}
+
+	short := EstimateMemory(&native.WebPInfo{Type: native.WebPTypeAnimated, Width: 500, Height: 500, FrameCount: 2}, 1000)
+	long := EstimateMemory(&native.WebPInfo{Type: native.WebPTypeAnimated, Width: 500, Height: 500, FrameCount: 500}, 1000)
+	if long <= short {
+		t.Errorf("500-frame estimate %d not above 2-frame estimate %d", long, short)
+	}
+
+	if got := EstimateMemory(&native.WebPInfo{}, 1000); got != 1000 {
+		t.Errorf("unknown type estimate = %d, want the file size", got)
+	}
+}
+
+// TestMemoryScheduler tests admission against the budget
+func TestMemoryScheduler(t *testing.T) {
+	if newMemoryScheduler(0) != nil {
+		t.Error("expected no scheduler without a budget")
+	}
+
+	ctx := context.Background()
+	s := newMemoryScheduler(100)
+
+	// A job larger than the budget runs alone
+	if err := s.acquire(ctx, 500); err != nil {
+		t.Fatal(err)
+	}
+	admitted := make(chan struct{})
+	go func() {
+		s.acquire(ctx, 10)
+		close(admitted)
+	}()
+	select {
+	case <-admitted:
+		t.Fatal("job admitted while the budget was exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.release(500)
+	<-admitted
+
+	// Jobs that fit together are admitted together (10 + 80)
+	if err := s.acquire(ctx, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting jobs return once the context is done
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- s.acquire(ctx, 50) }()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire after cancel = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Convert converts the WebP image read from r and writes it to w, routed by
+// options.Rules and options.Format, or by default: animated images become a
+// GIF, static ones a JPEG of options.JPEGQuality. The returned type is the
+// one the image was converted as: an animation that displays a single
+// picture counts as static (by its decoded content with options.Analyze).
+// options.Limits apply to the input; the options for directories, workers
+// and isolation are ignored.
+//
+// ctx is checked before decoding starts; native decoding in progress cannot
+// be interrupted. A panic is returned as a *PanicError. On error, part of a
+// GIF may already have been written to w.
+func Convert(ctx context.Context, r io.Reader, w io.Writer, options ProcessOptions) (native.WebPType, error) {
+	if err := ctx.Err(); err != nil {
+		return native.WebPTypeUnknown, err
+	}
+
+	data, err := native.ReadInput(r, options.Limits)
+	if err != nil {
+		return native.WebPTypeUnknown, err
+	}
+
+	// Detect WebP type from the container headers
+	info, err := native.ReadWebPInfo(bytes.NewReader(data))
+	if err != nil {
+		return native.WebPTypeUnknown, fmt.Errorf("failed to detect type: %w", err)
+	}
+	if err := options.Limits.Check(info, int64(len(data))); err != nil {
+		return info.Type, err
+	}
+	if err := ctx.Err(); err != nil {
+		return info.Type, err
+	}
+
+	var content *native.ContentInfo
+	if need := needsAnalysis(info, options); need != analyzeNone {
+		err := callRecovered(func() (err error) {
+			if need == analyzeStill {
+				var still bool
+				still, err = native.IsStillWebP(bytes.NewReader(data), options.Limits)
+				content = stillContent(info, still)
+				return err
+			}
+			content, err = native.AnalyzeWebP(bytes.NewReader(data), options.Limits)
+			return err
+		})
+		if err != nil {
+			return info.Type, fmt.Errorf("failed to analyze content: %w", err)
+		}
+	}
+	facts := Classify(info, content)
+
+	enc, err := selectEncoder(facts, options)
+	if err != nil {
+		return facts.Type, err
+	}
+
+	err = callRecovered(func() error {
+		return enc.Encode(w, data, encodeOptions(options))
+	})
+	return facts.Type, err
+}
@@ -0,0 +1,67 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// Small lossless WebP images: a red 4x4 still, and a red then blue 4x4 animation
+const (
+	staticWebP   = "RIFF\x18\x00\x00\x00WEBPVP8L\f\x00\x00\x00/\x03\xc0\x00\x00(@\xff\v\xd0\xff\x00"
+	animatedWebP = "RIFF|\x00\x00\x00WEBPVP8X\n\x00\x00\x00\x02\x00\x00\x00\x03\x00\x00\x03\x00\x00ANIM\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"ANMF$\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x03\x00\x00d\x00\x00\x00VP8L\f\x00\x00\x00/\x03\xc0\x00\x00(@\xff\v\xd0\xff\x00" +
+		"ANMF$\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x03\x00\x00d\x00\x00\x00VP8L\f\x00\x00\x00/\x03\xc0\x00\x00(@\x01\xfa\xdf\xff\x00"
+)
+
+// TestConvert tests in-memory conversion of both WebP types
+func TestConvert(t *testing.T) {
+	options := DefaultProcessOptions()
+
+	var out bytes.Buffer
+	webpType, err := Convert(context.Background(), bytes.NewReader([]byte(staticWebP)), &out, options)
+	if err != nil || webpType != native.WebPTypeStatic {
+		t.Fatalf("Convert(static) = %v, %v", webpType, err)
+	}
+	if cfg, err := jpeg.DecodeConfig(&out); err != nil || cfg.Width != 4 {
+		t.Errorf("static output: %+v, %v, want a 4x4 JPEG", cfg, err)
+	}
+
+	out.Reset()
+	webpType, err = Convert(context.Background(), bytes.NewReader([]byte(animatedWebP)), &out, options)
+	if err != nil || webpType != native.WebPTypeAnimated {
+		t.Fatalf("Convert(animated) = %v, %v", webpType, err)
+	}
+	if g, err := gif.DecodeAll(&out); err != nil || len(g.Image) != 2 {
+		t.Errorf("animated output: %v, want a 2-frame GIF", err)
+	}
+}
+
+// TestConvertErrors tests cancellation, limits and invalid input
+func TestConvertErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	if _, err := Convert(ctx, bytes.NewReader([]byte(staticWebP)), &out, DefaultProcessOptions()); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: err = %v, want context.Canceled", err)
+	}
+
+	options := DefaultProcessOptions()
+	options.Limits.MaxFrames = 1
+	webpType, err := Convert(context.Background(), bytes.NewReader([]byte(animatedWebP)), &out, options)
+	if !errors.Is(err, native.ErrLimitExceeded) || webpType != native.WebPTypeAnimated {
+		t.Errorf("frame limit: %v, %v, want animated and ErrLimitExceeded", webpType, err)
+	}
+
+	if _, err := Convert(context.Background(), bytes.NewReader([]byte("not a webp file")), &out, DefaultProcessOptions()); err == nil {
+		t.Error("invalid input: err = nil")
+	}
+	if out.Len() != 0 {
+		t.Errorf("%d byte(s) written on errors", out.Len())
+	}
+}
@@ -0,0 +1,272 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tomlParser reads the subset of TOML used by configuration files: [table]
+// headers with dotted keys, key = value pairs, basic and literal strings,
+// integers, floats, booleans, arrays (which may span lines) and # comments
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parseTOML parses a TOML document into nested maps
+func parseTOML(data []byte) (map[string]any, error) {
+	p := &tomlParser{src: string(data), line: 1}
+	root := map[string]any{}
+	table := root
+
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			keys, err := p.keys(']')
+			if err != nil {
+				return nil, err
+			}
+			if table, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.keys('=')
+			if err != nil {
+				return nil, err
+			}
+			if len(keys) != 1 {
+				return nil, p.errorf("dotted keys are only supported in table headers")
+			}
+			p.skipSpace()
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := table[keys[0]]; dup {
+				return nil, p.errorf("duplicate key %q", keys[0])
+			}
+			table[keys[0]] = value
+		}
+
+		// Nothing but a comment may follow on the line
+		p.skipSpace()
+		if !p.eof() && p.peek() != '\n' && p.peek() != '\r' && p.peek() != '#' {
+			return nil, p.errorf("unexpected %q after value", p.peek())
+		}
+	}
+}
+
+// table returns the table at the path of keys below root, creating it
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	table := root
+	for _, key := range keys {
+		next, ok := table[key]
+		if !ok {
+			next = map[string]any{}
+			table[key] = next
+		}
+		if table, ok = next.(map[string]any); !ok {
+			return nil, p.errorf("key %q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// keys reads dot-separated bare or quoted keys up to and including end
+func (p *tomlParser) keys(end byte) ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unexpected end of file")
+		}
+
+		var key string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected key, got %q", c)
+			}
+			key = p.src[start:p.pos]
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unexpected end of file")
+		}
+		switch c := p.peek(); c {
+		case '.':
+			p.pos++
+		case end:
+			p.pos++
+			return keys, nil
+		default:
+			return nil, p.errorf("unexpected %q after key %q", c, key)
+		}
+	}
+}
+
+// value reads a string, number, boolean or array
+func (p *tomlParser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("missing value")
+	}
+
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += len("false")
+		return false, nil
+	case c == '{':
+		return nil, p.errorf("inline tables are not supported")
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte("+-0123456789_.eE", p.peek()) >= 0 {
+		p.pos++
+	}
+	token := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+	if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil && token != "" {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", p.restOfLine(start))
+}
+
+// array reads a bracketed, comma-separated list of values
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++ // [
+	values := []any{}
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in array, got %q", p.peek())
+		}
+	}
+}
+
+// str reads a basic ("...", with escapes) or literal ('...') string on one line
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	start := p.pos
+	for p.pos++; !p.eof(); p.pos++ {
+		switch c := p.peek(); {
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos++
+		case c == quote:
+			p.pos++
+			raw := p.src[start:p.pos]
+			if quote == '\'' {
+				return raw[1 : len(raw)-1], nil
+			}
+			s, err := strconv.Unquote(raw)
+			if err != nil {
+				return "", p.errorf("invalid string %s", raw)
+			}
+			return s, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// skipSpace skips spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.src[p.pos]
+}
+
+// restOfLine returns the source from start to the end of its line
+func (p *tomlParser) restOfLine(start int) string {
+	end := strings.IndexByte(p.src[start:], '\n')
+	if end < 0 {
+		return strings.TrimSpace(p.src[start:])
+	}
+	return strings.TrimSpace(p.src[start : start+end])
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// isBareKeyChar reports whether c may appear in a bare key
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,513 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// IgnoreFileName is the per-directory file listing patterns to skip during the walk
+const IgnoreFileName = ".webpconvertignore"
+
+// DetectMode selects how the walk decides which files are WebP
+type DetectMode int
+
+const (
+	// DetectExtension selects files by their .webp extension
+	DetectExtension DetectMode = iota
+	// DetectContent sniffs the RIFF/WEBP magic of every file, regardless of extension
+	DetectContent
+)
+
+func (m DetectMode) String() string {
+	switch m {
+	case DetectContent:
+		return "content"
+	default:
+		return "extension"
+	}
+}
+
+// ParseDetectMode parses a detection mode name ("extension" or "content")
+func ParseDetectMode(s string) (DetectMode, error) {
+	switch strings.ToLower(s) {
+	case "extension", "ext", "":
+		return DetectExtension, nil
+	case "content":
+		return DetectContent, nil
+	default:
+		return DetectExtension, fmt.Errorf("unknown detect mode %q (use extension or content)", s)
+	}
+}
+
+// walkFunc is called for every file accepted by the walk filters, with the
+// options merged from the configuration files of its directory
+type walkFunc func(path string, info os.FileInfo, options *ProcessOptions) error
+
+// mismatchFunc is called when a file's extension disagrees with its content.
+// format is the detected content format (see native.SniffFormat).
+type mismatchFunc func(path string, format string)
+
+// walker holds the state of a filtered directory walk
+type walker struct {
+	root     string
+	options  ProcessOptions
+	fn       walkFunc
+	mismatch mismatchFunc
+	enter    func(dir string) error // If not nil, called for every directory walked before it is read
+}
+
+// WalkWebPFiles walks rootPath and calls fn for every WebP file selected by the
+// walk filters and detection mode in options, exactly as the converter would
+func WalkWebPFiles(rootPath string, options ProcessOptions, fn func(path string, info os.FileInfo) error) error {
+	return walkWebPFiles(rootPath, options, func(path string, info os.FileInfo, _ *ProcessOptions) error {
+		return fn(path, info)
+	}, nil)
+}
+
+// walkWebPFiles walks rootPath and calls fn for every WebP file that passes
+// the filters configured in options. In DetectContent mode, mismatch (if not nil)
+// is called for disguised WebPs and for .webp files that are not WebP.
+// Unless options.NoConfigFiles is set, the configuration files of
+// subdirectories are merged into the options passed to fn.
+func walkWebPFiles(rootPath string, options ProcessOptions, fn walkFunc, mismatch mismatchFunc) error {
+	w := &walker{root: rootPath, options: options, fn: fn, mismatch: mismatch}
+	return w.walk()
+}
+
+// walk walks w.root, which may also be a single file
+func (w *walker) walk() error {
+	info, err := os.Stat(w.root)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		filePath := w.root
+		w.root = filepath.Dir(filePath)
+		if w.acceptFile(filePath, info, nil) {
+			return w.fn(filePath, info, &w.options)
+		}
+		return nil
+	}
+
+	return w.walkDir(w.root, 1, []os.FileInfo{info}, nil, &w.options)
+}
+
+// walkDir visits the entries of dir, which sits at the given depth below the root.
+// ancestors holds the directories on the current path and is used to detect symlink loops.
+// options are the per-file options merged from the configuration files above dir.
+func (w *walker) walkDir(dir string, depth int, ancestors []os.FileInfo, ignores []*ignoreFile, options *ProcessOptions) error {
+	if w.enter != nil {
+		if err := w.enter(dir); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ignores, options, err = w.enterDir(dir, depth, ignores, options)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		entryPath := filepath.Join(dir, name)
+
+		if w.options.SkipHidden && isHidden(name) {
+			continue
+		}
+
+		var info os.FileInfo
+		if entry.Type()&os.ModeSymlink != 0 {
+			if !w.options.FollowSymlinks {
+				continue
+			}
+			// Resolve the link target; dangling links are skipped
+			info, err = os.Stat(entryPath)
+			if err != nil {
+				w.options.logger().Debug("skipping dangling symlink", "path", entryPath, "error", err)
+				continue
+			}
+		} else {
+			info, err = entry.Info()
+			if err != nil {
+				return err
+			}
+		}
+
+		if info.IsDir() {
+			if !w.acceptDir(name, entryPath, ignores) {
+				continue
+			}
+			// Files inside this directory would exceed the depth limit
+			if w.options.MaxDepth > 0 && depth+1 > w.options.MaxDepth {
+				continue
+			}
+			if isAncestor(info, ancestors) {
+				w.options.logger().Debug("skipping symlink loop", "path", entryPath)
+				continue
+			}
+			if err := w.walkDir(entryPath, depth+1, append(ancestors, info), ignores, options); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		if !w.acceptFile(entryPath, info, ignores) {
+			continue
+		}
+
+		if err := w.fn(entryPath, info, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enterDir adds the ignore file of dir to ignores and merges its
+// configuration file into options
+func (w *walker) enterDir(dir string, depth int, ignores []*ignoreFile, options *ProcessOptions) ([]*ignoreFile, *ProcessOptions, error) {
+	if !w.options.NoIgnoreFiles {
+		ignore, err := loadIgnoreFile(dir, w.relPath(dir))
+		if err != nil {
+			return nil, nil, err
+		}
+		if ignore != nil {
+			// Copy so sibling directories don't share appended matchers
+			ignores = append(ignores[:len(ignores):len(ignores)], ignore)
+		}
+	}
+
+	// The caller merges the configuration of the root itself
+	if depth > 1 && !w.options.NoConfigFiles {
+		var err error
+		if options, err = dirOptions(dir, options); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ignores, options, nil
+}
+
+// walkFile applies the walk of rootPath to a single file below it: fn is
+// called if the walk would select filePath, with the options merged for its
+// directory. Only the directories on the way to the file are read.
+func walkFile(rootPath, filePath string, options ProcessOptions, fn walkFunc, mismatch mismatchFunc) error {
+	w := &walker{root: rootPath, options: options, fn: fn, mismatch: mismatch}
+	return w.walkPath(filePath)
+}
+
+// walkPath applies the walk of w.root to a file or directory below it, as
+// walkFile does: the directories on the way are read for their ignore and
+// configuration files, and a directory the walk would enter is walked.
+func (w *walker) walkPath(p string) error {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	fileOptions := &w.options
+	var ignores []*ignoreFile
+	var ancestors []os.FileInfo
+	if w.options.FollowSymlinks {
+		// Only links can lead back to a directory on the way
+		info, err := os.Stat(w.root)
+		if err != nil {
+			return err
+		}
+		ancestors = append(ancestors, info)
+	}
+	dir := w.root
+	names := strings.Split(rel, string(filepath.Separator))
+	for depth := 1; ; depth++ {
+		if ignores, fileOptions, err = w.enterDir(dir, depth, ignores, fileOptions); err != nil {
+			return err
+		}
+
+		name := names[depth-1]
+		entryPath := filepath.Join(dir, name)
+		if w.options.SkipHidden && isHidden(name) {
+			return nil
+		}
+		info, err := os.Lstat(entryPath)
+		if err != nil {
+			return nil // Removed meanwhile
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !w.options.FollowSymlinks {
+				return nil
+			}
+			if info, err = os.Stat(entryPath); err != nil {
+				return nil
+			}
+		}
+
+		if depth == len(names) && !info.IsDir() {
+			if !info.Mode().IsRegular() || !w.acceptFile(entryPath, info, ignores) {
+				return nil
+			}
+			return w.fn(entryPath, info, fileOptions)
+		}
+
+		if !info.IsDir() || !w.acceptDir(name, entryPath, ignores) {
+			return nil
+		}
+		if w.options.MaxDepth > 0 && depth+1 > w.options.MaxDepth {
+			return nil
+		}
+		if isAncestor(info, ancestors) {
+			return nil
+		}
+		ancestors = append(ancestors, info)
+		if depth == len(names) {
+			return w.walkDir(entryPath, depth+1, ancestors, ignores, fileOptions)
+		}
+		dir = entryPath
+	}
+}
+
+// acceptDir reports whether the walk should descend into a directory
+func (w *walker) acceptDir(name, dirPath string, ignores []*ignoreFile) bool {
+	for _, skip := range w.options.SkipDirs {
+		if name == skip {
+			return false
+		}
+	}
+
+	rel := w.relPath(dirPath)
+	if matchAny(w.options.Exclude, rel) {
+		return false
+	}
+
+	return !isIgnored(ignores, rel, true)
+}
+
+// acceptFile reports whether a regular file passes the name, size and time filters
+// and is a WebP according to the detection mode
+func (w *walker) acceptFile(filePath string, info os.FileInfo, ignores []*ignoreFile) bool {
+	if w.options.Detect == DetectExtension && !hasWebPExt(filePath) {
+		return false
+	}
+
+	rel := w.relPath(filePath)
+	if len(w.options.Include) > 0 && !matchAny(w.options.Include, rel) {
+		return false
+	}
+	if matchAny(w.options.Exclude, rel) {
+		return false
+	}
+	if isIgnored(ignores, rel, false) {
+		return false
+	}
+
+	size := info.Size()
+	if w.options.MinSize > 0 && size < w.options.MinSize {
+		return false
+	}
+	if w.options.MaxSize > 0 && size > w.options.MaxSize {
+		return false
+	}
+
+	modTime := info.ModTime()
+	if !w.options.ModifiedAfter.IsZero() && !modTime.After(w.options.ModifiedAfter) {
+		return false
+	}
+	if !w.options.ModifiedBefore.IsZero() && !modTime.Before(w.options.ModifiedBefore) {
+		return false
+	}
+
+	if w.options.Detect == DetectContent {
+		return w.sniffWebP(filePath)
+	}
+
+	return true
+}
+
+// sniffWebP checks the file content for the WebP magic and reports extension mismatches
+func (w *walker) sniffWebP(filePath string) bool {
+	webpExt := hasWebPExt(filePath)
+
+	format, err := native.SniffFile(filePath)
+	if err != nil {
+		// Let unreadable .webp files through so the conversion reports the error
+		return webpExt
+	}
+
+	isWebP := format == native.FormatWebP
+	if isWebP != webpExt && w.mismatch != nil {
+		w.mismatch(filePath, format)
+	}
+
+	return isWebP
+}
+
+// hasWebPExt reports whether a path has the .webp extension
+func hasWebPExt(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".webp"
+}
+
+// relPath returns p relative to the walk root using forward slashes
+func (w *walker) relPath(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isHidden reports whether a file or directory name is hidden by Unix convention
+func isHidden(name string) bool {
+	return len(name) > 1 && strings.HasPrefix(name, ".")
+}
+
+// isAncestor reports whether dir is one of the directories on the current walk path
+func isAncestor(dir os.FileInfo, ancestors []os.FileInfo) bool {
+	for _, a := range ancestors {
+		if os.SameFile(dir, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether rel matches any of the glob patterns.
+// Patterns containing a slash are matched against the path relative to the root,
+// other patterns against the base name.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a single glob pattern against a slash-separated relative path
+func matchPattern(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments, where a "**" segment matches zero or more segments
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ignoreRule is a single pattern line from an ignore file
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreFile holds the rules of one .webpconvertignore file
+type ignoreFile struct {
+	base  string // Directory of the ignore file, relative to the walk root
+	rules []ignoreRule
+}
+
+// loadIgnoreFile reads the ignore file in dir, returning nil if there is none
+func loadIgnoreFile(dir, base string) (*ignoreFile, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ignore file: %w", err)
+	}
+	defer f.Close()
+
+	ignore := &ignoreFile{base: base}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		ignore.rules = append(ignore.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ignore file: %w", err)
+	}
+
+	return ignore, nil
+}
+
+// isIgnored applies the ignore files from the root down; the last matching rule wins
+func isIgnored(ignores []*ignoreFile, rel string, isDir bool) bool {
+	ignored := false
+	for _, ignore := range ignores {
+		local := rel
+		if ignore.base != "" {
+			local = strings.TrimPrefix(rel, ignore.base+"/")
+		}
+		for _, rule := range ignore.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			var ok bool
+			if rule.anchored {
+				ok = matchSegments(strings.Split(rule.pattern, "/"), strings.Split(local, "/"))
+			} else {
+				ok, _ = path.Match(rule.pattern, path.Base(local))
+			}
+			if ok {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
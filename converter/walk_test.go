@@ -0,0 +1,189 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// createTree creates empty files (and their parent directories) below root
+func createTree(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, name := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("RIFF"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+}
+
+// walkedFiles returns the walked paths relative to root, sorted
+func walkedFiles(t *testing.T, root string, options ProcessOptions) []string {
+	t.Helper()
+	var files []string
+	err := walkWebPFiles(root, options, func(path string, info os.FileInfo, _ *ProcessOptions) error {
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("walkWebPFiles failed: %v", err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// TestWalkFilters tests include/exclude patterns, depth and directory skipping
+func TestWalkFilters(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root,
+		"a.webp",
+		"b.WEBP",
+		"notes.txt",
+		"photos/c.webp",
+		"photos/thumbs/d.webp",
+		"node_modules/e.webp",
+		".cache/f.webp",
+		".hidden.webp",
+	)
+
+	tests := []struct {
+		name    string
+		options ProcessOptions
+		want    []string
+	}{
+		{"all", ProcessOptions{}, []string{".cache/f.webp", ".hidden.webp", "a.webp", "b.WEBP", "node_modules/e.webp", "photos/c.webp", "photos/thumbs/d.webp"}},
+		{"max depth", ProcessOptions{MaxDepth: 1}, []string{".hidden.webp", "a.webp", "b.WEBP"}},
+		{"max depth 2", ProcessOptions{MaxDepth: 2, SkipHidden: true, SkipDirs: []string{"node_modules"}}, []string{"a.webp", "b.WEBP", "photos/c.webp"}},
+		{"include base name", ProcessOptions{Include: []string{"[ab].*"}}, []string{"a.webp", "b.WEBP"}},
+		{"include path", ProcessOptions{Include: []string{"photos/**/*.webp"}}, []string{"photos/c.webp", "photos/thumbs/d.webp"}},
+		{"exclude directory", ProcessOptions{Exclude: []string{"thumbs"}, SkipHidden: true, SkipDirs: []string{"node_modules"}}, []string{"a.webp", "b.WEBP", "photos/c.webp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := walkedFiles(t, root, tt.options)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWalkSizeAndTimeFilters tests the size and modification time filters
+func TestWalkSizeAndTimeFilters(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, "small.webp", "old.webp")
+	if err := os.WriteFile(filepath.Join(root, "large.webp"), make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "old.webp"), old, old); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+
+	if got := walkedFiles(t, root, ProcessOptions{MinSize: 1024}); !reflect.DeepEqual(got, []string{"large.webp"}) {
+		t.Errorf("MinSize: got %v", got)
+	}
+	if got := walkedFiles(t, root, ProcessOptions{MaxSize: 1024}); !reflect.DeepEqual(got, []string{"old.webp", "small.webp"}) {
+		t.Errorf("MaxSize: got %v", got)
+	}
+	if got := walkedFiles(t, root, ProcessOptions{ModifiedBefore: time.Now().Add(-24 * time.Hour)}); !reflect.DeepEqual(got, []string{"old.webp"}) {
+		t.Errorf("ModifiedBefore: got %v", got)
+	}
+	if got := walkedFiles(t, root, ProcessOptions{ModifiedAfter: time.Now().Add(-24 * time.Hour)}); !reflect.DeepEqual(got, []string{"large.webp", "small.webp"}) {
+		t.Errorf("ModifiedAfter: got %v", got)
+	}
+}
+
+// TestWalkIgnoreFile tests .webpconvertignore handling
+func TestWalkIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root,
+		"keep.webp",
+		"skip.webp",
+		"drafts/a.webp",
+		"sub/b.webp",
+		"sub/c.webp",
+		"sub/deep/c.webp",
+	)
+	writeIgnore := func(dir, content string) {
+		if err := os.WriteFile(filepath.Join(root, dir, IgnoreFileName), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write ignore file: %v", err)
+		}
+	}
+	writeIgnore(".", "# comment\nskip.webp\ndrafts/\nc.webp\n")
+	writeIgnore("sub", "!/deep/c.webp\n")
+
+	want := []string{"keep.webp", "sub/b.webp", "sub/deep/c.webp"}
+	if got := walkedFiles(t, root, ProcessOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := walkedFiles(t, root, ProcessOptions{NoIgnoreFiles: true}); len(got) != 6 {
+		t.Errorf("NoIgnoreFiles: got %v", got)
+	}
+}
+
+// TestWalkSymlinks tests that symlinks are skipped by default and loops are detected
+func TestWalkSymlinks(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, "dir/a.webp")
+	if err := os.Symlink(root, filepath.Join(root, "dir", "loop")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "dir", "a.webp"), filepath.Join(root, "link.webp")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if got := walkedFiles(t, root, ProcessOptions{}); !reflect.DeepEqual(got, []string{"dir/a.webp"}) {
+		t.Errorf("default: got %v", got)
+	}
+
+	want := []string{"dir/a.webp", "link.webp"}
+	if got := walkedFiles(t, root, ProcessOptions{FollowSymlinks: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("FollowSymlinks: got %v, want %v", got, want)
+	}
+}
+
+// TestWalkContentDetection tests WebP detection by magic bytes instead of extension
+func TestWalkContentDetection(t *testing.T) {
+	root := t.TempDir()
+	files := map[string][]byte{
+		"real.webp":     []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+		"disguised.jpg": []byte("RIFF\x24\x00\x00\x00WEBPVP8L"),
+		"fake.webp":     {0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01},
+		"photo.png":     []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	var accepted []string
+	mismatches := map[string]string{}
+	err := walkWebPFiles(root, ProcessOptions{Detect: DetectContent}, func(path string, info os.FileInfo, _ *ProcessOptions) error {
+		accepted = append(accepted, filepath.Base(path))
+		return nil
+	}, func(path string, format string) {
+		mismatches[filepath.Base(path)] = format
+	})
+	if err != nil {
+		t.Fatalf("walkWebPFiles failed: %v", err)
+	}
+	sort.Strings(accepted)
+
+	if want := []string{"disguised.jpg", "real.webp"}; !reflect.DeepEqual(accepted, want) {
+		t.Errorf("accepted %v, want %v", accepted, want)
+	}
+	if want := map[string]string{"disguised.jpg": "webp", "fake.webp": "jpeg"}; !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("mismatches %v, want %v", mismatches, want)
+	}
+}
@@ -0,0 +1,324 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of WatchOptions
+const (
+	DefaultSettle       = 2 * time.Second
+	DefaultPollInterval = 2 * time.Second
+)
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	Settle   time.Duration // Time a file must go unchanged after its last write before it is converted (default: DefaultSettle)
+	Interval time.Duration // Scan interval of the polling watcher (default: DefaultPollInterval)
+	Poll     bool          // Poll even where inotify is available (default: false)
+	Initial  bool          // Also convert the files present when watching starts (default: false)
+}
+
+// changeWatcher reports the files below a directory that may have been
+// created or written
+type changeWatcher interface {
+	// run sends the paths of changed files until ctx is done or watching
+	// fails. A path may be sent several times for one change.
+	run(ctx context.Context, changed chan<- string) error
+}
+
+// Watch converts the WebP files below rootPath as they are created, moved
+// in or changed, until ctx is done. Changes are reported by inotify on
+// Linux and otherwise, or with watch.Poll or options.FollowSymlinks, by
+// scanning the tree every watch.Interval. A file is converted once its
+// size and modification time have not changed for watch.Settle, so files
+// still being written are left alone. The walk filters, ignore files and
+// configuration files of subdirectories apply as in ProcessDirectory; a file
+// whose configuration file cannot be read fails.
+//
+// Files are converted by the worker pool of ProcessDirectoryParallel and
+// reported to options.Observer with the same events: EventScanFinished
+// follows the initial scan, after which EventFileDiscovered announces each
+// file that settles, so Total keeps growing. EventBatchFinished is sent when
+// watching stops. With options.FailFast, the first failure stops watching.
+// If any file failed, the returned error is a *BatchError.
+func Watch(parent context.Context, rootPath string, options ProcessOptions, watch WatchOptions) (err error) {
+	if watch.Settle <= 0 {
+		watch.Settle = DefaultSettle
+	}
+	if watch.Interval <= 0 {
+		watch.Interval = DefaultPollInterval
+	}
+
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", rootPath)
+	}
+
+	notify := newNotifier(rootPath, options)
+	var stats ProcessStats
+	var discovered atomic.Int64
+	defer func() {
+		notify.emit(Event{Type: EventBatchFinished, Stats: &stats, Total: int(discovered.Load()), Err: err})
+	}()
+
+	numWorkers := options.NumWorkers
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	b := newBatch(numWorkers, options.MaxMemory)
+	defer b.removeAbandoned()
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	stop := func() {}
+	if options.FailFast {
+		stop = cancel
+	}
+
+	// Start watching before the initial scan, so no file falls in between
+	watcher, err := newChangeWatcher(rootPath, options, watch)
+	if err != nil {
+		return fmt.Errorf("error watching directory: %w", err)
+	}
+	changed := make(chan string, 64)
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- watcher.run(ctx, changed)
+	}()
+
+	jobs := make(chan ConversionJob, numWorkers*jobQueueFactor)
+	results := make(chan ConversionResult, numWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go worker(ctx, i, jobs, results, options, b, stop, notify, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var failures []*FileError
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		stats, failures = collectStats(results, func() int { return int(discovered.Load()) }, notify)
+	}()
+
+	queue := func(path string, info os.FileInfo, fileOptions *ProcessOptions) error {
+		n := discovered.Add(1)
+		notify.emit(Event{Type: EventFileDiscovered, Path: path, Total: int(n)})
+		jobs <- ConversionJob{Path: path, FileInfo: info, options: fileOptions}
+		return nil
+	}
+	mismatches := 0
+	mismatch := func(path string, format string) {
+		mismatches++
+		notify.emit(Event{Type: EventExtensionMismatch, Path: path, Format: format})
+	}
+
+	notify.emit(Event{Type: EventScanStarted})
+	var watchErr error
+	if watch.Initial {
+		if watchErr = walkWebPFiles(rootPath, options, queue, mismatch); watchErr != nil {
+			watchErr = fmt.Errorf("error scanning directory: %w", watchErr)
+			cancel()
+		}
+	}
+	if watchErr == nil {
+		notify.emit(Event{Type: EventScanFinished, Total: int(discovered.Load()), Workers: numWorkers})
+	}
+
+	// Hold changed files until they settle, then select them like the walk
+	pending := newDebouncer(watch.Settle)
+	ticker := time.NewTicker(max(watch.Settle/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case path := <-changed:
+			pending.touch(path, time.Now())
+		case <-ticker.C:
+			for _, path := range pending.ready(time.Now()) {
+				if err := walkFile(rootPath, path, options, queue, mismatch); err != nil {
+					// A broken configuration or ignore file fails its files,
+					// not the watch
+					n := discovered.Add(1)
+					notify.emit(Event{Type: EventFileDiscovered, Path: path, Total: int(n)})
+					results <- ConversionResult{Path: path, Error: err}
+					stop()
+				}
+			}
+		case watchErr = <-watchDone:
+			watchDone = nil
+			if watchErr != nil {
+				watchErr = fmt.Errorf("error watching directory: %w", watchErr)
+			}
+			cancel()
+		}
+	}
+
+	close(jobs)
+	if watchDone != nil {
+		<-watchDone
+	}
+	<-collected
+	stats.MismatchCount = mismatches
+	if watchErr != nil {
+		return watchErr
+	}
+	return batchError(failures, stats, interruption(parent, stats, false))
+}
+
+// newChangeWatcher returns the inotify watcher where available and wanted,
+// else the polling watcher. Either sees the changes made after it returns.
+func newChangeWatcher(rootPath string, options ProcessOptions, watch WatchOptions) (changeWatcher, error) {
+	// inotify does not see through symbolic links
+	if !watch.Poll && !options.FollowSymlinks {
+		w, err := newNotifyWatcher(rootPath, options)
+		if err == nil {
+			return w, nil
+		}
+		options.logger().Warn("falling back to polling", "error", err, "interval", watch.Interval)
+	}
+	return newPollWatcher(rootPath, options, watch.Interval)
+}
+
+// fileStamp identifies a version of a file's content
+type fileStamp struct {
+	size    int64
+	modTime int64 // Nanoseconds since the epoch
+}
+
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// debouncer holds changed files until they have been quiet for settle and
+// their size and modification time no longer change
+type debouncer struct {
+	settle  time.Duration
+	pending map[string]*pendingFile
+}
+
+// pendingFile is a changed file waiting to settle
+type pendingFile struct {
+	due   time.Time
+	stamp fileStamp
+}
+
+func newDebouncer(settle time.Duration) *debouncer {
+	return &debouncer{settle: settle, pending: make(map[string]*pendingFile)}
+}
+
+// touch records a change of path at now, restarting its wait
+func (d *debouncer) touch(path string, now time.Time) {
+	p, ok := d.pending[path]
+	if !ok {
+		p = &pendingFile{}
+		d.pending[path] = p
+	}
+	p.due = now.Add(d.settle)
+	if info, err := os.Stat(path); err == nil {
+		p.stamp = stampOf(info)
+	}
+}
+
+// ready removes and returns, sorted, the files whose wait is over at now.
+// A file that changed without an event waits again; one that is gone is
+// dropped.
+func (d *debouncer) ready(now time.Time) []string {
+	var paths []string
+	for path, p := range d.pending {
+		if now.Before(p.due) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			delete(d.pending, path)
+			continue
+		}
+		if stamp := stampOf(info); stamp != p.stamp {
+			p.due, p.stamp = now.Add(d.settle), stamp
+			continue
+		}
+		delete(d.pending, path)
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// pollWatcher finds changes by scanning the tree with the walk filters and
+// comparing the size and modification time of every file
+type pollWatcher struct {
+	root     string
+	options  ProcessOptions
+	interval time.Duration
+	seen     map[string]fileStamp // Stamps of the last scan
+}
+
+// newPollWatcher scans the tree for the baseline the first poll compares
+// against, so files added once it returns are reported
+func newPollWatcher(root string, options ProcessOptions, interval time.Duration) (*pollWatcher, error) {
+	w := &pollWatcher{root: root, options: options, interval: interval}
+	seen, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.seen = seen
+	return w, nil
+}
+
+func (w *pollWatcher) run(ctx context.Context, changed chan<- string) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := w.scan()
+		if err != nil {
+			// Directories may vanish while they are read
+			w.options.logger().Debug("scan failed", "root", w.root, "error", err)
+			continue
+		}
+		for path, stamp := range current {
+			if old, ok := w.seen[path]; ok && old == stamp {
+				continue
+			}
+			select {
+			case changed <- path:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		w.seen = current
+	}
+}
+
+// scan returns the stamps of the files the walk selects; configuration
+// files are read when a file settles
+func (w *pollWatcher) scan() (map[string]fileStamp, error) {
+	options := w.options
+	options.NoConfigFiles = true
+	stamps := make(map[string]fileStamp)
+	err := walkWebPFiles(w.root, options, func(path string, info os.FileInfo, _ *ProcessOptions) error {
+		stamps[path] = stampOf(info)
+		return nil
+	}, nil)
+	return stamps, err
+}
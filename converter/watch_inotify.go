@@ -0,0 +1,244 @@
+//go:build linux
+
+package converter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// inotifyMask selects the events of a watched directory that can leave a
+// new or changed file behind, or take one away
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM
+
+// inotifyWatcher watches a tree with one inotify watch per directory the
+// walk enters
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File // fd, read through the runtime poller so Close ends a pending Read
+	root    string
+	options ProcessOptions
+	dirs    map[int32]string     // Watched directory of each watch descriptor
+	stamps  map[string]fileStamp // Files the walk selects, as last reported or found
+}
+
+// newNotifyWatcher watches the directories below root. It fails where
+// inotify is not available or the watch limit
+// (fs.inotify.max_user_watches) is too low for the tree.
+func newNotifyWatcher(root string, options ProcessOptions) (changeWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		root:    root,
+		options: options,
+		dirs:    make(map[int32]string),
+	}
+	if w.stamps, err = w.scan(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// scan walks dir, the root or a directory below it, like the conversion,
+// watching every directory it enters, and returns the stamps of the files it
+// selects. Configuration files are read when a file settles.
+func (w *inotifyWatcher) scan(dir string) (map[string]fileStamp, error) {
+	options := w.options
+	options.NoConfigFiles = true
+	stamps := make(map[string]fileStamp)
+	walk := &walker{
+		root:    w.root,
+		options: options,
+		fn: func(path string, info os.FileInfo, _ *ProcessOptions) error {
+			stamps[path] = stampOf(info)
+			return nil
+		},
+		enter: func(dir string) error {
+			wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+			if err != nil {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			w.dirs[int32(wd)] = dir
+			return nil
+		},
+	}
+	if dir == w.root {
+		return stamps, walk.walk()
+	}
+	return stamps, walk.walkPath(dir)
+}
+
+// rescan walks dir, the root or a directory added below it, and reports the
+// files that are new or changed since they were last seen
+func (w *inotifyWatcher) rescan(dir string, send func(path string) bool) error {
+	current, err := w.scan(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// A directory vanished while it was read; its removal is reported too
+			w.options.logger().Debug("rescan failed", "root", w.root, "dir", dir, "error", err)
+			return nil
+		}
+		return err
+	}
+
+	previous := w.stamps
+	if dir == w.root {
+		w.stamps = current
+	} else {
+		previous = w.forget(dir)
+		maps.Copy(w.stamps, current)
+	}
+	for path, stamp := range current {
+		if old, ok := previous[path]; ok && old == stamp {
+			continue
+		}
+		if !send(path) {
+			break
+		}
+	}
+	return nil
+}
+
+// forget removes and returns the stamps of the files below dir
+func (w *inotifyWatcher) forget(dir string) map[string]fileStamp {
+	removed := make(map[string]fileStamp)
+	for path, stamp := range w.stamps {
+		if isBelow(path, dir) {
+			removed[path] = stamp
+			delete(w.stamps, path)
+		}
+	}
+	return removed
+}
+
+// unwatch stops watching dir and the directories below it, which moved
+// elsewhere; their watches would follow them
+func (w *inotifyWatcher) unwatch(dir string) {
+	for wd, watched := range w.dirs {
+		if watched == dir || isBelow(watched, dir) {
+			// Fails if the directory was removed meanwhile
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// stamp records the stamp of the file at path if the walk selects it, and
+// forgets it otherwise
+func (w *inotifyWatcher) stamp(path string) {
+	delete(w.stamps, path)
+	options := w.options
+	options.NoConfigFiles = true
+	err := walkFile(w.root, path, options, func(path string, info os.FileInfo, _ *ProcessOptions) error {
+		w.stamps[path] = stampOf(info)
+		return nil
+	}, nil)
+	if err != nil {
+		w.options.logger().Debug("cannot select changed file", "path", path, "error", err)
+	}
+}
+
+// isBelow reports whether path is inside the directory dir
+func isBelow(path, dir string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func (w *inotifyWatcher) run(ctx context.Context, changed chan<- string) error {
+	defer w.file.Close()
+	stop := context.AfterFunc(ctx, func() { w.file.Close() })
+	defer stop()
+
+	send := func(path string) bool {
+		select {
+		case changed <- path:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return os.NewSyscallError("read inotify", err)
+		}
+
+		// Events are a header and a name padded with NULs
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			off += syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[off:off+nameLen]), "\x00")
+			off += nameLen
+
+			if err := w.handle(wd, mask, name, send); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle reports the file of an event and keeps the stamps to the files
+// in the tree. A directory that appears is walked; the whole tree is only
+// walked again when events were lost.
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string, send func(path string) bool) error {
+	switch {
+	case mask&syscall.IN_Q_OVERFLOW != 0:
+		w.options.logger().Warn("inotify queue overflow, rescanning", "root", w.root)
+		return w.rescan(w.root, send)
+	case mask&syscall.IN_IGNORED != 0:
+		// The directory was removed
+		delete(w.dirs, wd)
+		return nil
+	}
+
+	dir, ok := w.dirs[wd]
+	if !ok || name == "" {
+		return nil
+	}
+	path := filepath.Join(dir, name)
+	gone := mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0
+
+	if mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case gone:
+			w.forget(path)
+			w.unwatch(path)
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			// The walk decides whether to watch it, and reports the files
+			// created in it before the watch
+			return w.rescan(path, send)
+		}
+		return nil
+	}
+
+	switch {
+	case gone:
+		// Converted originals leave this way; kept ones stay stamped so
+		// that a rescan does not convert them again
+		delete(w.stamps, path)
+		return nil
+	case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+		w.stamp(path)
+	}
+	send(path)
+	return nil
+}
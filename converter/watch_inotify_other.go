@@ -0,0 +1,10 @@
+//go:build !linux
+
+package converter
+
+import "errors"
+
+// newNotifyWatcher fails outside Linux, where Watch polls instead
+func newNotifyWatcher(root string, options ProcessOptions) (changeWatcher, error) {
+	return nil, errors.New("inotify is only available on Linux")
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+// TestInotifyRescan tests that only the directories the walk enters are
+// watched and that a rescan reports only new and changed files
+func TestInotifyRescan(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root,
+		"a.webp",
+		"b.webp",
+		"keep/c.webp",
+		"ignored/d.webp",
+		"node_modules/e.webp",
+		"deep/er/f.webp",
+	)
+	writeConfig(t, root, IgnoreFileName, "ignored/\n")
+
+	options := ProcessOptions{SkipDirs: []string{"node_modules"}, MaxDepth: 2}
+	cw, err := newNotifyWatcher(root, options)
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	w := cw.(*inotifyWatcher)
+	defer w.file.Close()
+
+	var dirs []string
+	for _, dir := range w.dirs {
+		rel, _ := filepath.Rel(root, dir)
+		dirs = append(dirs, filepath.ToSlash(rel))
+	}
+	sort.Strings(dirs)
+	if want := []string{".", "deep", "keep"}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("watched %v, want %v", dirs, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "b.webp"), []byte("RIFF1234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	createTree(t, root, "new/g.webp", "ignored/h.webp")
+
+	var sent []string
+	err = w.rescan(root, func(path string) bool {
+		rel, _ := filepath.Rel(root, path)
+		sent = append(sent, filepath.ToSlash(rel))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("rescan failed: %v", err)
+	}
+	sort.Strings(sent)
+	if want := []string{"b.webp", "new/g.webp"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("rescan sent %v, want %v", sent, want)
+	}
+}
+
+// TestInotifyEvents tests that a new directory is walked alone and that the
+// stamps only hold the selected files still in the tree
+func TestInotifyEvents(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, "a.webp", "keep/c.webp")
+
+	cw, err := newNotifyWatcher(root, ProcessOptions{})
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	w := cw.(*inotifyWatcher)
+	defer w.file.Close()
+
+	var sent []string
+	send := func(path string) bool {
+		rel, _ := filepath.Rel(root, path)
+		sent = append(sent, filepath.ToSlash(rel))
+		return true
+	}
+	// handle takes events by hand; the queued ones are never read
+	handle := func(dir string, mask uint32, name string) {
+		t.Helper()
+		sent = nil
+		for wd, watched := range w.dirs {
+			if watched == filepath.Join(root, dir) {
+				if err := w.handle(wd, mask, name, send); err != nil {
+					t.Fatalf("handle %s: %v", name, err)
+				}
+				return
+			}
+		}
+		t.Fatalf("%s is not watched", dir)
+	}
+	stamped := func() []string {
+		var paths []string
+		for path := range w.stamps {
+			rel, _ := filepath.Rel(root, path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// A change the watcher has not seen is left to its own event
+	if err := os.WriteFile(filepath.Join(root, "a.webp"), []byte("RIFF1234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	createTree(t, root, "sub/x/g.webp")
+	handle(".", syscall.IN_CREATE|syscall.IN_ISDIR, "sub")
+	if want := []string{"sub/x/g.webp"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("new directory sent %v, want %v", sent, want)
+	}
+	handle("sub/x", syscall.IN_CLOSE_WRITE, "g.webp")
+
+	// Outputs are reported, for the walk to pass over, but not stamped
+	createTree(t, root, "a_converted.jpg")
+	handle(".", syscall.IN_CLOSE_WRITE, "a_converted.jpg")
+	if want := []string{"a_converted.jpg"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("output sent %v, want %v", sent, want)
+	}
+	if want := []string{"a.webp", "keep/c.webp", "sub/x/g.webp"}; !reflect.DeepEqual(stamped(), want) {
+		t.Errorf("stamped %v, want %v", stamped(), want)
+	}
+
+	// Removed files and directories moved away are forgotten
+	if err := os.Remove(filepath.Join(root, "keep/c.webp")); err != nil {
+		t.Fatal(err)
+	}
+	handle("keep", syscall.IN_DELETE, "c.webp")
+	if err := os.Rename(filepath.Join(root, "sub"), filepath.Join(t.TempDir(), "sub")); err != nil {
+		t.Fatal(err)
+	}
+	handle(".", syscall.IN_MOVED_FROM|syscall.IN_ISDIR, "sub")
+	if sent != nil {
+		t.Errorf("removals sent %v", sent)
+	}
+	if want := []string{"a.webp"}; !reflect.DeepEqual(stamped(), want) {
+		t.Errorf("stamped %v after removals, want %v", stamped(), want)
+	}
+	for _, dir := range w.dirs {
+		if rel, _ := filepath.Rel(root, dir); rel != "." && rel != "keep" {
+			t.Errorf("still watching %s", rel)
+		}
+	}
+}
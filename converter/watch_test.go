@@ -0,0 +1,226 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDebouncer tests that files are held until they stop changing
+func TestDebouncer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.webp")
+	if err := os.WriteFile(path, []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDebouncer(time.Second)
+	start := time.Now()
+	d.touch(path, start)
+	d.touch(filepath.Join(dir, "gone.webp"), start)
+	if got := d.ready(start.Add(500 * time.Millisecond)); got != nil {
+		t.Errorf("ready before settling: %v", got)
+	}
+
+	// A write without an event restarts the wait
+	if err := os.WriteFile(path, []byte("RIFF1234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.ready(start.Add(time.Second)); got != nil {
+		t.Errorf("ready after a silent write: %v", got)
+	}
+	if got, want := d.ready(start.Add(2*time.Second)), []string{path}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ready = %v, want %v", got, want)
+	}
+	if len(d.pending) != 0 {
+		t.Errorf("still pending: %v", d.pending)
+	}
+}
+
+// TestWalkFile tests that a single file is selected exactly as by the walk
+func TestWalkFile(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root,
+		"a.webp",
+		"notes.txt",
+		"keep/b.webp",
+		"keep/skipped.webp",
+		"node_modules/c.webp",
+		"deep/er/d.webp",
+		"png/e.webp",
+	)
+	writeConfig(t, root, "keep/"+IgnoreFileName, "skipped.webp\n")
+	writeConfig(t, root, "png/webpconvert.json", `{"format": "png"}`)
+
+	options := ProcessOptions{SkipDirs: []string{"node_modules"}, MaxDepth: 2}
+	var walked []string
+	formats := map[string]string{}
+	for _, name := range []string{"a.webp", "notes.txt", "keep/b.webp", "keep/skipped.webp", "node_modules/c.webp", "deep/er/d.webp", "png/e.webp", "../outside.webp"} {
+		err := walkFile(root, filepath.Join(root, filepath.FromSlash(name)), options, func(path string, info os.FileInfo, fileOptions *ProcessOptions) error {
+			rel, _ := filepath.Rel(root, path)
+			walked = append(walked, filepath.ToSlash(rel))
+			formats[filepath.ToSlash(rel)] = fileOptions.Format
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatalf("walkFile(%s) failed: %v", name, err)
+		}
+	}
+
+	if want := []string{"a.webp", "keep/b.webp", "png/e.webp"}; !reflect.DeepEqual(walked, want) {
+		t.Errorf("walked %v, want %v", walked, want)
+	}
+	if formats["png/e.webp"] != "png" || formats["a.webp"] != "" {
+		t.Errorf("merged formats %v", formats)
+	}
+}
+
+// TestWatch tests that files created or moved in while watching are
+// converted once they settle, with both change watchers
+func TestWatch(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		root := t.TempDir()
+		createTree(t, root, "old.webp")
+		if err := os.WriteFile(filepath.Join(root, "old.webp"), []byte(staticWebP), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var mu sync.Mutex
+		var finished []string
+		var batch *Event
+		options := DefaultProcessOptions()
+		options.NumWorkers = 2
+		options.Observer = ObserverFunc(func(event Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch event.Type {
+			case EventFileFinished:
+				finished = append(finished, filepath.Base(event.Path))
+			case EventBatchFinished:
+				batch = &event
+			}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- Watch(ctx, root, options, WatchOptions{Settle: 50 * time.Millisecond, Interval: 20 * time.Millisecond, Poll: poll})
+		}()
+
+		// Give the watcher time to start, then add a file in a new
+		// directory and move another one in
+		time.Sleep(100 * time.Millisecond)
+		createTree(t, root, "new/a.webp")
+		if err := os.WriteFile(filepath.Join(root, "new", "a.webp"), []byte(staticWebP), 0644); err != nil {
+			t.Fatal(err)
+		}
+		staged := filepath.Join(t.TempDir(), "b.webp")
+		if err := os.WriteFile(staged, []byte(staticWebP), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(staged, filepath.Join(root, "b.webp")); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{filepath.Join(root, "new", "a.jpg"), filepath.Join(root, "b.jpg")}
+		deadline := time.Now().Add(5 * time.Second)
+		for !exist(want...) && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("poll %v: Watch failed: %v", poll, err)
+		}
+
+		if !exist(want...) {
+			t.Errorf("poll %v: outputs missing, converted %v", poll, finished)
+		}
+		if exist(filepath.Join(root, "old.jpg")) {
+			t.Errorf("poll %v: existing file converted without Initial", poll)
+		}
+		if batch == nil || batch.Stats.TotalProcessed != 2 || batch.Total != 2 {
+			t.Errorf("poll %v: batch finished %+v", poll, batch)
+		}
+	}
+}
+
+// TestWatchConfigError tests that a file under a broken configuration file
+// fails in the batch while watching goes on
+func TestWatchConfigError(t *testing.T) {
+	root := t.TempDir()
+	writeConfig(t, root, "broken/webpconvert.json", "{")
+
+	failed := make(chan string, 1)
+	options := DefaultProcessOptions()
+	options.Observer = ObserverFunc(func(event Event) {
+		if event.Type == EventFileFinished && event.Result.Error != nil {
+			failed <- event.Path
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, root, options, WatchOptions{Settle: 50 * time.Millisecond, Interval: 20 * time.Millisecond})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	createTree(t, root, "broken/a.webp")
+	select {
+	case path := <-failed:
+		if filepath.Base(path) != "a.webp" {
+			t.Errorf("failed %s, want a.webp", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("file under the broken configuration was not reported")
+	}
+	cancel()
+
+	var batchErr *BatchError
+	if err := <-done; !errors.As(err, &batchErr) || batchErr.Stats.ErrorCount != 1 || len(batchErr.Failures) != 1 {
+		t.Errorf("expected a batch with one failure, got %v", err)
+	}
+}
+
+// TestPollWatcherBaseline tests that a file added between building the
+// polling watcher and its first scan is reported
+func TestPollWatcherBaseline(t *testing.T) {
+	root := t.TempDir()
+	createTree(t, root, "old.webp")
+
+	w, err := newPollWatcher(root, DefaultProcessOptions(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTree(t, root, "new.webp")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan string, 4)
+	go w.run(ctx, changed)
+
+	select {
+	case path := <-changed:
+		if filepath.Base(path) != "new.webp" {
+			t.Errorf("reported %s, want new.webp", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file not reported")
+	}
+}
+
+// exist reports whether all paths exist
+func exist(paths ...string) bool {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
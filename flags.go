@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+)
+
+// stringListFlag collects a repeatable, comma-separated string flag
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
+
+// repeatedFlag collects a repeatable string flag, one value per occurrence
+type repeatedFlag []string
+
+func (s *repeatedFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *repeatedFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robsonalvesdevbr/webpconvert/converter"
+	"github.com/robsonalvesdevbr/webpconvert/native"
+)
+
+// inspectRecord is the inspection result for one file
+type inspectRecord struct {
+	Path    string              `json:"path"`
+	Format  string              `json:"format"` // Container format detected from the file signature
+	Info    *native.WebPInfo    `json:"info,omitempty"`
+	Content *native.ContentInfo `json:"content,omitempty"`    // Decoded content, with -analyze
+	Class   *inspectClass       `json:"classified,omitempty"` // Facts the converter routes the file by
+	Error   string              `json:"error,omitempty"`
+}
+
+// inspectClass is the classification of a file, see converter.Classify
+type inspectClass struct {
+	Type     native.WebPType `json:"type"`
+	HasAlpha bool            `json:"has_alpha"`
+}
+
+// runInspect implements "webpconvert inspect [flags] <files|dirs>..."
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	formatPtr := fs.String("format", "human", "Output format: human, json or ndjson")
+	detectPtr := fs.String("detect", "extension", "How to find WebP files in directories: extension or content")
+	analyzePtr := fs.Bool("analyze", true, "Decode files to show how the converter classifies them by content (-analyze=false: headers only)")
+	logFlags := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: webpconvert inspect [flags] <files|dirs>...\n\n")
+		fmt.Fprintf(fs.Output(), "Prints the detected type and container details of WebP files.\n")
+		fmt.Fprintf(fs.Output(), "Directories are scanned recursively.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	paths, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
+
+	if len(paths) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	format := strings.ToLower(*formatPtr)
+	if format != "human" && format != "json" && format != "ndjson" {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (use human, json or ndjson)\n", *formatPtr)
+		return exitUsage
+	}
+
+	detectMode, err := converter.ParseDetectMode(*detectPtr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	logger, err := logFlags.newLogger(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	// Collect files: explicit files are always inspected, directories use the converter's walk
+	var files []string
+	for _, arg := range paths {
+		info, err := os.Stat(arg)
+		if err != nil {
+			logger.Error("cannot access path", "path", arg, "error", err)
+			return exitFailure
+		}
+
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		options := converter.ProcessOptions{Detect: detectMode, Logger: logger}
+		err = converter.WalkWebPFiles(arg, options, func(path string, info os.FileInfo) error {
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			logger.Error("cannot scan directory", "path", arg, "error", err)
+			return exitFailure
+		}
+	}
+
+	records := make([]inspectRecord, 0, len(files))
+	failed := 0
+	for _, path := range files {
+		record := inspectFile(path, *analyzePtr)
+		if record.Error != "" {
+			failed++
+		}
+		records = append(records, record)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(records)
+	case "ndjson":
+		enc := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			if err = enc.Encode(record); err != nil {
+				break
+			}
+		}
+	default:
+		for i, record := range records {
+			if i > 0 {
+				fmt.Println()
+			}
+			printInspectRecord(os.Stdout, record)
+		}
+	}
+	if err != nil {
+		logger.Error("cannot write output", "error", err)
+		return exitFailure
+	}
+
+	return outcomeExitCode(failed, len(files))
+}
+
+// inspectFile sniffs and parses a single file with the same code the
+// converter uses; with analyze, it is decoded and classified like -analyze
+// does before a conversion
+func inspectFile(path string, analyze bool) inspectRecord {
+	record := inspectRecord{Path: path}
+
+	format, err := native.SniffFile(path)
+	if err != nil {
+		record.Format = native.FormatUnknown
+		record.Error = err.Error()
+		return record
+	}
+	record.Format = format
+
+	if format != native.FormatWebP {
+		record.Error = fmt.Sprintf("not a WebP file (content is %s)", format)
+		return record
+	}
+
+	info, err := native.GetWebPInfo(path)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	record.Info = info
+
+	if analyze {
+		content, err := native.AnalyzeWebPFile(path, native.Limits{})
+		if err != nil {
+			record.Error = fmt.Sprintf("failed to analyze content: %v", err)
+			return record
+		}
+		record.Content = content
+	}
+	class := converter.Classify(info, record.Content)
+	record.Class = &inspectClass{Type: class.Type, HasAlpha: class.HasAlpha}
+
+	return record
+}
+
+// printInspectRecord writes a human-readable description of a record
+func printInspectRecord(w io.Writer, record inspectRecord) {
+	fmt.Fprintf(w, "%s\n", record.Path)
+	if record.Error != "" {
+		fmt.Fprintf(w, "  Error: %s\n", record.Error)
+		return
+	}
+
+	info := record.Info
+	compression := "lossy"
+	if info.Lossless {
+		compression = "lossless"
+	} else if info.MixedCompression {
+		compression = "mixed lossy/lossless"
+	}
+
+	fmt.Fprintf(w, "  Type:        %s\n", info.Type)
+	fmt.Fprintf(w, "  Canvas:      %dx%d\n", info.Width, info.Height)
+	fmt.Fprintf(w, "  Compression: %s\n", compression)
+	fmt.Fprintf(w, "  Alpha:       %s\n", yesNo(info.HasAlpha))
+	fmt.Fprintf(w, "  File size:   %d bytes\n", info.FileSize)
+	fmt.Fprintf(w, "  Metadata:    ICC %d B, EXIF %d B, XMP %d B\n",
+		info.Metadata.ICCSize, info.Metadata.EXIFSize, info.Metadata.XMPSize)
+
+	if info.Type == native.WebPTypeAnimated {
+		loop := "infinite"
+		if info.LoopCount > 0 {
+			loop = fmt.Sprintf("%d", info.LoopCount)
+		}
+		bg := info.BackgroundColor
+		fmt.Fprintf(w, "  Animation:   %d frame(s), %d ms, loop %s, background #%02x%02x%02x%02x\n",
+			info.FrameCount, info.Duration, loop, bg.R, bg.G, bg.B, bg.A)
+	}
+
+	if content := record.Content; content != nil {
+		colors := strconv.Itoa(content.Colors)
+		if content.Colors > native.MaxPaletteColors {
+			colors = fmt.Sprintf("over %d", native.MaxPaletteColors)
+		}
+		fmt.Fprintf(w, "  Content:     %d of %d frame(s) distinct, alpha used %s, %s colors\n",
+			content.DistinctFrames, content.FrameCount, yesNo(content.UsesAlpha), colors)
+	}
+	if class := record.Class; class != nil {
+		fmt.Fprintf(w, "  Classified:  %s, alpha %s\n", class.Type, yesNo(class.HasAlpha))
+	}
+
+	fmt.Fprintf(w, "  Frames:\n")
+	for _, frame := range info.Frames {
+		kind := "lossy"
+		if frame.Lossless {
+			kind = "lossless"
+		}
+		fmt.Fprintf(w, "    #%-3d %dx%d at (%d,%d) %d ms %s alpha=%s blend=%s dispose=%s offset=%d size=%d\n",
+			frame.Index, frame.Width, frame.Height, frame.X, frame.Y, frame.Duration, kind,
+			yesNo(frame.HasAlpha), yesNo(frame.Blend), yesNo(frame.Dispose), frame.Offset, frame.Size)
+	}
+}
+
+// yesNo formats a boolean for human output
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
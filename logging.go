@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// logFlags holds the logging flags shared by the subcommands
+type logFlags struct {
+	format *string
+	level  *string
+}
+
+// addLogFlags registers -log-format and -log-level on fs
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		format: fs.String("log-format", "text", "Diagnostic log format on stderr: text or json"),
+		level:  fs.String("log-level", "warn", "Minimum log level: debug, info, warn or error"),
+	}
+}
+
+// newLogger builds the logger selected by the flags, writing to w
+func (f *logFlags) newLogger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*f.level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", *f.level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(*f.format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", *f.format)
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"runtime"
 
 	"github.com/robsonalvesdevbr/webpconvert/converter"
 )
@@ -13,76 +13,166 @@ import (
 // Version is set at build time via -ldflags
 var version = "dev"
 
+// Exit codes shared by all subcommands
+const (
+	exitOK          = 0   // Every file succeeded
+	exitPartial     = 1   // Some files failed, others succeeded
+	exitUsage       = 2   // Invalid flags or arguments
+	exitFailure     = 3   // Every file failed, or the command could not run
+	exitInterrupted = 130 // Stopped by SIGINT (128 + SIGINT)
+	exitTerminated  = 143 // Stopped by SIGTERM (128 + SIGTERM)
+)
+
+// command is a CLI subcommand with its own flag set and exit codes
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in help order
+var commands []command
+
+func init() {
+	commands = []command{
+		{"convert", "Convert WebP files to GIF/JPEG (default command)", runConvert},
+		{"watch", "Convert WebP files as they appear in a directory, until interrupted", runWatch},
+		{"inspect", "Show the detected type and container details of WebP files", runInspect},
+		{"config", "Print the settings merged from configuration files, profile and flags", runConfig},
+		{"version", "Print version and exit", runVersion},
+		{"help", "Show help for a command", runHelp},
+	}
+}
+
 func main() {
-	// Define command line flags
-	dirPtr := flag.String("dir", ".", "Directory to process (default: current directory)")
-	qualityPtr := flag.Int("quality", 100, "JPEG quality for static WebP (1-100, default: 100)")
-	workersPtr := flag.Int("workers", runtime.NumCPU(), "Number of parallel workers (default: CPU count)")
-	keepOriginalPtr := flag.Bool("keep-original", false, "Keep original WebP files after conversion (default: false)")
-	versionPtr := flag.Bool("version", false, "Print version and exit")
-	flag.Parse()
-
-	// Handle version flag
-	if *versionPtr {
-		fmt.Printf("webpconvert version %s\n", version)
-		os.Exit(0)
+	// Child process of -isolate: convert one file and exit
+	if converter.IsIsolateWorker() {
+		if err := converter.ServeIsolateWorker(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(exitOK)
+	}
+
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a subcommand. Anything that is not a known command name
+// (flags, a directory) is handed to convert, so "webpconvert <dir>" and the
+// original flag-only usage keep working.
+func run(args []string) int {
+	if len(args) == 0 {
+		return runConvert(nil)
 	}
 
-	// Validate quality
-	if *qualityPtr < 1 || *qualityPtr > 100 {
-		fmt.Fprintf(os.Stderr, "Error: quality must be between 1 and 100\n")
-		os.Exit(1)
+	switch args[0] {
+	case "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
 	}
 
-	// Validate workers
-	if *workersPtr < 1 {
-		fmt.Fprintf(os.Stderr, "Error: workers must be at least 1\n")
-		os.Exit(1)
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd.run(args[1:])
 	}
 
-	// Get absolute path
-	absPath, err := filepath.Abs(*dirPtr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
-		os.Exit(1)
+	return runConvert(args)
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
 	}
+	return nil
+}
 
-	// Check if directory exists
-	info, err := os.Stat(absPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error accessing directory: %v\n", err)
-		os.Exit(1)
+// printUsage writes the top-level help
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: webpconvert <command> [flags] [args]\n")
+	fmt.Fprintf(w, "       webpconvert [convert flags] [dir]\n\n")
+	fmt.Fprintf(w, "Commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(w, "\nRun \"webpconvert help <command>\" for the flags of a command.\n")
+	fmt.Fprintf(w, "\nExit codes:\n")
+	fmt.Fprintf(w, "  %-3d  all files succeeded\n", exitOK)
+	fmt.Fprintf(w, "  %-3d  partial failure: some files failed\n", exitPartial)
+	fmt.Fprintf(w, "  %-3d  usage error\n", exitUsage)
+	fmt.Fprintf(w, "  %-3d  total failure: every file failed, or the command could not run\n", exitFailure)
+	fmt.Fprintf(w, "  %-3d  interrupted by SIGINT\n", exitInterrupted)
+	fmt.Fprintf(w, "  %-3d  terminated by SIGTERM\n", exitTerminated)
+}
 
-	if !info.IsDir() {
-		fmt.Fprintf(os.Stderr, "Path is not a directory: %s\n", absPath)
-		os.Exit(1)
+// runVersion implements "webpconvert version"
+func runVersion(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: webpconvert version\n")
+		return exitUsage
 	}
+	fmt.Printf("webpconvert version %s\n", version)
+	return exitOK
+}
 
-	fmt.Printf("Processing WebP files in: %s\n", absPath)
-	fmt.Printf("JPEG Quality: %d\n", *qualityPtr)
-	fmt.Printf("Parallel Workers: %d\n", *workersPtr)
-	fmt.Printf("Keep Original: %v\n\n", *keepOriginalPtr)
+// runHelp implements "webpconvert help [command]"
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
 
-	// Process all WebP files in directory with options
-	options := converter.ProcessOptions{
-		JPEGQuality:  *qualityPtr,
-		NumWorkers:   *workersPtr,
-		KeepOriginal: *keepOriginalPtr,
+	cmd := findCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
 	}
 
-	// Use parallel processing if more than 1 worker is specified
-	if *workersPtr > 1 {
-		if err := converter.ProcessDirectoryParallel(absPath, options); err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing directory: %v\n", err)
-			os.Exit(1)
+	cmd.run([]string{"-h"})
+	return exitOK
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments and returns the positional arguments. "--" ends flag parsing.
+// Help asked for with -h goes to stdout and returns flag.ErrHelp; the usage
+// after a flag error goes to stderr.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	usage := fs.Usage
+	fs.Usage = func() {}
+	defer func() { fs.Usage = usage }()
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				fs.SetOutput(os.Stdout)
+				defer fs.SetOutput(nil)
+			}
+			usage()
+			return nil, err
 		}
-	} else {
-		if err := converter.ProcessDirectory(absPath, options); err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing directory: %v\n", err)
-			os.Exit(1)
+		remaining := fs.Args()
+		if len(remaining) == 0 {
+			return positional, nil
 		}
+		// flag stops after "--" and leaves everything that follows positional
+		if consumed := len(args) - len(remaining); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, remaining...), nil
+		}
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
 	}
+}
 
-	fmt.Println("\nConversion completed!")
+// isFlagSet reports whether a flag was given explicitly on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
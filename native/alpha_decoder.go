@@ -0,0 +1,294 @@
+//go:build !cgo || purego
+
+package native
+
+// Pure Go decoder of the ALPH chunk of lossy images, ported from libwebp's
+// src/dec/alpha_dec.c, src/dsp/filters.c and src/utils/quant_levels_dec_utils.c
+
+const (
+	alphaHeaderLen           = 1
+	alphaNoCompression       = 0
+	alphaLosslessCompression = 1
+	alphaPreprocessedLevels  = 1
+)
+
+// Prediction filters of the alpha plane
+const (
+	alphaFilterNone = iota
+	alphaFilterHorizontal
+	alphaFilterVertical
+	alphaFilterGradient
+)
+
+// alphaDecoder holds the decoded alpha plane of a lossy image. libwebp
+// decodes the plane as the macroblock rows are output, so a corrupt plane
+// only fails the decoding once rows past failedRow are needed.
+type alphaDecoder struct {
+	plane     []byte
+	failedRow int
+}
+
+// newAlphaDecoder decodes the alpha plane of a width x height image from the
+// payload of its ALPH chunk. It returns false if the chunk header, or the
+// header of its lossless stream, is invalid. Levels reduced by the encoder
+// are smoothed when dithering (0 to 100) is positive.
+func newAlphaDecoder(data []byte, width, height, dithering int) (*alphaDecoder, bool) {
+	if len(data) <= alphaHeaderLen {
+		return nil, false
+	}
+	method := int(data[0] & 0x03)
+	filter := int(data[0]>>2) & 0x03
+	preprocessing := int(data[0]>>4) & 0x03
+	reserved := int(data[0]>>6) & 0x03
+	if method > alphaLosslessCompression || preprocessing > alphaPreprocessedLevels || reserved != 0 {
+		return nil, false
+	}
+
+	a := &alphaDecoder{plane: make([]byte, width*height), failedRow: height}
+	payload := data[alphaHeaderLen:]
+	if method == alphaNoCompression {
+		if len(payload) < width*height {
+			return nil, false
+		}
+		copy(a.plane, payload)
+	} else {
+		d := &vp8lDecoder{br: newVP8LBitReader(payload)}
+		if _, ok := d.decodeImageStream(width, height, true); !ok {
+			return nil, false
+		}
+		if failedAt, ok := d.decodeAlphaPlane(a.plane); !ok {
+			a.failedRow = failedAt / d.width
+		}
+	}
+	unfilterAlpha(a.plane, width, height, filter)
+
+	if preprocessing == alphaPreprocessedLevels {
+		// Such planes are decoded in one pass, before the first row is used
+		if a.failedRow < height {
+			a.failedRow = 0
+		} else if dithering > 0 {
+			dequantizeLevels(a.plane, width, height, width, dithering)
+		}
+	}
+	return a, true
+}
+
+// decodeAlphaPlane decodes the pixels of an alpha plane whose headers were
+// read, storing the green components in plane. Planes made of palette
+// indices only are decoded one byte per pixel, like libwebp does, as the
+// two paths handle the end of the stream differently.
+func (d *vp8lDecoder) decodeAlphaPlane(plane []byte) (int, bool) {
+	if len(d.transforms) == 1 && d.transforms[0].kind == vp8lColorIndexingTransform && d.is8bOptimizable() {
+		indices := make([]byte, d.width*d.height)
+		failedAt, ok := d.decodeAlphaData(&d.hdr, indices, d.width, d.height)
+		if ok {
+			d.transforms[0].inverseColorIndexingAlpha(indices, plane)
+		}
+		return failedAt, ok
+	}
+
+	argb := make([]uint32, d.width*d.height)
+	failedAt, ok := d.decodeImageData(&d.hdr, argb, d.width, d.height)
+	if ok {
+		for i, c := range d.inverseTransforms(argb) {
+			plane[i] = byte(c >> 8)
+		}
+	}
+	return failedAt, ok
+}
+
+// is8bOptimizable reports whether red, blue and alpha have a single symbol in
+// every group and no color cache is used
+func (d *vp8lDecoder) is8bOptimizable() bool {
+	if d.hdr.colorCacheSize > 0 {
+		return false
+	}
+	for i := range d.hdr.groups {
+		htrees := &d.hdr.groups[i].htrees
+		if htrees[vp8lRed][0].bits > 0 || htrees[vp8lBlue][0].bits > 0 || htrees[vp8lAlpha][0].bits > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// unfilterAlpha undoes the prediction filter of an alpha plane in place
+func unfilterAlpha(plane []byte, width, height, filter int) {
+	if filter == alphaFilterNone {
+		return
+	}
+	var prev []byte
+	for y := 0; y < height; y++ {
+		row := plane[y*width : (y+1)*width]
+		switch {
+		case filter == alphaFilterHorizontal || prev == nil:
+			var pred byte
+			if prev != nil {
+				pred = prev[0]
+			}
+			for i := range row {
+				row[i] += pred
+				pred = row[i]
+			}
+		case filter == alphaFilterVertical:
+			for i := range row {
+				row[i] += prev[i]
+			}
+		default:
+			top := prev[0]
+			topLeft, left := top, top
+			for i := range row {
+				top = prev[i]
+				left = row[i] + gradientPredictor(left, top, topLeft)
+				topLeft = top
+				row[i] = left
+			}
+		}
+		prev = row
+	}
+}
+
+func gradientPredictor(a, b, c byte) byte {
+	g := int(a) + int(b) - int(c)
+	switch {
+	case g < 0:
+		return 0
+	case g > 255:
+		return 255
+	}
+	return byte(g)
+}
+
+// Fixed-point precisions of the level smoothing
+const (
+	dequantizeFix     = 16
+	dequantizeLFix    = 2
+	dequantizeLUTSize = 1<<(8+dequantizeLFix) - 1
+)
+
+// dequantizeLevels smooths the flat areas of a plane whose values were
+// reduced to a few levels, with a box filter whose radius grows with
+// strength (0 to 100). The last radius rows are left as they are.
+func dequantizeLevels(data []byte, width, height, stride, strength int) {
+	radius := 4 * strength / 100
+	if 2*radius+1 > width {
+		radius = (width - 1) >> 1
+	}
+	if 2*radius+1 > height {
+		radius = (height - 1) >> 1
+	}
+	if radius <= 0 {
+		return
+	}
+
+	// Distribution of the levels
+	var used [256]bool
+	minLevel, maxLevel := 255, 0
+	for y := 0; y < height; y++ {
+		for _, v := range data[y*stride : y*stride+width] {
+			minLevel = min(minLevel, int(v))
+			maxLevel = max(maxLevel, int(v))
+			used[v] = true
+		}
+	}
+	numLevels := 0
+	minLevelDist := maxLevel - minLevel
+	lastLevel := -1
+	for i, u := range used {
+		if !u {
+			continue
+		}
+		numLevels++
+		if lastLevel >= 0 {
+			minLevelDist = min(minLevelDist, i-lastLevel)
+		}
+		lastLevel = i
+	}
+	if numLevels <= 2 {
+		return
+	}
+
+	// Correction curve: identity up to 3/4 of the smallest level distance,
+	// then decreasing linearly to 0 at the distance
+	var correction [1 + 2*dequantizeLUTSize]int16
+	threshold1 := minLevelDist << dequantizeLFix
+	threshold2 := (3 * threshold1) >> 2
+	delta := threshold1 - threshold2
+	for i := 1; i <= dequantizeLUTSize; i++ {
+		c := 0
+		if i <= threshold2 {
+			c = i
+		} else if i < threshold1 {
+			c = threshold2 * (threshold1 - i) / delta
+		}
+		c >>= dequantizeLFix
+		correction[dequantizeLUTSize+i] = int16(c)
+		correction[dequantizeLUTSize-i] = int16(-c)
+	}
+
+	// Running vertical sums over 2*radius+1 rows, modulo 16 bits, in a ring
+	// of rows followed by the row of the current sums
+	r := 2*radius + 1
+	scratch := make([]uint16, (r+1)*width)
+	cur, top, end := 0, (r-1)*width, r*width
+	average := make([]uint16, width)
+	scale := uint32((1 << (dequantizeFix + dequantizeLFix)) / (r * r))
+	src, dst := 0, 0
+
+	for row := -radius; row < height; row++ {
+		var sum uint16
+		for x := 0; x < width; x++ {
+			sum += uint16(data[src+x])
+			v := scratch[top+x] + sum
+			scratch[end+x] = v - scratch[cur+x]
+			scratch[cur+x] = v
+		}
+		top = cur
+		cur += width
+		if cur == end {
+			cur = 0
+		}
+		// Edge rows are replicated
+		if row >= 0 && row < height-1 {
+			src += stride
+		}
+		if row < radius {
+			continue
+		}
+
+		// Horizontal box filter, mirrored at the edges
+		in := scratch[end : end+width]
+		x := 0
+		for ; x <= radius; x++ {
+			d := in[x+radius-1] + in[radius-x]
+			average[x] = uint16((uint32(d) * scale) >> dequantizeFix)
+		}
+		for ; x < width-radius; x++ {
+			d := in[x+radius] - in[x-radius-1]
+			average[x] = uint16((uint32(d) * scale) >> dequantizeFix)
+		}
+		for ; x < width; x++ {
+			d := 2*in[width-1] - in[2*width-2-radius-x] - in[x-radius-1]
+			average[x] = uint16((uint32(d) * scale) >> dequantizeFix)
+		}
+
+		out := data[dst : dst+width]
+		for x, v := range out {
+			if int(v) < maxLevel && int(v) > minLevel {
+				c := int(v) + int(correction[dequantizeLUTSize+int(average[x])-int(v)<<dequantizeLFix])
+				out[x] = clip8b(c)
+			}
+		}
+		dst += stride
+	}
+}
+
+func clip8b(v int) byte {
+	switch {
+	case v&^0xff == 0:
+		return byte(v)
+	case v < 0:
+		return 0
+	}
+	return 255
+}
@@ -0,0 +1,236 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// ContentInfo describes what the pixels of a WebP image actually use, as
+// opposed to the flags of its headers
+type ContentInfo struct {
+	FrameCount     int  `json:"frame_count"`     // Frames in the file
+	DistinctFrames int  `json:"distinct_frames"` // Frames that change the displayed canvas, counting the first
+	UsesAlpha      bool `json:"uses_alpha"`      // Some displayed pixel is not fully opaque
+	Colors         int  `json:"colors"`          // Distinct displayed colors up to MaxPaletteColors+1, fully transparent counting as one
+}
+
+// MaxPaletteColors is the palette size of GIF. ContentInfo.Colors counts no
+// further than one color more.
+const MaxPaletteColors = 256
+
+// colorCounter counts the distinct colors of RGBA pixels up to
+// MaxPaletteColors+1. A pixel equal to its left neighbor is skipped, so
+// flat areas cost a comparison each and photographic content ends the count
+// within a few rows.
+type colorCounter struct {
+	seen map[uint32]struct{}
+}
+
+// add counts the colors of a width x height RGBA area at the start of pix
+func (c *colorCounter) add(pix []byte, stride, width, height int) {
+	if c.seen == nil {
+		c.seen = make(map[uint32]struct{})
+	}
+	for y := 0; y < height && !c.full(); y++ {
+		row := pix[y*stride:][:4*width]
+		previous := -1
+		for x := 0; x < len(row); x += 4 {
+			key := int(binary.LittleEndian.Uint32(row[x:]))
+			if row[x+3] == 0 {
+				key = 0
+			}
+			if key != previous {
+				c.seen[uint32(key)] = struct{}{}
+				previous = key
+			}
+		}
+	}
+}
+
+// full reports whether more colors than a palette holds were found
+func (c *colorCounter) full() bool {
+	return len(c.seen) > MaxPaletteColors
+}
+
+// count returns the colors found, at most MaxPaletteColors+1
+func (c *colorCounter) count() int {
+	return min(len(c.seen), MaxPaletteColors+1)
+}
+
+// Still reports whether the image displays as a single picture: a static
+// image, a one-frame animation or one whose frames never change the canvas
+func (c *ContentInfo) Still() bool {
+	return c.DistinctFrames <= 1
+}
+
+// AnalyzeWebPFile decodes a WebP file and returns what its pixels use. The
+// file size, canvas and frame count are checked against limits first.
+func AnalyzeWebPFile(filePath string, limits Limits) (*ContentInfo, error) {
+	data, err := readInput(filePath, limits)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeWebP(data, limits, false)
+}
+
+// AnalyzeWebP is AnalyzeWebPFile for a WebP image read from r
+func AnalyzeWebP(r io.Reader, limits Limits) (*ContentInfo, error) {
+	data, err := ReadInput(r, limits)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeWebP(data, limits, false)
+}
+
+// IsStillWebPFile reports whether a WebP file displays as a single picture,
+// like ContentInfo.Still. Unlike AnalyzeWebPFile, an animation is only
+// decoded up to the first frame that changes the canvas, and a static image
+// not at all.
+func IsStillWebPFile(filePath string, limits Limits) (bool, error) {
+	data, err := readInput(filePath, limits)
+	if err != nil {
+		return false, err
+	}
+	return isStillWebP(data, limits)
+}
+
+// IsStillWebP is IsStillWebPFile for a WebP image read from r
+func IsStillWebP(r io.Reader, limits Limits) (bool, error) {
+	data, err := ReadInput(r, limits)
+	if err != nil {
+		return false, err
+	}
+	return isStillWebP(data, limits)
+}
+
+func isStillWebP(data []byte, limits Limits) (bool, error) {
+	features, err := readFeatures(data)
+	if err != nil {
+		return false, err
+	}
+	if !features.hasAnimation {
+		return true, nil
+	}
+	content, err := analyzeWebP(data, limits, true)
+	if err != nil {
+		return false, err
+	}
+	return content.Still(), nil
+}
+
+// errChanged ends the frames of an animation at its first change
+var errChanged = errors.New("canvas changed")
+
+// analyzeWebP decodes data and plays an animation on a canvas, comparing
+// the canvas after each frame with the one before. With stillOnly, playing
+// stops at the first change, leaving FrameCount, UsesAlpha and Colors
+// incomplete.
+func analyzeWebP(data []byte, limits Limits, stillOnly bool) (*ContentInfo, error) {
+	features, err := readFeatures(data)
+	if err != nil {
+		return nil, err
+	}
+	if !features.hasAnimation {
+		decoded, err := decodeStill(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		var colors colorCounter
+		colors.add(decoded.Data, decoded.Stride, decoded.Width, decoded.Height)
+		return &ContentInfo{FrameCount: 1, DistinctFrames: 1, UsesAlpha: !isOpaque(decoded.Data), Colors: colors.count()}, nil
+	}
+
+	d, err := newDemuxer(data, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	// The canvas starts transparent, like the animation decoder of libwebp
+	bounds := image.Rect(0, 0, d.width, d.height)
+	canvas := image.NewNRGBA(bounds)
+	previous := image.NewNRGBA(bounds)
+	var dispose image.Rectangle
+	var colors colorCounter
+	content := &ContentInfo{}
+
+	err = d.eachFrame(func(frame Frame) error {
+		// Clear the area of the previous frame if it was disposed
+		draw.Draw(canvas, dispose, image.Transparent, image.Point{}, draw.Src)
+		changed := dispose
+
+		op := draw.Src
+		if frame.Blend {
+			op = draw.Over
+		}
+		rect := frame.Image.Bounds()
+		draw.Draw(canvas, rect, frame.Image, rect.Min, op)
+		changed = changed.Union(rect).Intersect(bounds)
+		dispose = image.Rectangle{}
+		if frame.Dispose {
+			dispose = rect
+		}
+
+		content.FrameCount++
+		if content.FrameCount == 1 || !bytes.Equal(canvas.Pix, previous.Pix) {
+			content.DistinctFrames++
+			if stillOnly && content.DistinctFrames > 1 {
+				return errChanged
+			}
+			content.UsesAlpha = content.UsesAlpha || !isOpaque(canvas.Pix)
+			copy(previous.Pix, canvas.Pix)
+
+			// Past the first canvas, only the frame and disposed areas
+			// change
+			if content.DistinctFrames == 1 {
+				changed = bounds
+			}
+			if !colors.full() && !changed.Empty() {
+				colors.add(canvas.Pix[canvas.PixOffset(changed.Min.X, changed.Min.Y):], canvas.Stride, changed.Dx(), changed.Dy())
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChanged) {
+		return nil, err
+	}
+	content.Colors = colors.count()
+	return content, nil
+}
+
+// isOpaque reports whether every pixel of RGBA data is fully opaque
+func isOpaque(pix []byte) bool {
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeImage decodes the picture a still-format encoder writes: a static
+// image, or the first frame of an animation on its canvas. HasAlpha is only
+// set if some pixel is not fully opaque.
+func decodeImage(data []byte, limits Limits) (*DecodedWebPImage, error) {
+	features, err := readFeatures(data)
+	if err != nil || !features.hasAnimation {
+		return decodeStill(data, limits)
+	}
+
+	canvas, err := decodeFirstFrame(data, limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode first frame: %w", err)
+	}
+	return &DecodedWebPImage{
+		Data:     canvas.Pix,
+		Width:    canvas.Rect.Dx(),
+		Height:   canvas.Rect.Dy(),
+		HasAlpha: !isOpaque(canvas.Pix),
+		Stride:   canvas.Stride,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package native
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// TestAnalyzeWebP tests frame changes, alpha use and colors found by decoding
+func TestAnalyzeWebP(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	redFrame := chunk("ANMF", anmfPayload(8, 8, 100, chunk("VP8L", solidVP8L(8, 8, red))))
+	animation := func(frames ...[]byte) []byte {
+		chunks := [][]byte{
+			chunk("VP8X", vp8xPayload(vp8xFlagAnimation|vp8xFlagAlpha, 8, 8)),
+			chunk("ANIM", make([]byte, 6)),
+		}
+		return riff(append(chunks, frames...)...)
+	}
+
+	// A still image with the alpha hint set but no transparent pixel
+	flagged := solidVP8L(6, 6, red)
+	flagged[4] |= 0x10
+	translucent := solidVP8L(6, 6, color.NRGBA{R: 255, A: 128})
+
+	// A frame covering part of the canvas leaves the rest transparent
+	small := chunk("ANMF", anmfPayload(4, 4, 100, chunk("VP8L", solidVP8L(4, 4, red))))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		frames   int
+		distinct int
+		alpha    bool
+		colors   int
+	}{
+		{"opaque with alpha hint", riff(chunk("VP8L", flagged)), 1, 1, false, 1},
+		{"translucent still", riff(chunk("VP8L", translucent)), 1, 1, true, 1},
+		{"changing animation", animatedSolid(), 2, 2, false, 2},
+		{"identical frames", animation(redFrame, redFrame, redFrame), 3, 1, false, 1},
+		{"single partial frame", animation(small), 1, 1, true, 2},
+	}
+	for _, tt := range tests {
+		content, err := AnalyzeWebP(bytes.NewReader(tt.data), Limits{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if content.FrameCount != tt.frames || content.DistinctFrames != tt.distinct || content.UsesAlpha != tt.alpha || content.Colors != tt.colors {
+			t.Errorf("%s: %+v, want %d frame(s), %d distinct, alpha %v, %d color(s)", tt.name, *content, tt.frames, tt.distinct, tt.alpha, tt.colors)
+		}
+		if content.Still() != (tt.distinct == 1) {
+			t.Errorf("%s: Still() = %v", tt.name, content.Still())
+		}
+		if still, err := IsStillWebP(bytes.NewReader(tt.data), Limits{}); err != nil || still != (tt.distinct == 1) {
+			t.Errorf("%s: IsStillWebP = %v, %v", tt.name, still, err)
+		}
+	}
+
+	if _, err := AnalyzeWebP(bytes.NewReader(animatedSolid()), Limits{MaxFrames: 1}); err == nil {
+		t.Error("frame limit: err = nil")
+	}
+}
+
+// TestAnalyzeWebPColors tests the color count across frames and its limit
+func TestAnalyzeWebPColors(t *testing.T) {
+	frame := func(x, y, size int, c color.NRGBA, dispose bool) []byte {
+		p := anmfPayload(size, size, 100, chunk("VP8L", solidVP8L(size, size, c)))
+		put24(p[0:], x/2)
+		put24(p[3:], y/2)
+		p[15] = 0x02 // No blending
+		if dispose {
+			p[15] |= 0x01
+		}
+		return chunk("ANMF", p)
+	}
+	animation := func(frames ...[]byte) []byte {
+		chunks := [][]byte{
+			chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 16, 16)),
+			chunk("ANIM", make([]byte, 6)),
+		}
+		return riff(append(chunks, frames...)...)
+	}
+
+	// The disposed area turns transparent outside the next frame
+	disposed := animation(
+		frame(0, 0, 16, color.NRGBA{R: 255, A: 255}, false),
+		frame(0, 0, 4, color.NRGBA{B: 255, A: 255}, true),
+		frame(8, 8, 4, color.NRGBA{G: 255, A: 255}, false),
+	)
+
+	// One more color per frame than a palette holds
+	var frames [][]byte
+	for i := 0; i <= MaxPaletteColors; i++ {
+		frames = append(frames, frame(2*(i%8), 2*(i/8%8), 2, color.NRGBA{R: byte(i), G: byte(i >> 8), B: 1, A: 255}, false))
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		colors int
+	}{
+		{"disposed frame", disposed, 4},
+		{"one color per frame", animation(frames...), MaxPaletteColors + 1},
+		{"one color too few", animation(frames[:MaxPaletteColors-1]...), MaxPaletteColors},
+	}
+	for _, tt := range tests {
+		content, err := AnalyzeWebP(bytes.NewReader(tt.data), Limits{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if content.Colors != tt.colors {
+			t.Errorf("%s: %d color(s), want %d", tt.name, content.Colors, tt.colors)
+		}
+	}
+
+	content, err := AnalyzeWebPFile("testdata/yellow_rose.lossy-with-alpha.webp", Limits{})
+	if err != nil || content.Colors != MaxPaletteColors+1 {
+		t.Errorf("photo: %v, %v, want %d colors", content, err, MaxPaletteColors+1)
+	}
+}
+
+// TestDecodeStillOpaqueAlpha tests that unused alpha skips compositing
+func TestDecodeStillOpaqueAlpha(t *testing.T) {
+	data := solidVP8L(4, 4, color.NRGBA{B: 255, A: 255})
+	data[4] |= 0x10
+	decoded, err := decodeStill(riff(chunk("VP8L", data)), Limits{})
+	if err != nil {
+		t.Fatalf("decodeStill failed: %v", err)
+	}
+	if decoded.HasAlpha {
+		t.Error("HasAlpha = true for an image without transparent pixels")
+	}
+}
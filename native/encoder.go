@@ -0,0 +1,162 @@
+package native
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// EncodeOptions configures an Encoder
+type EncodeOptions struct {
+	Quality int    // 1-100, for lossy formats
+	Limits  Limits // Decoding limits for the WebP input (zero fields: DefaultLimits)
+}
+
+// Encoder writes WebP images in an output format. Encoders are registered
+// by name with RegisterEncoder; "jpeg", "gif", "png" and "apng" are built in.
+type Encoder interface {
+	Name() string            // Registry name, e.g. "jpeg"
+	Extensions() []string    // File extensions with the dot; the first is used for output files
+	SupportsAnimation() bool // Keeps every frame of an animation
+	SupportsAlpha() bool     // Keeps transparency (otherwise it is composited on white)
+
+	// Encode decodes the WebP image in data and writes it to w. The input
+	// dimensions are checked against options.Limits before decoding.
+	Encode(w io.Writer, data []byte, options EncodeOptions) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]Encoder)
+)
+
+func init() {
+	RegisterEncoder(jpegFormat{})
+	RegisterEncoder(gifFormat{})
+	RegisterEncoder(pngFormat{})
+	RegisterEncoder(apngFormat{})
+}
+
+// RegisterEncoder makes an encoder available by its name. It panics if the
+// name is empty or already registered, or if the encoder has no extension.
+func RegisterEncoder(enc Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	name := enc.Name()
+	if name == "" {
+		panic("native: RegisterEncoder with empty name")
+	}
+	if len(enc.Extensions()) == 0 {
+		panic("native: RegisterEncoder without extensions for " + name)
+	}
+	if _, dup := encoders[name]; dup {
+		panic("native: RegisterEncoder called twice for " + name)
+	}
+	encoders[name] = enc
+}
+
+// LookupEncoder returns the encoder registered under name
+func LookupEncoder(name string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	enc, ok := encoders[name]
+	return enc, ok
+}
+
+// Encoders returns the registered encoders, sorted by name
+func Encoders() []Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	list := make([]Encoder, 0, len(encoders))
+	for _, enc := range encoders {
+		list = append(list, enc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// EncoderNames returns the names of the registered encoders, sorted
+func EncoderNames() []string {
+	list := Encoders()
+	names := make([]string, len(list))
+	for i, enc := range list {
+		names[i] = enc.Name()
+	}
+	return names
+}
+
+// ConvertFile converts the WebP file at inputPath with enc and writes the
+// result to outputPath. The file size is checked against options.Limits
+// before reading. On error, outputPath may hold partial output.
+func ConvertFile(inputPath, outputPath string, enc Encoder, options EncodeOptions) (err error) {
+	data, err := readInput(inputPath, options.Limits)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return &EncodeError{Format: enc.Name(), Op: "create file", Err: err}
+	}
+	// Closing flushes the last data, so its failure fails the conversion
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = &EncodeError{Format: enc.Name(), Op: "close file", Err: closeErr}
+		}
+	}()
+
+	return enc.Encode(out, data, options)
+}
+
+// jpegFormat encodes still images to JPEG like ConvertWebPToJPEG. Of an
+// animation, only the first frame is written.
+type jpegFormat struct{}
+
+func (jpegFormat) Name() string            { return "jpeg" }
+func (jpegFormat) Extensions() []string    { return []string{".jpg", ".jpeg"} }
+func (jpegFormat) SupportsAnimation() bool { return false }
+func (jpegFormat) SupportsAlpha() bool     { return false }
+
+// Encode writes nothing unless encoding succeeds
+func (jpegFormat) Encode(w io.Writer, data []byte, options EncodeOptions) error {
+	// Validate quality
+	if options.Quality < 1 || options.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100, got %d", options.Quality)
+	}
+
+	jpegData, err := transcodeJPEG(data, options.Quality, options.Limits)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(jpegData); err != nil {
+		return fmt.Errorf("failed to encode JPEG: %w", &EncodeError{Format: "jpeg", Op: "write output", Err: err})
+	}
+	return nil
+}
+
+// gifFormat encodes animations, and still images as a single frame, to GIF
+// like ConvertWebPToGIF
+type gifFormat struct{}
+
+func (gifFormat) Name() string            { return "gif" }
+func (gifFormat) Extensions() []string    { return []string{".gif"} }
+func (gifFormat) SupportsAnimation() bool { return true }
+func (gifFormat) SupportsAlpha() bool     { return false }
+
+// Encode decodes one frame at a time; on error, part of the GIF may have
+// been written
+func (gifFormat) Encode(w io.Writer, data []byte, options EncodeOptions) error {
+	d, err := newDemuxer(data, options.Limits)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+
+	return transcodeGIF(d, w)
+}
@@ -0,0 +1,88 @@
+package native
+
+import (
+	"bytes"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEncoderRegistry tests the built-in encoders and registration
+func TestEncoderRegistry(t *testing.T) {
+	names := EncoderNames()
+	if len(names) < 2 || names[0] > names[1] {
+		t.Errorf("EncoderNames() = %v, want sorted built-ins", names)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		ext       string
+		animation bool
+	}{
+		{"jpeg", ".jpg", false},
+		{"gif", ".gif", true},
+		{"png", ".png", false},
+		{"apng", ".png", true},
+	} {
+		enc, ok := LookupEncoder(tc.name)
+		if !ok {
+			t.Fatalf("LookupEncoder(%q) not found", tc.name)
+		}
+		if enc.Extensions()[0] != tc.ext || enc.SupportsAnimation() != tc.animation {
+			t.Errorf("%s: extension %q, animation %v, want %q, %v", tc.name, enc.Extensions()[0], enc.SupportsAnimation(), tc.ext, tc.animation)
+		}
+	}
+	if _, ok := LookupEncoder("bmp"); ok {
+		t.Error("LookupEncoder(bmp) found an unregistered encoder")
+	}
+
+	for name, enc := range map[string]Encoder{"duplicate": jpegFormat{}, "no extension": noExtFormat{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %s did not panic", name)
+				}
+			}()
+			RegisterEncoder(enc)
+		}()
+	}
+	if _, ok := LookupEncoder("noext"); ok {
+		t.Error("encoder without extensions was registered")
+	}
+}
+
+// noExtFormat is an encoder with no file extension
+type noExtFormat struct{ jpegFormat }
+
+func (noExtFormat) Name() string         { return "noext" }
+func (noExtFormat) Extensions() []string { return nil }
+
+// TestConvertFile tests a still image written as a single-frame GIF
+func TestConvertFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "still.webp")
+	output := filepath.Join(dir, "still.gif")
+	if err := os.WriteFile(input, riff(chunk("VP8L", solidVP8L(6, 4, color.NRGBA{B: 255, A: 255}))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, _ := LookupEncoder("gif")
+	if err := ConvertFile(input, output, enc, EncodeOptions{}); err != nil {
+		t.Fatalf("ConvertFile failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) != 1 || g.Config.Width != 6 || g.Config.Height != 4 {
+		t.Errorf("output: %v, want a 6x4 single-frame GIF", err)
+	}
+
+	jpeg, _ := LookupEncoder("jpeg")
+	if err := ConvertFile(input, filepath.Join(dir, "still.jpg"), jpeg, EncodeOptions{Quality: 0}); err == nil {
+		t.Error("quality 0: err = nil")
+	}
+}
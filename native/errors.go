@@ -0,0 +1,169 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// Failure categories. Every decoding and encoding error returned by this
+// package matches one of them with errors.Is; the typed errors below carry
+// the underlying library status codes.
+var (
+	ErrCorruptBitstream   = errors.New("corrupt bitstream")
+	ErrTruncated          = errors.New("truncated data")
+	ErrUnsupportedFeature = errors.New("unsupported feature")
+	ErrOutOfMemory        = errors.New("out of memory")
+	ErrEncode             = errors.New("encoding failed")
+)
+
+// VP8Status mirrors libwebp's VP8StatusCode
+type VP8Status int
+
+const (
+	VP8StatusOK VP8Status = iota
+	VP8StatusOutOfMemory
+	VP8StatusInvalidParam
+	VP8StatusBitstreamError
+	VP8StatusUnsupportedFeature
+	VP8StatusSuspended
+	VP8StatusUserAbort
+	VP8StatusNotEnoughData
+)
+
+var vp8StatusNames = [...]string{
+	"ok", "out of memory", "invalid parameter", "bitstream error",
+	"unsupported feature", "suspended", "user abort", "not enough data",
+}
+
+func (s VP8Status) String() string {
+	if s >= 0 && int(s) < len(vp8StatusNames) {
+		return vp8StatusNames[s]
+	}
+	return fmt.Sprintf("VP8 status %d", int(s))
+}
+
+// category returns the sentinel error a status belongs to, or nil
+func (s VP8Status) category() error {
+	switch s {
+	case VP8StatusOutOfMemory:
+		return ErrOutOfMemory
+	case VP8StatusInvalidParam, VP8StatusBitstreamError:
+		return ErrCorruptBitstream
+	case VP8StatusUnsupportedFeature:
+		return ErrUnsupportedFeature
+	case VP8StatusSuspended, VP8StatusNotEnoughData:
+		return ErrTruncated
+	}
+	return nil
+}
+
+// DecodeError is a libwebp decoding or demuxing failure
+type DecodeError struct {
+	Op     string    // "decode" or "demux"
+	Frame  int       // 1-based animation frame, 0 for still images
+	Status VP8Status // libwebp status code
+}
+
+func (e *DecodeError) Error() string {
+	if e.Frame > 0 {
+		return fmt.Sprintf("WebP %s failed at frame %d: %s (VP8 status %d)", e.Op, e.Frame, e.Status, int(e.Status))
+	}
+	return fmt.Sprintf("WebP %s failed: %s (VP8 status %d)", e.Op, e.Status, int(e.Status))
+}
+
+// Unwrap returns the category of the status code
+func (e *DecodeError) Unwrap() error {
+	return e.Status.category()
+}
+
+// EncodeError is a libjpeg or giflib encoding failure. It always matches
+// ErrEncode, and also Err when set (e.g. ErrOutOfMemory or an OS error).
+type EncodeError struct {
+	Format  string // "jpeg" or "gif"
+	Op      string // Failed operation
+	Code    int    // Library error code (libjpeg message code, giflib E_GIF_ERR_*), 0 if none
+	Message string // Library error message, if any
+	Err     error
+}
+
+func (e *EncodeError) Error() string {
+	msg := fmt.Sprintf("%s encoding failed: %s", e.Format, e.Op)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Code != 0 {
+		msg += fmt.Sprintf(" (error code %d)", e.Code)
+	}
+	if e.Err != nil && e.Err != ErrOutOfMemory {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *EncodeError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrEncode}
+	}
+	return []error{ErrEncode, e.Err}
+}
+
+// giflib encoder error codes (E_GIF_ERR_*)
+const (
+	gifErrNotEnoughMem = 7
+)
+
+var gifErrorMessages = map[int]string{
+	1:  "failed to open file",
+	2:  "failed to write",
+	3:  "screen descriptor already written",
+	4:  "image descriptor already written",
+	5:  "no color map",
+	6:  "too much pixel data",
+	7:  "not enough memory",
+	8:  "disk is full",
+	9:  "failed to close file",
+	10: "file is not writable",
+}
+
+// gifError returns the EncodeError for a giflib error code
+func gifError(op string, code int) error {
+	err := &EncodeError{Format: "gif", Op: op, Code: code, Message: gifErrorMessages[code]}
+	if code == gifErrNotEnoughMem {
+		err.Err = ErrOutOfMemory
+	}
+	return err
+}
+
+// ErrorCause returns a short name for the category of err, for grouping
+// failures in reports: "limit_exceeded", "out_of_memory", "corrupt_bitstream",
+// "truncated", "unsupported_feature", "encode", "io" or "other"
+func ErrorCause(err error) string {
+	var pathErr *fs.PathError
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit_exceeded"
+	case errors.Is(err, ErrOutOfMemory):
+		return "out_of_memory"
+	case errors.Is(err, ErrCorruptBitstream):
+		return "corrupt_bitstream"
+	case errors.Is(err, ErrTruncated):
+		return "truncated"
+	case errors.Is(err, ErrUnsupportedFeature):
+		return "unsupported_feature"
+	case errors.Is(err, ErrEncode):
+		return "encode"
+	case errors.As(err, &pathErr), errors.As(err, &errno):
+		return "io"
+	}
+	return "other"
+}
+
+// corruptf returns an ErrCorruptBitstream error with details
+func corruptf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorruptBitstream, fmt.Sprintf(format, args...))
+}
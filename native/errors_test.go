@@ -0,0 +1,112 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestErrorCategories tests that typed errors match their sentinel categories
+func TestErrorCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"bitstream", &DecodeError{Op: "decode", Status: VP8StatusBitstreamError}, ErrCorruptBitstream},
+		{"not enough data", &DecodeError{Op: "demux", Status: VP8StatusNotEnoughData}, ErrTruncated},
+		{"unsupported", &DecodeError{Op: "decode", Frame: 2, Status: VP8StatusUnsupportedFeature}, ErrUnsupportedFeature},
+		{"decoder memory", &DecodeError{Op: "decode", Status: VP8StatusOutOfMemory}, ErrOutOfMemory},
+		{"gif write", gifError("write scanline", 2), ErrEncode},
+		{"gif memory", gifError("write scanline", gifErrNotEnoughMem), ErrOutOfMemory},
+		{"gif memory is encode", gifError("write scanline", gifErrNotEnoughMem), ErrEncode},
+		{"jpeg errno", &EncodeError{Format: "jpeg", Op: "open output file", Err: syscall.ENOENT}, os.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", tt.err, tt.want)
+			}
+		})
+	}
+
+	var decErr *DecodeError
+	if !errors.As(&DecodeError{Status: VP8StatusSuspended}, &decErr) || decErr.Status != VP8StatusSuspended {
+		t.Error("errors.As did not recover the VP8 status")
+	}
+
+	causes := map[error]string{
+		gifError("write scanline", gifErrNotEnoughMem):            "out_of_memory",
+		gifError("write scanline", 2):                             "encode",
+		&DecodeError{Op: "demux", Status: VP8StatusNotEnoughData}: "truncated",
+		&os.PathError{Op: "open", Path: "x", Err: syscall.EACCES}: "io",
+		errors.New("something else"):                              "other",
+	}
+	for err, want := range causes {
+		if got := ErrorCause(err); got != want {
+			t.Errorf("ErrorCause(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+// TestHeaderErrorCategories tests the categories of header parsing errors
+func TestHeaderErrorCategories(t *testing.T) {
+	valid := riff(chunk("VP8 ", vp8Payload(8, 8)))
+	badVersion := vp8lPayload(8, 8, false)
+	badVersion[4] |= 0x20 // Version bits
+
+	tests := map[string]struct {
+		data []byte
+		want error
+	}{
+		"truncated":    {valid[:len(valid)-4], ErrTruncated},
+		"bad VP8":      {riff(chunk("VP8 ", make([]byte, 10))), ErrCorruptBitstream},
+		"no image":     {riff(chunk("VP8X", vp8xPayload(0, 8, 8))), ErrCorruptBitstream},
+		"VP8L version": {riff(chunk("VP8L", badVersion)), ErrUnsupportedFeature},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadWebPHeader(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestDecodeErrors tests that libwebp status codes reach the caller
+func TestDecodeErrors(t *testing.T) {
+	// A lossy key frame whose first partition is larger than the chunk
+	payload := vp8Payload(16, 16)
+	payload[0], payload[1], payload[2] = 0xf0, 0xff, 0x00 // Key frame, shown, partition size 2047
+	corrupt := riff(chunk("VP8 ", payload))
+
+	_, err := DecodeWebPAdvanced(corrupt)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+	if decErr.Status != VP8StatusBitstreamError || !errors.Is(err, ErrCorruptBitstream) {
+		t.Errorf("status = %v, want %v", decErr.Status, VP8StatusBitstreamError)
+	}
+
+	// The GIF path reports a truncated container through the demuxer
+	animated := riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 16, 16)),
+		chunk("ANIM", make([]byte, 6)),
+		chunk("ANMF", anmfPayload(16, 16, 100, chunk("VP8L", vp8lPayload(16, 16, false)))),
+	)
+	input := filepath.Join(t.TempDir(), "cut.webp")
+	if err := os.WriteFile(input, animated[:len(animated)-8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = ConvertWebPToGIF(input, input+".gif")
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("ConvertWebPToGIF = %v, want ErrTruncated", err)
+	}
+}
@@ -0,0 +1,243 @@
+//go:build cgo && !purego
+
+package native
+
+/*
+#cgo pkg-config: libwebp libwebpdemux
+#cgo LDFLAGS: -lgif
+#include <stdint.h>
+#include <stdlib.h>
+#include <webp/decode.h>
+#include <webp/demux.h>
+#include <gif_lib.h>
+
+// goGIFWrite is the output function of GIF encoders (gif_writer.go)
+extern int goGIFWrite(GifFileType* gif, GifByteType* buf, int len);
+
+// open_gif_writer starts a GIF encoder writing through goGIFWrite. The cgo
+// handle of the destination is kept as the user data of the encoder.
+static GifFileType* open_gif_writer(uintptr_t handle, int* error) {
+    return EGifOpen((void*)handle, (OutputFunc)goGIFWrite, error);
+}
+*/
+import "C"
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// gifEncoder writes a GIF to an io.Writer through giflib's output callback
+type gifEncoder struct {
+	gif    *C.GifFileType
+	out    *gifWriter
+	handle cgo.Handle // Handle of out, the user data of gif
+}
+
+// newGIFEncoder starts a looping GIF with the given screen size
+func newGIFEncoder(w io.Writer, width, height int) (*gifEncoder, error) {
+	out := &gifWriter{w: bufio.NewWriter(w)}
+	handle := cgo.NewHandle(out)
+
+	var errCode C.int
+	gifFile := C.open_gif_writer(C.uintptr_t(handle), &errCode)
+	if gifFile == nil {
+		handle.Delete()
+		return nil, gifError("open encoder", int(errCode))
+	}
+	enc := &gifEncoder{gif: gifFile, out: out, handle: handle}
+
+	// Set GIF screen descriptor WITHOUT global color map (use local per frame)
+	// This allows each frame to have its own optimized 256-color palette
+	if C.EGifPutScreenDesc(gifFile, C.int(width), C.int(height), 8, 0, nil) == C.GIF_ERROR {
+		err := enc.withWriteError(gifError("write screen descriptor", int(gifFile.Error)))
+		enc.close()
+		return nil, err
+	}
+
+	// Add Netscape 2.0 extension for looping
+	if err := addLoopingExtension(gifFile); err != nil {
+		err = enc.withWriteError(err)
+		enc.close()
+		return nil, err
+	}
+
+	return enc, nil
+}
+
+// writeFrame quantizes a frame to its own 256-color palette and writes it at
+// its bounds. index numbers the frame from 1 in error messages.
+func (e *gifEncoder) writeFrame(frame Frame, index int) error {
+	bounds := frame.Image.Bounds()
+	frameWidth := bounds.Dx()
+	frameHeight := bounds.Dy()
+
+	indexedData, framePalette := quantizeFrame(frame.Image)
+
+	// Create local color map for this frame
+	localColorMap := C.GifMakeMapObject(256, nil)
+	if localColorMap == nil {
+		return fmt.Errorf("%w: color map for frame %d", ErrOutOfMemory, index)
+	}
+	// The image descriptor keeps its own copy
+	defer C.GifFreeMapObject(localColorMap)
+
+	// Copy frame palette to local color map
+	localColors := unsafe.Slice(localColorMap.Colors, len(framePalette))
+	for i := 0; i < len(framePalette); i++ {
+		localColors[i].Red = C.GifByteType(framePalette[i].R)
+		localColors[i].Green = C.GifByteType(framePalette[i].G)
+		localColors[i].Blue = C.GifByteType(framePalette[i].B)
+	}
+
+	// Add graphics control extension (for timing)
+	duration := gifDelay(frame.Duration)
+
+	var gce [4]C.GifByteType
+	// Disposal method: 0 = unspecified (let decoder decide, like Pillow)
+	gce[0] = 0x00 // No disposal method specified
+	gce[1] = C.GifByteType(duration & 0xff)
+	gce[2] = C.GifByteType((duration >> 8) & 0xff)
+	gce[3] = 0 // No transparent color
+
+	if C.EGifPutExtension(e.gif, C.GRAPHICS_EXT_FUNC_CODE, 4, unsafe.Pointer(&gce[0])) == C.GIF_ERROR {
+		return e.withWriteError(gifError(fmt.Sprintf("write graphics control extension for frame %d", index), int(e.gif.Error)))
+	}
+
+	// Write frame WITH local color map
+	if C.EGifPutImageDesc(e.gif, C.int(bounds.Min.X), C.int(bounds.Min.Y), C.int(frameWidth), C.int(frameHeight), C.bool(false), localColorMap) == C.GIF_ERROR {
+		return e.withWriteError(gifError(fmt.Sprintf("write image descriptor for frame %d", index), int(e.gif.Error)))
+	}
+
+	// Write scanlines
+	for y := 0; y < frameHeight; y++ {
+		line := (*C.GifByteType)(unsafe.Pointer(&indexedData[y*frameWidth]))
+		if C.EGifPutLine(e.gif, line, C.int(frameWidth)) == C.GIF_ERROR {
+			return e.withWriteError(gifError(fmt.Sprintf("write scanline %d in frame %d", y, index), int(e.gif.Error)))
+		}
+	}
+
+	return nil
+}
+
+// close writes the GIF trailer, flushes the output and frees the encoder
+func (e *gifEncoder) close() error {
+	defer e.handle.Delete()
+
+	// giflib frees the encoder even when writing the trailer fails
+	var errCode C.int
+	if C.EGifCloseFile(e.gif, &errCode) == C.GIF_ERROR {
+		return e.withWriteError(gifError("write trailer", int(errCode)))
+	}
+	if err := e.out.flush(); err != nil {
+		return &EncodeError{Format: "gif", Op: "flush output", Err: err}
+	}
+	return nil
+}
+
+// withWriteError adds the error of the destination writer, which giflib
+// only reports as a failed write, to a giflib error
+func (e *gifEncoder) withWriteError(err error) error {
+	var encErr *EncodeError
+	if e.out.err != nil && errors.As(err, &encErr) && encErr.Err == nil {
+		encErr.Err = e.out.err
+	}
+	return err
+}
+
+// addLoopingExtension adds Netscape 2.0 extension for infinite looping
+func addLoopingExtension(gifFile *C.GifFileType) error {
+	// Netscape 2.0 application extension
+	appExt := []byte("NETSCAPE2.0")
+	if C.EGifPutExtensionLeader(gifFile, C.APPLICATION_EXT_FUNC_CODE) == C.GIF_ERROR {
+		return gifError("write looping extension leader", int(gifFile.Error))
+	}
+
+	if C.EGifPutExtensionBlock(gifFile, C.int(len(appExt)), unsafe.Pointer(&appExt[0])) == C.GIF_ERROR {
+		return gifError("write application extension", int(gifFile.Error))
+	}
+
+	// Loop count sub-block (0 = infinite)
+	loopBlock := []byte{1, 0, 0} // sub-block id=1, loop count=0 (infinite)
+	if C.EGifPutExtensionBlock(gifFile, 3, unsafe.Pointer(&loopBlock[0])) == C.GIF_ERROR {
+		return gifError("write loop sub-block", int(gifFile.Error))
+	}
+
+	if C.EGifPutExtensionTrailer(gifFile) == C.GIF_ERROR {
+		return gifError("write extension trailer", int(gifFile.Error))
+	}
+
+	return nil
+}
+
+// analyzeAllFramesForGlobalPalette analyzes all frames to create a global color palette
+// This prevents color flickering between frames in the output GIF
+func analyzeAllFramesForGlobalPalette(demux *C.WebPDemuxer, width, height, frameCount int) ([]RGB, error) {
+	// Collect colors from all frames
+	allColors := make(map[uint32]int) // color -> frequency
+
+	var iter C.WebPIterator
+	if C.WebPDemuxGetFrame(demux, 1, &iter) == 0 {
+		return nil, fmt.Errorf("failed to get first frame for analysis")
+	}
+	defer C.WebPDemuxReleaseIterator(&iter)
+
+	frameNum := 0
+	for {
+		frameNum++
+
+		// Decode frame properly
+		fragmentSize := int(iter.fragment.size)
+
+		var outWidth, outHeight C.int
+		rgbaData := C.WebPDecodeRGBA(
+			iter.fragment.bytes,
+			C.size_t(fragmentSize),
+			&outWidth,
+			&outHeight,
+		)
+
+		if rgbaData == nil {
+			return nil, fmt.Errorf("failed to decode frame %d during analysis", frameNum)
+		}
+
+		// Collect colors from RGBA data
+		frameWidth := int(outWidth)
+		frameHeight := int(outHeight)
+		pixelCount := frameWidth * frameHeight
+
+		rgbaSlice := unsafe.Slice((*byte)(rgbaData), pixelCount*4)
+		for i := 0; i < pixelCount; i++ {
+			r := uint32(rgbaSlice[i*4])
+			g := uint32(rgbaSlice[i*4+1])
+			b := uint32(rgbaSlice[i*4+2])
+			colorKey := (r << 16) | (g << 8) | b
+			allColors[colorKey]++
+		}
+
+		C.WebPFree(unsafe.Pointer(rgbaData))
+
+		// Move to next frame
+		if C.WebPDemuxNextFrame(&iter) == 0 {
+			break
+		}
+	}
+
+	// Convert color histogram to RGB slice
+	colorList := make([]RGB, 0, len(allColors))
+	for colorKey := range allColors {
+		r := byte(colorKey >> 16)
+		g := byte(colorKey >> 8)
+		b := byte(colorKey)
+		colorList = append(colorList, RGB{R: r, G: g, B: b})
+	}
+
+	// Use Octree to quantize to 256 colors
+	// Use reasonable dimensions for dithering (not needed for palette generation)
+	_, globalPalette := QuantizeImageOctreeWithDimensions(colorList, 256, width, height)
+
+	return globalPalette, nil
+}
@@ -0,0 +1,127 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// gifEncoder writes a GIF frame by frame, each quantized to its own
+// 256-color palette, so only the frame being written is held in memory.
+// The blocks are those giflib writes for the libwebp build.
+type gifEncoder struct {
+	w   *bufio.Writer
+	err error // First write error; later writes are skipped
+}
+
+// newGIFEncoder starts a looping GIF with the given screen size
+func newGIFEncoder(w io.Writer, width, height int) (*gifEncoder, error) {
+	e := &gifEncoder{w: bufio.NewWriter(w)}
+
+	// Logical screen descriptor WITHOUT global color table (use local per frame)
+	e.write([]byte("GIF89a"))
+	e.write(binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, uint16(width)), uint16(height)))
+	e.write([]byte{0x70, 0, 0}) // 8-bit color resolution, background 0, no aspect ratio
+
+	// Netscape 2.0 extension for infinite looping
+	e.write([]byte{0x21, 0xff, 11})
+	e.write([]byte("NETSCAPE2.0"))
+	e.write([]byte{3, 1, 0, 0, 0})
+
+	if e.err != nil {
+		return nil, &EncodeError{Format: "gif", Op: "write screen descriptor", Err: e.err}
+	}
+	return e, nil
+}
+
+// write writes p unless an earlier write failed
+func (e *gifEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// writeFrame quantizes a frame to its own 256-color palette and writes it at
+// its bounds. index numbers the frame from 1 in error messages.
+func (e *gifEncoder) writeFrame(frame Frame, index int) error {
+	bounds := frame.Image.Bounds()
+	indexedData, framePalette := quantizeFrame(frame.Image)
+
+	// Graphics control extension (for timing); disposal method is left
+	// unspecified, like the libwebp build does
+	duration := gifDelay(frame.Duration)
+	e.write([]byte{0x21, 0xf9, 4, 0, byte(duration), byte(duration >> 8), 0, 0})
+
+	// Image descriptor with a 256-entry local color table
+	desc := []byte{0x2c}
+	for _, v := range []int{bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy()} {
+		desc = binary.LittleEndian.AppendUint16(desc, uint16(v))
+	}
+	e.write(append(desc, 0x87))
+	var colors [256 * 3]byte
+	for i, c := range framePalette {
+		colors[3*i], colors[3*i+1], colors[3*i+2] = c.R, c.G, c.B
+	}
+	e.write(colors[:])
+
+	// LZW-compressed indices in sub-blocks of up to 255 bytes
+	const litWidth = 8
+	e.write([]byte{litWidth})
+	blocks := &gifBlockWriter{e: e}
+	lw := lzw.NewWriter(blocks, lzw.LSB, litWidth)
+	lw.Write(indexedData)
+	lw.Close()
+	blocks.flush()
+	e.write([]byte{0})
+
+	if e.err != nil {
+		return &EncodeError{Format: "gif", Op: fmt.Sprintf("write frame %d", index), Err: e.err}
+	}
+	return nil
+}
+
+// close writes the GIF trailer and flushes the output
+func (e *gifEncoder) close() error {
+	e.write([]byte{0x3b})
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if e.err != nil {
+		return &EncodeError{Format: "gif", Op: "write trailer", Err: e.err}
+	}
+	return nil
+}
+
+// gifBlockWriter splits image data into the length-prefixed sub-blocks of
+// the GIF format
+type gifBlockWriter struct {
+	e   *gifEncoder
+	buf [256]byte // Length byte and up to 255 data bytes
+	n   int
+}
+
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		k := copy(b.buf[1+b.n:], p[written:])
+		b.n += k
+		written += k
+		if b.n == 255 {
+			b.flush()
+		}
+	}
+	return len(p), b.e.err
+}
+
+// flush writes the buffered data as a sub-block
+func (b *gifBlockWriter) flush() {
+	if b.n == 0 {
+		return
+	}
+	b.buf[0] = byte(b.n)
+	b.e.write(b.buf[:1+b.n])
+	b.n = 0
+}
@@ -0,0 +1,49 @@
+//go:build cgo && !purego
+
+package native
+
+/*
+#cgo LDFLAGS: -lgif
+#include <gif_lib.h>
+*/
+import "C"
+import (
+	"bufio"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// gifWriter is the destination of a GIF encoder. giflib only sees whether a
+// write succeeded, so the first error is kept for the EncodeError.
+type gifWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// write writes p unless an earlier write failed, and returns the bytes written
+func (g *gifWriter) write(p []byte) int {
+	if g.err != nil {
+		return 0
+	}
+	n, err := g.w.Write(p)
+	g.err = err
+	return n
+}
+
+// flush writes the buffered data
+func (g *gifWriter) flush() error {
+	if g.err == nil {
+		g.err = g.w.Flush()
+	}
+	return g.err
+}
+
+// goGIFWrite is the giflib OutputFunc of GIF encoders. The user data of the
+// encoder is the cgo handle of its gifWriter. This file holds no C
+// definitions, as required for exported functions.
+//
+//export goGIFWrite
+func goGIFWrite(gif *C.GifFileType, buf *C.GifByteType, n C.int) C.int {
+	out := cgo.Handle(uintptr(gif.UserData)).Value().(*gifWriter)
+	return C.int(out.write(unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(n))))
+}
@@ -0,0 +1,139 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// configHeaderSize is enough leading bytes to read the canvas size of a
+// simple (VP8, VP8L) or extended (VP8X) file
+const configHeaderSize = 64
+
+// Disposal methods of the frames of a WebP, with the values of image/gif
+const (
+	DisposalNone       = 0x01 // Leave the frame on the canvas
+	DisposalBackground = 0x02 // Clear the frame area to transparent before the next frame
+)
+
+// errFirstFrame stops the frame loop of Decode after the first frame
+var errFirstFrame = errors.New("first frame decoded")
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBP", Decode, DecodeConfig)
+}
+
+// WebP holds the frames of a WebP image, in the style of gif.GIF. A static
+// image has a single frame.
+type WebP struct {
+	Image           []*image.NRGBA // Frames; the bounds of each are its position on the canvas
+	Delay           []int          // Display time of each frame in milliseconds (gif.GIF uses 100ths of a second)
+	Disposal        []byte         // DisposalNone or DisposalBackground, per frame
+	Blend           []bool         // Alpha-blend each frame onto the canvas (otherwise overwrite its area)
+	LoopCount       int            // 0 = infinite, otherwise the number of times the animation plays
+	BackgroundColor color.NRGBA    // Background color hint of the ANIM chunk
+	Config          image.Config   // Canvas size and color model
+}
+
+// Decode reads a WebP image from r within DefaultLimits. A static image
+// decodes to an *image.NRGBA; for an animation, the first frame is returned
+// on a transparent canvas, like image/gif returns the first frame. Decode is
+// registered with image.Decode under the name "webp".
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ReadInput(r, Limits{})
+	if err != nil {
+		return nil, err
+	}
+
+	features, err := readFeatures(data)
+	if err != nil {
+		return nil, err
+	}
+	if features.hasAnimation {
+		return decodeFirstFrame(data, Limits{})
+	}
+
+	decoded, err := decodeStill(data, Limits{})
+	if err != nil {
+		return nil, err
+	}
+	return decoded.NRGBA(), nil
+}
+
+// DecodeConfig returns the canvas size of a WebP image, read from its first
+// bytes only. The color model is color.NRGBAModel, the model of the images
+// Decode and DecodeAll return.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	header := make([]byte, configHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if n == 0 {
+		return image.Config{}, fmt.Errorf("%w: WebP data is empty", ErrTruncated)
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return image.Config{}, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+
+	features, err := readFeatures(header[:n])
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      features.width,
+		Height:     features.height,
+	}, nil
+}
+
+// DecodeAll reads a WebP image from r within DefaultLimits and returns all
+// of its frames as stored in the file
+func DecodeAll(r io.Reader) (*WebP, error) {
+	anim, err := DecodeAnimated(r)
+	if err != nil {
+		return nil, err
+	}
+
+	webp := &WebP{
+		LoopCount:       anim.LoopCount,
+		BackgroundColor: anim.BackgroundColor,
+		Config: image.Config{
+			ColorModel: color.NRGBAModel,
+			Width:      anim.Width,
+			Height:     anim.Height,
+		},
+	}
+	for _, frame := range anim.Frames {
+		disposal := byte(DisposalNone)
+		if frame.Dispose {
+			disposal = DisposalBackground
+		}
+		webp.Image = append(webp.Image, frame.Image)
+		webp.Delay = append(webp.Delay, frame.Duration)
+		webp.Disposal = append(webp.Disposal, disposal)
+		webp.Blend = append(webp.Blend, frame.Blend)
+	}
+	return webp, nil
+}
+
+// decodeFirstFrame decodes the first frame of an animation onto a
+// transparent canvas
+func decodeFirstFrame(data []byte, limits Limits) (*image.NRGBA, error) {
+	d, err := newDemuxer(data, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, d.width, d.height))
+	err = d.eachFrame(func(frame Frame) error {
+		bounds := frame.Image.Bounds()
+		draw.Draw(canvas, bounds, frame.Image, bounds.Min, draw.Src)
+		return errFirstFrame
+	})
+	if err != errFirstFrame {
+		return nil, err
+	}
+	return canvas, nil
+}
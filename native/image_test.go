@@ -0,0 +1,128 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestImageDecode tests decoding through the image package registry
+func TestImageDecode(t *testing.T) {
+	green := color.NRGBA{G: 255, A: 128}
+	data := riff(chunk("VP8L", solidVP8L(6, 5, green)))
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "webp" {
+		t.Fatalf("image.Decode = %q, %v", format, err)
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("image.Decode returned %T, want *image.NRGBA", img)
+	}
+	if nrgba.Bounds() != image.Rect(0, 0, 6, 5) || nrgba.NRGBAAt(5, 4) != green {
+		t.Errorf("decoded %v with color %v, want 6x5 %v", nrgba.Bounds(), nrgba.NRGBAAt(5, 4), green)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "webp" || cfg.Width != 6 || cfg.Height != 5 || cfg.ColorModel != color.NRGBAModel {
+		t.Errorf("image.DecodeConfig = %+v, %q, %v", cfg, format, err)
+	}
+
+	// An animation decodes to its first frame on the canvas
+	img, _, err = image.Decode(bytes.NewReader(animatedSolid()))
+	if err != nil {
+		t.Fatalf("image.Decode(animated) failed: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 8, 8) || img.At(7, 7) != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("animated: %v with color %v, want 8x8 red", img.Bounds(), img.At(7, 7))
+	}
+}
+
+// TestDecodeConfig tests that the canvas size is read from the first bytes only
+func TestDecodeConfig(t *testing.T) {
+	lossy := append(vp8Payload(300, 200), make([]byte, 1000)...)
+	lossy[0] = 0x10 // Key frame, shown
+
+	tests := []struct {
+		name          string
+		data          []byte
+		width, height int
+	}{
+		{"lossy", riff(chunk("VP8 ", lossy)), 300, 200},
+		{"lossless", riff(chunk("VP8L", solidVP8L(40, 30, color.NRGBA{A: 255}))), 40, 30},
+		{"animated", animatedSolid(), 8, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &countingReaderAt{data: tt.data}
+			cfg, err := DecodeConfig(&readerFromAt{r: r})
+			if err != nil || cfg.Width != tt.width || cfg.Height != tt.height {
+				t.Fatalf("DecodeConfig = %+v, %v, want %dx%d", cfg, err, tt.width, tt.height)
+			}
+			if r.read > configHeaderSize {
+				t.Errorf("read %d bytes, want at most %d", r.read, configHeaderSize)
+			}
+		})
+	}
+
+	if _, err := DecodeConfig(bytes.NewReader(nil)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("empty input: err = %v, want ErrTruncated", err)
+	}
+	if _, err := DecodeConfig(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WEBPjunkjunk"))); err == nil {
+		t.Error("invalid input: err = nil")
+	}
+}
+
+// readerFromAt reads a countingReaderAt sequentially
+type readerFromAt struct {
+	r   *countingReaderAt
+	off int64
+}
+
+func (r *readerFromAt) Read(p []byte) (int, error) {
+	n, err := r.r.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+// TestDecodeAll tests the gif.GIF-style view of an animation
+func TestDecodeAll(t *testing.T) {
+	webp, err := DecodeAll(bytes.NewReader(animatedSolid()))
+	if err != nil {
+		t.Fatalf("DecodeAll failed: %v", err)
+	}
+
+	if len(webp.Image) != 2 || webp.Config.Width != 8 || webp.Config.Height != 8 || webp.LoopCount != 3 {
+		t.Fatalf("unexpected WebP: %d frame(s), config %+v, loop %d", len(webp.Image), webp.Config, webp.LoopCount)
+	}
+	if webp.Delay[0] != 100 || webp.Delay[1] != 250 {
+		t.Errorf("Delay = %v, want [100 250]", webp.Delay)
+	}
+	if webp.Disposal[0] != DisposalNone || webp.Disposal[1] != DisposalBackground {
+		t.Errorf("Disposal = %v", webp.Disposal)
+	}
+	if !webp.Blend[0] || webp.Blend[1] {
+		t.Errorf("Blend = %v, want [true false]", webp.Blend)
+	}
+	if webp.Image[1].Bounds() != image.Rect(4, 2, 8, 6) {
+		t.Errorf("frame 2 bounds = %v", webp.Image[1].Bounds())
+	}
+}
+
+// TestDecodedImageViews tests the image.Image views of a decoded image
+func TestDecodedImageViews(t *testing.T) {
+	decoded, err := DecodeWebPAdvanced(riff(chunk("VP8L", solidVP8L(3, 2, color.NRGBA{R: 200, A: 128}))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := decoded.NRGBA().NRGBAAt(2, 1); c != (color.NRGBA{R: 200, A: 128}) {
+		t.Errorf("NRGBA color = %v", c)
+	}
+	rgba := decoded.RGBA()
+	if c := rgba.RGBAAt(2, 1); c.A != 128 || c.R < 99 || c.R > 101 {
+		t.Errorf("RGBA color = %v, want premultiplied red about 100", c)
+	}
+}
@@ -0,0 +1,184 @@
+//go:build cgo && !purego
+
+package native
+
+/*
+#cgo pkg-config: libwebp
+#cgo LDFLAGS: -ljpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <webp/decode.h>
+#include <jpeglib.h>
+#include <jerror.h>
+#include <setjmp.h>
+
+// Return value of encode_jpeg_to_mem besides 0 and positive libjpeg message codes
+#define JPEG_ENC_FAILED -1
+
+// jpeg_error_handler replaces libjpeg's default handler, which exits the process
+typedef struct {
+	struct jpeg_error_mgr pub;
+	jmp_buf jump;
+} jpeg_error_handler;
+
+static void jpeg_error_exit(j_common_ptr cinfo) {
+	jpeg_error_handler *handler = (jpeg_error_handler *)cinfo->err;
+	longjmp(handler->jump, 1);
+}
+
+// mem_destination writes the JPEG to a malloc'ed buffer grown with realloc.
+// Unlike jpeg_mem_dest, which reports its replacement buffer only once the
+// compression finishes, *out is always the current buffer, so the error path
+// can free it.
+typedef struct {
+	struct jpeg_destination_mgr pub;
+	unsigned char **out;     // Current buffer
+	unsigned long *out_size; // Its capacity, then the JPEG size once finished
+} mem_destination;
+
+static void mem_init_destination(j_compress_ptr cinfo) {
+	mem_destination *dest = (mem_destination *)cinfo->dest;
+	dest->pub.next_output_byte = *dest->out;
+	dest->pub.free_in_buffer = *dest->out_size;
+}
+
+static boolean mem_empty_output_buffer(j_compress_ptr cinfo) {
+	mem_destination *dest = (mem_destination *)cinfo->dest;
+	unsigned long size = *dest->out_size;
+	unsigned char *grown = realloc(*dest->out, size * 2);
+	if (grown == NULL) {
+		ERREXIT1(cinfo, JERR_OUT_OF_MEMORY, 10);
+	}
+	*dest->out = grown;
+	*dest->out_size = size * 2;
+	dest->pub.next_output_byte = grown + size;
+	dest->pub.free_in_buffer = size;
+	return TRUE;
+}
+
+static void mem_term_destination(j_compress_ptr cinfo) {
+	mem_destination *dest = (mem_destination *)cinfo->dest;
+	*dest->out_size -= dest->pub.free_in_buffer;
+}
+
+// Complete JPEG encoding in C to avoid CGO pointer issues.
+// The JPEG is written to *out, a malloc'ed buffer of *out_size bytes (not 0)
+// that grows when it fills up. On success *out_size is the JPEG size and the
+// caller frees *out; on failure *out is freed and set to NULL. Returns 0 on
+// success, or the libjpeg message code (JPEG_ENC_FAILED if none) with its
+// text in msg (JMSG_LENGTH_MAX bytes).
+int encode_jpeg_to_mem(unsigned char *rgb_data, int width, int height, int quality, unsigned char **out, unsigned long *out_size, char *msg) {
+	struct jpeg_compress_struct cinfo;
+	jpeg_error_handler jerr;
+	mem_destination dest;
+
+	cinfo.err = jpeg_std_error(&jerr.pub);
+	jerr.pub.error_exit = jpeg_error_exit;
+	if (setjmp(jerr.jump)) {
+		int code = jerr.pub.msg_code;
+		(*cinfo.err->format_message)((j_common_ptr)&cinfo, msg);
+		free(*out);
+		*out = NULL;
+		jpeg_destroy_compress(&cinfo);
+		return code > 0 ? code : JPEG_ENC_FAILED;
+	}
+	jpeg_create_compress(&cinfo);
+	dest.pub.init_destination = mem_init_destination;
+	dest.pub.empty_output_buffer = mem_empty_output_buffer;
+	dest.pub.term_destination = mem_term_destination;
+	dest.out = out;
+	dest.out_size = out_size;
+	cinfo.dest = &dest.pub;
+
+	cinfo.image_width = width;
+	cinfo.image_height = height;
+	cinfo.input_components = 3;
+	cinfo.in_color_space = JCS_RGB;
+
+	jpeg_set_defaults(&cinfo);
+	jpeg_set_quality(&cinfo, quality, TRUE);
+
+	// Optimize for quality
+	cinfo.dct_method = JDCT_ISLOW;  // Highest quality DCT method
+	cinfo.optimize_coding = TRUE;   // Optimize Huffman tables
+
+	// Enable progressive encoding FIRST (before setting chroma)
+	// jpeg_simple_progression() modifies the scan script and may reset component info
+	jpeg_simple_progression(&cinfo);
+
+	// CRITICAL: Disable chroma subsampling for maximum quality (4:4:4)
+	// This MUST be set AFTER jpeg_simple_progression() to prevent reset
+	// Default 4:2:0 loses 75% of color resolution - 4:4:4 keeps 100%
+	cinfo.comp_info[0].h_samp_factor = 1;
+	cinfo.comp_info[0].v_samp_factor = 1;
+	cinfo.comp_info[1].h_samp_factor = 1;
+	cinfo.comp_info[1].v_samp_factor = 1;
+	cinfo.comp_info[2].h_samp_factor = 1;
+	cinfo.comp_info[2].v_samp_factor = 1;
+
+	jpeg_start_compress(&cinfo, TRUE);
+
+	int row_stride = width * 3;
+	while (cinfo.next_scanline < cinfo.image_height) {
+		JSAMPROW row_pointer = &rgb_data[cinfo.next_scanline * row_stride];
+		jpeg_write_scanlines(&cinfo, &row_pointer, 1);
+	}
+
+	jpeg_finish_compress(&cinfo);
+	jpeg_destroy_compress(&cinfo);
+
+	return 0;
+}
+
+// Check if WebP has alpha channel
+int webp_has_alpha(const uint8_t *data, size_t data_size) {
+	WebPBitstreamFeatures features;
+	if (WebPGetFeatures(data, data_size, &features) != VP8_STATUS_OK) {
+		return -1;  // Error
+	}
+	return features.has_alpha ? 1 : 0;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// encodeJPEG encodes RGB data to JPEG in memory using libjpeg
+func encodeJPEG(rgbData []byte, width, height, quality int) ([]byte, error) {
+	// Copy RGB data to C memory to avoid CGO pointer issues
+	cRGBData := C.malloc(C.size_t(len(rgbData)))
+	if cRGBData == nil {
+		return nil, fmt.Errorf("%w: RGB buffer for JPEG encoding", ErrOutOfMemory)
+	}
+	defer C.free(cRGBData)
+
+	C.memcpy(cRGBData, unsafe.Pointer(&rgbData[0]), C.size_t(len(rgbData)))
+
+	// Start with a quarter of the RGB size, which fits most photos; the
+	// buffer grows if needed
+	outSize := C.ulong(len(rgbData)/4 + 64<<10)
+	out := (*C.uchar)(C.malloc(C.size_t(outSize)))
+	if out == nil {
+		return nil, fmt.Errorf("%w: JPEG output buffer", ErrOutOfMemory)
+	}
+	defer func() { C.free(unsafe.Pointer(out)) }()
+
+	// Call C function to encode JPEG
+	var msg [C.JMSG_LENGTH_MAX]C.char
+	result := C.encode_jpeg_to_mem((*C.uchar)(cRGBData), C.int(width), C.int(height), C.int(quality), &out, &outSize, &msg[0])
+	if result == 0 {
+		return C.GoBytes(unsafe.Pointer(out), C.int(outSize)), nil
+	}
+
+	encErr := &EncodeError{Format: "jpeg", Op: "compress", Code: int(result), Message: C.GoString(&msg[0])}
+	if result == C.JPEG_ENC_FAILED {
+		encErr.Code = 0
+	}
+	if result == C.JERR_OUT_OF_MEMORY {
+		encErr.Err = ErrOutOfMemory
+	}
+	return nil, encErr
+}
@@ -0,0 +1,30 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// encodeJPEG encodes RGB data to JPEG in memory using image/jpeg. Unlike
+// the libjpeg build, the output is baseline with 4:2:0 chroma subsampling
+// and standard Huffman tables.
+func encodeJPEG(rgbData []byte, width, height, quality int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(rgbData); i, j = i+3, j+4 {
+		img.Pix[j] = rgbData[i]
+		img.Pix[j+1] = rgbData[i+1]
+		img.Pix[j+2] = rgbData[i+2]
+		img.Pix[j+3] = 0xff
+	}
+
+	// Start with a quarter of the RGB size, which fits most photos
+	var buf bytes.Buffer
+	buf.Grow(len(rgbData)/4 + 64<<10)
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, &EncodeError{Format: "jpeg", Op: "compress", Message: err.Error()}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,146 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrLimitExceeded is matched by every *LimitError
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// Limits bounds what a conversion may decode, so that a crafted file cannot
+// claim a huge canvas or thousands of frames. Limits are checked against the
+// file size and the container headers before anything is decoded. A zero
+// field uses the value of DefaultLimits; a negative field disables that limit.
+type Limits struct {
+	MaxCanvasPixels int64 // Canvas width × height
+	MaxFrames       int   // Animation frames
+	MaxTotalPixels  int64 // Decoded pixels over all frames (canvas × frames)
+	MaxInputBytes   int64 // Size of the input file
+}
+
+// DefaultLimits are safe for untrusted input while accepting large photos
+// (100 megapixels) and long animations
+var DefaultLimits = Limits{
+	MaxCanvasPixels: 100_000_000,
+	MaxFrames:       5000,
+	MaxTotalPixels:  1 << 30,
+	MaxInputBytes:   256 << 20,
+}
+
+// LimitError reports a file exceeding one of its Limits
+type LimitError struct {
+	Limit string // "canvas_pixels", "frames", "total_pixels" or "input_bytes"
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s %d exceeds limit %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// withDefaults returns l with zero fields replaced by DefaultLimits
+func (l Limits) withDefaults() Limits {
+	if l.MaxCanvasPixels == 0 {
+		l.MaxCanvasPixels = DefaultLimits.MaxCanvasPixels
+	}
+	if l.MaxFrames == 0 {
+		l.MaxFrames = DefaultLimits.MaxFrames
+	}
+	if l.MaxTotalPixels == 0 {
+		l.MaxTotalPixels = DefaultLimits.MaxTotalPixels
+	}
+	if l.MaxInputBytes == 0 {
+		l.MaxInputBytes = DefaultLimits.MaxInputBytes
+	}
+	return l
+}
+
+// exceeds returns a *LimitError if value is above a positive max
+func exceeds(limit string, value, max int64) error {
+	if max > 0 && value > max {
+		return &LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+// Check returns a *LimitError if a file of inputBytes bytes described by
+// info exceeds the limits
+func (l Limits) Check(info *WebPInfo, inputBytes int64) error {
+	if err := l.checkInput(inputBytes); err != nil {
+		return err
+	}
+	return l.checkImage(info.Width, info.Height, info.FrameCount)
+}
+
+// checkInput checks the size of an input file
+func (l Limits) checkInput(inputBytes int64) error {
+	return exceeds("input_bytes", inputBytes, l.withDefaults().MaxInputBytes)
+}
+
+// checkImage checks the canvas size and frame count read from a header
+func (l Limits) checkImage(width, height, frames int) error {
+	l = l.withDefaults()
+	canvas := int64(width) * int64(height)
+	frames = max(frames, 1)
+
+	if err := exceeds("canvas_pixels", canvas, l.MaxCanvasPixels); err != nil {
+		return err
+	}
+	if err := exceeds("frames", int64(frames), int64(l.MaxFrames)); err != nil {
+		return err
+	}
+	return exceeds("total_pixels", canvas*int64(frames), l.MaxTotalPixels)
+}
+
+// readInput reads a WebP file, refusing files larger than the input limit
+// before reading them
+func readInput(path string, limits Limits) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP file: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP file: %w", err)
+	}
+	if err := limits.checkInput(fi.Size()); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: WebP file is empty", ErrTruncated)
+	}
+	return data, nil
+}
+
+// ReadInput reads a WebP image from r, failing as soon as it exceeds the
+// input limit of limits
+func ReadInput(r io.Reader, limits Limits) ([]byte, error) {
+	if max := limits.withDefaults().MaxInputBytes; max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebP data: %w", err)
+	}
+	if err := limits.checkInput(int64(len(data))); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: WebP data is empty", ErrTruncated)
+	}
+	return data, nil
+}
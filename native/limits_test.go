@@ -0,0 +1,95 @@
+package native
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLimitsCheck tests limit checks against header facts
+func TestLimitsCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    Limits
+		info      WebPInfo
+		size      int64
+		wantLimit string
+	}{
+		{"defaults accept small image", Limits{}, WebPInfo{Width: 1000, Height: 1000, FrameCount: 1}, 1 << 20, ""},
+		{"canvas", Limits{}, WebPInfo{Width: 16383, Height: 16383, FrameCount: 1}, 1000, "canvas_pixels"},
+		{"frames", Limits{MaxFrames: 10}, WebPInfo{Width: 10, Height: 10, FrameCount: 11}, 1000, "frames"},
+		{"total pixels", Limits{}, WebPInfo{Width: 4000, Height: 4000, FrameCount: 100}, 1000, "total_pixels"},
+		{"input bytes", Limits{}, WebPInfo{Width: 10, Height: 10}, 1 << 30, "input_bytes"},
+		{"unlimited", Limits{MaxCanvasPixels: -1, MaxFrames: -1, MaxTotalPixels: -1, MaxInputBytes: -1}, WebPInfo{Width: 16383, Height: 16383, FrameCount: 10000}, 1 << 40, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(&tt.info, tt.size)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+				t.Fatalf("Check = %v, want %s limit error", err, tt.wantLimit)
+			}
+			if !errors.Is(err, ErrLimitExceeded) || ErrorCause(err) != "limit_exceeded" {
+				t.Errorf("error %v does not match ErrLimitExceeded", err)
+			}
+		})
+	}
+}
+
+// TestConvertWithLimits tests that the converters refuse files before decoding them
+func TestConvertWithLimits(t *testing.T) {
+	dir := t.TempDir()
+	static := filepath.Join(dir, "static.webp")
+	if err := os.WriteFile(static, riff(chunk("VP8L", vp8lPayload(16, 16, false))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	animated := filepath.Join(dir, "animated.webp")
+	data := riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 16, 16)),
+		chunk("ANIM", make([]byte, 6)),
+		chunk("ANMF", anmfPayload(16, 16, 100, chunk("VP8L", vp8lPayload(16, 16, false)))),
+	)
+	if err := os.WriteFile(animated, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		convert   func(out string) error
+		wantLimit string
+	}{
+		{"jpeg canvas", func(out string) error {
+			return ConvertWebPToJPEGWithLimits(static, out, 90, Limits{MaxCanvasPixels: 100})
+		}, "canvas_pixels"},
+		{"jpeg input", func(out string) error {
+			return ConvertWebPToJPEGWithLimits(static, out, 90, Limits{MaxInputBytes: 10})
+		}, "input_bytes"},
+		{"gif total pixels", func(out string) error {
+			return ConvertWebPToGIFWithLimits(animated, out, Limits{MaxTotalPixels: 100})
+		}, "total_pixels"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, "out")
+			err := tt.convert(out)
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != tt.wantLimit {
+				t.Fatalf("got %v, want %s limit error", err, tt.wantLimit)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Errorf("output created for a refused file")
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package native
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// pngSignature starts every PNG and APNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNG frame control values
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1 // Clear the frame area to transparent black
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+// pngFormat encodes still images to PNG, keeping transparency. Of an
+// animation, only the first frame is written.
+type pngFormat struct{}
+
+func (pngFormat) Name() string            { return "png" }
+func (pngFormat) Extensions() []string    { return []string{".png"} }
+func (pngFormat) SupportsAnimation() bool { return false }
+func (pngFormat) SupportsAlpha() bool     { return true }
+
+// Encode writes nothing unless encoding succeeds
+func (pngFormat) Encode(w io.Writer, data []byte, options EncodeOptions) error {
+	decoded, err := decodeImage(data, options.Limits)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, decoded.NRGBA()); err != nil {
+		return &EncodeError{Format: "png", Op: "encode", Err: err}
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return &EncodeError{Format: "png", Op: "write output", Err: err}
+	}
+	return nil
+}
+
+// apngFormat encodes animations, and still images as a single frame, to
+// animated PNG. Unlike GIF, frames keep full color and alpha, and the frame
+// rectangles, blending and disposal of the WebP map directly to APNG.
+type apngFormat struct{}
+
+func (apngFormat) Name() string            { return "apng" }
+func (apngFormat) Extensions() []string    { return []string{".png", ".apng"} }
+func (apngFormat) SupportsAnimation() bool { return true }
+func (apngFormat) SupportsAlpha() bool     { return true }
+
+// Encode decodes one frame at a time; on error, part of the APNG may have
+// been written
+func (apngFormat) Encode(w io.Writer, data []byte, options EncodeOptions) error {
+	d, err := newDemuxer(data, options.Limits)
+	if err != nil {
+		return err
+	}
+	defer d.close()
+
+	enc := &apngEncoder{w: w}
+	enc.writeHeader(d.width, d.height, d.frameCount, d.loopCount)
+
+	canvas := image.Rect(0, 0, d.width, d.height)
+	err = d.eachFrame(func(frame Frame) error {
+		img := frame.Image
+		blend := frame.Blend
+		if enc.seq == 0 {
+			// The first frame is the default image and must cover the canvas
+			full := image.NewNRGBA(canvas)
+			draw.Draw(full, img.Bounds(), img, img.Bounds().Min, draw.Src)
+			img, blend = full, false
+		}
+		enc.writeFrame(img, frame.Duration, blend, frame.Dispose)
+		return enc.err
+	})
+	if err != nil {
+		return err
+	}
+
+	enc.writeChunk("IEND", nil)
+	return enc.err
+}
+
+// apngEncoder writes the chunks of an APNG file, keeping the first write error
+type apngEncoder struct {
+	w   io.Writer
+	seq uint32 // Sequence number of the next fcTL or fdAT chunk
+	buf bytes.Buffer
+	err error
+}
+
+// writeHeader writes the signature, the IHDR chunk of an 8-bit RGBA image
+// and the animation control chunk
+func (e *apngEncoder) writeHeader(width, height, frames, loopCount int) {
+	if _, err := io.WriteString(e.w, pngSignature); err != nil {
+		e.err = &EncodeError{Format: "apng", Op: "write output", Err: err}
+		return
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 6 // Truecolor with alpha
+	e.writeChunk("IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(frames))
+	binary.BigEndian.PutUint32(actl[4:], uint32(loopCount))
+	e.writeChunk("acTL", actl)
+}
+
+// writeFrame writes the frame control chunk and the compressed pixels of a
+// frame placed at the bounds of img. duration is in milliseconds.
+func (e *apngEncoder) writeFrame(img *image.NRGBA, duration int, blend, dispose bool) {
+	bounds := img.Bounds()
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], e.seq)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+	binary.BigEndian.PutUint32(fctl[12:], uint32(bounds.Min.X))
+	binary.BigEndian.PutUint32(fctl[16:], uint32(bounds.Min.Y))
+	num, den := apngDelay(duration)
+	binary.BigEndian.PutUint16(fctl[20:], num)
+	binary.BigEndian.PutUint16(fctl[22:], den)
+	fctl[24] = apngDisposeNone
+	if dispose {
+		fctl[24] = apngDisposeBackground
+	}
+	fctl[25] = apngBlendSource
+	if blend {
+		fctl[25] = apngBlendOver
+	}
+	first := e.seq == 0
+	e.seq++
+	e.writeChunk("fcTL", fctl)
+
+	pixels, err := compressRows(img)
+	if err != nil {
+		if e.err == nil {
+			e.err = &EncodeError{Format: "apng", Op: "compress", Err: err}
+		}
+		return
+	}
+	if first {
+		e.writeChunk("IDAT", pixels)
+		return
+	}
+	fdat := make([]byte, 4, 4+len(pixels))
+	binary.BigEndian.PutUint32(fdat, e.seq)
+	e.seq++
+	e.writeChunk("fdAT", append(fdat, pixels...))
+}
+
+// writeChunk writes a chunk with its length and CRC
+func (e *apngEncoder) writeChunk(name string, data []byte) {
+	if e.err != nil {
+		return
+	}
+
+	e.buf.Reset()
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	e.buf.Write(header[:])
+	e.buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	binary.Write(&e.buf, binary.BigEndian, crc.Sum32())
+
+	if _, err := e.w.Write(e.buf.Bytes()); err != nil {
+		e.err = &EncodeError{Format: "apng", Op: "write output", Err: err}
+	}
+}
+
+// apngDelay converts a frame duration in milliseconds to the delay fraction
+// of a frame control chunk, in seconds. Durations the GIF output replaces
+// with its 100 ms default (see gifDelay) get the same default, so both
+// formats play a file at the same speed.
+func apngDelay(duration int) (num, den uint16) {
+	if duration < 10 {
+		duration = 100
+	}
+	switch {
+	case duration <= 0xffff:
+		return uint16(duration), 1000
+	case duration/10 <= 0xffff:
+		return uint16(duration / 10), 100
+	}
+	return uint16(min(duration/1000, 0xffff)), 1
+}
+
+// compressRows returns the zlib stream of the filtered rows of img, choosing
+// the filter of each row like image/png: the one with the smallest sum of
+// absolute differences
+func compressRows(img *image.NRGBA) ([]byte, error) {
+	const bpp = 4
+	bounds := img.Bounds()
+	rowSize := bounds.Dx() * bpp
+
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	prev := make([]byte, rowSize)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+rowSize)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := img.PixOffset(bounds.Min.X, y)
+		row := img.Pix[offset : offset+rowSize]
+
+		best, bestSum := 0, -1
+		for f := range filtered {
+			dst := filtered[f][1:]
+			sum := 0
+			for i := range row {
+				var a, c int
+				if i >= bpp {
+					a, c = int(row[i-bpp]), int(prev[i-bpp])
+				}
+				b := int(prev[i])
+				var v byte
+				switch f {
+				case 0:
+					v = row[i]
+				case 1:
+					v = row[i] - byte(a)
+				case 2:
+					v = row[i] - byte(b)
+				case 3:
+					v = row[i] - byte((a+b)/2)
+				case 4:
+					v = row[i] - byte(paeth(a, b, c))
+				}
+				dst[i] = v
+				sum += abs8(v)
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = f, sum
+			}
+		}
+
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		copy(prev, row)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// paeth returns the Paeth predictor of a (left), b (above) and c (upper left)
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := absInt(p-a), absInt(p-b), absInt(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// abs8 returns the magnitude of a filtered byte read as a signed value
+func abs8(v byte) int {
+	if v < 128 {
+		return int(v)
+	}
+	return 256 - int(v)
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
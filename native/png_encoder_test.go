@@ -0,0 +1,142 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// pngChunk is a chunk read back from a PNG stream
+type pngChunk struct {
+	name string
+	data []byte
+}
+
+// readPNGChunks splits a PNG stream into chunks, checking the CRCs
+func readPNGChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		t.Fatal("missing PNG signature")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		c := pngChunk{name: string(data[4:8]), data: data[8 : 8+n]}
+		if crc := binary.BigEndian.Uint32(data[8+n:]); crc != crc32.ChecksumIEEE(data[4:8+n]) {
+			t.Errorf("%s: bad CRC", c.name)
+		}
+		chunks = append(chunks, c)
+		data = data[12+n:]
+	}
+	return chunks
+}
+
+// TestEncodeAPNG tests frame control values and the pixels of each frame
+func TestEncodeAPNG(t *testing.T) {
+	enc, _ := LookupEncoder("apng")
+	var out bytes.Buffer
+	if err := enc.Encode(&out, animatedSolid(), EncodeOptions{}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// Decoders without APNG support show the first frame
+	img, err := png.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	if r, g, b, _ := img.At(7, 7).RGBA(); img.Bounds().Dx() != 8 || r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("default image = %v, want 8x8 red", img.Bounds())
+	}
+
+	var names []string
+	var fctl, fdat []byte
+	for _, c := range readPNGChunks(t, out.Bytes()) {
+		names = append(names, c.name)
+		switch c.name {
+		case "acTL":
+			if frames, plays := binary.BigEndian.Uint32(c.data), binary.BigEndian.Uint32(c.data[4:]); frames != 2 || plays != 3 {
+				t.Errorf("acTL = %d frames, %d plays, want 2, 3", frames, plays)
+			}
+		case "fcTL":
+			fctl = c.data
+		case "fdAT":
+			fdat = c.data
+		}
+	}
+	if got, want := names, []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "IEND"}; !slices.Equal(got, want) {
+		t.Fatalf("chunks = %v, want %v", got, want)
+	}
+
+	// The second frame: 4x4 at (4, 2), 250 ms, disposed, not blended
+	want := []uint32{1, 4, 4, 4, 2}
+	for i, v := range want {
+		if got := binary.BigEndian.Uint32(fctl[i*4:]); got != v {
+			t.Errorf("fcTL field %d = %d, want %d", i, got, v)
+		}
+	}
+	if num, den := binary.BigEndian.Uint16(fctl[20:]), binary.BigEndian.Uint16(fctl[22:]); num != 250 || den != 1000 {
+		t.Errorf("delay = %d/%d, want 250/1000", num, den)
+	}
+	for _, tc := range []struct {
+		duration int
+		num, den uint16
+	}{{0, 100, 1000}, {5, 100, 1000}, {10, 10, 1000}, {70000, 7000, 100}} {
+		if num, den := apngDelay(tc.duration); num != tc.num || den != tc.den {
+			t.Errorf("apngDelay(%d) = %d/%d, want %d/%d", tc.duration, num, den, tc.num, tc.den)
+		}
+	}
+	if fctl[24] != apngDisposeBackground || fctl[25] != apngBlendSource {
+		t.Errorf("dispose %d, blend %d, want %d, %d", fctl[24], fctl[25], apngDisposeBackground, apngBlendSource)
+	}
+
+	// Its fdAT payload is the IDAT of a standalone 4x4 image
+	if seq := binary.BigEndian.Uint32(fdat); seq != 2 {
+		t.Errorf("fdAT sequence = %d, want 2", seq)
+	}
+	var frame bytes.Buffer
+	e := &apngEncoder{w: &frame}
+	frame.WriteString(pngSignature)
+	ihdr := []byte{0, 0, 0, 4, 0, 0, 0, 4, 8, 6, 0, 0, 0}
+	e.writeChunk("IHDR", ihdr)
+	e.writeChunk("IDAT", fdat[4:])
+	e.writeChunk("IEND", nil)
+	img, err = png.Decode(&frame)
+	if err != nil {
+		t.Fatalf("second frame: %v", err)
+	}
+	if c := color.NRGBAModel.Convert(img.At(0, 0)); c != (color.NRGBA{B: 255, A: 255}) {
+		t.Errorf("second frame color = %v, want blue", c)
+	}
+}
+
+// TestEncodePNG tests a transparent still image
+func TestEncodePNG(t *testing.T) {
+	enc, _ := LookupEncoder("png")
+	webp := riff(chunk("VP8L", solidVP8L(5, 3, color.NRGBA{G: 255, A: 64})))
+	var out bytes.Buffer
+	if err := enc.Encode(&out, webp, EncodeOptions{}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	if c := color.NRGBAModel.Convert(img.At(4, 2)); img.Bounds().Dx() != 5 || c != (color.NRGBA{G: 255, A: 64}) {
+		t.Errorf("pixel = %v, want transparent green kept", c)
+	}
+
+	// Of an animation, the first frame on the canvas
+	out.Reset()
+	if err := enc.Encode(&out, animatedSolid(), EncodeOptions{}); err != nil {
+		t.Fatalf("Encode(animation) failed: %v", err)
+	}
+	if img, err := png.Decode(&out); err != nil || img.Bounds().Dx() != 8 {
+		t.Errorf("animation: %v, want the 8x8 first frame", err)
+	}
+}
@@ -0,0 +1,66 @@
+package native
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Image container formats recognized by SniffFormat
+const (
+	FormatUnknown = "unknown"
+	FormatWebP    = "webp"
+	FormatJPEG    = "jpeg"
+	FormatPNG     = "png"
+	FormatGIF     = "gif"
+	FormatBMP     = "bmp"
+	FormatTIFF    = "tiff"
+)
+
+// SniffLen is the number of leading bytes SniffFormat needs
+const SniffLen = 12
+
+// IsWebPHeader reports whether header starts with the RIFF/WEBP magic
+func IsWebPHeader(header []byte) bool {
+	return len(header) >= 12 &&
+		bytes.Equal(header[0:4], []byte("RIFF")) &&
+		bytes.Equal(header[8:12], []byte("WEBP"))
+}
+
+// SniffFormat identifies an image container from its leading bytes
+func SniffFormat(header []byte) string {
+	switch {
+	case IsWebPHeader(header):
+		return FormatWebP
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.HasPrefix(header, []byte("BM")):
+		return FormatBMP
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return FormatTIFF
+	default:
+		return FormatUnknown
+	}
+}
+
+// SniffFile reads the first bytes of a file and identifies its image format
+func SniffFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return FormatUnknown, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, SniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return SniffFormat(header[:n]), nil
+}
@@ -0,0 +1,1154 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Pure Go decoder of the VP8 (lossy) bitstream, ported from libwebp's
+// src/dec/vp8_dec.c, tree_dec.c, quant_dec.c and frame_dec.c. It reproduces
+// the output of WebPDecode to RGBA with fancy upsampling, including the
+// dithering options, and reports the same status on invalid streams.
+
+// Intra prediction modes. The 16x16 and chroma modes share the values of
+// their 4x4 counterparts; the DC variants for the edges of the image follow.
+const (
+	predBDC = iota
+	predBTM
+	predBVE
+	predBHE
+	predBRD
+	predBVR
+	predBLD
+	predBVL
+	predBHD
+	predBHU
+
+	predDC = predBDC
+	predTM = predBTM
+	predV  = predBVE
+	predH  = predBHE
+
+	predDCNoTop     = 4
+	predDCNoLeft    = 5
+	predDCNoTopLeft = 6
+)
+
+const (
+	vp8FrameHeaderSize = 10
+	vp8MaxPartitions   = 8
+	vp8MinDitherAmp    = 4
+)
+
+// vp8BitReader is libwebp's boolean decoder, loading 56 bits at a time. Like
+// the original it sets eof only once a bit past the end of the data is used.
+type vp8BitReader struct {
+	buf   []byte
+	pos   int
+	value uint64
+	rng   uint32 // current range minus 1
+	bits  int    // number of valid bits left
+	eof   bool
+}
+
+func (br *vp8BitReader) init(buf []byte) {
+	*br = vp8BitReader{buf: buf, rng: 255 - 1, bits: -8}
+	br.loadNewBytes()
+}
+
+func (br *vp8BitReader) loadNewBytes() {
+	if br.pos+8 <= len(br.buf) {
+		in := binary.BigEndian.Uint64(br.buf[br.pos:]) >> 8
+		br.pos += 7
+		br.value = in | br.value<<56
+		br.bits += 56
+		return
+	}
+	switch {
+	case br.pos < len(br.buf):
+		br.bits += 8
+		br.value = uint64(br.buf[br.pos]) | br.value<<8
+		br.pos++
+	case !br.eof:
+		br.value <<= 8
+		br.bits += 8
+		br.eof = true
+	default:
+		br.bits = 0
+	}
+}
+
+func (br *vp8BitReader) getBit(prob uint8) int {
+	rng := br.rng
+	if br.bits < 0 {
+		br.loadNewBytes()
+	}
+	pos := br.bits
+	split := (rng * uint32(prob)) >> 8
+	value := uint32(br.value >> pos)
+	bit := 0
+	if value > split {
+		rng -= split
+		br.value -= uint64(split+1) << pos
+		bit = 1
+	} else {
+		rng = split + 1
+	}
+	shift := 7 ^ (bits.Len32(rng) - 1)
+	rng <<= shift
+	br.bits -= shift
+	br.rng = rng - 1
+	return bit
+}
+
+// getSigned returns v or -v, reading the sign with probability 1/2
+func (br *vp8BitReader) getSigned(v int) int {
+	if br.bits < 0 {
+		br.loadNewBytes()
+	}
+	pos := br.bits
+	split := br.rng >> 1
+	value := uint32(br.value >> pos)
+	mask := int32(split-value) >> 31
+	br.bits--
+	br.rng += uint32(mask)
+	br.rng |= 1
+	br.value -= uint64((split+1)&uint32(mask)) << pos
+	return (v ^ int(mask)) - int(mask)
+}
+
+func (br *vp8BitReader) getValue(n int) int {
+	v := 0
+	for n > 0 {
+		n--
+		v |= br.getBit(0x80) << n
+	}
+	return v
+}
+
+func (br *vp8BitReader) getSignedValue(n int) int {
+	v := br.getValue(n)
+	if br.getValue(1) != 0 {
+		return -v
+	}
+	return v
+}
+
+// vp8CheckSignature reports whether data starts with the start code of a
+// key frame
+func vp8CheckSignature(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0x9d && data[1] == 0x01 && data[2] == 0x2a
+}
+
+// vp8GetInfo validates the frame header of a VP8 bitstream of chunkSize
+// bytes and returns its dimensions
+func vp8GetInfo(data []byte, chunkSize int) (width, height int, ok bool) {
+	if len(data) < vp8FrameHeaderSize || !vp8CheckSignature(data[3:]) {
+		return 0, 0, false
+	}
+	bits := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+	width = (int(data[7])<<8 | int(data[6])) & 0x3fff
+	height = (int(data[9])<<8 | int(data[8])) & 0x3fff
+	switch {
+	case bits&1 != 0: // not a key frame
+		return 0, 0, false
+	case (bits>>1)&7 > 3: // unknown profile
+		return 0, 0, false
+	case (bits>>4)&1 == 0: // invisible frame
+		return 0, 0, false
+	case int(bits>>5) >= chunkSize: // partition length
+		return 0, 0, false
+	case width == 0 || height == 0:
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+type vp8QuantMatrix struct {
+	y1, y2, uv [2]int
+	uvQuant    int // chroma AC quantizer, selecting the dithering amplitude
+	dither     int
+}
+
+// vp8FInfo is the loop filter strength of a macroblock
+type vp8FInfo struct {
+	limit, ilevel, hevThresh int
+	inner                    bool
+}
+
+// vp8MB holds the non-zero flags of the blocks on the edges of a macroblock,
+// read as context by its neighbors
+type vp8MB struct {
+	nz, nzDC uint8
+}
+
+// vp8MBData is a parsed macroblock of the current row
+type vp8MBData struct {
+	coeffs    [384]int16
+	isI4x4    bool
+	imodes    [16]uint8
+	uvmode    uint8
+	segment   uint8
+	skip      bool
+	nonZeroY  uint32
+	nonZeroUV uint32
+	dither    int
+}
+
+type vp8TopSamples struct {
+	y [16]byte
+	u [8]byte
+	v [8]byte
+}
+
+type vp8Decoder struct {
+	br               vp8BitReader
+	parts            [vp8MaxPartitions]vp8BitReader
+	numPartsMinusOne int
+
+	width, height int
+	mbW, mbH      int
+
+	useSegment     bool
+	updateMap      bool
+	absoluteDelta  bool
+	quantizer      [4]int
+	filterStrength [4]int
+
+	simpleFilter bool
+	filterLevel  int
+	sharpness    int
+	useLFDelta   bool
+	refLFDelta   [4]int
+	modeLFDelta  [4]int
+	filterType   int // 0 none, 1 simple, 2 complex
+
+	segmentProbas [3]uint8
+	bands         [4][8][3][11]uint8
+	useSkipProba  bool
+	skipProba     uint8
+
+	dqm        [4]vp8QuantMatrix
+	fstrengths [4][2]vp8FInfo
+
+	rng            *vp8Random // set when dithering the chroma planes
+	alphaDithering int
+	alphaData      []byte
+	alpha          *alphaDecoder
+
+	// Per frame state
+	intraT []uint8
+	intraL [4]uint8
+	mbInfo []vp8MB // mbInfo[0] is the left neighbor
+	mbData []vp8MBData
+	fInfo  []vp8FInfo
+	yuvT   []vp8TopSamples
+	yuvB   [vp8YUVSize]byte
+
+	// Decoded planes, padded to whole macroblocks
+	y, u, v           []byte
+	yStride, uvStride int
+}
+
+// decodeVP8 decodes a VP8 bitstream to RGBA like WebPDecode, with the ALPH
+// chunk payload of the image, if any, as its alpha channel. dithering and
+// alphaDithering are the strengths (0 to 100) of WebPDecoderOptions.
+func decodeVP8(data, alphaData []byte, dithering, alphaDithering int) (pix []byte, width, height int, status VP8Status) {
+	d := &vp8Decoder{alphaData: alphaData}
+	if status := d.getHeaders(data); status != VP8StatusOK {
+		return nil, 0, 0, status
+	}
+	d.initDithering(dithering, alphaDithering)
+	if status := d.decodeFrame(); status != VP8StatusOK {
+		return nil, 0, 0, status
+	}
+	return d.toRGBA(), d.width, d.height, VP8StatusOK
+}
+
+// getHeaders parses the frame header and the first partition up to the
+// macroblock data
+func (d *vp8Decoder) getHeaders(buf []byte) VP8Status {
+	if len(buf) < 4 {
+		return VP8StatusNotEnoughData
+	}
+	bits := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+	keyFrame := bits&1 == 0
+	partitionLength := int(bits >> 5)
+	if (bits>>1)&7 > 3 {
+		return VP8StatusBitstreamError
+	}
+	if (bits>>4)&1 == 0 {
+		return VP8StatusUnsupportedFeature
+	}
+	buf = buf[3:]
+
+	if keyFrame {
+		if len(buf) < 7 {
+			return VP8StatusNotEnoughData
+		}
+		if !vp8CheckSignature(buf) {
+			return VP8StatusBitstreamError
+		}
+		d.width = (int(buf[4])<<8 | int(buf[3])) & 0x3fff
+		d.height = (int(buf[6])<<8 | int(buf[5])) & 0x3fff
+		buf = buf[7:]
+		d.mbW = (d.width + 15) >> 4
+		d.mbH = (d.height + 15) >> 4
+
+		d.segmentProbas = [3]uint8{255, 255, 255}
+		d.useSegment, d.updateMap, d.absoluteDelta = false, false, true
+		d.quantizer, d.filterStrength = [4]int{}, [4]int{}
+	}
+
+	if partitionLength > len(buf) {
+		return VP8StatusNotEnoughData
+	}
+	br := &d.br
+	br.init(buf[:partitionLength])
+	buf = buf[partitionLength:]
+
+	if keyFrame {
+		br.getValue(1) // color space
+		br.getValue(1) // clamping type
+	}
+	if !d.parseSegmentHeader() {
+		return VP8StatusBitstreamError
+	}
+	if !d.parseFilterHeader() {
+		return VP8StatusBitstreamError
+	}
+	if status := d.parsePartitions(buf); status != VP8StatusOK {
+		return status
+	}
+	d.parseQuant()
+	if !keyFrame {
+		return VP8StatusUnsupportedFeature
+	}
+	br.getValue(1) // update_proba, ignored
+	d.parseProba()
+	return VP8StatusOK
+}
+
+func (d *vp8Decoder) parseSegmentHeader() bool {
+	br := &d.br
+	d.useSegment = br.getValue(1) != 0
+	if d.useSegment {
+		d.updateMap = br.getValue(1) != 0
+		if br.getValue(1) != 0 { // update data
+			d.absoluteDelta = br.getValue(1) != 0
+			for s := range d.quantizer {
+				d.quantizer[s] = 0
+				if br.getValue(1) != 0 {
+					d.quantizer[s] = br.getSignedValue(7)
+				}
+			}
+			for s := range d.filterStrength {
+				d.filterStrength[s] = 0
+				if br.getValue(1) != 0 {
+					d.filterStrength[s] = br.getSignedValue(6)
+				}
+			}
+		}
+		if d.updateMap {
+			for s := range d.segmentProbas {
+				d.segmentProbas[s] = 255
+				if br.getValue(1) != 0 {
+					d.segmentProbas[s] = uint8(br.getValue(8))
+				}
+			}
+		}
+	} else {
+		d.updateMap = false
+	}
+	return !br.eof
+}
+
+func (d *vp8Decoder) parseFilterHeader() bool {
+	br := &d.br
+	d.simpleFilter = br.getValue(1) != 0
+	d.filterLevel = br.getValue(6)
+	d.sharpness = br.getValue(3)
+	d.useLFDelta = br.getValue(1) != 0
+	if d.useLFDelta && br.getValue(1) != 0 { // update lf-delta
+		for i := range d.refLFDelta {
+			if br.getValue(1) != 0 {
+				d.refLFDelta[i] = br.getSignedValue(6)
+			}
+		}
+		for i := range d.modeLFDelta {
+			if br.getValue(1) != 0 {
+				d.modeLFDelta[i] = br.getSignedValue(6)
+			}
+		}
+	}
+	switch {
+	case d.filterLevel == 0:
+		d.filterType = 0
+	case d.simpleFilter:
+		d.filterType = 1
+	default:
+		d.filterType = 2
+	}
+	return !br.eof
+}
+
+// parsePartitions sets up the readers of the token partitions. The last one
+// extends to the end of the data.
+func (d *vp8Decoder) parsePartitions(buf []byte) VP8Status {
+	d.numPartsMinusOne = 1<<d.br.getValue(2) - 1
+	last := d.numPartsMinusOne
+	if len(buf) < 3*last {
+		return VP8StatusNotEnoughData
+	}
+	sz := buf
+	partStart := 3 * last
+	sizeLeft := len(buf) - partStart
+	for p := 0; p < last; p++ {
+		psize := int(sz[0]) | int(sz[1])<<8 | int(sz[2])<<16
+		psize = min(psize, sizeLeft)
+		d.parts[p].init(buf[partStart : partStart+psize])
+		partStart += psize
+		sizeLeft -= psize
+		sz = sz[3:]
+	}
+	d.parts[last].init(buf[partStart:])
+	if partStart < len(buf) {
+		return VP8StatusOK
+	}
+	return VP8StatusNotEnoughData
+}
+
+func (d *vp8Decoder) parseQuant() {
+	br := &d.br
+	baseQ0 := br.getValue(7)
+	var deltas [5]int // y1 dc, y2 dc, y2 ac, uv dc, uv ac
+	for i := range deltas {
+		if br.getValue(1) != 0 {
+			deltas[i] = br.getSignedValue(4)
+		}
+	}
+	dqy1DC, dqy2DC, dqy2AC, dquvDC, dquvAC := deltas[0], deltas[1], deltas[2], deltas[3], deltas[4]
+
+	clip := func(v, m int) int { return min(max(v, 0), m) }
+	for i := range d.dqm {
+		var q int
+		if d.useSegment {
+			q = d.quantizer[i]
+			if !d.absoluteDelta {
+				q += baseQ0
+			}
+		} else if i > 0 {
+			d.dqm[i] = d.dqm[0]
+			continue
+		} else {
+			q = baseQ0
+		}
+		m := &d.dqm[i]
+		m.y1[0] = int(vp8DcTable[clip(q+dqy1DC, 127)])
+		m.y1[1] = int(vp8AcTable[clip(q, 127)])
+		m.y2[0] = int(vp8DcTable[clip(q+dqy2DC, 127)]) * 2
+		// x*155/100 is (x*101581)>>16 for all the table values
+		m.y2[1] = max((int(vp8AcTable[clip(q+dqy2AC, 127)])*101581)>>16, 8)
+		m.uv[0] = int(vp8DcTable[clip(q+dquvDC, 117)])
+		m.uv[1] = int(vp8AcTable[clip(q+dquvAC, 127)])
+		m.uvQuant = q + dquvAC
+	}
+}
+
+func (d *vp8Decoder) parseProba() {
+	br := &d.br
+	for t := range d.bands {
+		for b := range d.bands[t] {
+			for c := range d.bands[t][b] {
+				for p := range d.bands[t][b][c] {
+					v := vp8CoeffsProba0[t][b][c][p]
+					if br.getBit(vp8CoeffsUpdateProba[t][b][c][p]) != 0 {
+						v = uint8(br.getValue(8))
+					}
+					d.bands[t][b][c][p] = v
+				}
+			}
+		}
+	}
+	d.useSkipProba = br.getValue(1) != 0
+	if d.useSkipProba {
+		d.skipProba = uint8(br.getValue(8))
+	}
+}
+
+// initDithering sets the chroma dithering amplitude of each segment from
+// the strength (0 to 100), for the segments with a fine enough quantizer
+func (d *vp8Decoder) initDithering(strength, alphaStrength int) {
+	const maxAmp = 1<<vp8RandomDitherFix - 1
+	f := 0
+	switch {
+	case strength > 100:
+		f = maxAmp
+	case strength > 0:
+		f = strength * maxAmp / 100
+	}
+	if f > 0 {
+		allAmp := 0
+		for s := range d.dqm {
+			m := &d.dqm[s]
+			if m.uvQuant < len(vp8QuantToDitherAmp) {
+				m.dither = (f * int(vp8QuantToDitherAmp[max(m.uvQuant, 0)])) >> 3
+			}
+			allAmp |= m.dither
+		}
+		if allAmp != 0 {
+			d.rng = newVP8Random()
+		}
+	}
+	d.alphaDithering = min(max(alphaStrength, 0), 100)
+}
+
+// precomputeFilterStrengths computes the loop filter strength of each
+// segment, for 16x16 and 4x4 macroblocks
+func (d *vp8Decoder) precomputeFilterStrengths() {
+	if d.filterType == 0 {
+		return
+	}
+	for s := range d.fstrengths {
+		baseLevel := d.filterLevel
+		if d.useSegment {
+			baseLevel = d.filterStrength[s]
+			if !d.absoluteDelta {
+				baseLevel += d.filterLevel
+			}
+		}
+		for i4x4 := 0; i4x4 <= 1; i4x4++ {
+			info := &d.fstrengths[s][i4x4]
+			level := baseLevel
+			if d.useLFDelta {
+				level += d.refLFDelta[0]
+				if i4x4 == 1 {
+					level += d.modeLFDelta[0]
+				}
+			}
+			level = min(max(level, 0), 63)
+			if level > 0 {
+				ilevel := level
+				if d.sharpness > 0 {
+					if d.sharpness > 4 {
+						ilevel >>= 2
+					} else {
+						ilevel >>= 1
+					}
+					ilevel = min(ilevel, 9-d.sharpness)
+				}
+				ilevel = max(ilevel, 1)
+				info.ilevel = ilevel
+				info.limit = 2*level + ilevel
+				switch {
+				case level >= 40:
+					info.hevThresh = 2
+				case level >= 15:
+					info.hevThresh = 1
+				default:
+					info.hevThresh = 0
+				}
+			} else {
+				info.limit = 0
+			}
+			info.inner = i4x4 == 1
+		}
+	}
+}
+
+// decodeFrame parses and reconstructs the macroblocks row by row
+func (d *vp8Decoder) decodeFrame() VP8Status {
+	d.precomputeFilterStrengths()
+
+	d.intraT = make([]uint8, 4*d.mbW)
+	d.mbInfo = make([]vp8MB, d.mbW+1)
+	d.mbData = make([]vp8MBData, d.mbW)
+	d.fInfo = make([]vp8FInfo, d.mbW)
+	d.yuvT = make([]vp8TopSamples, d.mbW)
+	d.yStride = 16 * d.mbW
+	d.uvStride = 8 * d.mbW
+	d.y = make([]byte, d.yStride*16*d.mbH)
+	d.u = make([]byte, d.uvStride*8*d.mbH)
+	d.v = make([]byte, d.uvStride*8*d.mbH)
+
+	for mbY := 0; mbY < d.mbH; mbY++ {
+		tokenBr := &d.parts[mbY&d.numPartsMinusOne]
+		if !d.parseIntraModeRow() {
+			return VP8StatusNotEnoughData
+		}
+		for mbX := 0; mbX < d.mbW; mbX++ {
+			if !d.decodeMB(mbX, tokenBr) {
+				return VP8StatusNotEnoughData
+			}
+		}
+		// Prepare for the next row
+		d.mbInfo[0] = vp8MB{}
+		d.intraL = [4]uint8{}
+
+		d.reconstructRow(mbY)
+		if d.filterType > 0 {
+			for mbX := 0; mbX < d.mbW; mbX++ {
+				d.filterMB(mbX, mbY)
+			}
+		}
+		if d.rng != nil {
+			d.ditherRow(mbY)
+		}
+		if status := d.finishRow(mbY); status != VP8StatusOK {
+			return status
+		}
+	}
+	return VP8StatusOK
+}
+
+func (d *vp8Decoder) parseIntraModeRow() bool {
+	for mbX := 0; mbX < d.mbW; mbX++ {
+		d.parseIntraMode(mbX)
+	}
+	return !d.br.eof
+}
+
+func (d *vp8Decoder) parseIntraMode(mbX int) {
+	br := &d.br
+	top := d.intraT[4*mbX : 4*mbX+4]
+	left := d.intraL[:]
+	block := &d.mbData[mbX]
+
+	block.segment = 0
+	if d.updateMap {
+		if br.getBit(d.segmentProbas[0]) == 0 {
+			block.segment = uint8(br.getBit(d.segmentProbas[1]))
+		} else {
+			block.segment = uint8(br.getBit(d.segmentProbas[2]) + 2)
+		}
+	}
+	if d.useSkipProba {
+		block.skip = br.getBit(d.skipProba) != 0
+	}
+
+	block.isI4x4 = br.getBit(145) == 0
+	if !block.isI4x4 {
+		var ymode uint8
+		if br.getBit(156) != 0 {
+			ymode = predH
+			if br.getBit(128) != 0 {
+				ymode = predTM
+			}
+		} else {
+			ymode = predDC
+			if br.getBit(163) != 0 {
+				ymode = predV
+			}
+		}
+		block.imodes[0] = ymode
+		for i := 0; i < 4; i++ {
+			top[i] = ymode
+			left[i] = ymode
+		}
+	} else {
+		for y := 0; y < 4; y++ {
+			ymode := left[y]
+			for x := 0; x < 4; x++ {
+				prob := &vp8BModesProba[top[x]][ymode]
+				i := int(vp8YModesIntra4[br.getBit(prob[0])])
+				for i > 0 {
+					i = int(vp8YModesIntra4[2*i+br.getBit(prob[i])])
+				}
+				ymode = uint8(-i)
+				top[x] = ymode
+			}
+			copy(block.imodes[4*y:], top)
+			left[y] = ymode
+		}
+	}
+
+	switch {
+	case br.getBit(142) == 0:
+		block.uvmode = predDC
+	case br.getBit(114) == 0:
+		block.uvmode = predV
+	case br.getBit(183) != 0:
+		block.uvmode = predTM
+	default:
+		block.uvmode = predH
+	}
+}
+
+// getLargeValue reads a coefficient magnitude of 2 or more
+func getLargeValue(br *vp8BitReader, p *[11]uint8) int {
+	if br.getBit(p[3]) == 0 {
+		if br.getBit(p[4]) == 0 {
+			return 2
+		}
+		return 3 + br.getBit(p[5])
+	}
+	if br.getBit(p[6]) == 0 {
+		if br.getBit(p[7]) == 0 {
+			return 5 + br.getBit(159)
+		}
+		v := 7 + 2*br.getBit(165)
+		return v + br.getBit(145)
+	}
+	bit1 := br.getBit(p[8])
+	bit0 := br.getBit(p[9+bit1])
+	cat := 2*bit1 + bit0
+	v := 0
+	for _, prob := range vp8Cats[cat] {
+		v += v + br.getBit(prob)
+	}
+	return v + 3 + 8<<cat
+}
+
+// getCoeffs reads the coefficients of a block from position n, dequantized
+// with dq, and returns the position of the last non-zero one plus one
+func getCoeffs(br *vp8BitReader, bands *[8][3][11]uint8, ctx int, dq [2]int, n int, out []int16) int {
+	p := &bands[vp8Bands[n]][ctx]
+	for ; n < 16; n++ {
+		if br.getBit(p[0]) == 0 {
+			return n // previous coefficient was the last non-zero one
+		}
+		for br.getBit(p[1]) == 0 { // zero coefficients
+			n++
+			if n == 16 {
+				return 16
+			}
+			p = &bands[vp8Bands[n]][0]
+		}
+		pCtx := &bands[vp8Bands[n+1]]
+		var v int
+		if br.getBit(p[2]) == 0 {
+			v = 1
+			p = &pCtx[1]
+		} else {
+			v = getLargeValue(br, p)
+			p = &pCtx[2]
+		}
+		q := dq[1]
+		if n == 0 {
+			q = dq[0]
+		}
+		out[vp8Zigzag[n]] = int16(br.getSigned(v) * q)
+	}
+	return 16
+}
+
+// nzCodeBits appends the 2-bit summary of a block used to pick its inverse
+// transform: 0 empty, 1 DC only, 2 three coefficients at most, 3 more
+func nzCodeBits(nzCoeffs uint32, nz int, dcNz bool) uint32 {
+	nzCoeffs <<= 2
+	switch {
+	case nz > 3:
+		nzCoeffs |= 3
+	case nz > 1:
+		nzCoeffs |= 2
+	case dcNz:
+		nzCoeffs |= 1
+	}
+	return nzCoeffs
+}
+
+// parseResiduals reads the coefficients of a macroblock and reports whether
+// they are all zero
+func (d *vp8Decoder) parseResiduals(mbX int, tokenBr *vp8BitReader) bool {
+	mb := &d.mbInfo[mbX+1]
+	leftMB := &d.mbInfo[0]
+	block := &d.mbData[mbX]
+	q := &d.dqm[block.segment]
+	coeffs := block.coeffs[:]
+	clear(coeffs)
+
+	var acProba *[8][3][11]uint8
+	first := 0
+	if !block.isI4x4 { // parse DC
+		var dc [16]int16
+		ctx := int(mb.nzDC + leftMB.nzDC)
+		nz := getCoeffs(tokenBr, &d.bands[1], ctx, q.y2, 0, dc[:])
+		var nzDC uint8
+		if nz > 0 {
+			nzDC = 1
+		}
+		mb.nzDC, leftMB.nzDC = nzDC, nzDC
+		if nz > 1 { // more than just the DC
+			transformWHT(dc[:], coeffs)
+		} else {
+			dc0 := int16((int(dc[0]) + 3) >> 3)
+			for i := 0; i < 16*16; i += 16 {
+				coeffs[i] = dc0
+			}
+		}
+		first = 1
+		acProba = &d.bands[0]
+	} else {
+		acProba = &d.bands[3]
+	}
+
+	var nonZeroY, nonZeroUV uint32
+	tnz := mb.nz & 0x0f
+	lnz := leftMB.nz & 0x0f
+	dst := 0
+	for y := 0; y < 4; y++ {
+		l := lnz & 1
+		var nzCoeffs uint32
+		for x := 0; x < 4; x++ {
+			ctx := int(l + tnz&1)
+			nz := getCoeffs(tokenBr, acProba, ctx, q.y1, first, coeffs[dst:dst+16])
+			l = 0
+			if nz > first {
+				l = 1
+			}
+			tnz = tnz>>1 | l<<7
+			nzCoeffs = nzCodeBits(nzCoeffs, nz, coeffs[dst] != 0)
+			dst += 16
+		}
+		tnz >>= 4
+		lnz = lnz>>1 | l<<7
+		nonZeroY = nonZeroY<<8 | nzCoeffs
+	}
+	outTNz := uint32(tnz)
+	outLNz := uint32(lnz >> 4)
+
+	for ch := 0; ch < 4; ch += 2 {
+		var nzCoeffs uint32
+		tnz = mb.nz >> (4 + ch)
+		lnz = leftMB.nz >> (4 + ch)
+		for y := 0; y < 2; y++ {
+			l := lnz & 1
+			for x := 0; x < 2; x++ {
+				ctx := int(l + tnz&1)
+				nz := getCoeffs(tokenBr, &d.bands[2], ctx, q.uv, 0, coeffs[dst:dst+16])
+				l = 0
+				if nz > 0 {
+					l = 1
+				}
+				tnz = tnz>>1 | l<<3
+				nzCoeffs = nzCodeBits(nzCoeffs, nz, coeffs[dst] != 0)
+				dst += 16
+			}
+			tnz >>= 2
+			lnz = lnz>>1 | l<<5
+		}
+		nonZeroUV |= nzCoeffs << (4 * ch)
+		outTNz |= uint32(tnz<<4) << ch
+		outLNz |= uint32(lnz&0xf0) << ch
+	}
+	mb.nz = uint8(outTNz)
+	leftMB.nz = uint8(outLNz)
+
+	block.nonZeroY = nonZeroY
+	block.nonZeroUV = nonZeroUV
+	// Flat blocks, with few chroma coefficients, are dithered
+	block.dither = q.dither
+	if nonZeroUV&0xaaaa != 0 {
+		block.dither = 0
+	}
+	return nonZeroY|nonZeroUV == 0
+}
+
+func (d *vp8Decoder) decodeMB(mbX int, tokenBr *vp8BitReader) bool {
+	left := &d.mbInfo[0]
+	mb := &d.mbInfo[mbX+1]
+	block := &d.mbData[mbX]
+	skip := d.useSkipProba && block.skip
+	if !skip {
+		skip = d.parseResiduals(mbX, tokenBr)
+	} else {
+		left.nz, mb.nz = 0, 0
+		if !block.isI4x4 {
+			left.nzDC, mb.nzDC = 0, 0
+		}
+		block.nonZeroY = 0
+		block.nonZeroUV = 0
+		block.dither = 0
+	}
+
+	if d.filterType > 0 {
+		i4x4 := 0
+		if block.isI4x4 {
+			i4x4 = 1
+		}
+		d.fInfo[mbX] = d.fstrengths[block.segment][i4x4]
+		d.fInfo[mbX].inner = d.fInfo[mbX].inner || !skip
+	}
+	return !tokenBr.eof
+}
+
+// vp8Scan is the offset of each 4x4 luma block in the work area
+var vp8Scan = [16]int{
+	0 + 0*vp8BPS, 4 + 0*vp8BPS, 8 + 0*vp8BPS, 12 + 0*vp8BPS,
+	0 + 4*vp8BPS, 4 + 4*vp8BPS, 8 + 4*vp8BPS, 12 + 4*vp8BPS,
+	0 + 8*vp8BPS, 4 + 8*vp8BPS, 8 + 8*vp8BPS, 12 + 8*vp8BPS,
+	0 + 12*vp8BPS, 4 + 12*vp8BPS, 8 + 12*vp8BPS, 12 + 12*vp8BPS,
+}
+
+// checkMode replaces the DC prediction on the edges of the image
+func checkMode(mbX, mbY int, mode uint8) int {
+	if mode == predBDC {
+		if mbX == 0 {
+			if mbY == 0 {
+				return predDCNoTopLeft
+			}
+			return predDCNoLeft
+		}
+		if mbY == 0 {
+			return predDCNoTop
+		}
+	}
+	return int(mode)
+}
+
+func doTransform(bits uint32, src []int16, b []byte, dst int) {
+	switch bits >> 30 {
+	case 3:
+		transformOne(src, b, dst)
+	case 2:
+		transformAC3(src, b, dst)
+	case 1:
+		transformDC(src, b, dst)
+	}
+}
+
+func doUVTransform(bits uint32, src []int16, b []byte, dst int) {
+	if bits&0xff != 0 { // any non-zero coefficient
+		if bits&0xaa != 0 { // any non-zero AC coefficient
+			transformUV(src, b, dst)
+		} else {
+			transformDCUV(src, b, dst)
+		}
+	}
+}
+
+// reconstructRow predicts and adds the residuals of a row of macroblocks,
+// storing the unfiltered samples in the planes
+func (d *vp8Decoder) reconstructRow(mbY int) {
+	b := d.yuvB[:]
+	const yDst, uDst, vDst = vp8YOff, vp8UOff, vp8VOff
+
+	// Initialize the left-most block
+	for j := 0; j < 16; j++ {
+		b[yDst+j*vp8BPS-1] = 129
+	}
+	for j := 0; j < 8; j++ {
+		b[uDst+j*vp8BPS-1] = 129
+		b[vDst+j*vp8BPS-1] = 129
+	}
+	// and its top-left sample
+	if mbY > 0 {
+		b[yDst-1-vp8BPS], b[uDst-1-vp8BPS], b[vDst-1-vp8BPS] = 129, 129, 129
+	} else {
+		// The top row stays valid for the whole top macroblock row
+		fillBytes(b[yDst-vp8BPS-1:yDst-vp8BPS+16+4], 127)
+		fillBytes(b[uDst-vp8BPS-1:uDst-vp8BPS+8], 127)
+		fillBytes(b[vDst-vp8BPS-1:vDst-vp8BPS+8], 127)
+	}
+
+	for mbX := 0; mbX < d.mbW; mbX++ {
+		block := &d.mbData[mbX]
+
+		// Rotate in the left samples from the previous block
+		if mbX > 0 {
+			for j := -1; j < 16; j++ {
+				copy(b[yDst+j*vp8BPS-4:yDst+j*vp8BPS], b[yDst+j*vp8BPS+12:yDst+j*vp8BPS+16])
+			}
+			for j := -1; j < 8; j++ {
+				copy(b[uDst+j*vp8BPS-4:uDst+j*vp8BPS], b[uDst+j*vp8BPS+4:uDst+j*vp8BPS+8])
+				copy(b[vDst+j*vp8BPS-4:vDst+j*vp8BPS], b[vDst+j*vp8BPS+4:vDst+j*vp8BPS+8])
+			}
+		}
+
+		topYUV := &d.yuvT[mbX]
+		coeffs := block.coeffs[:]
+		bits := block.nonZeroY
+		if mbY > 0 {
+			copy(b[yDst-vp8BPS:], topYUV.y[:])
+			copy(b[uDst-vp8BPS:], topYUV.u[:])
+			copy(b[vDst-vp8BPS:], topYUV.v[:])
+		}
+
+		if block.isI4x4 {
+			topRight := yDst - vp8BPS + 16
+			if mbY > 0 {
+				if mbX >= d.mbW-1 { // on the rightmost border
+					fillBytes(b[topRight:topRight+4], topYUV.y[15])
+				} else {
+					copy(b[topRight:topRight+4], d.yuvT[mbX+1].y[:4])
+				}
+			}
+			// Replicate the top-right samples below
+			for k := 1; k <= 3; k++ {
+				copy(b[topRight+4*k*vp8BPS:topRight+4*k*vp8BPS+4], b[topRight:topRight+4])
+			}
+			for n := 0; n < 16; n++ {
+				dst := yDst + vp8Scan[n]
+				vp8PredLuma4[block.imodes[n]](b, dst)
+				doTransform(bits, coeffs[16*n:], b, dst)
+				bits <<= 2
+			}
+		} else {
+			vp8PredLuma16[checkMode(mbX, mbY, block.imodes[0])](b, yDst)
+			if bits != 0 {
+				for n := 0; n < 16; n++ {
+					doTransform(bits, coeffs[16*n:], b, yDst+vp8Scan[n])
+					bits <<= 2
+				}
+			}
+		}
+
+		// Chroma
+		bitsUV := block.nonZeroUV
+		predFunc := vp8PredChroma8[checkMode(mbX, mbY, block.uvmode)]
+		predFunc(b, uDst)
+		predFunc(b, vDst)
+		doUVTransform(bitsUV, coeffs[16*16:], b, uDst)
+		doUVTransform(bitsUV>>8, coeffs[20*16:], b, vDst)
+
+		// Stash away the top samples for the next row
+		if mbY < d.mbH-1 {
+			copy(topYUV.y[:], b[yDst+15*vp8BPS:])
+			copy(topYUV.u[:], b[uDst+7*vp8BPS:])
+			copy(topYUV.v[:], b[vDst+7*vp8BPS:])
+		}
+
+		yOut := mbY*16*d.yStride + mbX*16
+		uvOut := mbY*8*d.uvStride + mbX*8
+		for j := 0; j < 16; j++ {
+			copy(d.y[yOut+j*d.yStride:yOut+j*d.yStride+16], b[yDst+j*vp8BPS:])
+		}
+		for j := 0; j < 8; j++ {
+			copy(d.u[uvOut+j*d.uvStride:uvOut+j*d.uvStride+8], b[uDst+j*vp8BPS:])
+			copy(d.v[uvOut+j*d.uvStride:uvOut+j*d.uvStride+8], b[vDst+j*vp8BPS:])
+		}
+	}
+}
+
+func fillBytes(b []byte, v byte) {
+	for i := range b {
+		b[i] = v
+	}
+}
+
+// filterMB applies the loop filter to the edges of a macroblock
+func (d *vp8Decoder) filterMB(mbX, mbY int) {
+	info := &d.fInfo[mbX]
+	limit := info.limit
+	if limit == 0 {
+		return
+	}
+	yStride := d.yStride
+	yDst := mbY*16*yStride + mbX*16
+	if d.filterType == 1 { // simple
+		if mbX > 0 {
+			simpleFilter16(d.y, yDst, 1, yStride, limit+4)
+		}
+		if info.inner {
+			simpleFilter16i(d.y, yDst, 1, yStride, limit)
+		}
+		if mbY > 0 {
+			simpleFilter16(d.y, yDst, yStride, 1, limit+4)
+		}
+		if info.inner {
+			simpleFilter16i(d.y, yDst, yStride, 1, limit)
+		}
+		return
+	}
+
+	uvStride := d.uvStride
+	uvDst := mbY*8*uvStride + mbX*8
+	ilevel, hevThresh := info.ilevel, info.hevThresh
+	if mbX > 0 {
+		filterLoop26(d.y, yDst, 1, yStride, 16, limit+4, ilevel, hevThresh)
+		filterLoop26(d.u, uvDst, 1, uvStride, 8, limit+4, ilevel, hevThresh)
+		filterLoop26(d.v, uvDst, 1, uvStride, 8, limit+4, ilevel, hevThresh)
+	}
+	if info.inner {
+		filterLoop24i(d.y, yDst, 1, yStride, 16, limit, ilevel, hevThresh)
+		filterLoop24i(d.u, uvDst, 1, uvStride, 8, limit, ilevel, hevThresh)
+		filterLoop24i(d.v, uvDst, 1, uvStride, 8, limit, ilevel, hevThresh)
+	}
+	if mbY > 0 {
+		filterLoop26(d.y, yDst, yStride, 1, 16, limit+4, ilevel, hevThresh)
+		filterLoop26(d.u, uvDst, uvStride, 1, 8, limit+4, ilevel, hevThresh)
+		filterLoop26(d.v, uvDst, uvStride, 1, 8, limit+4, ilevel, hevThresh)
+	}
+	if info.inner {
+		filterLoop24i(d.y, yDst, yStride, 1, 16, limit, ilevel, hevThresh)
+		filterLoop24i(d.u, uvDst, uvStride, 1, 8, limit, ilevel, hevThresh)
+		filterLoop24i(d.v, uvDst, uvStride, 1, 8, limit, ilevel, hevThresh)
+	}
+}
+
+// ditherRow adds noise to the flat chroma blocks of a filtered row
+func (d *vp8Decoder) ditherRow(mbY int) {
+	for mbX := 0; mbX < d.mbW; mbX++ {
+		amp := d.mbData[mbX].dither
+		if amp >= vp8MinDitherAmp {
+			uvDst := mbY*8*d.uvStride + mbX*8
+			d.rng.dither8x8(d.u, uvDst, d.uvStride, amp)
+			d.rng.dither8x8(d.v, uvDst, d.uvStride, amp)
+		}
+	}
+}
+
+// finishRow checks that the alpha rows libwebp outputs with a macroblock row
+// decode. The rows above it that the loop filter may still change are only
+// output with the next one.
+func (d *vp8Decoder) finishRow(mbY int) VP8Status {
+	if d.alphaData == nil {
+		return VP8StatusOK
+	}
+	extraRows := vp8FilterExtraRows[d.filterType]
+	yStart := 16 * mbY
+	yEnd := 16 * (mbY + 1)
+	if mbY > 0 {
+		yStart -= extraRows
+	}
+	if mbY < d.mbH-1 {
+		yEnd -= extraRows
+	}
+	yEnd = min(yEnd, d.height)
+	if yStart >= yEnd {
+		return VP8StatusOK
+	}
+	if d.alpha == nil {
+		alpha, ok := newAlphaDecoder(d.alphaData, d.width, d.height, d.alphaDithering)
+		if !ok {
+			return VP8StatusOutOfMemory
+		}
+		d.alpha = alpha
+	}
+	if yEnd > d.alpha.failedRow {
+		return VP8StatusBitstreamError
+	}
+	return VP8StatusOK
+}
+
+// toRGBA converts the decoded planes with the fancy upsampler
+func (d *vp8Decoder) toRGBA() []byte {
+	w, h := d.width, d.height
+	pix := make([]byte, 4*w*h)
+	yRow := func(y int) []byte { return d.y[y*d.yStride:] }
+	uRow := func(y int) []byte { return d.u[y*d.uvStride:] }
+	vRow := func(y int) []byte { return d.v[y*d.uvStride:] }
+	dstRow := func(y int) []byte { return pix[4*w*y:] }
+
+	// The first row mirrors the chroma samples at the boundary
+	upsampleLinePair(yRow(0), nil, uRow(0), vRow(0), uRow(0), vRow(0), dstRow(0), nil, w)
+	for y := 1; y+1 < h; y += 2 {
+		k := (y + 1) / 2
+		upsampleLinePair(yRow(y), yRow(y+1), uRow(k-1), vRow(k-1), uRow(k), vRow(k), dstRow(y), dstRow(y+1), w)
+	}
+	if h&1 == 0 {
+		k := h/2 - 1
+		upsampleLinePair(yRow(h-1), nil, uRow(k), vRow(k), uRow(k), vRow(k), dstRow(h-1), nil, w)
+	}
+
+	for i := 0; i < w*h; i++ {
+		a := byte(0xff)
+		if d.alpha != nil {
+			a = d.alpha.plane[i]
+		}
+		pix[4*i+3] = a
+	}
+	return pix
+}
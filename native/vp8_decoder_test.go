@@ -0,0 +1,128 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// decodePlanes decodes data to the internal planes of the VP8 decoder,
+// without dithering
+func decodePlanes(t *testing.T, data []byte) *vp8Decoder {
+	t.Helper()
+	var headers webpHeaders
+	if _, status := parseHeaders(data, &headers); status != VP8StatusOK {
+		t.Fatalf("parseHeaders: %v", status)
+	}
+	d := &vp8Decoder{alphaData: headers.alphaData}
+	if status := d.getHeaders(data[headers.offset:]); status != VP8StatusOK {
+		t.Fatalf("getHeaders: %v", status)
+	}
+	d.initDithering(0, 0)
+	if status := d.decodeFrame(); status != VP8StatusOK {
+		t.Fatalf("decodeFrame: %v", status)
+	}
+	return d
+}
+
+// TestVP8DecoderPlanes tests that the decoded planes are those of libwebp
+// byte for byte
+func TestVP8DecoderPlanes(t *testing.T) {
+	for _, fx := range lossyFixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			data, ref := readFixture(t, fx)
+			d := decodePlanes(t, data)
+
+			compare := func(name string, got []byte, stride int, want []byte, w, h int) {
+				diffs := 0
+				for y := 0; y < h; y++ {
+					diffs += countDiffs(got[y*stride:][:w], want[y*w:][:w])
+				}
+				if diffs > 0 {
+					t.Errorf("%s: %d of %d samples differ", name, diffs, w*h)
+				}
+			}
+			h2 := (ref.height + 1) / 2
+			compare("Y", d.y, d.yStride, ref.y, ref.width, ref.height)
+			compare("U", d.u, d.uvStride, ref.u, ref.w2, h2)
+			compare("V", d.v, d.uvStride, ref.v, ref.w2, h2)
+			switch {
+			case ref.a == nil && d.alpha != nil:
+				t.Error("unexpected alpha plane")
+			case ref.a != nil && d.alpha == nil:
+				t.Error("missing alpha plane")
+			case ref.a != nil:
+				compare("A", d.alpha.plane, ref.width, ref.a, ref.width, ref.height)
+			}
+		})
+	}
+}
+
+func countDiffs(a, b []byte) int {
+	n := 0
+	for i := range a {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// TestDecodeCorrupt tests that truncated and damaged files fail or decode
+// to a whole image, without a panic in the partition and coefficient readers
+func TestDecodeCorrupt(t *testing.T) {
+	for _, fx := range lossyFixtures[:3] {
+		data, err := os.ReadFile(filepath.Join("testdata", fx.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headers webpHeaders
+		if _, status := parseHeaders(data, &headers); status != VP8StatusOK {
+			t.Fatalf("%s: %v", fx.name, status)
+		}
+
+		// Every cut in the frame header, the first partition and the
+		// token partitions
+		for n := headers.offset; n < len(data); n += 1 + (n-headers.offset)/16 {
+			if _, _, _, status := decodeWebP(data[:n], 0, 0); status == VP8StatusOK {
+				t.Errorf("%s: truncated to %d bytes: decoded", fx.name, n)
+			}
+		}
+
+		damaged := make([]byte, len(data))
+		step := max((len(data)-headers.offset)/200, 1)
+		for i := headers.offset; i < len(data); i += step {
+			copy(damaged, data)
+			damaged[i] ^= 0x5a
+			checkDecode(t, damaged)
+		}
+	}
+}
+
+// checkDecode decodes data and fails if it yields an image of the wrong size
+func checkDecode(t *testing.T, data []byte) {
+	t.Helper()
+	features, err := readFeatures(data)
+	if err != nil || features.width*features.height > 1<<22 {
+		return
+	}
+	pix, width, height, status := decodeWebP(data, 0, 0)
+	if status == VP8StatusOK && (width != features.width || height != features.height || len(pix) != 4*width*height) {
+		t.Errorf("decoded %dx%d with %d bytes for %dx%d", width, height, len(pix), features.width, features.height)
+	}
+}
+
+func FuzzDecodeWebP(f *testing.F) {
+	for _, fx := range lossyFixtures {
+		data, err := os.ReadFile(filepath.Join("testdata", fx.name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add(bytes.Repeat([]byte{0}, 64))
+	f.Fuzz(checkDecode)
+}
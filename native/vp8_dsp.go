@@ -0,0 +1,691 @@
+//go:build !cgo || purego
+
+package native
+
+// Reconstruction, loop filtering and color conversion of the pure Go VP8
+// decoder, ported from libwebp's src/dsp/dec.c, src/dsp/upsampling.c and
+// src/dsp/yuv.h
+
+// vp8BPS is the stride of the macroblock work area
+const vp8BPS = 32
+
+// Offsets of the Y, U and V samples in the work area, which keeps the row
+// above and the column on the left of each plane
+const (
+	vp8YUVSize = vp8BPS*17 + vp8BPS*9
+	vp8YOff    = vp8BPS*1 + 8
+	vp8UOff    = vp8YOff + vp8BPS*16 + vp8BPS
+	vp8VOff    = vp8UOff + 16
+)
+
+func clip1(v int) byte {
+	return clip8b(v)
+}
+
+func sclip1(v int) int {
+	return min(max(v, -128), 127)
+}
+
+func sclip2(v int) int {
+	return min(max(v, -16), 15)
+}
+
+func transformMul1(a int) int {
+	return (a*20091)>>16 + a
+}
+
+func transformMul2(a int) int {
+	return (a * 35468) >> 16
+}
+
+func storePixel(b []byte, i, v int) {
+	b[i] = clip8b(int(b[i]) + v>>3)
+}
+
+// transformOne adds the inverse DCT of the 16 coefficients in to the 4x4
+// block at dst
+func transformOne(in []int16, b []byte, dst int) {
+	var tmp [16]int
+	for i := 0; i < 4; i++ {
+		a := int(in[i]) + int(in[8+i])
+		bb := int(in[i]) - int(in[8+i])
+		c := transformMul2(int(in[4+i])) - transformMul1(int(in[12+i]))
+		d := transformMul1(int(in[4+i])) + transformMul2(int(in[12+i]))
+		tmp[4*i] = a + d
+		tmp[4*i+1] = bb + c
+		tmp[4*i+2] = bb - c
+		tmp[4*i+3] = a - d
+	}
+	for i := 0; i < 4; i++ {
+		dc := tmp[i] + 4
+		a := dc + tmp[8+i]
+		bb := dc - tmp[8+i]
+		c := transformMul2(tmp[4+i]) - transformMul1(tmp[12+i])
+		d := transformMul1(tmp[4+i]) + transformMul2(tmp[12+i])
+		row := dst + i*vp8BPS
+		storePixel(b, row, a+d)
+		storePixel(b, row+1, bb+c)
+		storePixel(b, row+2, bb-c)
+		storePixel(b, row+3, a-d)
+	}
+}
+
+// transformAC3 is transformOne when only in[0], in[1] and in[4] are set
+func transformAC3(in []int16, b []byte, dst int) {
+	a := int(in[0]) + 4
+	c4 := transformMul2(int(in[4]))
+	d4 := transformMul1(int(in[4]))
+	c1 := transformMul2(int(in[1]))
+	d1 := transformMul1(int(in[1]))
+	for y, dc := range [4]int{a + d4, a + c4, a - c4, a - d4} {
+		row := dst + y*vp8BPS
+		storePixel(b, row, dc+d1)
+		storePixel(b, row+1, dc+c1)
+		storePixel(b, row+2, dc-c1)
+		storePixel(b, row+3, dc-d1)
+	}
+}
+
+// transformDC is transformOne when only in[0] is set
+func transformDC(in []int16, b []byte, dst int) {
+	dc := int(in[0]) + 4
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			storePixel(b, dst+y*vp8BPS+x, dc)
+		}
+	}
+}
+
+// transformUV adds the residuals of the four 4x4 blocks of a chroma plane
+func transformUV(in []int16, b []byte, dst int) {
+	transformOne(in, b, dst)
+	transformOne(in[16:], b, dst+4)
+	transformOne(in[32:], b, dst+4*vp8BPS)
+	transformOne(in[48:], b, dst+4*vp8BPS+4)
+}
+
+func transformDCUV(in []int16, b []byte, dst int) {
+	if in[0] != 0 {
+		transformDC(in, b, dst)
+	}
+	if in[16] != 0 {
+		transformDC(in[16:], b, dst+4)
+	}
+	if in[32] != 0 {
+		transformDC(in[32:], b, dst+4*vp8BPS)
+	}
+	if in[48] != 0 {
+		transformDC(in[48:], b, dst+4*vp8BPS+4)
+	}
+}
+
+// transformWHT computes the DC coefficients of the 16 luma blocks from the
+// second order block
+func transformWHT(in []int16, out []int16) {
+	var tmp [16]int
+	for i := 0; i < 4; i++ {
+		a0 := int(in[i]) + int(in[12+i])
+		a1 := int(in[4+i]) + int(in[8+i])
+		a2 := int(in[4+i]) - int(in[8+i])
+		a3 := int(in[i]) - int(in[12+i])
+		tmp[i] = a0 + a1
+		tmp[8+i] = a0 - a1
+		tmp[4+i] = a3 + a2
+		tmp[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := tmp[4*i] + 3
+		a0 := dc + tmp[4*i+3]
+		a1 := tmp[4*i+1] + tmp[4*i+2]
+		a2 := tmp[4*i+1] - tmp[4*i+2]
+		a3 := dc - tmp[4*i+3]
+		out[64*i] = int16((a0 + a1) >> 3)
+		out[64*i+16] = int16((a3 + a2) >> 3)
+		out[64*i+32] = int16((a0 - a1) >> 3)
+		out[64*i+48] = int16((a3 - a2) >> 3)
+	}
+}
+
+// Intra predictions. Each predictor fills the block at dst of the work
+// area from the row above and the column on its left.
+
+type vp8PredFunc func(b []byte, dst int)
+
+func fillBlock(b []byte, dst, size int, v byte) {
+	for y := 0; y < size; y++ {
+		row := b[dst+y*vp8BPS : dst+y*vp8BPS+size]
+		for x := range row {
+			row[x] = v
+		}
+	}
+}
+
+func trueMotion(b []byte, dst, size int) {
+	top := dst - vp8BPS
+	topLeft := int(b[top-1])
+	for y := 0; y < size; y++ {
+		row := dst + y*vp8BPS
+		left := int(b[row-1])
+		for x := 0; x < size; x++ {
+			b[row+x] = clip1(int(b[top+x]) + left - topLeft)
+		}
+	}
+}
+
+func verticalPred(b []byte, dst, size int) {
+	for y := 0; y < size; y++ {
+		copy(b[dst+y*vp8BPS:dst+y*vp8BPS+size], b[dst-vp8BPS:dst-vp8BPS+size])
+	}
+}
+
+func horizontalPred(b []byte, dst, size int) {
+	for y := 0; y < size; y++ {
+		row := b[dst+y*vp8BPS : dst+y*vp8BPS+size]
+		left := b[dst+y*vp8BPS-1]
+		for x := range row {
+			row[x] = left
+		}
+	}
+}
+
+// dcPred fills a size x size block with the rounded average of the top
+// and/or left samples, given log2 of their count
+func dcPred(b []byte, dst, size int, top, left bool, shift int) {
+	dc := 1 << (shift - 1)
+	for i := 0; i < size; i++ {
+		if top {
+			dc += int(b[dst-vp8BPS+i])
+		}
+		if left {
+			dc += int(b[dst+i*vp8BPS-1])
+		}
+	}
+	fillBlock(b, dst, size, byte(dc>>shift))
+}
+
+// vp8PredLuma16 is indexed by the 16x16 modes followed by the DC variants
+// for the edges of the image
+var vp8PredLuma16 = [7]vp8PredFunc{
+	func(b []byte, dst int) { dcPred(b, dst, 16, true, true, 5) },
+	func(b []byte, dst int) { trueMotion(b, dst, 16) },
+	func(b []byte, dst int) { verticalPred(b, dst, 16) },
+	func(b []byte, dst int) { horizontalPred(b, dst, 16) },
+	func(b []byte, dst int) { dcPred(b, dst, 16, false, true, 4) },
+	func(b []byte, dst int) { dcPred(b, dst, 16, true, false, 4) },
+	func(b []byte, dst int) { fillBlock(b, dst, 16, 0x80) },
+}
+
+// vp8PredChroma8 is vp8PredLuma16 for the 8x8 chroma blocks
+var vp8PredChroma8 = [7]vp8PredFunc{
+	func(b []byte, dst int) { dcPred(b, dst, 8, true, true, 4) },
+	func(b []byte, dst int) { trueMotion(b, dst, 8) },
+	func(b []byte, dst int) { verticalPred(b, dst, 8) },
+	func(b []byte, dst int) { horizontalPred(b, dst, 8) },
+	func(b []byte, dst int) { dcPred(b, dst, 8, false, true, 3) },
+	func(b []byte, dst int) { dcPred(b, dst, 8, true, false, 3) },
+	func(b []byte, dst int) { fillBlock(b, dst, 8, 0x80) },
+}
+
+func avg3(a, b, c int) byte {
+	return byte((a + 2*b + c + 2) >> 2)
+}
+
+func avg2(a, b int) byte {
+	return byte((a + b + 1) >> 1)
+}
+
+// pred4 gives the 4x4 predictors access to their neighbors: top(-1) is the
+// top-left sample, top(0..7) the row above and left(0..3) the left column
+type pred4 struct {
+	b   []byte
+	dst int
+}
+
+func (p pred4) top(i int) int        { return int(p.b[p.dst-vp8BPS+i]) }
+func (p pred4) left(i int) int       { return int(p.b[p.dst+i*vp8BPS-1]) }
+func (p pred4) set(x, y int, v byte) { p.b[p.dst+x+y*vp8BPS] = v }
+
+func ve4(b []byte, dst int) {
+	p := pred4{b, dst}
+	var vals [4]byte
+	for i := range vals {
+		vals[i] = avg3(p.top(i-1), p.top(i), p.top(i+1))
+	}
+	for y := 0; y < 4; y++ {
+		copy(b[dst+y*vp8BPS:], vals[:])
+	}
+}
+
+func he4(b []byte, dst int) {
+	p := pred4{b, dst}
+	a, bb, c, d, e := p.top(-1), p.left(0), p.left(1), p.left(2), p.left(3)
+	fillBlockRow(b, dst, avg3(a, bb, c))
+	fillBlockRow(b, dst+vp8BPS, avg3(bb, c, d))
+	fillBlockRow(b, dst+2*vp8BPS, avg3(c, d, e))
+	fillBlockRow(b, dst+3*vp8BPS, avg3(d, e, e))
+}
+
+func fillBlockRow(b []byte, i int, v byte) {
+	b[i], b[i+1], b[i+2], b[i+3] = v, v, v, v
+}
+
+func dc4(b []byte, dst int) {
+	p := pred4{b, dst}
+	dc := 4
+	for i := 0; i < 4; i++ {
+		dc += p.top(i) + p.left(i)
+	}
+	fillBlock(b, dst, 4, byte(dc>>3))
+}
+
+func rd4(b []byte, dst int) {
+	p := pred4{b, dst}
+	i, j, k, l := p.left(0), p.left(1), p.left(2), p.left(3)
+	x, a, bb, c, d := p.top(-1), p.top(0), p.top(1), p.top(2), p.top(3)
+	p.set(0, 3, avg3(j, k, l))
+	v := avg3(i, j, k)
+	p.set(1, 3, v)
+	p.set(0, 2, v)
+	v = avg3(x, i, j)
+	p.set(2, 3, v)
+	p.set(1, 2, v)
+	p.set(0, 1, v)
+	v = avg3(a, x, i)
+	p.set(3, 3, v)
+	p.set(2, 2, v)
+	p.set(1, 1, v)
+	p.set(0, 0, v)
+	v = avg3(bb, a, x)
+	p.set(3, 2, v)
+	p.set(2, 1, v)
+	p.set(1, 0, v)
+	v = avg3(c, bb, a)
+	p.set(3, 1, v)
+	p.set(2, 0, v)
+	p.set(3, 0, avg3(d, c, bb))
+}
+
+func ld4(b []byte, dst int) {
+	p := pred4{b, dst}
+	a, bb, c, d := p.top(0), p.top(1), p.top(2), p.top(3)
+	e, f, g, h := p.top(4), p.top(5), p.top(6), p.top(7)
+	p.set(0, 0, avg3(a, bb, c))
+	v := avg3(bb, c, d)
+	p.set(1, 0, v)
+	p.set(0, 1, v)
+	v = avg3(c, d, e)
+	p.set(2, 0, v)
+	p.set(1, 1, v)
+	p.set(0, 2, v)
+	v = avg3(d, e, f)
+	p.set(3, 0, v)
+	p.set(2, 1, v)
+	p.set(1, 2, v)
+	p.set(0, 3, v)
+	v = avg3(e, f, g)
+	p.set(3, 1, v)
+	p.set(2, 2, v)
+	p.set(1, 3, v)
+	v = avg3(f, g, h)
+	p.set(3, 2, v)
+	p.set(2, 3, v)
+	p.set(3, 3, avg3(g, h, h))
+}
+
+func vr4(b []byte, dst int) {
+	p := pred4{b, dst}
+	i, j, k := p.left(0), p.left(1), p.left(2)
+	x, a, bb, c, d := p.top(-1), p.top(0), p.top(1), p.top(2), p.top(3)
+	v := avg2(x, a)
+	p.set(0, 0, v)
+	p.set(1, 2, v)
+	v = avg2(a, bb)
+	p.set(1, 0, v)
+	p.set(2, 2, v)
+	v = avg2(bb, c)
+	p.set(2, 0, v)
+	p.set(3, 2, v)
+	p.set(3, 0, avg2(c, d))
+
+	p.set(0, 3, avg3(k, j, i))
+	p.set(0, 2, avg3(j, i, x))
+	v = avg3(i, x, a)
+	p.set(0, 1, v)
+	p.set(1, 3, v)
+	v = avg3(x, a, bb)
+	p.set(1, 1, v)
+	p.set(2, 3, v)
+	v = avg3(a, bb, c)
+	p.set(2, 1, v)
+	p.set(3, 3, v)
+	p.set(3, 1, avg3(bb, c, d))
+}
+
+func vl4(b []byte, dst int) {
+	p := pred4{b, dst}
+	a, bb, c, d := p.top(0), p.top(1), p.top(2), p.top(3)
+	e, f, g, h := p.top(4), p.top(5), p.top(6), p.top(7)
+	p.set(0, 0, avg2(a, bb))
+	v := avg2(bb, c)
+	p.set(1, 0, v)
+	p.set(0, 2, v)
+	v = avg2(c, d)
+	p.set(2, 0, v)
+	p.set(1, 2, v)
+	v = avg2(d, e)
+	p.set(3, 0, v)
+	p.set(2, 2, v)
+
+	p.set(0, 1, avg3(a, bb, c))
+	v = avg3(bb, c, d)
+	p.set(1, 1, v)
+	p.set(0, 3, v)
+	v = avg3(c, d, e)
+	p.set(2, 1, v)
+	p.set(1, 3, v)
+	v = avg3(d, e, f)
+	p.set(3, 1, v)
+	p.set(2, 3, v)
+	p.set(3, 2, avg3(e, f, g))
+	p.set(3, 3, avg3(f, g, h))
+}
+
+func hu4(b []byte, dst int) {
+	p := pred4{b, dst}
+	i, j, k, l := p.left(0), p.left(1), p.left(2), p.left(3)
+	p.set(0, 0, avg2(i, j))
+	v := avg2(j, k)
+	p.set(2, 0, v)
+	p.set(0, 1, v)
+	v = avg2(k, l)
+	p.set(2, 1, v)
+	p.set(0, 2, v)
+	p.set(1, 0, avg3(i, j, k))
+	v = avg3(j, k, l)
+	p.set(3, 0, v)
+	p.set(1, 1, v)
+	v = avg3(k, l, l)
+	p.set(3, 1, v)
+	p.set(1, 2, v)
+	for _, xy := range [][2]int{{3, 2}, {2, 2}, {0, 3}, {1, 3}, {2, 3}, {3, 3}} {
+		p.set(xy[0], xy[1], byte(l))
+	}
+}
+
+func hd4(b []byte, dst int) {
+	p := pred4{b, dst}
+	i, j, k, l := p.left(0), p.left(1), p.left(2), p.left(3)
+	x, a, bb, c := p.top(-1), p.top(0), p.top(1), p.top(2)
+	v := avg2(i, x)
+	p.set(0, 0, v)
+	p.set(2, 1, v)
+	v = avg2(j, i)
+	p.set(0, 1, v)
+	p.set(2, 2, v)
+	v = avg2(k, j)
+	p.set(0, 2, v)
+	p.set(2, 3, v)
+	p.set(0, 3, avg2(l, k))
+
+	p.set(3, 0, avg3(a, bb, c))
+	p.set(2, 0, avg3(x, a, bb))
+	v = avg3(i, x, a)
+	p.set(1, 0, v)
+	p.set(3, 1, v)
+	v = avg3(j, i, x)
+	p.set(1, 1, v)
+	p.set(3, 2, v)
+	v = avg3(k, j, i)
+	p.set(1, 2, v)
+	p.set(3, 3, v)
+	p.set(1, 3, avg3(l, k, j))
+}
+
+// vp8PredLuma4 is indexed by the 4x4 modes
+var vp8PredLuma4 = [10]vp8PredFunc{
+	dc4,
+	func(b []byte, dst int) { trueMotion(b, dst, 4) },
+	ve4, he4, rd4, vr4, ld4, vl4, hd4, hu4,
+}
+
+// Loop filters. The samples p3..p0 precede the edge at p and q0..q3 follow
+// it, step bytes apart.
+
+func abs0(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// doFilter2 changes the 2 samples next to the edge
+func doFilter2(b []byte, p, step int) {
+	p1, p0, q0, q1 := int(b[p-2*step]), int(b[p-step]), int(b[p]), int(b[p+step])
+	a := 3*(q0-p0) + sclip1(p1-q1)
+	a1 := sclip2((a + 4) >> 3)
+	a2 := sclip2((a + 3) >> 3)
+	b[p-step] = clip1(p0 + a2)
+	b[p] = clip1(q0 - a1)
+}
+
+// doFilter4 changes the 4 samples next to the edge
+func doFilter4(b []byte, p, step int) {
+	p1, p0, q0, q1 := int(b[p-2*step]), int(b[p-step]), int(b[p]), int(b[p+step])
+	a := 3 * (q0 - p0)
+	a1 := sclip2((a + 4) >> 3)
+	a2 := sclip2((a + 3) >> 3)
+	a3 := (a1 + 1) >> 1
+	b[p-2*step] = clip1(p1 + a3)
+	b[p-step] = clip1(p0 + a2)
+	b[p] = clip1(q0 - a1)
+	b[p+step] = clip1(q1 - a3)
+}
+
+// doFilter6 changes the 6 samples next to the edge
+func doFilter6(b []byte, p, step int) {
+	p2, p1, p0 := int(b[p-3*step]), int(b[p-2*step]), int(b[p-step])
+	q0, q1, q2 := int(b[p]), int(b[p+step]), int(b[p+2*step])
+	a := sclip1(3*(q0-p0) + sclip1(p1-q1))
+	a1 := (27*a + 63) >> 7
+	a2 := (18*a + 63) >> 7
+	a3 := (9*a + 63) >> 7
+	b[p-3*step] = clip1(p2 + a3)
+	b[p-2*step] = clip1(p1 + a2)
+	b[p-step] = clip1(p0 + a1)
+	b[p] = clip1(q0 - a1)
+	b[p+step] = clip1(q1 - a2)
+	b[p+2*step] = clip1(q2 - a3)
+}
+
+// hev reports a high edge variance
+func hev(b []byte, p, step, thresh int) bool {
+	p1, p0, q0, q1 := int(b[p-2*step]), int(b[p-step]), int(b[p]), int(b[p+step])
+	return abs0(p1-p0) > thresh || abs0(q1-q0) > thresh
+}
+
+func needsFilter(b []byte, p, step, t int) bool {
+	p1, p0, q0, q1 := int(b[p-2*step]), int(b[p-step]), int(b[p]), int(b[p+step])
+	return 4*abs0(p0-q0)+abs0(p1-q1) <= t
+}
+
+func needsFilter2(b []byte, p, step, t, it int) bool {
+	p3, p2, p1 := int(b[p-4*step]), int(b[p-3*step]), int(b[p-2*step])
+	p0, q0 := int(b[p-step]), int(b[p])
+	q1, q2, q3 := int(b[p+step]), int(b[p+2*step]), int(b[p+3*step])
+	if 4*abs0(p0-q0)+abs0(p1-q1) > t {
+		return false
+	}
+	return abs0(p3-p2) <= it && abs0(p2-p1) <= it && abs0(p1-p0) <= it &&
+		abs0(q3-q2) <= it && abs0(q2-q1) <= it && abs0(q1-q0) <= it
+}
+
+// simpleFilter16 filters the 16 samples of an edge, vstride apart, across
+// which samples are hstride apart
+func simpleFilter16(b []byte, p, hstride, vstride, thresh int) {
+	thresh2 := 2*thresh + 1
+	for i := 0; i < 16; i++ {
+		if needsFilter(b, p+i*vstride, hstride, thresh2) {
+			doFilter2(b, p+i*vstride, hstride)
+		}
+	}
+}
+
+// simpleFilter16i filters the three inner edges of a macroblock
+func simpleFilter16i(b []byte, p, hstride, vstride, thresh int) {
+	for k := 1; k <= 3; k++ {
+		simpleFilter16(b, p+4*k*hstride, hstride, vstride, thresh)
+	}
+}
+
+// filterLoop26 filters a macroblock edge
+func filterLoop26(b []byte, p, hstride, vstride, size, thresh, ithresh, hevThresh int) {
+	thresh2 := 2*thresh + 1
+	for ; size > 0; size-- {
+		if needsFilter2(b, p, hstride, thresh2, ithresh) {
+			if hev(b, p, hstride, hevThresh) {
+				doFilter2(b, p, hstride)
+			} else {
+				doFilter6(b, p, hstride)
+			}
+		}
+		p += vstride
+	}
+}
+
+// filterLoop24 filters an inner edge
+func filterLoop24(b []byte, p, hstride, vstride, size, thresh, ithresh, hevThresh int) {
+	thresh2 := 2*thresh + 1
+	for ; size > 0; size-- {
+		if needsFilter2(b, p, hstride, thresh2, ithresh) {
+			if hev(b, p, hstride, hevThresh) {
+				doFilter2(b, p, hstride)
+			} else {
+				doFilter4(b, p, hstride)
+			}
+		}
+		p += vstride
+	}
+}
+
+// filterLoop24i filters the inner edges of a block of size samples
+func filterLoop24i(b []byte, p, hstride, vstride, size, thresh, ithresh, hevThresh int) {
+	for k := 4; k < size; k += 4 {
+		filterLoop24(b, p+k*hstride, hstride, vstride, size, thresh, ithresh, hevThresh)
+	}
+}
+
+// Dithering of the chroma planes
+
+const (
+	vp8DitherAmpBits        = 7
+	vp8DitherAmpCenter      = 1 << vp8DitherAmpBits
+	vp8DitherDescale        = 4
+	vp8DitherDescaleRounder = 1 << (vp8DitherDescale - 1)
+	vp8RandomDitherFix      = 8
+)
+
+// vp8Random is libwebp's difference-based pseudo-random generator
+type vp8Random struct {
+	index1, index2 int
+	tab            [55]uint32
+}
+
+func newVP8Random() *vp8Random {
+	return &vp8Random{index1: 0, index2: 31, tab: vp8RandomTable}
+}
+
+// bits2 returns a number of numBits bits centered on 1<<(numBits-1), with
+// an amplitude of amp/256
+func (rg *vp8Random) bits2(numBits, amp int) int {
+	diff := (rg.tab[rg.index1] - rg.tab[rg.index2]) & 0x7fffffff
+	rg.tab[rg.index1] = diff
+	if rg.index1++; rg.index1 == len(rg.tab) {
+		rg.index1 = 0
+	}
+	if rg.index2++; rg.index2 == len(rg.tab) {
+		rg.index2 = 0
+	}
+	v := int(int32(diff<<1) >> (32 - numBits))
+	v = (v * amp) >> vp8RandomDitherFix
+	return v + 1<<(numBits-1)
+}
+
+// dither8x8 adds random noise of amplitude amp to an 8x8 block
+func (rg *vp8Random) dither8x8(b []byte, dst, stride, amp int) {
+	var dither [64]byte
+	for i := range dither {
+		dither[i] = byte(rg.bits2(vp8DitherAmpBits+1, amp))
+	}
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			delta := (int(dither[8*j+i]) - vp8DitherAmpCenter + vp8DitherDescaleRounder) >> vp8DitherDescale
+			b[dst+i] = clip8b(int(b[dst+i]) + delta)
+		}
+		dst += stride
+	}
+}
+
+// YUV to RGB conversion
+
+const (
+	yuvFix2  = 6
+	yuvMask2 = (256 << yuvFix2) - 1
+)
+
+func multHi(v, coeff int) int {
+	return (v * coeff) >> 8
+}
+
+func yuvClip8(v int) byte {
+	if v&^yuvMask2 == 0 {
+		return byte(v >> yuvFix2)
+	}
+	if v < 0 {
+		return 0
+	}
+	return 255
+}
+
+func yuvToRGB(y, u, v int, rgb []byte) {
+	rgb[0] = yuvClip8(multHi(y, 19077) + multHi(v, 26149) - 14234)
+	rgb[1] = yuvClip8(multHi(y, 19077) - multHi(u, 6419) - multHi(v, 13320) + 8708)
+	rgb[2] = yuvClip8(multHi(y, 19077) + multHi(u, 33050) - 17685)
+}
+
+// upsampleLinePair converts a pair of rows to RGB, interpolating the chroma
+// samples of the rows around them: topU/topV are the chroma rows above or
+// at the pair, curU/curV those below. bottomY may be nil for a single row.
+// Only the RGB components of the destination are set.
+func upsampleLinePair(topY, bottomY, topU, topV, curU, curV, topDst, bottomDst []byte, length int) {
+	tlU, tlV := int(topU[0]), int(topV[0])
+	lU, lV := int(curU[0]), int(curV[0])
+	yuvToRGB(int(topY[0]), (3*tlU+lU+2)>>2, (3*tlV+lV+2)>>2, topDst)
+	if bottomY != nil {
+		yuvToRGB(int(bottomY[0]), (3*lU+tlU+2)>>2, (3*lV+tlV+2)>>2, bottomDst)
+	}
+	lastPixelPair := (length - 1) >> 1
+	for x := 1; x <= lastPixelPair; x++ {
+		tU, tV := int(topU[x]), int(topV[x])
+		u, v := int(curU[x]), int(curV[x])
+		avgU := tlU + tU + lU + u + 8
+		avgV := tlV + tV + lV + v + 8
+		diag12U := (avgU + 2*(tU+lU)) >> 3
+		diag12V := (avgV + 2*(tV+lV)) >> 3
+		diag03U := (avgU + 2*(tlU+u)) >> 3
+		diag03V := (avgV + 2*(tlV+v)) >> 3
+		yuvToRGB(int(topY[2*x-1]), (diag12U+tlU)>>1, (diag12V+tlV)>>1, topDst[4*(2*x-1):])
+		yuvToRGB(int(topY[2*x]), (diag03U+tU)>>1, (diag03V+tV)>>1, topDst[4*(2*x):])
+		if bottomY != nil {
+			yuvToRGB(int(bottomY[2*x-1]), (diag03U+lU)>>1, (diag03V+lV)>>1, bottomDst[4*(2*x-1):])
+			yuvToRGB(int(bottomY[2*x]), (diag12U+u)>>1, (diag12V+v)>>1, bottomDst[4*(2*x):])
+		}
+		tlU, tlV = tU, tV
+		lU, lV = u, v
+	}
+	if length&1 == 0 {
+		yuvToRGB(int(topY[length-1]), (3*tlU+lU+2)>>2, (3*tlV+lV+2)>>2, topDst[4*(length-1):])
+		if bottomY != nil {
+			yuvToRGB(int(bottomY[length-1]), (3*lU+tlU+2)>>2, (3*lV+tlV+2)>>2, bottomDst[4*(length-1):])
+		}
+	}
+}
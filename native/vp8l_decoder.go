@@ -0,0 +1,1114 @@
+//go:build !cgo || purego
+
+package native
+
+// Pure Go decoder of the VP8L (lossless) bitstream, ported from libwebp's
+// src/dec/vp8l_dec.c so that it accepts, rejects and decodes the same
+// streams, down to the bit reader state at the end of the data
+
+const (
+	vp8lMagicByte          = 0x2f
+	vp8lImageSizeBits      = 14
+	vp8lVersionBits        = 3
+	vp8lMaxNumBitRead      = 24
+	vp8lNumLiteralCodes    = 256
+	vp8lNumLengthCodes     = 24
+	vp8lNumCodeLengthCodes = 19
+	vp8lMaxCacheBits       = 11
+	vp8lMaxCodeLength      = 15
+	vp8lDefaultCodeLength  = 8
+	vp8lCodeLengthLiterals = 16
+	vp8lCodeLengthRepeat   = 16
+	vp8lHuffmanTableBits   = 8
+	vp8lLengthsTableBits   = 7
+	vp8lColorCacheHashMul  = 0x1e35a7bd
+)
+
+// Indices of the five Huffman codes of a group
+const (
+	vp8lGreen = iota
+	vp8lRed
+	vp8lBlue
+	vp8lAlpha
+	vp8lDist
+)
+
+// Transform types
+const (
+	vp8lPredictorTransform = iota
+	vp8lCrossColorTransform
+	vp8lSubtractGreenTransform
+	vp8lColorIndexingTransform
+)
+
+var (
+	vp8lCodeLengthExtraBits     = [3]int{2, 3, 7}
+	vp8lCodeLengthRepeatOffsets = [3]int{3, 3, 11}
+)
+
+// vp8lBitReader is libwebp's VP8LBitReader: a 64-bit window refilled byte by
+// byte, which reports the end of the stream only once bits past it were used
+type vp8lBitReader struct {
+	buf    []byte
+	val    uint64
+	pos    int
+	bitPos int
+	eos    bool
+}
+
+func newVP8LBitReader(data []byte) vp8lBitReader {
+	br := vp8lBitReader{buf: data}
+	n := min(len(data), 8)
+	for i := 0; i < n; i++ {
+		br.val |= uint64(data[i]) << (8 * i)
+	}
+	br.pos = n
+	return br
+}
+
+func (br *vp8lBitReader) prefetch() uint32 {
+	return uint32(br.val >> (uint(br.bitPos) & 63))
+}
+
+func (br *vp8lBitReader) isEndOfStream() bool {
+	return br.eos || (br.pos == len(br.buf) && br.bitPos > 64)
+}
+
+func (br *vp8lBitReader) setEndOfStream() {
+	br.eos = true
+	br.bitPos = 0
+}
+
+func (br *vp8lBitReader) shiftBytes() {
+	for br.bitPos >= 8 && br.pos < len(br.buf) {
+		br.val >>= 8
+		br.val |= uint64(br.buf[br.pos]) << 56
+		br.pos++
+		br.bitPos -= 8
+	}
+	if br.isEndOfStream() {
+		br.setEndOfStream()
+	}
+}
+
+func (br *vp8lBitReader) fillBitWindow() {
+	if br.bitPos >= 32 {
+		br.shiftBytes()
+	}
+}
+
+func (br *vp8lBitReader) readBits(n int) uint32 {
+	if !br.eos && n <= vp8lMaxNumBitRead {
+		val := br.prefetch() & (1<<n - 1)
+		br.bitPos += n
+		br.shiftBytes()
+		return val
+	}
+	br.setEndOfStream()
+	return 0
+}
+
+// readSymbol decodes a symbol with a two-level lookup table
+func (br *vp8lBitReader) readSymbol(table []huffmanCode) int {
+	val := br.prefetch()
+	i := int(val & (1<<vp8lHuffmanTableBits - 1))
+	if nbits := int(table[i].bits) - vp8lHuffmanTableBits; nbits > 0 {
+		br.bitPos += vp8lHuffmanTableBits
+		val = br.prefetch()
+		i += int(table[i].value)
+		i += int(val & (1<<nbits - 1))
+	}
+	br.bitPos += int(table[i].bits)
+	return int(table[i].value)
+}
+
+// copyDistance reads a length or distance from its prefix symbol
+func (br *vp8lBitReader) copyDistance(symbol int) int {
+	if symbol < 4 {
+		return symbol + 1
+	}
+	extraBits := (symbol - 2) >> 1
+	offset := (2 + symbol&1) << extraBits
+	return offset + int(br.readBits(extraBits)) + 1
+}
+
+// huffmanCode is an entry of a lookup table: a symbol and its code length,
+// or in the root table, the size and offset of a second-level table
+type huffmanCode struct {
+	bits  uint8
+	value uint16
+}
+
+// newHuffmanTable builds the lookup table of a canonical code given its code
+// lengths, or returns nil if they do not form a complete code
+func newHuffmanTable(rootBits int, codeLengths []int) []huffmanCode {
+	size := buildHuffmanTable(nil, rootBits, codeLengths)
+	if size == 0 {
+		return nil
+	}
+	table := make([]huffmanCode, size)
+	buildHuffmanTable(table, rootBits, codeLengths)
+	return table
+}
+
+// buildHuffmanTable returns the size of the lookup table of a code, or 0 for
+// an invalid code. The table is filled in unless it is nil.
+func buildHuffmanTable(table []huffmanCode, rootBits int, codeLengths []int) int {
+	var count, offset [vp8lMaxCodeLength + 1]int
+	for _, length := range codeLengths {
+		if length > vp8lMaxCodeLength {
+			return 0
+		}
+		count[length]++
+	}
+	if count[0] == len(codeLengths) {
+		return 0
+	}
+	for length := 1; length < vp8lMaxCodeLength; length++ {
+		if count[length] > 1<<length {
+			return 0
+		}
+		offset[length+1] = offset[length] + count[length]
+	}
+
+	// Sort the symbols by code length, then by value
+	var sorted []uint16
+	if table != nil {
+		sorted = make([]uint16, len(codeLengths))
+	}
+	for symbol, length := range codeLengths {
+		if length > 0 {
+			if sorted != nil {
+				sorted[offset[length]] = uint16(symbol)
+			}
+			offset[length]++
+		}
+	}
+
+	totalSize := 1 << rootBits
+	if offset[vp8lMaxCodeLength] == 1 {
+		// A single symbol, read with zero bits
+		if table != nil {
+			replicateValue(table, 1, totalSize, huffmanCode{bits: 0, value: sorted[0]})
+		}
+		return totalSize
+	}
+
+	low := -1
+	mask := totalSize - 1
+	key := 0
+	numNodes, numOpen := 1, 1
+	tableSize := totalSize
+	tableOffset := 0
+	symbol := 0
+
+	for length, step := 1, 2; length <= rootBits; length, step = length+1, step<<1 {
+		numOpen <<= 1
+		numNodes += numOpen
+		numOpen -= count[length]
+		if numOpen < 0 {
+			return 0
+		}
+		if table == nil {
+			continue
+		}
+		for ; count[length] > 0; count[length]-- {
+			code := huffmanCode{bits: uint8(length), value: sorted[symbol]}
+			symbol++
+			replicateValue(table[key:], step, tableSize, code)
+			key = nextHuffmanKey(key, length)
+		}
+	}
+
+	for length, step := rootBits+1, 2; length <= vp8lMaxCodeLength; length, step = length+1, step<<1 {
+		numOpen <<= 1
+		numNodes += numOpen
+		numOpen -= count[length]
+		if numOpen < 0 {
+			return 0
+		}
+		for ; count[length] > 0; count[length]-- {
+			if key&mask != low {
+				if table != nil {
+					tableOffset += tableSize
+				}
+				tableBits := nextTableBitSize(count[:], length, rootBits)
+				tableSize = 1 << tableBits
+				totalSize += tableSize
+				low = key & mask
+				if table != nil {
+					table[low] = huffmanCode{bits: uint8(tableBits + rootBits), value: uint16(tableOffset - low)}
+				}
+			}
+			if table != nil {
+				code := huffmanCode{bits: uint8(length - rootBits), value: sorted[symbol]}
+				symbol++
+				replicateValue(table[tableOffset+key>>rootBits:], step, tableSize, code)
+			}
+			key = nextHuffmanKey(key, length)
+		}
+	}
+
+	if numNodes != 2*offset[vp8lMaxCodeLength]-1 {
+		return 0
+	}
+	return totalSize
+}
+
+// nextHuffmanKey increments the bit-reversed key of a code of the given
+// length
+func nextHuffmanKey(key, length int) int {
+	step := 1 << (length - 1)
+	for key&step != 0 {
+		step >>= 1
+	}
+	if step == 0 {
+		return key
+	}
+	return key&(step-1) + step
+}
+
+// replicateValue stores code at table[0], table[step], ... below end
+func replicateValue(table []huffmanCode, step, end int, code huffmanCode) {
+	for {
+		end -= step
+		table[end] = code
+		if end <= 0 {
+			return
+		}
+	}
+}
+
+// nextTableBitSize returns the key length of the second-level table that
+// starts with codes of the given length
+func nextTableBitSize(count []int, length, rootBits int) int {
+	left := 1 << (length - rootBits)
+	for length < vp8lMaxCodeLength {
+		left -= count[length]
+		if left <= 0 {
+			break
+		}
+		length++
+		left <<= 1
+	}
+	return length - rootBits
+}
+
+// htreeGroup holds the five codes used for a tile of the image
+type htreeGroup struct {
+	htrees [5][]huffmanCode
+	// isTrivialLiteral is set when red, blue and alpha have a single symbol,
+	// which literalARB holds; isTrivialCode also has green in literalARB
+	isTrivialLiteral bool
+	isTrivialCode    bool
+	literalARB       uint32
+}
+
+// vp8lColorCache holds recently used colors, indexed by a hash
+type vp8lColorCache struct {
+	colors    []uint32
+	hashShift uint
+}
+
+func (c *vp8lColorCache) insert(argb uint32) {
+	c.colors[(argb*vp8lColorCacheHashMul)>>c.hashShift] = argb
+}
+
+// vp8lMetadata holds the entropy codes of an image
+type vp8lMetadata struct {
+	colorCacheSize int
+	colorCache     vp8lColorCache
+	huffmanImage   []uint32
+	huffmanBits    int
+	huffmanXSize   int
+	huffmanMask    int
+	groups         []htreeGroup
+}
+
+func (hdr *vp8lMetadata) groupForPos(x, y int) *htreeGroup {
+	if hdr.huffmanBits == 0 {
+		return &hdr.groups[0]
+	}
+	return &hdr.groups[hdr.huffmanImage[hdr.huffmanXSize*(y>>hdr.huffmanBits)+x>>hdr.huffmanBits]]
+}
+
+// vp8lTransform is an image transform, undone in reverse reading order
+type vp8lTransform struct {
+	kind  int
+	bits  int
+	xsize int
+	ysize int
+	data  []uint32
+}
+
+// vp8lDecoder decodes a VP8L bitstream, or the VP8L-compressed alpha plane
+// of a lossy image
+type vp8lDecoder struct {
+	br             vp8lBitReader
+	width          int // width of the coded image; less than the picture's when pixels are packed
+	height         int
+	transforms     []vp8lTransform
+	transformsSeen int
+	hdr            vp8lMetadata
+}
+
+func subSampleSize(size, bits int) int {
+	return (size + 1<<bits - 1) >> bits
+}
+
+// decodeVP8L decodes a VP8L bitstream to RGBA. Every failure of libwebp's
+// lossless decoder is a bitstream error.
+func decodeVP8L(data []byte) (pix []byte, width, height int, status VP8Status) {
+	d := &vp8lDecoder{br: newVP8LBitReader(data)}
+	width, height, _, ok := d.readImageInfo()
+	if !ok {
+		return nil, 0, 0, VP8StatusBitstreamError
+	}
+	if _, ok := d.decodeImageStream(width, height, true); !ok {
+		return nil, 0, 0, VP8StatusBitstreamError
+	}
+
+	argb := make([]uint32, d.width*d.height)
+	if _, ok := d.decodeImageData(&d.hdr, argb, d.width, d.height); !ok {
+		return nil, 0, 0, VP8StatusBitstreamError
+	}
+	argb = d.inverseTransforms(argb)
+
+	pix = make([]byte, 4*width*height)
+	for i, c := range argb {
+		pix[4*i] = byte(c >> 16)
+		pix[4*i+1] = byte(c >> 8)
+		pix[4*i+2] = byte(c)
+		pix[4*i+3] = byte(c >> 24)
+	}
+	return pix, width, height, VP8StatusOK
+}
+
+func (d *vp8lDecoder) readImageInfo() (width, height int, hasAlpha, ok bool) {
+	br := &d.br
+	if br.readBits(8) != vp8lMagicByte {
+		return 0, 0, false, false
+	}
+	width = int(br.readBits(vp8lImageSizeBits)) + 1
+	height = int(br.readBits(vp8lImageSizeBits)) + 1
+	hasAlpha = br.readBits(1) != 0
+	if br.readBits(vp8lVersionBits) != 0 {
+		return 0, 0, false, false
+	}
+	return width, height, hasAlpha, !br.eos
+}
+
+// decodeImageStream reads an image of xsize x ysize. At level 0 it reads the
+// transforms and the entropy codes of the main image into d and returns no
+// pixels; sub-images (transform data, meta codes) are decoded and returned.
+func (d *vp8lDecoder) decodeImageStream(xsize, ysize int, level0 bool) ([]uint32, bool) {
+	br := &d.br
+	transformXSize := xsize
+	ok := true
+
+	if level0 {
+		for ok && br.readBits(1) != 0 {
+			ok = d.readTransform(&transformXSize, ysize)
+		}
+	}
+
+	colorCacheBits := 0
+	if ok && br.readBits(1) != 0 {
+		colorCacheBits = int(br.readBits(4))
+		if colorCacheBits < 1 || colorCacheBits > vp8lMaxCacheBits {
+			return nil, false
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	hdr, ok := d.readHuffmanCodes(transformXSize, ysize, colorCacheBits, level0)
+	if !ok {
+		return nil, false
+	}
+	if colorCacheBits > 0 {
+		hdr.colorCacheSize = 1 << colorCacheBits
+		hdr.colorCache = vp8lColorCache{
+			colors:    make([]uint32, 1<<colorCacheBits),
+			hashShift: uint(32 - colorCacheBits),
+		}
+	}
+	hdr.huffmanXSize = subSampleSize(transformXSize, hdr.huffmanBits)
+	hdr.huffmanMask = ^0
+	if hdr.huffmanBits != 0 {
+		hdr.huffmanMask = 1<<hdr.huffmanBits - 1
+	}
+	d.width, d.height = transformXSize, ysize
+
+	if level0 {
+		d.hdr = hdr
+		return nil, true
+	}
+
+	data := make([]uint32, transformXSize*ysize)
+	if _, ok := d.decodeImageData(&hdr, data, transformXSize, ysize); !ok || br.eos {
+		return nil, false
+	}
+	return data, true
+}
+
+func (d *vp8lDecoder) readTransform(xsize *int, ysize int) bool {
+	br := &d.br
+	kind := int(br.readBits(2))
+	if d.transformsSeen&(1<<kind) != 0 {
+		return false
+	}
+	d.transformsSeen |= 1 << kind
+
+	t := vp8lTransform{kind: kind, xsize: *xsize, ysize: ysize}
+	ok := true
+	switch kind {
+	case vp8lPredictorTransform, vp8lCrossColorTransform:
+		t.bits = int(br.readBits(3)) + 2
+		t.data, ok = d.decodeImageStream(subSampleSize(t.xsize, t.bits), subSampleSize(t.ysize, t.bits), false)
+	case vp8lColorIndexingTransform:
+		numColors := int(br.readBits(8)) + 1
+		switch {
+		case numColors > 16:
+			t.bits = 0
+		case numColors > 4:
+			t.bits = 1
+		case numColors > 2:
+			t.bits = 2
+		default:
+			t.bits = 3
+		}
+		*xsize = subSampleSize(t.xsize, t.bits)
+		t.data, ok = d.decodeImageStream(numColors, 1, false)
+		if ok {
+			t.data = expandColorMap(t.data, t.bits)
+		}
+	}
+	d.transforms = append(d.transforms, t)
+	return ok
+}
+
+// expandColorMap undoes the delta coding of a palette and pads it with
+// transparent black to every index the packed pixels can hold
+func expandColorMap(colors []uint32, bits int) []uint32 {
+	expanded := make([]uint32, 1<<(8>>bits))
+	expanded[0] = colors[0]
+	for i := 1; i < len(colors); i++ {
+		expanded[i] = addPixels(colors[i], expanded[i-1])
+	}
+	return expanded
+}
+
+func (d *vp8lDecoder) readHuffmanCodes(xsize, ysize, colorCacheBits int, allowRecursion bool) (vp8lMetadata, bool) {
+	br := &d.br
+	var hdr vp8lMetadata
+	numGroups, numGroupsMax := 1, 1
+	var mapping []int
+
+	if allowRecursion && br.readBits(1) != 0 {
+		precision := int(br.readBits(3)) + 2
+		image, ok := d.decodeImageStream(subSampleSize(xsize, precision), subSampleSize(ysize, precision), false)
+		if !ok {
+			return hdr, false
+		}
+		hdr.huffmanBits = precision
+		for i, pixel := range image {
+			// The group index is stored in the red and green bytes
+			group := int(pixel>>8) & 0xffff
+			image[i] = uint32(group)
+			if group >= numGroupsMax {
+				numGroupsMax = group + 1
+			}
+		}
+		// Large indices are remapped to the groups actually used
+		if numGroupsMax > 1000 || numGroupsMax > xsize*ysize {
+			mapping = make([]int, numGroupsMax)
+			for i := range mapping {
+				mapping[i] = -1
+			}
+			numGroups = 0
+			for i, group := range image {
+				if mapping[group] == -1 {
+					mapping[group] = numGroups
+					numGroups++
+				}
+				image[i] = uint32(mapping[group])
+			}
+		} else {
+			numGroups = numGroupsMax
+		}
+		hdr.huffmanImage = image
+	}
+
+	if br.eos {
+		return hdr, false
+	}
+
+	maxAlphabetSize := vp8lAlphabetSize[vp8lGreen]
+	if colorCacheBits > 0 {
+		maxAlphabetSize += 1 << colorCacheBits
+	}
+	codeLengths := make([]int, maxAlphabetSize)
+	hdr.groups = make([]htreeGroup, numGroups)
+
+	for i := 0; i < numGroupsMax; i++ {
+		// The codes of unused groups are checked but not kept
+		if mapping != nil && mapping[i] == -1 {
+			for j := range vp8lAlphabetSize {
+				if d.readHuffmanCode(alphabetSize(j, colorCacheBits), codeLengths) == nil {
+					return hdr, false
+				}
+			}
+			continue
+		}
+
+		group := &hdr.groups[i]
+		if mapping != nil {
+			group = &hdr.groups[mapping[i]]
+		}
+		isTrivialLiteral := true
+		totalBits := 0
+		for j := range vp8lAlphabetSize {
+			table := d.readHuffmanCode(alphabetSize(j, colorCacheBits), codeLengths)
+			if table == nil {
+				return hdr, false
+			}
+			group.htrees[j] = table
+			if isTrivialLiteral && (j == vp8lRed || j == vp8lBlue || j == vp8lAlpha) {
+				isTrivialLiteral = table[0].bits == 0
+			}
+			totalBits += int(table[0].bits)
+		}
+		group.isTrivialLiteral = isTrivialLiteral
+		if isTrivialLiteral {
+			red := uint32(group.htrees[vp8lRed][0].value)
+			blue := uint32(group.htrees[vp8lBlue][0].value)
+			alpha := uint32(group.htrees[vp8lAlpha][0].value)
+			group.literalARB = alpha<<24 | red<<16 | blue
+			if green := group.htrees[vp8lGreen][0].value; totalBits == 0 && green < vp8lNumLiteralCodes {
+				group.isTrivialCode = true
+				group.literalARB |= uint32(green) << 8
+			}
+		}
+	}
+	return hdr, true
+}
+
+func alphabetSize(code, colorCacheBits int) int {
+	size := vp8lAlphabetSize[code]
+	if code == vp8lGreen && colorCacheBits > 0 {
+		size += 1 << colorCacheBits
+	}
+	return size
+}
+
+// readHuffmanCode reads a code and returns its lookup table, or nil if the
+// code is invalid. codeLengths is scratch space of at least alphabetSize.
+func (d *vp8lDecoder) readHuffmanCode(alphabetSize int, codeLengths []int) []huffmanCode {
+	br := &d.br
+	codeLengths = codeLengths[:alphabetSize]
+	clear(codeLengths)
+
+	ok := true
+	if br.readBits(1) != 0 {
+		// One or two symbols with 1-bit codes; symbols outside the alphabet
+		// are dropped
+		numSymbols := int(br.readBits(1)) + 1
+		firstSymbolBits := 1
+		if br.readBits(1) != 0 {
+			firstSymbolBits = 8
+		}
+		if symbol := int(br.readBits(firstSymbolBits)); symbol < alphabetSize {
+			codeLengths[symbol] = 1
+		}
+		if numSymbols == 2 {
+			if symbol := int(br.readBits(8)); symbol < alphabetSize {
+				codeLengths[symbol] = 1
+			}
+		}
+	} else {
+		var codeLengthCodeLengths [vp8lNumCodeLengthCodes]int
+		numCodes := int(br.readBits(4)) + 4
+		for i := 0; i < numCodes; i++ {
+			codeLengthCodeLengths[vp8lCodeLengthOrder[i]] = int(br.readBits(3))
+		}
+		ok = d.readHuffmanCodeLengths(codeLengthCodeLengths[:], codeLengths)
+	}
+
+	if !ok || br.eos {
+		return nil
+	}
+	return newHuffmanTable(vp8lHuffmanTableBits, codeLengths)
+}
+
+// readHuffmanCodeLengths reads the code lengths of len(codeLengths) symbols,
+// themselves Huffman coded
+func (d *vp8lDecoder) readHuffmanCodeLengths(codeLengthCodeLengths, codeLengths []int) bool {
+	br := &d.br
+	table := newHuffmanTable(vp8lLengthsTableBits, codeLengthCodeLengths)
+	if table == nil {
+		return false
+	}
+
+	numSymbols := len(codeLengths)
+	maxSymbol := numSymbols
+	if br.readBits(1) != 0 {
+		lengthBits := 2 + 2*int(br.readBits(3))
+		maxSymbol = 2 + int(br.readBits(lengthBits))
+		if maxSymbol > numSymbols {
+			return false
+		}
+	}
+
+	prevCodeLen := vp8lDefaultCodeLength
+	for symbol := 0; symbol < numSymbols; {
+		if maxSymbol == 0 {
+			break
+		}
+		maxSymbol--
+		br.fillBitWindow()
+		p := table[br.prefetch()&(1<<vp8lLengthsTableBits-1)]
+		br.bitPos += int(p.bits)
+		codeLen := int(p.value)
+		if codeLen < vp8lCodeLengthLiterals {
+			codeLengths[symbol] = codeLen
+			symbol++
+			if codeLen != 0 {
+				prevCodeLen = codeLen
+			}
+			continue
+		}
+
+		slot := codeLen - vp8lCodeLengthLiterals
+		repeat := int(br.readBits(vp8lCodeLengthExtraBits[slot])) + vp8lCodeLengthRepeatOffsets[slot]
+		if symbol+repeat > numSymbols {
+			return false
+		}
+		length := 0
+		if codeLen == vp8lCodeLengthRepeat {
+			length = prevCodeLen
+		}
+		for ; repeat > 0; repeat-- {
+			codeLengths[symbol] = length
+			symbol++
+		}
+	}
+	return true
+}
+
+// decodeImageData decodes the entropy-coded pixels of a width x height image.
+// On failure it returns the index of the pixel whose decoding failed, which
+// is 0 if the stream was already exhausted by the headers.
+func (d *vp8lDecoder) decodeImageData(hdr *vp8lMetadata, data []uint32, width, height int) (int, bool) {
+	br := &d.br
+	end := width * height
+	src, lastCached := 0, 0
+	row, col := 0, 0
+	lenCodeLimit := vp8lNumLiteralCodes + vp8lNumLengthCodes
+	colorCacheLimit := lenCodeLimit + hdr.colorCacheSize
+	mask := hdr.huffmanMask
+	exhausted := br.isEndOfStream()
+
+	insertPending := func() {
+		for ; lastCached < src; lastCached++ {
+			hdr.colorCache.insert(data[lastCached])
+		}
+	}
+
+	var group *htreeGroup
+	if src < end {
+		group = hdr.groupForPos(col, row)
+	}
+
+loop:
+	for src < end {
+		if col&mask == 0 {
+			group = hdr.groupForPos(col, row)
+		}
+
+		if group.isTrivialCode {
+			data[src] = group.literalARB
+		} else {
+			br.fillBitWindow()
+			code := br.readSymbol(group.htrees[vp8lGreen])
+			if br.isEndOfStream() {
+				break
+			}
+			switch {
+			case code < vp8lNumLiteralCodes:
+				if group.isTrivialLiteral {
+					data[src] = group.literalARB | uint32(code)<<8
+				} else {
+					red := br.readSymbol(group.htrees[vp8lRed])
+					br.fillBitWindow()
+					blue := br.readSymbol(group.htrees[vp8lBlue])
+					alpha := br.readSymbol(group.htrees[vp8lAlpha])
+					if br.isEndOfStream() {
+						break loop
+					}
+					data[src] = uint32(alpha)<<24 | uint32(red)<<16 | uint32(code)<<8 | uint32(blue)
+				}
+			case code < lenCodeLimit:
+				length := br.copyDistance(code - vp8lNumLiteralCodes)
+				distSymbol := br.readSymbol(group.htrees[vp8lDist])
+				br.fillBitWindow()
+				dist := planeCodeToDistance(width, br.copyDistance(distSymbol))
+				if br.isEndOfStream() {
+					break loop
+				}
+				if src < dist || end-src < length {
+					return src, false
+				}
+				for i := src; i < src+length; i++ {
+					data[i] = data[i-dist]
+				}
+				src += length
+				col += length
+				for col >= width {
+					col -= width
+					row++
+				}
+				if col&mask != 0 {
+					group = hdr.groupForPos(col, row)
+				}
+				if hdr.colorCacheSize > 0 {
+					insertPending()
+				}
+				continue
+			case code < colorCacheLimit:
+				insertPending()
+				data[src] = hdr.colorCache.colors[code-lenCodeLimit]
+			default:
+				return src, false
+			}
+		}
+
+		src++
+		col++
+		if col >= width {
+			col = 0
+			row++
+			if hdr.colorCacheSize > 0 {
+				insertPending()
+			}
+		}
+	}
+
+	br.eos = br.isEndOfStream()
+	if br.eos {
+		if exhausted {
+			return 0, false
+		}
+		return src, false
+	}
+	return end, true
+}
+
+// decodeAlphaData is decodeImageData for an alpha plane made of palette
+// indices only, which it stores one byte per pixel. Unlike the ARGB path, a
+// literal read at the end of the stream is kept, and the plane is valid if
+// it was the last pixel.
+func (d *vp8lDecoder) decodeAlphaData(hdr *vp8lMetadata, data []byte, width, height int) (int, bool) {
+	br := &d.br
+	end := width * height
+	pos, row, col := 0, 0, 0
+	mask := hdr.huffmanMask
+	failedAt := 0
+	ok := true
+
+	var group *htreeGroup
+	if pos < end {
+		group = hdr.groupForPos(col, row)
+	}
+
+	for !br.eos && pos < end {
+		if col&mask == 0 {
+			group = hdr.groupForPos(col, row)
+		}
+		failedAt = pos
+		br.fillBitWindow()
+		code := br.readSymbol(group.htrees[vp8lGreen])
+		if code < vp8lNumLiteralCodes {
+			data[pos] = byte(code)
+			pos++
+			col++
+			if col >= width {
+				col = 0
+				row++
+			}
+		} else if code < vp8lNumLiteralCodes+vp8lNumLengthCodes {
+			length := br.copyDistance(code - vp8lNumLiteralCodes)
+			distSymbol := br.readSymbol(group.htrees[vp8lDist])
+			br.fillBitWindow()
+			dist := planeCodeToDistance(width, br.copyDistance(distSymbol))
+			if pos < dist || end-pos < length {
+				ok = false
+				break
+			}
+			for i := pos; i < pos+length; i++ {
+				data[i] = data[i-dist]
+			}
+			pos += length
+			col += length
+			for col >= width {
+				col -= width
+				row++
+			}
+			if pos < end && col&mask != 0 {
+				group = hdr.groupForPos(col, row)
+			}
+		} else {
+			ok = false
+			break
+		}
+		br.eos = br.isEndOfStream()
+	}
+
+	br.eos = br.isEndOfStream()
+	if !ok || (br.eos && pos < end) {
+		return failedAt, false
+	}
+	return end, true
+}
+
+// planeCodeToDistance converts a distance code to a pixel offset; the first
+// 120 codes are short 2D offsets
+func planeCodeToDistance(xsize, planeCode int) int {
+	if planeCode > len(vp8lCodeToPlane) {
+		return planeCode - len(vp8lCodeToPlane)
+	}
+	distCode := int(vp8lCodeToPlane[planeCode-1])
+	yoffset := distCode >> 4
+	xoffset := 8 - distCode&0xf
+	if dist := yoffset*xsize + xoffset; dist >= 1 {
+		return dist
+	}
+	return 1
+}
+
+// inverseTransforms undoes the transforms of the decoded pixels of the main
+// image, returning pixels of the full picture width
+func (d *vp8lDecoder) inverseTransforms(pixels []uint32) []uint32 {
+	for n := len(d.transforms) - 1; n >= 0; n-- {
+		pixels = d.transforms[n].inverse(pixels)
+	}
+	return pixels
+}
+
+func (t *vp8lTransform) inverse(in []uint32) []uint32 {
+	switch t.kind {
+	case vp8lSubtractGreenTransform:
+		for i, argb := range in {
+			green := (argb >> 8) & 0xff
+			redBlue := (argb&0x00ff00ff + (green<<16 | green)) & 0x00ff00ff
+			in[i] = argb&0xff00ff00 | redBlue
+		}
+	case vp8lPredictorTransform:
+		t.inversePredictor(in)
+	case vp8lCrossColorTransform:
+		t.inverseCrossColor(in)
+	case vp8lColorIndexingTransform:
+		return t.inverseColorIndexing(in)
+	}
+	return in
+}
+
+func (t *vp8lTransform) inversePredictor(pix []uint32) {
+	width := t.xsize
+	// The first row predicts from the left, its first pixel from black
+	pix[0] = addPixels(pix[0], argbBlack)
+	for x := 1; x < width; x++ {
+		pix[x] = addPixels(pix[x], pix[x-1])
+	}
+
+	tileWidth := 1 << t.bits
+	tilesPerRow := subSampleSize(width, t.bits)
+	for y := 1; y < t.ysize; y++ {
+		row := pix[y*width : (y+1)*width]
+		top := pix[(y-1)*width:]
+		modes := t.data[(y>>t.bits)*tilesPerRow:]
+
+		// The first pixel of other rows predicts from the top
+		row[0] = addPixels(row[0], top[0])
+		for x := 1; x < width; {
+			mode := (modes[x>>t.bits] >> 8) & 0xf
+			xEnd := min(x&^(tileWidth-1)+tileWidth, width)
+			for ; x < xEnd; x++ {
+				row[x] = addPixels(row[x], vp8lPredict(mode, row[x-1], top[x-1:]))
+			}
+		}
+	}
+}
+
+const argbBlack = 0xff000000
+
+// vp8lPredict returns the prediction of a pixel from its left neighbor and
+// the row above, top[0] being above-left
+func vp8lPredict(mode uint32, left uint32, top []uint32) uint32 {
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top[1]
+	case 3:
+		return top[2]
+	case 4:
+		return top[0]
+	case 5:
+		return average2(average2(left, top[2]), top[1])
+	case 6:
+		return average2(left, top[0])
+	case 7:
+		return average2(left, top[1])
+	case 8:
+		return average2(top[0], top[1])
+	case 9:
+		return average2(top[1], top[2])
+	case 10:
+		return average2(average2(left, top[0]), average2(top[1], top[2]))
+	case 11:
+		return selectPredictor(top[1], left, top[0])
+	case 12:
+		return clampedAddSubtractFull(left, top[1], top[0])
+	case 13:
+		return clampedAddSubtractHalf(left, top[1], top[0])
+	}
+	return argbBlack
+}
+
+func addPixels(a, b uint32) uint32 {
+	alphaAndGreen := (a & 0xff00ff00) + (b & 0xff00ff00)
+	redAndBlue := (a & 0x00ff00ff) + (b & 0x00ff00ff)
+	return alphaAndGreen&0xff00ff00 | redAndBlue&0x00ff00ff
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+func clip255(a int) uint32 {
+	if a < 0 {
+		return 0
+	}
+	if a > 255 {
+		return 255
+	}
+	return uint32(a)
+}
+
+func clampedAddSubtractFull(c0, c1, c2 uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(c0>>shift&0xff) + int(c1>>shift&0xff) - int(c2>>shift&0xff)
+		out |= clip255(v) << shift
+	}
+	return out
+}
+
+func clampedAddSubtractHalf(c0, c1, c2 uint32) uint32 {
+	ave := average2(c0, c1)
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		a := int(ave >> shift & 0xff)
+		b := int(c2 >> shift & 0xff)
+		out |= clip255(a+(a-b)/2) << shift
+	}
+	return out
+}
+
+func selectPredictor(a, b, c uint32) uint32 {
+	paMinusPb := 0
+	for shift := 0; shift < 32; shift += 8 {
+		ac := int(a >> shift & 0xff)
+		bc := int(b >> shift & 0xff)
+		cc := int(c >> shift & 0xff)
+		paMinusPb += abs(bc-cc) - abs(ac-cc)
+	}
+	if paMinusPb <= 0 {
+		return a
+	}
+	return b
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (t *vp8lTransform) inverseCrossColor(pix []uint32) {
+	width := t.xsize
+	tilesPerRow := subSampleSize(width, t.bits)
+	for y := 0; y < t.ysize; y++ {
+		row := pix[y*width : (y+1)*width]
+		codes := t.data[(y>>t.bits)*tilesPerRow:]
+		for x, argb := range row {
+			code := codes[x>>t.bits]
+			greenToRed := int8(code)
+			greenToBlue := int8(code >> 8)
+			redToBlue := int8(code >> 16)
+
+			green := int8(argb >> 8)
+			newRed := int(argb>>16) & 0xff
+			newBlue := int(argb) & 0xff
+			newRed += colorTransformDelta(greenToRed, green)
+			newRed &= 0xff
+			newBlue += colorTransformDelta(greenToBlue, green)
+			newBlue += colorTransformDelta(redToBlue, int8(newRed))
+			newBlue &= 0xff
+			row[x] = argb&0xff00ff00 | uint32(newRed)<<16 | uint32(newBlue)
+		}
+	}
+}
+
+func colorTransformDelta(colorPred, color int8) int {
+	return (int(colorPred) * int(color)) >> 5
+}
+
+// inverseColorIndexing maps palette indices, possibly packed several per
+// pixel, to colors
+func (t *vp8lTransform) inverseColorIndexing(in []uint32) []uint32 {
+	width := t.xsize
+	out := make([]uint32, width*t.ysize)
+	bitsPerPixel := 8 >> t.bits
+	packedWidth := subSampleSize(width, t.bits)
+	countMask := 1<<t.bits - 1
+	bitMask := uint32(1)<<bitsPerPixel - 1
+	for y := 0; y < t.ysize; y++ {
+		src := in[y*packedWidth:]
+		dst := out[y*width : (y+1)*width]
+		var packed uint32
+		for x := range dst {
+			if x&countMask == 0 {
+				packed = (src[x>>t.bits] >> 8) & 0xff
+			}
+			dst[x] = t.data[packed&bitMask]
+			packed >>= bitsPerPixel
+		}
+	}
+	return out
+}
+
+// inverseColorIndexingAlpha maps packed palette indices of an alpha plane to
+// the green component of their colors
+func (t *vp8lTransform) inverseColorIndexingAlpha(in []byte, out []byte) {
+	width := t.xsize
+	bitsPerPixel := 8 >> t.bits
+	packedWidth := subSampleSize(width, t.bits)
+	countMask := 1<<t.bits - 1
+	bitMask := uint32(1)<<bitsPerPixel - 1
+	for y := 0; y < t.ysize; y++ {
+		src := in[y*packedWidth:]
+		dst := out[y*width : (y+1)*width]
+		var packed uint32
+		for x := range dst {
+			if x&countMask == 0 {
+				packed = uint32(src[x>>t.bits])
+			}
+			dst[x] = byte(t.data[packed&bitMask] >> 8)
+			packed >>= bitsPerPixel
+		}
+	}
+}
@@ -0,0 +1,177 @@
+//go:build cgo && !purego
+
+package native
+
+/*
+#cgo pkg-config: libwebp
+#include <stdlib.h>
+#include <string.h>
+#include <webp/decode.h>
+
+// DecodedImage holds decoded WebP image data
+typedef struct {
+    uint8_t* data;
+    int width;
+    int height;
+    int has_alpha;
+    int stride;
+} DecodedImage;
+
+// decode_webp_advanced decodes WebP with maximum quality settings.
+// On failure it returns NULL and stores the VP8StatusCode in *status.
+DecodedImage* decode_webp_advanced(const uint8_t* webp_data, size_t webp_size, int* status_out) {
+    *status_out = VP8_STATUS_INVALID_PARAM;
+    if (!webp_data || webp_size == 0) {
+        return NULL;
+    }
+
+    // Initialize decoder configuration
+    WebPDecoderConfig config;
+    if (!WebPInitDecoderConfig(&config)) {
+        return NULL;
+    }
+
+    // Get image features first
+    WebPBitstreamFeatures features;
+    VP8StatusCode status = WebPGetFeatures(webp_data, webp_size, &features);
+    *status_out = status;
+    if (status != VP8_STATUS_OK) {
+        return NULL;
+    }
+
+    // Configure decoder for maximum quality
+    config.options.bypass_filtering = 0;           // Apply deblocking filters
+    config.options.no_fancy_upsampling = 0;        // Use high-quality upsampling
+    config.options.use_threads = 1;                // Enable multi-threading
+    config.options.dithering_strength = 100;       // Maximum dithering
+    config.options.flip = 0;                       // Don't flip
+    config.options.alpha_dithering_strength = 100; // Maximum alpha dithering
+    config.options.use_scaling = 0;                // No scaling
+    config.options.scaled_width = 0;
+    config.options.scaled_height = 0;
+
+    // Configure output format - ALWAYS use RGBA for consistency and correctness
+    // This prevents buffer mismatch issues and simplifies code
+    config.output.colorspace = MODE_RGBA;
+
+    // Decode with advanced configuration
+    status = WebPDecode(webp_data, webp_size, &config);
+    *status_out = status;
+    if (status != VP8_STATUS_OK) {
+        WebPFreeDecBuffer(&config.output);
+        return NULL;
+    }
+
+    // Allocate result structure
+    DecodedImage* result = (DecodedImage*)malloc(sizeof(DecodedImage));
+    *status_out = VP8_STATUS_OUT_OF_MEMORY;
+    if (!result) {
+        WebPFreeDecBuffer(&config.output);
+        return NULL;
+    }
+
+    // Set image properties
+    result->width = config.output.width;
+    result->height = config.output.height;
+    result->has_alpha = features.has_alpha;
+
+    // Calculate data size and stride - always 4 channels (RGBA)
+    int stride = result->width * 4;
+    size_t data_size = stride * result->height;
+    result->stride = stride;
+
+    // Allocate and copy image data
+    result->data = (uint8_t*)malloc(data_size);
+    if (!result->data) {
+        free(result);
+        WebPFreeDecBuffer(&config.output);
+        return NULL;
+    }
+
+    // Copy decoded data - always from RGBA buffer
+    memcpy(result->data, config.output.u.RGBA.rgba, data_size);
+
+    // Free WebP decoder buffer
+    WebPFreeDecBuffer(&config.output);
+
+    *status_out = VP8_STATUS_OK;
+    return result;
+}
+
+// free_decoded_image frees memory allocated for DecodedImage
+void free_decoded_image(DecodedImage* img) {
+    if (img) {
+        if (img->data) {
+            free(img->data);
+        }
+        free(img);
+    }
+}
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// DecodeWebPAdvanced decodes a WebP image with maximum quality settings
+// This function uses WebPDecoderConfig with optimized settings for best quality
+func DecodeWebPAdvanced(webpData []byte) (*DecodedWebPImage, error) {
+	if len(webpData) == 0 {
+		return nil, fmt.Errorf("%w: empty WebP data", ErrTruncated)
+	}
+
+	// Call C function to decode with advanced settings
+	var status C.int
+	cDecoded := C.decode_webp_advanced(
+		(*C.uint8_t)(unsafe.Pointer(&webpData[0])),
+		C.size_t(len(webpData)),
+		&status,
+	)
+
+	if cDecoded == nil {
+		return nil, &DecodeError{Op: "decode", Status: VP8Status(status)}
+	}
+	defer C.free_decoded_image(cDecoded)
+
+	// Extract image properties
+	width := int(cDecoded.width)
+	height := int(cDecoded.height)
+	hasAlpha := int(cDecoded.has_alpha) != 0
+	stride := int(cDecoded.stride)
+
+	// Calculate data size
+	dataSize := stride * height
+
+	// Copy image data to Go slice
+	data := make([]byte, dataSize)
+	C.memcpy(
+		unsafe.Pointer(&data[0]),
+		unsafe.Pointer(cDecoded.data),
+		C.size_t(dataSize),
+	)
+
+	return &DecodedWebPImage{
+		Data:     data,
+		Width:    width,
+		Height:   height,
+		HasAlpha: hasAlpha,
+		Stride:   stride,
+	}, nil
+}
+
+// readFeatures returns the features libwebp reads from the headers in data,
+// which may be the first bytes of a file only
+func readFeatures(data []byte) (webpFeatures, error) {
+	var features C.WebPBitstreamFeatures
+	status := C.WebPGetFeatures((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &features)
+	if status != C.VP8_STATUS_OK {
+		return webpFeatures{}, &DecodeError{Op: "decode", Status: VP8Status(status)}
+	}
+	return webpFeatures{
+		width:        int(features.width),
+		height:       int(features.height),
+		hasAlpha:     features.has_alpha != 0,
+		hasAnimation: features.has_animation != 0,
+	}, nil
+}
@@ -0,0 +1,315 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Pure Go counterpart of webp_decoder_cgo.go. The container parsing in front
+// of the VP8 and VP8L decoders is ported from libwebp's src/dec/webp_dec.c,
+// so that WebPGetFeatures and WebPDecode accept the same files and report
+// the same status codes.
+
+const (
+	tagSize         = 4
+	maxChunkPayload = 1<<32 - 1 - chunkHeaderSize - 1
+	maxImageArea    = 1 << 32
+)
+
+// webpHeaders locates the image bitstream of a file, like libwebp's
+// WebPHeaderStructure
+type webpHeaders struct {
+	offset         int    // Offset of the VP8/VP8L bitstream
+	compressedSize int64  // Size of the bitstream
+	alphaData      []byte // Payload of the ALPH chunk, if any
+	isLossless     bool
+}
+
+// DecodeWebPAdvanced decodes a WebP image with maximum quality settings
+// This function uses the decoder options of the libwebp build: fancy
+// upsampling, loop filtering and maximum dithering
+func DecodeWebPAdvanced(webpData []byte) (*DecodedWebPImage, error) {
+	if len(webpData) == 0 {
+		return nil, fmt.Errorf("%w: empty WebP data", ErrTruncated)
+	}
+
+	// Get image features first
+	features, err := readFeatures(webpData)
+	if err != nil {
+		return nil, err
+	}
+
+	pix, width, height, status := decodeWebP(webpData, 100, 100)
+	if status != VP8StatusOK {
+		return nil, &DecodeError{Op: "decode", Status: status}
+	}
+
+	return &DecodedWebPImage{
+		Data:     pix,
+		Width:    width,
+		Height:   height,
+		HasAlpha: features.hasAlpha,
+		Stride:   width * 4,
+	}, nil
+}
+
+// readFeatures returns the features read from the headers in data, which
+// may be the first bytes of a file only
+func readFeatures(data []byte) (webpFeatures, error) {
+	features, status := parseHeaders(data, nil)
+	if status != VP8StatusOK {
+		return webpFeatures{}, &DecodeError{Op: "decode", Status: status}
+	}
+	return features, nil
+}
+
+// decodeWebP decodes a still image, or the payload of an animation frame, to
+// RGBA like WebPDecode. dithering and alphaDithering are the strengths (0 to
+// 100) of WebPDecoderOptions.
+func decodeWebP(data []byte, dithering, alphaDithering int) (pix []byte, width, height int, status VP8Status) {
+	if _, status := parseHeaders(data, nil); status != VP8StatusOK {
+		// WebPDecode treats missing data as an error
+		if status == VP8StatusNotEnoughData {
+			status = VP8StatusBitstreamError
+		}
+		return nil, 0, 0, status
+	}
+
+	var headers webpHeaders
+	features, status := parseHeaders(data, &headers)
+	if (status == VP8StatusOK || status == VP8StatusNotEnoughData) && features.hasAnimation {
+		// Animation frames are decoded one by one through the demuxer
+		status = VP8StatusUnsupportedFeature
+	}
+	if status != VP8StatusOK {
+		return nil, 0, 0, status
+	}
+
+	if headers.isLossless {
+		return decodeVP8L(data[headers.offset:])
+	}
+	return decodeVP8(data[headers.offset:], headers.alphaData, dithering, alphaDithering)
+}
+
+// parseHeaders parses the RIFF, VP8X and optional chunks of data up to the
+// image bitstream and returns the features of the image, like libwebp's
+// ParseHeadersInternal. Without headers, data may be the first bytes of a
+// file only, and the features of an animation are read from its VP8X chunk.
+// With headers, data must be complete and the bitstream location is stored.
+// The animation flag is returned even if parsing fails after the VP8X chunk.
+func parseHeaders(data []byte, headers *webpHeaders) (webpFeatures, VP8Status) {
+	var features webpFeatures
+	if len(data) < riffHeaderSize {
+		return features, VP8StatusNotEnoughData
+	}
+	haveAllData := headers != nil
+	size := len(data)
+
+	data, riffSize, status := parseRIFF(data, haveAllData)
+	if status != VP8StatusOK {
+		return features, status
+	}
+	foundRIFF := riffSize > 0
+
+	data, foundVP8X, canvasWidth, canvasHeight, flags, status := parseVP8X(data)
+	if status != VP8StatusOK {
+		return features, status
+	}
+	if !foundRIFF && foundVP8X {
+		return features, VP8StatusBitstreamError
+	}
+
+	features.hasAlpha = flags&vp8xFlagAlpha != 0
+	features.hasAnimation = flags&vp8xFlagAnimation != 0
+	imageWidth, imageHeight := canvasWidth, canvasHeight
+	var alphaData []byte
+
+	status = func() VP8Status {
+		if foundVP8X && features.hasAnimation && headers == nil {
+			return VP8StatusOK
+		}
+		if len(data) < tagSize {
+			return VP8StatusNotEnoughData
+		}
+
+		// Skip over optional chunks if data started with "RIFF + VP8X" or "ALPH"
+		if (foundRIFF && foundVP8X) || (!foundRIFF && !foundVP8X && string(data[:tagSize]) == "ALPH") {
+			var status VP8Status
+			data, alphaData, status = parseOptionalChunks(data, riffSize)
+			if status != VP8StatusOK {
+				return status
+			}
+		}
+
+		data, compressedSize, isLossless, status := parseVP8ChunkHeader(data, haveAllData, riffSize)
+		if status != VP8StatusOK {
+			return status
+		}
+		if compressedSize > maxChunkPayload {
+			return VP8StatusBitstreamError
+		}
+
+		if !isLossless {
+			if len(data) < vp8FrameHeaderSize {
+				return VP8StatusNotEnoughData
+			}
+			var ok bool
+			if imageWidth, imageHeight, ok = vp8GetInfo(data, int(min(compressedSize, math.MaxInt32))); !ok {
+				return VP8StatusBitstreamError
+			}
+		} else {
+			if len(data) < vp8lHeaderSize {
+				return VP8StatusNotEnoughData
+			}
+			var ok bool
+			if imageWidth, imageHeight, features.hasAlpha, ok = vp8lGetInfo(data); !ok {
+				return VP8StatusBitstreamError
+			}
+		}
+		// The image must cover the canvas of a still extended file
+		if foundVP8X && (canvasWidth != imageWidth || canvasHeight != imageHeight) {
+			return VP8StatusBitstreamError
+		}
+
+		if headers != nil {
+			*headers = webpHeaders{
+				offset:         size - len(data),
+				compressedSize: compressedSize,
+				alphaData:      alphaData,
+				isLossless:     isLossless,
+			}
+		}
+		return VP8StatusOK
+	}()
+
+	// The canvas of an extended file is known even if the image is cut short
+	if status == VP8StatusOK || (status == VP8StatusNotEnoughData && foundVP8X && headers == nil) {
+		features.hasAlpha = features.hasAlpha || alphaData != nil
+		features.width, features.height = imageWidth, imageHeight
+		return features, VP8StatusOK
+	}
+	return webpFeatures{hasAnimation: features.hasAnimation}, status
+}
+
+// parseRIFF skips the RIFF header, if any, and returns the RIFF payload size
+// (0 without RIFF header)
+func parseRIFF(data []byte, haveAllData bool) ([]byte, int64, VP8Status) {
+	if len(data) < riffHeaderSize || string(data[:4]) != "RIFF" {
+		return data, 0, VP8StatusOK
+	}
+	if string(data[8:12]) != "WEBP" {
+		return nil, 0, VP8StatusBitstreamError
+	}
+	size := int64(binary.LittleEndian.Uint32(data[4:]))
+	if size < tagSize+chunkHeaderSize || size > maxChunkPayload {
+		return nil, 0, VP8StatusBitstreamError
+	}
+	if haveAllData && size > int64(len(data)-chunkHeaderSize) {
+		return nil, 0, VP8StatusNotEnoughData
+	}
+	return data[riffHeaderSize:], size, VP8StatusOK
+}
+
+// parseVP8X skips the VP8X chunk, if any, and returns the canvas size and
+// feature flags it holds
+func parseVP8X(data []byte) (rest []byte, found bool, width, height int, flags byte, status VP8Status) {
+	if len(data) < chunkHeaderSize {
+		return nil, false, 0, 0, 0, VP8StatusNotEnoughData
+	}
+	if string(data[:4]) != "VP8X" {
+		return data, false, 0, 0, 0, VP8StatusOK
+	}
+	if binary.LittleEndian.Uint32(data[4:]) != vp8xPayloadSize {
+		return nil, false, 0, 0, 0, VP8StatusBitstreamError
+	}
+	if len(data) < chunkHeaderSize+vp8xPayloadSize {
+		return nil, false, 0, 0, 0, VP8StatusNotEnoughData
+	}
+
+	flags = data[8]
+	width = 1 + int(uint24(data[12:15]))
+	height = 1 + int(uint24(data[15:18]))
+	if int64(width)*int64(height) >= maxImageArea {
+		return nil, false, 0, 0, 0, VP8StatusBitstreamError
+	}
+	return data[chunkHeaderSize+vp8xPayloadSize:], true, width, height, flags, VP8StatusOK
+}
+
+// parseOptionalChunks skips the chunks in front of the VP8/VP8L chunk and
+// returns the payload of the ALPH chunk among them, if any
+func parseOptionalChunks(data []byte, riffSize int64) (rest, alphaData []byte, status VP8Status) {
+	// The RIFF tag, VP8X chunk and the chunks so far
+	totalSize := int64(tagSize + chunkHeaderSize + vp8xPayloadSize)
+	for {
+		if len(data) < chunkHeaderSize {
+			return nil, nil, VP8StatusNotEnoughData
+		}
+
+		chunkSize := int64(binary.LittleEndian.Uint32(data[4:]))
+		if chunkSize > maxChunkPayload {
+			return nil, nil, VP8StatusBitstreamError
+		}
+		// Chunks are padded to even sizes
+		diskChunkSize := (chunkHeaderSize + chunkSize + 1) &^ 1
+		totalSize += diskChunkSize
+		if riffSize > 0 && totalSize > riffSize {
+			return nil, nil, VP8StatusBitstreamError
+		}
+
+		switch string(data[:4]) {
+		case "VP8 ", "VP8L":
+			return data, alphaData, VP8StatusOK
+		}
+		if int64(len(data)) < diskChunkSize {
+			return nil, nil, VP8StatusNotEnoughData
+		}
+		if string(data[:4]) == "ALPH" {
+			alphaData = data[chunkHeaderSize : chunkHeaderSize+chunkSize]
+		}
+		data = data[diskChunkSize:]
+	}
+}
+
+// parseVP8ChunkHeader skips the VP8/VP8L chunk header, if any, and returns the
+// size of the bitstream and whether it is lossless. Data without chunk
+// header is a raw bitstream.
+func parseVP8ChunkHeader(data []byte, haveAllData bool, riffSize int64) (rest []byte, compressedSize int64, isLossless bool, status VP8Status) {
+	// "WEBP" tag and the VP8 chunk header
+	const minimalSize = tagSize + chunkHeaderSize
+
+	if len(data) < chunkHeaderSize {
+		return nil, 0, false, VP8StatusNotEnoughData
+	}
+	tag := string(data[:4])
+	if tag != "VP8 " && tag != "VP8L" {
+		return data, int64(len(data)), vp8lCheckSignature(data), VP8StatusOK
+	}
+
+	size := int64(binary.LittleEndian.Uint32(data[4:]))
+	if riffSize >= minimalSize && size > riffSize-minimalSize {
+		return nil, 0, false, VP8StatusBitstreamError
+	}
+	if haveAllData && size > int64(len(data)-chunkHeaderSize) {
+		return nil, 0, false, VP8StatusNotEnoughData
+	}
+	return data[chunkHeaderSize:], size, tag == "VP8L", VP8StatusOK
+}
+
+// vp8lCheckSignature reports whether data starts like a VP8L bitstream of
+// a supported version
+func vp8lCheckSignature(data []byte) bool {
+	return len(data) >= vp8lHeaderSize && data[0] == vp8lMagicByte && data[4]>>5 == 0
+}
+
+// vp8lGetInfo validates the header of a VP8L bitstream and returns its
+// dimensions and alpha hint
+func vp8lGetInfo(data []byte) (width, height int, hasAlpha, ok bool) {
+	if !vp8lCheckSignature(data) {
+		return 0, 0, false, false
+	}
+	d := &vp8lDecoder{br: newVP8LBitReader(data)}
+	return d.readImageInfo()
+}
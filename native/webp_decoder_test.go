@@ -0,0 +1,147 @@
+package native
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lossyFixture is a lossy WebP in testdata with the planes libwebp decodes
+// it to. The reference PNGs come from dwebp -pgm: a gray image with the Y
+// plane on top, the U and V planes side by side below it and, with alpha,
+// the A plane last. Files with a filtered ALPH chunk share the VP8 data of
+// blue-purple-pink.lossy.webp and have alphaPattern as alpha.
+type lossyFixture struct {
+	name      string
+	reference string
+	pattern   bool // Alpha is alphaPattern
+}
+
+var lossyFixtures = []lossyFixture{
+	{"blue-purple-pink.lossy.webp", "blue-purple-pink.lossy.webp.ycbcr.png", false},               // Simple loop filter
+	{"video-001.lossy.webp", "video-001.lossy.webp.ycbcr.png", false},                             // Odd height
+	{"yellow_rose.lossy-with-alpha.webp", "yellow_rose.lossy-with-alpha.webp.nycbcra.png", false}, // Normal loop filter, lossless alpha
+	{"blue-purple-pink.alpha-horizontal.webp", "blue-purple-pink.lossy.webp.ycbcr.png", true},
+	{"blue-purple-pink.alpha-vertical.webp", "blue-purple-pink.lossy.webp.ycbcr.png", true},
+	{"blue-purple-pink.alpha-gradient.webp", "blue-purple-pink.lossy.webp.ycbcr.png", true},
+}
+
+// alphaPattern is the alpha of the filtered-alpha fixtures
+func alphaPattern(x, y int) byte {
+	return byte(x*7 + y*y*3 + x*y)
+}
+
+// yuvPlanes holds reference planes; a is nil without alpha
+type yuvPlanes struct {
+	y, u, v, a        []byte
+	width, height, w2 int
+}
+
+// readFixture returns the data of a fixture and its reference planes
+func readFixture(t *testing.T, fx lossyFixture) ([]byte, yuvPlanes) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fx.name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("testdata", fx.reference))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := img.(*image.Gray)
+
+	features, err := readFeatures(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := features.width, features.height
+	w2, h2 := (w+1)/2, (h+1)/2
+	plane := func(r image.Rectangle) []byte {
+		p := make([]byte, 0, r.Dx()*r.Dy())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			p = append(p, gray.Pix[y*gray.Stride+r.Min.X:][:r.Dx()]...)
+		}
+		return p
+	}
+	planes := yuvPlanes{
+		y:      plane(image.Rect(0, 0, w, h)),
+		u:      plane(image.Rect(0, h, w2, h+h2)),
+		v:      plane(image.Rect(w2, h, 2*w2, h+h2)),
+		width:  w,
+		height: h,
+		w2:     w2,
+	}
+	switch {
+	case fx.pattern:
+		planes.a = make([]byte, w*h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				planes.a[y*w+x] = alphaPattern(x, y)
+			}
+		}
+	case gray.Bounds().Dy() > h+h2:
+		planes.a = plane(image.Rect(0, h+h2, w, 2*h+h2))
+	}
+	return data, planes
+}
+
+// TestDecodeLossy tests lossy and lossy+alpha decoding against the planes of
+// libwebp, with either build. Alpha must match exactly. The RGB output is
+// upsampled and dithered, so it is held to a plain conversion of the
+// reference planes on average.
+func TestDecodeLossy(t *testing.T) {
+	for _, fx := range lossyFixtures {
+		data, ref := readFixture(t, fx)
+		img, err := DecodeWebPAdvanced(data)
+		if err != nil {
+			t.Errorf("%s: %v", fx.name, err)
+			continue
+		}
+		if img.Width != ref.width || img.Height != ref.height || img.HasAlpha != (ref.a != nil) {
+			t.Errorf("%s: got %dx%d alpha %v", fx.name, img.Width, img.Height, img.HasAlpha)
+			continue
+		}
+
+		var diff, alphaDiffs int
+		for y := 0; y < ref.height; y++ {
+			for x := 0; x < ref.width; x++ {
+				uv := y/2*ref.w2 + x/2
+				want := referenceRGB(ref.y[y*ref.width+x], ref.u[uv], ref.v[uv])
+				got := img.Data[y*img.Stride+4*x:]
+				for i, c := range want {
+					diff += max(int(c), int(got[i])) - min(int(c), int(got[i]))
+				}
+				wantA := byte(0xff)
+				if ref.a != nil {
+					wantA = ref.a[y*ref.width+x]
+				}
+				if got[3] != wantA {
+					alphaDiffs++
+				}
+			}
+		}
+		if mean := float64(diff) / float64(3*ref.width*ref.height); mean > 3 {
+			t.Errorf("%s: RGB off by %.2f on average", fx.name, mean)
+		}
+		if alphaDiffs > 0 {
+			t.Errorf("%s: %d alpha values differ", fx.name, alphaDiffs)
+		}
+	}
+}
+
+// referenceRGB converts a BT.601 sample to RGB
+func referenceRGB(y, u, v byte) [3]byte {
+	clip := func(x float64) byte {
+		return byte(min(max(x+0.5, 0), 255))
+	}
+	l := 1.164 * (float64(y) - 16)
+	cb, cr := float64(u)-128, float64(v)-128
+	return [3]byte{clip(l + 1.596*cr), clip(l - 0.813*cr - 0.391*cb), clip(l + 2.018*cb)}
+}
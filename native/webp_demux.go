@@ -0,0 +1,66 @@
+package native
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// Animation is a decoded WebP image: its frames as stored in the file, each
+// covering a rectangle of the canvas. A static image decodes to one frame.
+type Animation struct {
+	Width           int // Canvas width
+	Height          int // Canvas height
+	LoopCount       int // 0 = infinite
+	BackgroundColor color.NRGBA
+	Frames          []Frame
+}
+
+// Frame is one decoded frame. The bounds of Image are its position on the canvas.
+type Frame struct {
+	Image    *image.NRGBA
+	Duration int  // Display time in milliseconds
+	Blend    bool // Alpha-blend onto the previous canvas (otherwise overwrite)
+	Dispose  bool // Dispose the frame area to background after display
+}
+
+// DecodeAnimated reads a WebP image from r and decodes every frame, within
+// DefaultLimits
+func DecodeAnimated(r io.Reader) (*Animation, error) {
+	return DecodeAnimatedWithLimits(r, Limits{})
+}
+
+// DecodeAnimatedWithLimits is DecodeAnimated for untrusted input: the input
+// size, canvas and frame count are checked against limits before decoding
+func DecodeAnimatedWithLimits(r io.Reader, limits Limits) (*Animation, error) {
+	data, err := ReadInput(r, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newDemuxer(data, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	anim := &Animation{
+		Width:           d.width,
+		Height:          d.height,
+		LoopCount:       d.loopCount,
+		BackgroundColor: d.background,
+		Frames:          make([]Frame, 0, d.frameCount),
+	}
+	err = d.eachFrame(func(frame Frame) error {
+		// The pixels are only valid until the callback returns
+		img := *frame.Image
+		img.Pix = append([]byte(nil), img.Pix...)
+		frame.Image = &img
+		anim.Frames = append(anim.Frames, frame)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return anim, nil
+}
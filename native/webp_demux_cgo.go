@@ -0,0 +1,164 @@
+//go:build cgo && !purego
+
+package native
+
+/*
+#cgo pkg-config: libwebp libwebpdemux
+#include <stdlib.h>
+#include <string.h>
+#include <webp/decode.h>
+#include <webp/demux.h>
+#include <webp/mux_types.h>
+
+// decode_frame_rgba decodes a frame like WebPDecodeRGBA but also reports the
+// VP8StatusCode. The returned buffer is freed with WebPFree.
+static uint8_t* decode_frame_rgba(const uint8_t* data, size_t size, int* width, int* height, int* status) {
+    WebPDecoderConfig config;
+    if (!WebPInitDecoderConfig(&config)) {
+        *status = VP8_STATUS_INVALID_PARAM;
+        return NULL;
+    }
+    config.output.colorspace = MODE_RGBA;
+    *status = WebPDecode(data, size, &config);
+    if (*status != VP8_STATUS_OK) {
+        WebPFreeDecBuffer(&config.output);
+        return NULL;
+    }
+    *width = config.output.width;
+    *height = config.output.height;
+    // Internally allocated RGBA output lives in a single WebPMalloc'ed block
+    return config.output.u.RGBA.rgba;
+}
+
+// demux_with_state creates a demuxer and reports its parse state
+static WebPDemuxer* demux_with_state(const WebPData* data, int* state) {
+    WebPDemuxState s;
+    WebPDemuxer* demux = WebPDemuxPartial(data, &s);
+    *state = s;
+    if (demux != NULL && s != WEBP_DEMUX_DONE) {
+        WebPDemuxDelete(demux);
+        return NULL;
+    }
+    return demux;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"unsafe"
+)
+
+// demuxer walks the frames of a WebP file held in C memory
+type demuxer struct {
+	data       unsafe.Pointer // C copy of the file, referenced by demux
+	demux      *C.WebPDemuxer
+	width      int
+	height     int
+	frameCount int
+	loopCount  int
+	background color.NRGBA
+}
+
+// newDemuxer parses the container of data and checks its canvas and frame
+// count against limits
+func newDemuxer(data []byte, limits Limits) (*demuxer, error) {
+	// Allocate C memory and copy data to avoid CGO pointer issues
+	cData := C.malloc(C.size_t(len(data)))
+	if cData == nil {
+		return nil, fmt.Errorf("%w: WebP input buffer", ErrOutOfMemory)
+	}
+	C.memcpy(cData, unsafe.Pointer(&data[0]), C.size_t(len(data)))
+
+	webpData := C.WebPData{
+		bytes: (*C.uint8_t)(cData),
+		size:  C.size_t(len(data)),
+	}
+
+	// Create demuxer; a file cut short parses only partially
+	var demuxState C.int
+	demux := C.demux_with_state(&webpData, &demuxState)
+	if demux == nil {
+		C.free(cData)
+		status := VP8StatusBitstreamError
+		if demuxState != C.WEBP_DEMUX_PARSE_ERROR {
+			status = VP8StatusNotEnoughData
+		}
+		return nil, &DecodeError{Op: "demux", Status: status}
+	}
+
+	// The ANIM background color is stored in blue, green, red, alpha order
+	bg := uint32(C.WebPDemuxGetI(demux, C.WEBP_FF_BACKGROUND_COLOR))
+	d := &demuxer{
+		data:       cData,
+		demux:      demux,
+		width:      int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_WIDTH)),
+		height:     int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_HEIGHT)),
+		frameCount: int(C.WebPDemuxGetI(demux, C.WEBP_FF_FRAME_COUNT)),
+		loopCount:  int(C.WebPDemuxGetI(demux, C.WEBP_FF_LOOP_COUNT)),
+		background: color.NRGBA{R: uint8(bg >> 16), G: uint8(bg >> 8), B: uint8(bg), A: uint8(bg >> 24)},
+	}
+
+	if d.frameCount == 0 {
+		d.close()
+		return nil, corruptf("no frames found in WebP file")
+	}
+	if err := limits.checkImage(d.width, d.height, d.frameCount); err != nil {
+		d.close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// close frees the demuxer and its copy of the file
+func (d *demuxer) close() {
+	C.WebPDemuxDelete(d.demux)
+	C.free(d.data)
+}
+
+// eachFrame decodes the frames in order and calls fn with each. The pixels of
+// frame.Image are only valid during the call.
+func (d *demuxer) eachFrame(fn func(frame Frame) error) error {
+	var iter C.WebPIterator
+	if C.WebPDemuxGetFrame(d.demux, 1, &iter) == 0 {
+		return corruptf("failed to get first frame")
+	}
+	defer C.WebPDemuxReleaseIterator(&iter)
+
+	for {
+		var outWidth, outHeight, status C.int
+		rgbaData := C.decode_frame_rgba(
+			iter.fragment.bytes,
+			iter.fragment.size,
+			&outWidth,
+			&outHeight,
+			&status,
+		)
+		if rgbaData == nil {
+			return &DecodeError{Op: "decode", Frame: int(iter.frame_num), Status: VP8Status(status)}
+		}
+
+		width, height := int(outWidth), int(outHeight)
+		x, y := int(iter.x_offset), int(iter.y_offset)
+		frame := Frame{
+			Image: &image.NRGBA{
+				Pix:    unsafe.Slice((*byte)(rgbaData), width*height*4),
+				Stride: width * 4,
+				Rect:   image.Rect(x, y, x+width, y+height),
+			},
+			Duration: int(iter.duration),
+			Blend:    iter.blend_method == C.WEBP_MUX_BLEND,
+			Dispose:  iter.dispose_method == C.WEBP_MUX_DISPOSE_BACKGROUND,
+		}
+		err := fn(frame)
+		C.WebPFree(unsafe.Pointer(rgbaData))
+		if err != nil {
+			return err
+		}
+
+		if C.WebPDemuxNextFrame(&iter) == 0 {
+			return nil
+		}
+	}
+}
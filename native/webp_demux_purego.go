@@ -0,0 +1,672 @@
+//go:build !cgo || purego
+
+package native
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+)
+
+// Pure Go counterpart of webp_demux_cgo.go. The container parsing is ported
+// from libwebp's src/demux/demux.c, so that files are accepted, rejected or
+// reported as truncated like WebPDemuxPartial does.
+
+// vp8xValidFlags are the VP8X feature flags a demuxer accepts
+const vp8xValidFlags = vp8xFlagAlpha | vp8xFlagAnimation | vp8xFlagEXIF | vp8xFlagICC | vp8xFlagXMP
+
+// demuxState mirrors libwebpdemux's WebPDemuxState
+type demuxState int
+
+const (
+	demuxParseError    demuxState = iota - 1 // An error occurred while parsing
+	demuxParsingHeader                       // Not enough data to parse the full header
+	demuxParsedHeader                        // Header parsed, the frames may be incomplete
+	demuxDone                                // Entire file parsed
+)
+
+// parseStatus is the outcome of a parsing step
+type parseStatus int
+
+const (
+	parseOK parseStatus = iota
+	parseNeedMoreData
+	parseError
+)
+
+// demuxChunk is the location of a chunk, header included, in the file
+type demuxChunk struct {
+	offset int
+	size   int
+}
+
+// demuxFrame is a frame found by the demuxer, with the location of its
+// image and alpha chunks
+type demuxFrame struct {
+	x, y          int
+	width, height int
+	hasAlpha      bool
+	duration      int
+	blend         bool
+	dispose       bool
+	frameNum      int
+	complete      bool // The image chunk is entirely in the data
+	image         demuxChunk
+	alpha         demuxChunk
+}
+
+// demuxer walks the frames of a WebP file
+type demuxer struct {
+	data       []byte
+	frames     []*demuxFrame
+	width      int
+	height     int
+	frameCount int
+	loopCount  int
+	background color.NRGBA
+}
+
+// newDemuxer parses the container of data and checks its canvas and frame
+// count against limits
+func newDemuxer(data []byte, limits Limits) (*demuxer, error) {
+	// A file cut short parses only partially
+	p, state := demux(data)
+	if p == nil || state != demuxDone {
+		status := VP8StatusBitstreamError
+		if state != demuxParseError {
+			status = VP8StatusNotEnoughData
+		}
+		return nil, &DecodeError{Op: "demux", Status: status}
+	}
+
+	// The ANIM background color is stored in blue, green, red, alpha order
+	bg := p.bgcolor
+	d := &demuxer{
+		data:       p.buf,
+		frames:     p.frames,
+		width:      p.canvasWidth,
+		height:     p.canvasHeight,
+		frameCount: len(p.frames),
+		loopCount:  p.loopCount,
+		background: color.NRGBA{R: uint8(bg >> 16), G: uint8(bg >> 8), B: uint8(bg), A: uint8(bg >> 24)},
+	}
+
+	if d.frameCount == 0 {
+		return nil, corruptf("no frames found in WebP file")
+	}
+	if err := limits.checkImage(d.width, d.height, d.frameCount); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// close releases the demuxer, which holds no resources outside the Go heap
+func (d *demuxer) close() {}
+
+// eachFrame decodes the frames in order and calls fn with each. The pixels of
+// frame.Image are only valid during the call.
+func (d *demuxer) eachFrame(fn func(frame Frame) error) error {
+	for _, f := range d.frames {
+		pix, width, height, status := decodeWebP(d.payload(f), 0, 0)
+		if status != VP8StatusOK {
+			return &DecodeError{Op: "decode", Frame: f.frameNum, Status: status}
+		}
+
+		frame := Frame{
+			Image: &image.NRGBA{
+				Pix:    pix,
+				Stride: width * 4,
+				Rect:   image.Rect(f.x, f.y, f.x+width, f.y+height),
+			},
+			Duration: f.duration,
+			Blend:    f.blend,
+			Dispose:  f.dispose,
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payload returns the chunks of a frame to decode: its image chunk, preceded
+// by its alpha chunk and any chunks in between
+func (d *demuxer) payload(f *demuxFrame) []byte {
+	start, size := f.image.offset, f.image.size
+	if f.alpha.size > 0 {
+		interSize := 0
+		if f.image.offset > 0 {
+			interSize = f.image.offset - (f.alpha.offset + f.alpha.size)
+		}
+		start = f.alpha.offset
+		size += f.alpha.size + interSize
+	}
+	return d.data[start : start+size]
+}
+
+// demuxParser holds the state of libwebp's WebPDemuxer while parsing
+type demuxParser struct {
+	buf          []byte // The data, cut at the end of the RIFF chunk
+	start        int    // Parsing position in buf
+	riffEnd      int64  // End of the RIFF chunk, past the end of buf if data is missing
+	state        demuxState
+	extended     bool
+	flags        byte
+	canvasWidth  int
+	canvasHeight int
+	loopCount    int
+	bgcolor      uint32
+	frames       []*demuxFrame
+}
+
+// demux parses the container of data, which may be cut short, like
+// WebPDemuxPartial. It returns nil if the data is invalid or too short to
+// hold the file header.
+func demux(data []byte) (*demuxParser, demuxState) {
+	p := &demuxParser{
+		buf:          data,
+		state:        demuxParsingHeader,
+		canvasWidth:  -1,
+		canvasHeight: -1,
+		loopCount:    1,
+		bgcolor:      0xffffffff, // White background by default
+	}
+
+	status := p.readHeader()
+	if status != parseOK {
+		// Without WebP file header, the data may be a raw VP8/VP8L bitstream
+		if status == parseError {
+			if status = p.rawImage(); status == parseOK {
+				return p, demuxDone
+			}
+		}
+		if status == parseNeedMoreData {
+			return nil, demuxParsingHeader
+		}
+		return nil, demuxParseError
+	}
+	partial := int64(len(p.buf)) < p.riffEnd
+
+	var valid func() bool
+	switch string(p.buf[p.start : p.start+tagSize]) {
+	case "VP8 ", "VP8L":
+		status = p.parseSingleImage()
+		valid = p.isValidSimpleFormat
+	case "VP8X":
+		status = p.parseVP8X()
+		valid = p.isValidExtendedFormat
+	default:
+		return nil, p.state
+	}
+
+	if status == parseOK {
+		p.state = demuxDone
+	}
+	if status == parseNeedMoreData && !partial {
+		status = parseError
+	}
+	if status != parseError && !valid() {
+		status = parseError
+	}
+	if status == parseError {
+		return nil, demuxParseError
+	}
+	return p, p.state
+}
+
+// dataSize returns the size of the data left to parse
+func (p *demuxParser) dataSize() int {
+	return len(p.buf) - p.start
+}
+
+// sizeIsInvalid reports whether size bytes from the parsing position
+// exceed the end of the RIFF chunk
+func (p *demuxParser) sizeIsInvalid(size int64) bool {
+	return size > p.riffEnd-int64(p.start)
+}
+
+func (p *demuxParser) skip(size int) {
+	p.start += size
+}
+
+func (p *demuxParser) rewind(size int) {
+	p.start -= size
+}
+
+func (p *demuxParser) readByte() byte {
+	b := p.buf[p.start]
+	p.start++
+	return b
+}
+
+func (p *demuxParser) readLE16() int {
+	v := binary.LittleEndian.Uint16(p.buf[p.start:])
+	p.start += 2
+	return int(v)
+}
+
+func (p *demuxParser) readLE24() int {
+	v := uint24(p.buf[p.start:])
+	p.start += 3
+	return int(v)
+}
+
+func (p *demuxParser) readLE32() uint32 {
+	v := binary.LittleEndian.Uint32(p.buf[p.start:])
+	p.start += 4
+	return v
+}
+
+func (p *demuxParser) readTag() string {
+	tag := string(p.buf[p.start : p.start+tagSize])
+	p.start += tagSize
+	return tag
+}
+
+// readHeader validates the RIFF header and skips it
+func (p *demuxParser) readHeader() parseStatus {
+	if p.dataSize() < riffHeaderSize+chunkHeaderSize {
+		return parseNeedMoreData
+	}
+	if string(p.buf[0:4]) != "RIFF" || string(p.buf[8:12]) != "WEBP" {
+		return parseError
+	}
+
+	riffSize := int64(binary.LittleEndian.Uint32(p.buf[4:]))
+	if riffSize < chunkHeaderSize || riffSize > maxChunkPayload {
+		return parseError
+	}
+
+	// There's no point in reading past the end of the RIFF chunk
+	p.riffEnd = riffSize + chunkHeaderSize
+	if int64(len(p.buf)) > p.riffEnd {
+		p.buf = p.buf[:p.riffEnd]
+	}
+
+	p.skip(riffHeaderSize)
+	return parseOK
+}
+
+// rawImage makes a one-frame file of a raw VP8/VP8L bitstream
+func (p *demuxParser) rawImage() parseStatus {
+	features, status := parseHeaders(p.buf, nil)
+	if status != VP8StatusOK {
+		if status == VP8StatusNotEnoughData {
+			return parseNeedMoreData
+		}
+		return parseError
+	}
+
+	frame := &demuxFrame{
+		image:    demuxChunk{offset: 0, size: len(p.buf)},
+		width:    features.width,
+		height:   features.height,
+		hasAlpha: features.hasAlpha,
+		frameNum: 1,
+		complete: true,
+	}
+	p.addFrame(frame)
+	p.state = demuxDone
+	p.canvasWidth, p.canvasHeight = frame.width, frame.height
+	if frame.hasAlpha {
+		p.flags |= vp8xFlagAlpha
+	}
+	return parseOK
+}
+
+// addFrame appends a frame, unless the last one is incomplete
+func (p *demuxParser) addFrame(frame *demuxFrame) bool {
+	if n := len(p.frames); n > 0 && !p.frames[n-1].complete {
+		return false
+	}
+	p.frames = append(p.frames, frame)
+	return true
+}
+
+// storeFrame stores the location of the image bearing chunks at the parsing
+// position in frame. minSize is the data size required to start, if any.
+func (p *demuxParser) storeFrame(frameNum int, minSize int64, frame *demuxFrame) parseStatus {
+	alphaChunks, imageChunks := 0, 0
+	if p.dataSize() < chunkHeaderSize || int64(p.dataSize()) < minSize {
+		return parseNeedMoreData
+	}
+
+	status := parseOK
+	for done := false; !done && status == parseOK; {
+		chunkStart := p.start
+		fourCC := p.readTag()
+		payloadSize := int64(p.readLE32())
+		if payloadSize > maxChunkPayload {
+			return parseError
+		}
+
+		payloadSizePadded := payloadSize + payloadSize&1
+		payloadAvailable := min(payloadSizePadded, int64(p.dataSize()))
+		chunkSize := chunkHeaderSize + int(payloadAvailable)
+		if p.sizeIsInvalid(payloadSizePadded) {
+			return parseError
+		}
+		if payloadSizePadded > int64(p.dataSize()) {
+			status = parseNeedMoreData
+		}
+
+		switch {
+		case fourCC == "ALPH" && alphaChunks == 0:
+			alphaChunks++
+			frame.alpha = demuxChunk{offset: chunkStart, size: chunkSize}
+			frame.hasAlpha = true
+			frame.frameNum = frameNum
+			p.skip(int(payloadAvailable))
+
+		case fourCC == "VP8L" && alphaChunks > 0:
+			// VP8L has its own alpha
+			return parseError
+
+		case (fourCC == "VP8 " || fourCC == "VP8L") && imageChunks == 0:
+			// Extract the bitstream features, tolerating failures when the
+			// data is incomplete
+			features, vp8Status := parseHeaders(p.buf[chunkStart:chunkStart+chunkSize], nil)
+			if status == parseNeedMoreData && vp8Status == VP8StatusNotEnoughData {
+				return parseNeedMoreData
+			} else if vp8Status != VP8StatusOK {
+				return parseError
+			}
+			imageChunks++
+			frame.image = demuxChunk{offset: chunkStart, size: chunkSize}
+			frame.width, frame.height = features.width, features.height
+			frame.hasAlpha = frame.hasAlpha || features.hasAlpha
+			frame.frameNum = frameNum
+			frame.complete = status == parseOK
+			p.skip(int(payloadAvailable))
+
+		default:
+			// Not part of this frame: leave the chunk to the caller
+			p.rewind(chunkHeaderSize)
+			done = true
+		}
+
+		if int64(p.start) == p.riffEnd {
+			done = true
+		} else if p.dataSize() < chunkHeaderSize {
+			status = parseNeedMoreData
+		}
+	}
+	return status
+}
+
+// newFrame checks that a frame chunk of actualSize bytes holds at least
+// minSize bytes, and that they are available
+func (p *demuxParser) newFrame(minSize, actualSize int64) parseStatus {
+	if p.sizeIsInvalid(minSize) || actualSize < minSize {
+		return parseError
+	}
+	if int64(p.dataSize()) < minSize {
+		return parseNeedMoreData
+	}
+	return parseOK
+}
+
+// parseAnimationFrame parses an ANMF chunk, whose padded payload size is
+// frameChunkSize, and the image chunks it holds
+func (p *demuxParser) parseAnimationFrame(frameChunkSize int64) parseStatus {
+	isAnimation := p.flags&vp8xFlagAnimation != 0
+	anmfPayloadSize := frameChunkSize - anmfHeaderSize
+
+	status := p.newFrame(anmfHeaderSize, frameChunkSize)
+	if status != parseOK {
+		return status
+	}
+
+	frame := &demuxFrame{
+		x:        2 * p.readLE24(),
+		y:        2 * p.readLE24(),
+		width:    1 + p.readLE24(),
+		height:   1 + p.readLE24(),
+		duration: p.readLE24(),
+	}
+	bits := p.readByte()
+	frame.dispose = bits&1 != 0
+	frame.blend = bits&2 == 0
+	if int64(frame.width)*int64(frame.height) >= maxImageArea {
+		return parseError
+	}
+
+	// Store a frame only if the animation flag is set and some data for
+	// this frame is available
+	start := p.start
+	status = p.storeFrame(len(p.frames)+1, anmfPayloadSize, frame)
+	if status != parseError && int64(p.start-start) > anmfPayloadSize {
+		status = parseError
+	}
+	if status != parseError && isAnimation && frame.frameNum > 0 {
+		if !p.addFrame(frame) {
+			status = parseError
+		}
+	}
+	return status
+}
+
+// parseSingleImage parses the image chunks of a still image
+func (p *demuxParser) parseSingleImage() parseStatus {
+	if len(p.frames) > 0 || p.sizeIsInvalid(chunkHeaderSize) {
+		return parseError
+	}
+	if p.dataSize() < chunkHeaderSize {
+		return parseNeedMoreData
+	}
+
+	// A partial frame is allowed, so no minimum size is imposed
+	frame := &demuxFrame{}
+	status := p.storeFrame(1, 0, frame)
+	if status == parseError {
+		return status
+	}
+
+	// Clear any alpha when the alpha flag is missing
+	if p.flags&vp8xFlagAlpha == 0 && frame.alpha.size > 0 {
+		frame.alpha = demuxChunk{}
+		frame.hasAlpha = false
+	}
+
+	// The frame size is the canvas size of simple files
+	if !p.extended && frame.width > 0 && frame.height > 0 {
+		p.state = demuxParsedHeader
+		p.canvasWidth, p.canvasHeight = frame.width, frame.height
+		if frame.hasAlpha {
+			p.flags |= vp8xFlagAlpha
+		}
+	}
+	if !p.addFrame(frame) {
+		// The last frame was left incomplete
+		return parseError
+	}
+	return status
+}
+
+// parseVP8X parses an extended file from its VP8X chunk
+func (p *demuxParser) parseVP8X() parseStatus {
+	if p.dataSize() < chunkHeaderSize {
+		return parseNeedMoreData
+	}
+
+	p.extended = true
+	p.skip(tagSize)
+	vp8xSize := int64(p.readLE32())
+	if vp8xSize > maxChunkPayload || vp8xSize < vp8xPayloadSize {
+		return parseError
+	}
+	vp8xSize += vp8xSize & 1
+	if p.sizeIsInvalid(vp8xSize) {
+		return parseError
+	}
+	if int64(p.dataSize()) < vp8xSize {
+		return parseNeedMoreData
+	}
+
+	p.flags = p.readByte()
+	p.skip(3) // Reserved
+	p.canvasWidth = 1 + p.readLE24()
+	p.canvasHeight = 1 + p.readLE24()
+	if int64(p.canvasWidth)*int64(p.canvasHeight) >= maxImageArea {
+		return parseError
+	}
+	p.skip(int(vp8xSize) - vp8xPayloadSize) // Skip any trailing data
+	p.state = demuxParsedHeader
+
+	if p.sizeIsInvalid(chunkHeaderSize) {
+		return parseError
+	}
+	if p.dataSize() < chunkHeaderSize {
+		return parseNeedMoreData
+	}
+	return p.parseVP8XChunks()
+}
+
+// parseVP8XChunks parses the chunks following the VP8X chunk
+func (p *demuxParser) parseVP8XChunks() parseStatus {
+	isAnimation := p.flags&vp8xFlagAnimation != 0
+	animChunks := 0
+	status := parseOK
+
+	for status == parseOK {
+		fourCC := p.readTag()
+		chunkSize := int64(p.readLE32())
+		if chunkSize > maxChunkPayload {
+			return parseError
+		}
+		chunkSizePadded := chunkSize + chunkSize&1
+		if p.sizeIsInvalid(chunkSizePadded) {
+			return parseError
+		}
+
+		switch fourCC {
+		case "VP8X":
+			return parseError
+
+		case "ALPH", "VP8 ", "VP8L":
+			// Every frame of an animation is in an ANMF chunk
+			if animChunks > 0 || isAnimation {
+				return parseError
+			}
+			p.rewind(chunkHeaderSize)
+			status = p.parseSingleImage()
+
+		case "ANIM":
+			if chunkSizePadded < animPayloadSize {
+				return parseError
+			}
+			switch {
+			case int64(p.dataSize()) < chunkSizePadded:
+				status = parseNeedMoreData
+			case animChunks == 0:
+				animChunks++
+				p.bgcolor = p.readLE32()
+				p.loopCount = p.readLE16()
+				p.skip(int(chunkSizePadded) - animPayloadSize)
+			default:
+				status = p.skipChunk(chunkSizePadded)
+			}
+
+		case "ANMF":
+			// ANIM precedes the frames
+			if animChunks == 0 {
+				return parseError
+			}
+			status = p.parseAnimationFrame(chunkSizePadded)
+
+		default:
+			// Metadata and unknown chunks
+			status = p.skipChunk(chunkSizePadded)
+		}
+
+		if int64(p.start) == p.riffEnd {
+			break
+		} else if p.dataSize() < chunkHeaderSize {
+			status = parseNeedMoreData
+		}
+	}
+	return status
+}
+
+// skipChunk skips the payload of a chunk whose header was read
+func (p *demuxParser) skipChunk(chunkSizePadded int64) parseStatus {
+	if chunkSizePadded > int64(p.dataSize()) {
+		return parseNeedMoreData
+	}
+	p.skip(int(chunkSizePadded))
+	return parseOK
+}
+
+// isValidSimpleFormat checks the frame of a simple (VP8, VP8L) file
+func (p *demuxParser) isValidSimpleFormat() bool {
+	if p.state == demuxParsingHeader {
+		return true
+	}
+	if p.canvasWidth <= 0 || p.canvasHeight <= 0 || len(p.frames) == 0 {
+		return false
+	}
+	frame := p.frames[0]
+	return frame.width > 0 && frame.height > 0
+}
+
+// isValidExtendedFormat checks the frames of an extended (VP8X) file
+func (p *demuxParser) isValidExtendedFormat() bool {
+	isAnimation := p.flags&vp8xFlagAnimation != 0
+	if p.state == demuxParsingHeader {
+		return true
+	}
+	if p.canvasWidth <= 0 || p.canvasHeight <= 0 || p.loopCount < 0 {
+		return false
+	}
+	if p.state == demuxDone && len(p.frames) == 0 {
+		return false
+	}
+	if p.flags&^vp8xValidFlags != 0 {
+		return false
+	}
+
+	for i, f := range p.frames {
+		if !isAnimation && f.frameNum > 1 {
+			return false
+		}
+
+		if f.complete {
+			if f.alpha.size == 0 && f.image.size == 0 {
+				return false
+			}
+			// The alpha chunk precedes the image chunk
+			if f.alpha.size > 0 && f.alpha.offset > f.image.offset {
+				return false
+			}
+			if f.width <= 0 || f.height <= 0 {
+				return false
+			}
+		} else {
+			// A complete file has no partial frame
+			if p.state == demuxDone {
+				return false
+			}
+			if f.alpha.size > 0 && f.image.size > 0 && f.alpha.offset > f.image.offset {
+				return false
+			}
+			// No frame follows an incomplete one
+			if i < len(p.frames)-1 {
+				return false
+			}
+		}
+
+		if f.width > 0 && f.height > 0 && !f.inCanvas(!isAnimation, p.canvasWidth, p.canvasHeight) {
+			return false
+		}
+	}
+	return true
+}
+
+// inCanvas reports whether a frame fits in the canvas or, if exact, covers
+// it exactly
+func (f *demuxFrame) inCanvas(exact bool, canvasWidth, canvasHeight int) bool {
+	if exact {
+		return f.x == 0 && f.y == 0 && f.width == canvasWidth && f.height == canvasHeight
+	}
+	return f.x >= 0 && f.y >= 0 && f.x+f.width <= canvasWidth && f.y+f.height <= canvasHeight
+}
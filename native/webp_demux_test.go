@@ -0,0 +1,82 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// animatedSolid builds an 8x8 animation: a red frame covering the canvas,
+// then a blue 4x4 frame at (4, 2) that is not blended and disposed
+func animatedSolid() []byte {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	frame2 := anmfPayload(4, 4, 250, chunk("VP8L", solidVP8L(4, 4, blue)))
+	put24(frame2[0:], 2) // X = 4
+	put24(frame2[3:], 1) // Y = 2
+	frame2[15] = 0x03    // No blending, dispose to background
+
+	return riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 8, 8)),
+		chunk("ANIM", []byte{0x10, 0x20, 0x30, 0xff, 3, 0}),
+		chunk("ANMF", anmfPayload(8, 8, 100, chunk("VP8L", solidVP8L(8, 8, red)))),
+		chunk("ANMF", frame2),
+	)
+}
+
+// TestDecodeAnimated tests frame pixels, placement and timing
+func TestDecodeAnimated(t *testing.T) {
+	anim, err := DecodeAnimated(bytes.NewReader(animatedSolid()))
+	if err != nil {
+		t.Fatalf("DecodeAnimated failed: %v", err)
+	}
+
+	if anim.Width != 8 || anim.Height != 8 || anim.LoopCount != 3 || len(anim.Frames) != 2 {
+		t.Fatalf("unexpected animation: %dx%d loop %d, %d frame(s)", anim.Width, anim.Height, anim.LoopCount, len(anim.Frames))
+	}
+	if want := (color.NRGBA{R: 0x30, G: 0x20, B: 0x10, A: 0xff}); anim.BackgroundColor != want {
+		t.Errorf("BackgroundColor = %v, want %v", anim.BackgroundColor, want)
+	}
+
+	tests := []struct {
+		bounds   image.Rectangle
+		color    color.NRGBA
+		duration int
+		blend    bool
+		dispose  bool
+	}{
+		{image.Rect(0, 0, 8, 8), color.NRGBA{R: 255, A: 255}, 100, true, false},
+		{image.Rect(4, 2, 8, 6), color.NRGBA{B: 255, A: 255}, 250, false, true},
+	}
+	for i, tt := range tests {
+		frame := anim.Frames[i]
+		if frame.Image.Bounds() != tt.bounds {
+			t.Errorf("frame %d bounds = %v, want %v", i, frame.Image.Bounds(), tt.bounds)
+		}
+		if c := frame.Image.NRGBAAt(tt.bounds.Max.X-1, tt.bounds.Max.Y-1); c != tt.color {
+			t.Errorf("frame %d color = %v, want %v", i, c, tt.color)
+		}
+		if frame.Duration != tt.duration || frame.Blend != tt.blend || frame.Dispose != tt.dispose {
+			t.Errorf("frame %d = %d ms blend=%v dispose=%v", i, frame.Duration, frame.Blend, frame.Dispose)
+		}
+	}
+}
+
+// TestDecodeAnimatedErrors tests limits and invalid input
+func TestDecodeAnimatedErrors(t *testing.T) {
+	if _, err := DecodeAnimatedWithLimits(bytes.NewReader(animatedSolid()), Limits{MaxFrames: 1}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("frame limit: err = %v, want ErrLimitExceeded", err)
+	}
+	if _, err := DecodeAnimatedWithLimits(bytes.NewReader(animatedSolid()), Limits{MaxInputBytes: 20}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("input limit: err = %v, want ErrLimitExceeded", err)
+	}
+	if _, err := DecodeAnimated(bytes.NewReader(nil)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("empty input: err = %v, want ErrTruncated", err)
+	}
+	if _, err := DecodeAnimated(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WEBPjunk"))); err == nil {
+		t.Error("invalid input: err = nil")
+	}
+}
@@ -1,17 +1,8 @@
 package native
 
-/*
-#cgo pkg-config: libwebp libwebpdemux
-#include <stdlib.h>
-#include <string.h>
-#include <webp/decode.h>
-#include <webp/demux.h>
-*/
-import "C"
 import (
 	"fmt"
 	"os"
-	"unsafe"
 )
 
 // WebPType represents the type of a WebP file
@@ -34,85 +25,64 @@ func (t WebPType) String() string {
 	}
 }
 
-// DetectWebPType detects if a WebP file is animated or static using libwebp
-func DetectWebPType(filePath string) (WebPType, error) {
-	// Read file into memory
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return WebPTypeUnknown, fmt.Errorf("failed to read file: %w", err)
-	}
-
-	if len(data) == 0 {
-		return WebPTypeUnknown, fmt.Errorf("file is empty")
-	}
-
-	// Allocate C memory and copy data to avoid CGO pointer issues
-	cData := C.malloc(C.size_t(len(data)))
-	if cData == nil {
-		return WebPTypeUnknown, fmt.Errorf("failed to allocate memory")
-	}
-	defer C.free(cData)
-
-	C.memcpy(cData, unsafe.Pointer(&data[0]), C.size_t(len(data)))
-
-	// Create WebPData structure
-	webpData := C.WebPData{
-		bytes: (*C.uint8_t)(cData),
-		size:  C.size_t(len(data)),
-	}
+// MarshalText implements encoding.TextMarshaler so the type serializes by name
+func (t WebPType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
 
-	// Create demuxer
-	demux := C.WebPDemux(&webpData)
-	if demux == nil {
-		return WebPTypeUnknown, fmt.Errorf("failed to create WebP demuxer")
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *WebPType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "static":
+		*t = WebPTypeStatic
+	case "animated":
+		*t = WebPTypeAnimated
+	case "unknown":
+		*t = WebPTypeUnknown
+	default:
+		return fmt.Errorf("unknown WebP type %q", text)
 	}
-	defer C.WebPDemuxDelete(demux)
-
-	// Get canvas information
-	flags := C.WebPDemuxGetI(demux, C.WEBP_FF_FORMAT_FLAGS)
+	return nil
+}
 
-	// Check if file has animation flag
-	if (flags & C.ANIMATION_FLAG) != 0 {
-		return WebPTypeAnimated, nil
+// ReadWebPHeaderFile parses the container header of a WebP file without reading its pixel data
+func ReadWebPHeaderFile(filePath string) (*WebPHeader, error) {
+	info, err := GetWebPInfo(filePath)
+	if err != nil {
+		return nil, err
 	}
 
-	return WebPTypeStatic, nil
+	header := info.Header()
+	return &header, nil
 }
 
-// GetWebPInfo returns basic information about a WebP file
-func GetWebPInfo(filePath string) (width, height, frameCount int, err error) {
-	data, err := os.ReadFile(filePath)
+// GetWebPInfo returns detailed container information about a WebP file,
+// read from its chunk headers without decoding
+func GetWebPInfo(filePath string) (*WebPInfo, error) {
+	f, err := os.Open(filePath)
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	defer f.Close()
 
-	if len(data) == 0 {
-		return 0, 0, 0, fmt.Errorf("file is empty")
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// Allocate C memory and copy data
-	cData := C.malloc(C.size_t(len(data)))
-	if cData == nil {
-		return 0, 0, 0, fmt.Errorf("failed to allocate memory")
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("%w: file is empty", ErrTruncated)
 	}
-	defer C.free(cData)
-
-	C.memcpy(cData, unsafe.Pointer(&data[0]), C.size_t(len(data)))
 
-	webpData := C.WebPData{
-		bytes: (*C.uint8_t)(cData),
-		size:  C.size_t(len(data)),
-	}
+	return ReadWebPInfo(f)
+}
 
-	demux := C.WebPDemux(&webpData)
-	if demux == nil {
-		return 0, 0, 0, fmt.Errorf("failed to create WebP demuxer")
+// DetectWebPType detects if a WebP file is animated or static from its container header
+func DetectWebPType(filePath string) (WebPType, error) {
+	header, err := ReadWebPHeaderFile(filePath)
+	if err != nil {
+		return WebPTypeUnknown, err
 	}
-	defer C.WebPDemuxDelete(demux)
-
-	width = int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_WIDTH))
-	height = int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_HEIGHT))
-	frameCount = int(C.WebPDemuxGetI(demux, C.WEBP_FF_FRAME_COUNT))
 
-	return width, height, frameCount, nil
+	return header.Type, nil
 }
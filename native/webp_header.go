@@ -0,0 +1,174 @@
+package native
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// VP8X feature flags
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagXMP       = 0x04
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagAlpha     = 0x10
+	vp8xFlagICC       = 0x20
+)
+
+// Chunk header and fixed payload sizes from the WebP container specification
+const (
+	riffHeaderSize  = 12
+	chunkHeaderSize = 8
+	vp8xPayloadSize = 10
+	animPayloadSize = 6
+	anmfHeaderSize  = 16
+	vp8HeaderSize   = 10
+	vp8lHeaderSize  = 5
+)
+
+// WebPHeader holds the container facts that can be read without decoding any pixels
+type WebPHeader struct {
+	Type       WebPType
+	Width      int  // Canvas width in pixels
+	Height     int  // Canvas height in pixels
+	HasAlpha   bool // Alpha flag (VP8X) or alpha hint (VP8L)
+	Lossless   bool // First image is VP8L-encoded
+	HasICC     bool
+	HasEXIF    bool
+	HasXMP     bool
+	LoopCount  int // Animation loop count (0 = infinite)
+	FrameCount int
+}
+
+// chunkHeader is a RIFF chunk header located at offset within the file
+type chunkHeader struct {
+	fourCC string
+	size   int64 // Payload size, without padding
+	offset int64 // Offset of the chunk header
+}
+
+// payload returns the offset of the chunk payload
+func (c chunkHeader) payload() int64 {
+	return c.offset + chunkHeaderSize
+}
+
+// next returns the offset of the following chunk (payloads are padded to even sizes)
+func (c chunkHeader) next() int64 {
+	return c.payload() + c.size + c.size&1
+}
+
+// readAt reads exactly n bytes at off, turning short reads into a truncation error
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	read, err := r.ReadAt(buf, off)
+	if read == n {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		return nil, fmt.Errorf("%w: file ends at offset %d", ErrTruncated, off+int64(read))
+	}
+	return nil, fmt.Errorf("failed to read file: %w", err)
+}
+
+// readChunkHeader reads the chunk header at off and checks it fits in the RIFF payload
+func readChunkHeader(r io.ReaderAt, off, riffEnd int64) (chunkHeader, error) {
+	buf, err := readAt(r, off, chunkHeaderSize)
+	if err != nil {
+		return chunkHeader{}, err
+	}
+
+	chunk := chunkHeader{
+		fourCC: string(buf[0:4]),
+		size:   int64(binary.LittleEndian.Uint32(buf[4:8])),
+		offset: off,
+	}
+	if chunk.payload()+chunk.size > riffEnd {
+		return chunkHeader{}, corruptf("chunk %q at offset %d exceeds RIFF size", chunk.fourCC, off)
+	}
+
+	return chunk, nil
+}
+
+// readRIFFHeader validates the RIFF/WEBP signature and returns the end offset of the RIFF payload
+func readRIFFHeader(r io.ReaderAt) (int64, error) {
+	buf, err := readAt(r, 0, riffHeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	if !IsWebPHeader(buf) {
+		return 0, corruptf("not a WebP file")
+	}
+
+	riffSize := int64(binary.LittleEndian.Uint32(buf[4:8]))
+	// The payload holds at least the "WEBP" tag and one chunk header
+	if riffSize < 4+chunkHeaderSize {
+		return 0, corruptf("invalid RIFF size %d", riffSize)
+	}
+
+	riffEnd := chunkHeaderSize + riffSize
+	// Make sure the file really holds the whole RIFF payload without reading it
+	if _, err := readAt(r, riffEnd-1, 1); err != nil {
+		return 0, err
+	}
+
+	return riffEnd, nil
+}
+
+// parseVP8Header reads the dimensions and profile from a lossy VP8 key frame header
+func parseVP8Header(r io.ReaderAt, chunk chunkHeader) (width, height, profile int, err error) {
+	if chunk.size < vp8HeaderSize {
+		return 0, 0, 0, corruptf("VP8 chunk too small")
+	}
+	buf, err := readAt(r, chunk.payload(), vp8HeaderSize)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if buf[0]&0x01 != 0 {
+		return 0, 0, 0, corruptf("VP8 frame is not a key frame")
+	}
+	if buf[3] != 0x9d || buf[4] != 0x01 || buf[5] != 0x2a {
+		return 0, 0, 0, corruptf("invalid VP8 start code")
+	}
+
+	profile = int(buf[0]>>1) & 0x07
+	width = int(binary.LittleEndian.Uint16(buf[6:8]) & 0x3fff)
+	height = int(binary.LittleEndian.Uint16(buf[8:10]) & 0x3fff)
+	return width, height, profile, nil
+}
+
+// parseVP8LHeader reads the dimensions and alpha hint from a lossless VP8L header
+func parseVP8LHeader(r io.ReaderAt, chunk chunkHeader) (width, height int, hasAlpha bool, err error) {
+	if chunk.size < vp8lHeaderSize {
+		return 0, 0, false, corruptf("VP8L chunk too small")
+	}
+	buf, err := readAt(r, chunk.payload(), vp8lHeaderSize)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if buf[0] != 0x2f {
+		return 0, 0, false, corruptf("invalid VP8L signature")
+	}
+
+	bits := binary.LittleEndian.Uint32(buf[1:5])
+	if bits>>29 != 0 {
+		return 0, 0, false, fmt.Errorf("%w: VP8L version %d", ErrUnsupportedFeature, bits>>29)
+	}
+
+	width = int(bits&0x3fff) + 1
+	height = int((bits>>14)&0x3fff) + 1
+	hasAlpha = (bits>>28)&1 != 0
+	return width, height, hasAlpha, nil
+}
+
+// ReadWebPHeader parses the WebP container from r. Only the RIFF, VP8X and
+// image bitstream headers are read, plus the chunk headers needed to count
+// animation frames, so the cost is independent of the pixel data size.
+func ReadWebPHeader(r io.ReaderAt) (*WebPHeader, error) {
+	info, err := ReadWebPInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header := info.Header()
+	return &header, nil
+}
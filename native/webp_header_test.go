@@ -0,0 +1,220 @@
+package native
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// chunk builds a RIFF chunk with padding
+func chunk(fourCC string, payload []byte) []byte {
+	buf := make([]byte, 8, 8+len(payload)+1)
+	copy(buf, fourCC)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(payload)))
+	buf = append(buf, payload...)
+	if len(payload)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// riff wraps chunks in a RIFF/WEBP container
+func riff(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	buf := make([]byte, 12, 12+len(body))
+	copy(buf, "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(4+len(body)))
+	copy(buf[8:], "WEBP")
+	return append(buf, body...)
+}
+
+// put24 writes a 24-bit little-endian value
+func put24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func vp8Payload(width, height int) []byte {
+	p := make([]byte, 32)
+	p[3], p[4], p[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(p[6:], uint16(width))
+	binary.LittleEndian.PutUint16(p[8:], uint16(height))
+	return p
+}
+
+func vp8lPayload(width, height int, alpha bool) []byte {
+	p := make([]byte, 16)
+	p[0] = 0x2f
+	bits := uint32(width-1) | uint32(height-1)<<14
+	if alpha {
+		bits |= 1 << 28
+	}
+	binary.LittleEndian.PutUint32(p[1:], bits)
+	return p
+}
+
+// solidVP8L builds a lossless bitstream of a single color. Every prefix code
+// has one symbol, so the pixels take no bits.
+func solidVP8L(width, height int, c color.NRGBA) []byte {
+	out := []byte{0x2f}
+	var bits uint64
+	var n uint
+	put := func(v uint64, count uint) {
+		bits |= v << n
+		for n += count; n >= 8; n -= 8 {
+			out = append(out, byte(bits))
+			bits >>= 8
+		}
+	}
+
+	put(uint64(width-1), 14)
+	put(uint64(height-1), 14)
+	put(0, 1) // Alpha hint
+	put(0, 3) // Version
+	put(0, 1) // No transform
+	put(0, 1) // No color cache
+	put(0, 1) // No meta prefix codes
+	for _, v := range []uint8{c.G, c.R, c.B, c.A} {
+		put(1, 1)         // Simple code
+		put(0, 1)         // One symbol
+		put(1, 1)         // 8-bit symbol
+		put(uint64(v), 8) // Symbol
+	}
+	put(0b0001, 4) // Distance: simple code, one 1-bit symbol 0
+	if n > 0 {
+		out = append(out, byte(bits))
+	}
+	return out
+}
+
+func vp8xPayload(flags byte, width, height int) []byte {
+	p := make([]byte, 10)
+	p[0] = flags
+	put24(p[4:], width-1)
+	put24(p[7:], height-1)
+	return p
+}
+
+func anmfPayload(width, height, duration int, frame []byte) []byte {
+	p := make([]byte, 16)
+	put24(p[6:], width-1)
+	put24(p[9:], height-1)
+	put24(p[12:], duration)
+	return append(p, frame...)
+}
+
+// countingReaderAt records how many bytes were read
+type countingReaderAt struct {
+	data []byte
+	read int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(r.data).ReadAt(p, off)
+	r.read += n
+	return n, err
+}
+
+// TestReadWebPHeader tests header parsing of simple and extended containers
+func TestReadWebPHeader(t *testing.T) {
+	animated := riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation|vp8xFlagAlpha|vp8xFlagICC, 300, 200)),
+		chunk("ICCP", make([]byte, 11)),
+		chunk("ANIM", []byte{0, 0, 0, 0, 3, 0}),
+		chunk("ANMF", anmfPayload(300, 200, 100, append(chunk("ALPH", make([]byte, 5)), chunk("VP8 ", vp8Payload(300, 200))...))),
+		chunk("ANMF", anmfPayload(300, 200, 100, chunk("VP8L", vp8lPayload(300, 200, true)))),
+		chunk("ANMF", anmfPayload(10, 10, 100, chunk("VP8L", vp8lPayload(10, 10, true)))),
+		chunk("EXIF", make([]byte, 7)),
+	)
+
+	tests := []struct {
+		name string
+		data []byte
+		want WebPHeader
+	}{
+		{
+			name: "lossy",
+			data: riff(chunk("VP8 ", vp8Payload(640, 480))),
+			want: WebPHeader{Type: WebPTypeStatic, Width: 640, Height: 480, FrameCount: 1},
+		},
+		{
+			name: "lossless",
+			data: riff(chunk("VP8L", vp8lPayload(17, 9, true))),
+			want: WebPHeader{Type: WebPTypeStatic, Width: 17, Height: 9, HasAlpha: true, Lossless: true, FrameCount: 1},
+		},
+		{
+			name: "extended static",
+			data: riff(chunk("VP8X", vp8xPayload(vp8xFlagAlpha|vp8xFlagXMP, 64, 32)), chunk("ALPH", make([]byte, 3)), chunk("VP8 ", vp8Payload(64, 32)), chunk("XMP ", make([]byte, 4))),
+			want: WebPHeader{Type: WebPTypeStatic, Width: 64, Height: 32, HasAlpha: true, HasXMP: true, FrameCount: 1},
+		},
+		{
+			name: "animated",
+			data: animated,
+			want: WebPHeader{Type: WebPTypeAnimated, Width: 300, Height: 200, HasAlpha: true, HasICC: true, HasEXIF: true, LoopCount: 3, FrameCount: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadWebPHeader(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("ReadWebPHeader failed: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadWebPHeaderReadsOnlyHeaders tests that pixel data is never read
+func TestReadWebPHeaderReadsOnlyHeaders(t *testing.T) {
+	frame := chunk("VP8 ", append(vp8Payload(100, 100), make([]byte, 64*1024)...))
+	data := riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 100, 100)),
+		chunk("ANIM", make([]byte, 6)),
+		chunk("ANMF", anmfPayload(100, 100, 50, frame)),
+		chunk("ANMF", anmfPayload(100, 100, 50, frame)),
+	)
+
+	r := &countingReaderAt{data: data}
+	header, err := ReadWebPHeader(r)
+	if err != nil {
+		t.Fatalf("ReadWebPHeader failed: %v", err)
+	}
+	if header.FrameCount != 2 {
+		t.Errorf("FrameCount = %d, want 2", header.FrameCount)
+	}
+	if r.read > 256 {
+		t.Errorf("read %d of %d bytes, want only headers", r.read, len(data))
+	}
+}
+
+// TestReadWebPHeaderErrors tests rejection of invalid and truncated files
+func TestReadWebPHeaderErrors(t *testing.T) {
+	valid := riff(chunk("VP8 ", vp8Payload(8, 8)))
+
+	tests := map[string][]byte{
+		"empty":          {},
+		"not webp":       []byte("RIFF\x04\x00\x00\x00WAVEfmt "),
+		"jpeg":           {0xFF, 0xD8, 0xFF, 0xE0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"truncated":      valid[:len(valid)-4],
+		"bad chunk":      riff(chunk("JUNK", make([]byte, 10))),
+		"bad VP8":        riff(chunk("VP8 ", make([]byte, 10))),
+		"no image":       riff(chunk("VP8X", vp8xPayload(0, 8, 8))),
+		"short VP8X":     riff(chunk("VP8X", make([]byte, 4))),
+		"bad VP8L":       riff(chunk("VP8L", make([]byte, 5))),
+		"huge chunk":     append(valid[:16:16], 0xff, 0xff, 0xff, 0x7f),
+		"no chunks":      []byte("RIFF\x04\x00\x00\x00WEBP"),
+		"short riff":     []byte("RIFF"),
+		"truncated VP8X": riff(chunk("VP8X", vp8xPayload(vp8xFlagAnimation, 8, 8)))[:20],
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadWebPHeader(bytes.NewReader(data)); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
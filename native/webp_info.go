@@ -0,0 +1,297 @@
+package native
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// ALPH chunk header values, indexed by the corresponding bit field
+var (
+	alphaCompressionNames = [4]string{"none", "lossless", "reserved", "reserved"}
+	alphaFilterNames      = [4]string{"none", "horizontal", "vertical", "gradient"}
+)
+
+// FrameHints holds encoder settings visible in a frame's bitstream headers
+type FrameHints struct {
+	VP8Profile         int    `json:"vp8_profile,omitempty"`         // Lossy: VP8 version/profile (0-3)
+	AlphaCompression   string `json:"alpha_compression,omitempty"`   // ALPH: "none" or "lossless"
+	AlphaFilter        string `json:"alpha_filter,omitempty"`        // ALPH: prediction filter
+	AlphaPreprocessing bool   `json:"alpha_preprocessing,omitempty"` // ALPH: level reduction applied
+}
+
+// FrameInfo describes one frame of a WebP image. Static images have a single
+// frame covering the canvas.
+type FrameInfo struct {
+	Index    int        `json:"index"`
+	X        int        `json:"x"`
+	Y        int        `json:"y"`
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Duration int        `json:"duration_ms"`
+	Blend    bool       `json:"blend"`   // Alpha-blend onto the previous canvas (otherwise overwrite)
+	Dispose  bool       `json:"dispose"` // Dispose the frame area to background after display
+	Lossless bool       `json:"lossless"`
+	HasAlpha bool       `json:"has_alpha"`
+	Offset   int64      `json:"offset"` // File offset of the frame's first chunk
+	Size     int64      `json:"size"`   // Total size of the frame's chunks in bytes
+	Hints    FrameHints `json:"hints"`
+}
+
+// MetadataInfo holds the payload sizes of the metadata chunks (0 if absent)
+type MetadataInfo struct {
+	ICCSize  int64 `json:"icc_size"`
+	EXIFSize int64 `json:"exif_size"`
+	XMPSize  int64 `json:"xmp_size"`
+}
+
+// WebPInfo describes a WebP container in detail, down to every animation frame.
+// It is read from chunk and bitstream headers only; no pixel data is decoded,
+// so Colors is left for a content analysis to fill in.
+type WebPInfo struct {
+	Type             WebPType     `json:"type"`
+	Width            int          `json:"width"`  // Canvas width
+	Height           int          `json:"height"` // Canvas height
+	HasAlpha         bool         `json:"has_alpha"`
+	Lossless         bool         `json:"lossless"`          // Every frame is VP8L-encoded
+	MixedCompression bool         `json:"mixed_compression"` // Frames mix lossy and lossless encoding
+	Extended         bool         `json:"extended"`          // Uses the VP8X extended format
+	LoopCount        int          `json:"loop_count"`        // 0 = infinite
+	BackgroundColor  color.NRGBA  `json:"background_color"`
+	Duration         int          `json:"duration_ms"` // Sum of frame durations
+	FrameCount       int          `json:"frame_count"`
+	Frames           []FrameInfo  `json:"frames"`
+	Metadata         MetadataInfo `json:"metadata"`
+	FileSize         int64        `json:"file_size"`        // Size declared by the RIFF header
+	Colors           int          `json:"colors,omitempty"` // ContentInfo.Colors if decoded, else 0
+}
+
+// Header summarizes the info as a WebPHeader
+func (info *WebPInfo) Header() WebPHeader {
+	header := WebPHeader{
+		Type:       info.Type,
+		Width:      info.Width,
+		Height:     info.Height,
+		HasAlpha:   info.HasAlpha,
+		HasICC:     info.Metadata.ICCSize > 0,
+		HasEXIF:    info.Metadata.EXIFSize > 0,
+		HasXMP:     info.Metadata.XMPSize > 0,
+		LoopCount:  info.LoopCount,
+		FrameCount: info.FrameCount,
+	}
+	if len(info.Frames) > 0 {
+		header.Lossless = info.Frames[0].Lossless
+	}
+	return header
+}
+
+// parseFrameImage reads the optional ALPH chunk and the VP8/VP8L bitstream header
+// of one image starting at off, and returns the offset just past the image
+func parseFrameImage(r io.ReaderAt, off, end int64, frame *FrameInfo) (int64, error) {
+	chunk, err := readChunkHeader(r, off, end)
+	if err != nil {
+		return 0, err
+	}
+
+	if chunk.fourCC == "ALPH" {
+		if chunk.size < 1 {
+			return 0, corruptf("ALPH chunk too small")
+		}
+		buf, err := readAt(r, chunk.payload(), 1)
+		if err != nil {
+			return 0, err
+		}
+		frame.HasAlpha = true
+		frame.Hints.AlphaCompression = alphaCompressionNames[buf[0]&0x03]
+		frame.Hints.AlphaFilter = alphaFilterNames[(buf[0]>>2)&0x03]
+		frame.Hints.AlphaPreprocessing = (buf[0]>>4)&0x03 == 1
+
+		if chunk.next()+chunkHeaderSize > end {
+			return 0, corruptf("ALPH chunk without image data")
+		}
+		chunk, err = readChunkHeader(r, chunk.next(), end)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var width, height int
+	switch chunk.fourCC {
+	case "VP8 ":
+		width, height, frame.Hints.VP8Profile, err = parseVP8Header(r, chunk)
+	case "VP8L":
+		var hasAlpha bool
+		width, height, hasAlpha, err = parseVP8LHeader(r, chunk)
+		frame.Lossless = true
+		frame.HasAlpha = hasAlpha
+	default:
+		return 0, corruptf("expected image chunk, found %q", chunk.fourCC)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// ANMF frames carry their own size; static images take it from the bitstream
+	if frame.Width == 0 {
+		frame.Width, frame.Height = width, height
+	}
+	frame.Size = chunk.next() - frame.Offset
+
+	return chunk.next(), nil
+}
+
+// parseANMF reads an animation frame header and its image
+func parseANMF(r io.ReaderAt, chunk chunkHeader) (FrameInfo, error) {
+	if chunk.size < anmfHeaderSize+chunkHeaderSize {
+		return FrameInfo{}, corruptf("ANMF chunk too small")
+	}
+	buf, err := readAt(r, chunk.payload(), anmfHeaderSize)
+	if err != nil {
+		return FrameInfo{}, err
+	}
+
+	frame := FrameInfo{
+		X:        2 * int(uint24(buf[0:3])),
+		Y:        2 * int(uint24(buf[3:6])),
+		Width:    int(uint24(buf[6:9])) + 1,
+		Height:   int(uint24(buf[9:12])) + 1,
+		Duration: int(uint24(buf[12:15])),
+		Blend:    buf[15]&0x02 == 0,
+		Dispose:  buf[15]&0x01 != 0,
+		Offset:   chunk.offset,
+	}
+
+	end := chunk.payload() + chunk.size
+	if _, err := parseFrameImage(r, chunk.payload()+anmfHeaderSize, end, &frame); err != nil {
+		return FrameInfo{}, fmt.Errorf("frame at offset %d: %w", chunk.offset, err)
+	}
+	// The frame spans the whole ANMF chunk
+	frame.Size = chunk.next() - chunk.offset
+
+	return frame, nil
+}
+
+// uint24 decodes a 24-bit little-endian value
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// ReadWebPInfo parses the WebP container from r, reading only chunk and
+// bitstream headers (a few dozen bytes per frame)
+func ReadWebPInfo(r io.ReaderAt) (*WebPInfo, error) {
+	riffEnd, err := readRIFFHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := readChunkHeader(r, riffHeaderSize, riffEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &WebPInfo{Type: WebPTypeStatic, FileSize: riffEnd}
+
+	switch first.fourCC {
+	case "VP8 ", "VP8L":
+		frame := FrameInfo{Offset: first.offset}
+		if _, err := parseFrameImage(r, first.offset, riffEnd, &frame); err != nil {
+			return nil, err
+		}
+		info.addFrame(frame)
+		info.Width, info.Height = frame.Width, frame.Height
+		info.HasAlpha = frame.HasAlpha
+		return info, nil
+
+	case "VP8X":
+		// Extended format, handled below
+
+	default:
+		return nil, corruptf("unexpected first chunk %q", first.fourCC)
+	}
+
+	if first.size < vp8xPayloadSize {
+		return nil, corruptf("VP8X chunk too small")
+	}
+	buf, err := readAt(r, first.payload(), vp8xPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := buf[0]
+	info.Extended = true
+	info.Width = int(uint24(buf[4:7])) + 1
+	info.Height = int(uint24(buf[7:10])) + 1
+	info.HasAlpha = flags&vp8xFlagAlpha != 0
+	if flags&vp8xFlagAnimation != 0 {
+		info.Type = WebPTypeAnimated
+	}
+
+	for off := first.next(); off+chunkHeaderSize <= riffEnd; {
+		chunk, err := readChunkHeader(r, off, riffEnd)
+		if err != nil {
+			return nil, err
+		}
+		off = chunk.next()
+
+		switch chunk.fourCC {
+		case "ICCP":
+			info.Metadata.ICCSize = chunk.size
+		case "EXIF":
+			info.Metadata.EXIFSize = chunk.size
+		case "XMP ":
+			info.Metadata.XMPSize = chunk.size
+
+		case "ANIM":
+			if chunk.size < animPayloadSize {
+				return nil, corruptf("ANIM chunk too small")
+			}
+			anim, err := readAt(r, chunk.payload(), animPayloadSize)
+			if err != nil {
+				return nil, err
+			}
+			// Stored in [Blue, Green, Red, Alpha] byte order
+			info.BackgroundColor = color.NRGBA{R: anim[2], G: anim[1], B: anim[0], A: anim[3]}
+			info.LoopCount = int(binary.LittleEndian.Uint16(anim[4:6]))
+
+		case "ANMF":
+			frame, err := parseANMF(r, chunk)
+			if err != nil {
+				return nil, err
+			}
+			info.addFrame(frame)
+
+		case "ALPH", "VP8 ", "VP8L":
+			// Still image of an extended file; only one is allowed
+			if info.FrameCount > 0 {
+				continue
+			}
+			frame := FrameInfo{Offset: chunk.offset}
+			next, err := parseFrameImage(r, chunk.offset, riffEnd, &frame)
+			if err != nil {
+				return nil, err
+			}
+			info.addFrame(frame)
+			off = next
+		}
+	}
+
+	if info.FrameCount == 0 && info.Type == WebPTypeStatic {
+		return nil, corruptf("no image data found")
+	}
+
+	return info, nil
+}
+
+// addFrame appends a frame and updates the aggregate fields
+func (info *WebPInfo) addFrame(frame FrameInfo) {
+	frame.Index = len(info.Frames)
+	info.Frames = append(info.Frames, frame)
+	info.FrameCount = len(info.Frames)
+	info.Duration += frame.Duration
+
+	if frame.Lossless != info.Frames[0].Lossless {
+		info.MixedCompression = true
+	}
+	info.Lossless = info.Frames[0].Lossless && !info.MixedCompression
+}
@@ -0,0 +1,72 @@
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// TestReadWebPInfo tests per-frame details of an animated container
+func TestReadWebPInfo(t *testing.T) {
+	frame1 := anmfPayload(300, 200, 80, append(chunk("ALPH", []byte{0x05, 0, 0}), chunk("VP8 ", vp8Payload(300, 200))...))
+	frame2 := anmfPayload(10, 20, 120, chunk("VP8L", vp8lPayload(10, 20, false)))
+	put24(frame2[0:], 5)  // X = 10
+	put24(frame2[3:], 15) // Y = 30
+	frame2[15] = 0x03     // No blending, dispose to background
+
+	data := riff(
+		chunk("VP8X", vp8xPayload(vp8xFlagAnimation|vp8xFlagAlpha, 300, 200)),
+		chunk("ANIM", []byte{0x10, 0x20, 0x30, 0xff, 2, 0}),
+		chunk("ANMF", frame1),
+		chunk("ANMF", frame2),
+		chunk("XMP ", make([]byte, 9)),
+	)
+
+	info, err := ReadWebPInfo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadWebPInfo failed: %v", err)
+	}
+
+	if info.Type != WebPTypeAnimated || info.FrameCount != 2 || info.Duration != 200 || info.LoopCount != 2 {
+		t.Errorf("unexpected summary: %+v", info)
+	}
+	if want := (color.NRGBA{R: 0x30, G: 0x20, B: 0x10, A: 0xff}); info.BackgroundColor != want {
+		t.Errorf("BackgroundColor = %v, want %v", info.BackgroundColor, want)
+	}
+	if !info.MixedCompression || info.Lossless {
+		t.Errorf("Lossless = %v, MixedCompression = %v", info.Lossless, info.MixedCompression)
+	}
+	if info.Metadata.XMPSize != 9 {
+		t.Errorf("XMPSize = %d, want 9", info.Metadata.XMPSize)
+	}
+
+	want := []FrameInfo{
+		{
+			Index: 0, Width: 300, Height: 200, Duration: 80, Blend: true, HasAlpha: true,
+			Offset: 12 + 8 + 10 + 8 + 6, Size: int64(8 + len(frame1)),
+			Hints: FrameHints{AlphaCompression: "lossless", AlphaFilter: "horizontal"},
+		},
+		{
+			Index: 1, X: 10, Y: 30, Width: 10, Height: 20, Duration: 120, Dispose: true, Lossless: true,
+			Offset: 12 + 8 + 10 + 8 + 6 + 8 + int64(len(frame1)), Size: int64(8 + len(frame2)),
+		},
+	}
+	if !reflect.DeepEqual(info.Frames, want) {
+		t.Errorf("Frames = %+v\nwant %+v", info.Frames, want)
+	}
+
+	// The info must survive a JSON round trip for ingestion by other tools
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var decoded WebPInfo
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, info) {
+		t.Errorf("JSON round trip mismatch:\n%s", encoded)
+	}
+}
@@ -1,279 +1,134 @@
 package native
 
-/*
-#cgo pkg-config: libwebp libwebpdemux
-#cgo LDFLAGS: -lgif
-#include <stdlib.h>
-#include <string.h>
-#include <webp/decode.h>
-#include <webp/demux.h>
-#include <webp/mux_types.h>
-#include <gif_lib.h>
-*/
-import "C"
 import (
 	"fmt"
+	"image"
+	"io"
 	"os"
-	"unsafe"
 )
 
 // ConvertWebPToGIF converts an animated WebP file to GIF format
 func ConvertWebPToGIF(inputPath, outputPath string) error {
+	return ConvertWebPToGIFWithLimits(inputPath, outputPath, Limits{})
+}
+
+// ConvertWebPToGIFWithLimits is ConvertWebPToGIF for untrusted input: the
+// file size, canvas and frame count are checked against limits before decoding
+func ConvertWebPToGIFWithLimits(inputPath, outputPath string, limits Limits) (err error) {
 	// Read WebP file
-	data, err := os.ReadFile(inputPath)
+	data, err := readInput(inputPath, limits)
 	if err != nil {
-		return fmt.Errorf("failed to read WebP file: %w", err)
-	}
-
-	if len(data) == 0 {
-		return fmt.Errorf("WebP file is empty")
-	}
-
-	// Allocate C memory and copy data to avoid CGO pointer issues
-	cData := C.malloc(C.size_t(len(data)))
-	if cData == nil {
-		return fmt.Errorf("failed to allocate memory")
-	}
-	defer C.free(cData)
-
-	C.memcpy(cData, unsafe.Pointer(&data[0]), C.size_t(len(data)))
-
-	// Create WebPData structure
-	webpData := C.WebPData{
-		bytes: (*C.uint8_t)(cData),
-		size:  C.size_t(len(data)),
+		return err
 	}
 
-	// Create demuxer
-	demux := C.WebPDemux(&webpData)
-	if demux == nil {
-		return fmt.Errorf("failed to create WebP demuxer")
-	}
-	defer C.WebPDemuxDelete(demux)
-
-	// Get canvas info
-	width := int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_WIDTH))
-	height := int(C.WebPDemuxGetI(demux, C.WEBP_FF_CANVAS_HEIGHT))
-	frameCount := int(C.WebPDemuxGetI(demux, C.WEBP_FF_FRAME_COUNT))
-
-	if frameCount == 0 {
-		return fmt.Errorf("no frames found in WebP file")
+	d, err := newDemuxer(data, limits)
+	if err != nil {
+		return err
 	}
+	defer d.close()
 
 	// Open GIF file for writing
-	cFilename := C.CString(outputPath)
-	defer C.free(unsafe.Pointer(cFilename))
-
-	var errCode C.int
-	gifFile := C.EGifOpenFileName(cFilename, C.bool(false), &errCode)
-	if gifFile == nil {
-		return fmt.Errorf("failed to create GIF file: error code %d", errCode)
-	}
-	defer C.EGifCloseFile(gifFile, &errCode)
-
-	// Set GIF screen descriptor WITHOUT global color map (use local per frame)
-	// This allows each frame to have its own optimized 256-color palette
-	if C.EGifPutScreenDesc(gifFile, C.int(width), C.int(height), 8, 0, nil) == C.GIF_ERROR {
-		return fmt.Errorf("failed to write GIF screen descriptor")
-	}
-
-	// Add Netscape 2.0 extension for looping
-	if err := addLoopingExtension(gifFile); err != nil {
-		return fmt.Errorf("failed to add looping extension: %w", err)
-	}
-
-	// Iterate through frames
-	var iter C.WebPIterator
-	if C.WebPDemuxGetFrame(demux, 1, &iter) == 0 {
-		return fmt.Errorf("failed to get first frame")
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return &EncodeError{Format: "gif", Op: "create file", Err: err}
 	}
-	defer C.WebPDemuxReleaseIterator(&iter)
-
-	for {
-		// Decode frame with proper blending/compositing
-		// Use WebPDecodeRGBA on the full fragment to get properly composited frame
-		fragmentSize := int(iter.fragment.size)
-
-		var outWidth, outHeight C.int
-		rgbaData := C.WebPDecodeRGBA(
-			iter.fragment.bytes,
-			C.size_t(fragmentSize),
-			&outWidth,
-			&outHeight,
-		)
-
-		if rgbaData == nil {
-			return fmt.Errorf("failed to decode frame %d", iter.frame_num)
-		}
-		defer C.WebPFree(unsafe.Pointer(rgbaData))
-
-		// Convert RGBA to RGB pixels
-		frameWidth := int(outWidth)
-		frameHeight := int(outHeight)
-		pixelCount := frameWidth * frameHeight
-		rgbPixels := make([]RGB, pixelCount)
-
-		rgbaSlice := unsafe.Slice((*byte)(rgbaData), pixelCount*4)
-		for i := 0; i < pixelCount; i++ {
-			rgbPixels[i] = RGB{
-				R: rgbaSlice[i*4],
-				G: rgbaSlice[i*4+1],
-				B: rgbaSlice[i*4+2],
-				// Skip alpha channel (i*4+3)
-			}
-		}
-
-		// Quantize frame to 256 colors using Octree (like Pillow does)
-		// Use simple Octree without dithering to match Python/Pillow behavior
-		indexedData, framePalette := QuantizeImageOctreeWithDimensions(rgbPixels, 256, frameWidth, frameHeight)
-
-		// Create local color map for this frame
-		localColorMap := C.GifMakeMapObject(256, nil)
-		if localColorMap == nil {
-			return fmt.Errorf("failed to create local color map for frame %d", iter.frame_num)
-		}
-
-		// Copy frame palette to local color map
-		localColors := unsafe.Slice(localColorMap.Colors, len(framePalette))
-		for i := 0; i < len(framePalette); i++ {
-			localColors[i].Red = C.GifByteType(framePalette[i].R)
-			localColors[i].Green = C.GifByteType(framePalette[i].G)
-			localColors[i].Blue = C.GifByteType(framePalette[i].B)
+	// Closing flushes the last data, so its failure fails the conversion
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = &EncodeError{Format: "gif", Op: "close file", Err: closeErr}
 		}
+	}()
 
-		// Add graphics control extension (for timing)
-		duration := int(iter.duration) / 10 // Convert ms to centiseconds
-		if duration < 1 {
-			duration = 10 // Default 100ms
-		}
-
-		var gce [4]C.GifByteType
-		// Disposal method: 0 = unspecified (let decoder decide, like Pillow)
-		gce[0] = 0x00 // No disposal method specified
-		gce[1] = C.GifByteType(duration & 0xff)
-		gce[2] = C.GifByteType((duration >> 8) & 0xff)
-		gce[3] = 0 // No transparent color
-
-		if C.EGifPutExtension(gifFile, C.GRAPHICS_EXT_FUNC_CODE, 4, unsafe.Pointer(&gce[0])) == C.GIF_ERROR {
-			C.GifFreeMapObject(localColorMap)
-			return fmt.Errorf("failed to write graphics control extension")
-		}
-
-		// Write frame WITH local color map
-		if C.EGifPutImageDesc(gifFile, 0, 0, C.int(frameWidth), C.int(frameHeight), C.bool(false), localColorMap) == C.GIF_ERROR {
-			C.GifFreeMapObject(localColorMap)
-			return fmt.Errorf("failed to write image descriptor for frame %d", iter.frame_num)
-		}
-
-		// Write scanlines
-		for y := 0; y < frameHeight; y++ {
-			line := (*C.GifByteType)(unsafe.Pointer(&indexedData[y*frameWidth]))
-			if C.EGifPutLine(gifFile, line, C.int(frameWidth)) == C.GIF_ERROR {
-				return fmt.Errorf("failed to write scanline %d in frame %d", y, iter.frame_num)
-			}
-		}
+	return transcodeGIF(d, out)
+}
 
-		// Move to next frame
-		if C.WebPDemuxNextFrame(&iter) == 0 {
-			break
-		}
+// ConvertWebPToGIFStream reads an animated WebP image from r and writes it to
+// w as a GIF. The input size, canvas and frame count are checked against
+// limits before decoding. On error, part of the GIF may have been written.
+func ConvertWebPToGIFStream(r io.Reader, w io.Writer, limits Limits) error {
+	data, err := ReadInput(r, limits)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return gifFormat{}.Encode(w, data, EncodeOptions{Limits: limits})
 }
 
-// addLoopingExtension adds Netscape 2.0 extension for infinite looping
-func addLoopingExtension(gifFile *C.GifFileType) error {
-	// Netscape 2.0 application extension
-	appExt := []byte("NETSCAPE2.0")
-	if C.EGifPutExtensionLeader(gifFile, C.APPLICATION_EXT_FUNC_CODE) == C.GIF_ERROR {
-		return fmt.Errorf("failed to write extension leader")
-	}
-
-	if C.EGifPutExtensionBlock(gifFile, C.int(len(appExt)), unsafe.Pointer(&appExt[0])) == C.GIF_ERROR {
-		return fmt.Errorf("failed to write application extension")
+// EncodeGIF writes anim to w as a GIF that loops forever, like the output of
+// ConvertWebPToGIF. Each frame is quantized to its own 256-color palette and
+// placed at its bounds on the canvas; transparency is not kept.
+func EncodeGIF(w io.Writer, anim *Animation) (err error) {
+	if len(anim.Frames) == 0 {
+		return fmt.Errorf("%w: gif: animation has no frames", ErrEncode)
 	}
 
-	// Loop count sub-block (0 = infinite)
-	loopBlock := []byte{1, 0, 0} // sub-block id=1, loop count=0 (infinite)
-	if C.EGifPutExtensionBlock(gifFile, 3, unsafe.Pointer(&loopBlock[0])) == C.GIF_ERROR {
-		return fmt.Errorf("failed to write loop sub-block")
+	enc, err := newGIFEncoder(w, anim.Width, anim.Height)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if closeErr := enc.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
 
-	if C.EGifPutExtensionTrailer(gifFile) == C.GIF_ERROR {
-		return fmt.Errorf("failed to write extension trailer")
+	for i, frame := range anim.Frames {
+		if err := enc.writeFrame(frame, i+1); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-// analyzeAllFramesForGlobalPalette analyzes all frames to create a global color palette
-// This prevents color flickering between frames in the output GIF
-func analyzeAllFramesForGlobalPalette(demux *C.WebPDemuxer, width, height, frameCount int) ([]RGB, error) {
-	// Collect colors from all frames
-	allColors := make(map[uint32]int) // color -> frequency
-
-	var iter C.WebPIterator
-	if C.WebPDemuxGetFrame(demux, 1, &iter) == 0 {
-		return nil, fmt.Errorf("failed to get first frame for analysis")
+// transcodeGIF writes the frames of d to w as a GIF, decoding one frame at a time
+func transcodeGIF(d *demuxer, w io.Writer) (err error) {
+	enc, err := newGIFEncoder(w, d.width, d.height)
+	if err != nil {
+		return err
 	}
-	defer C.WebPDemuxReleaseIterator(&iter)
-
-	frameNum := 0
-	for {
-		frameNum++
-
-		// Decode frame properly
-		fragmentSize := int(iter.fragment.size)
-
-		var outWidth, outHeight C.int
-		rgbaData := C.WebPDecodeRGBA(
-			iter.fragment.bytes,
-			C.size_t(fragmentSize),
-			&outWidth,
-			&outHeight,
-		)
-
-		if rgbaData == nil {
-			return nil, fmt.Errorf("failed to decode frame %d during analysis", frameNum)
-		}
-
-		// Collect colors from RGBA data
-		frameWidth := int(outWidth)
-		frameHeight := int(outHeight)
-		pixelCount := frameWidth * frameHeight
-
-		rgbaSlice := unsafe.Slice((*byte)(rgbaData), pixelCount*4)
-		for i := 0; i < pixelCount; i++ {
-			r := uint32(rgbaSlice[i*4])
-			g := uint32(rgbaSlice[i*4+1])
-			b := uint32(rgbaSlice[i*4+2])
-			colorKey := (r << 16) | (g << 8) | b
-			allColors[colorKey]++
+	defer func() {
+		if closeErr := enc.close(); closeErr != nil && err == nil {
+			err = closeErr
 		}
+	}()
 
-		C.WebPFree(unsafe.Pointer(rgbaData))
+	index := 0
+	return d.eachFrame(func(frame Frame) error {
+		index++
+		return enc.writeFrame(frame, index)
+	})
+}
 
-		// Move to next frame
-		if C.WebPDemuxNextFrame(&iter) == 0 {
-			break
+// quantizeFrame reduces the pixels of a frame to its own 256-color palette
+// and returns the palette indices, row by row
+func quantizeFrame(img *image.NRGBA) ([]byte, []RGB) {
+	bounds := img.Bounds()
+	frameWidth := bounds.Dx()
+	frameHeight := bounds.Dy()
+
+	// Convert RGBA to RGB pixels
+	rgbPixels := make([]RGB, 0, frameWidth*frameHeight)
+	for y := 0; y < frameHeight; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+frameWidth*4]
+		for x := 0; x < len(row); x += 4 {
+			// Skip alpha channel (x+3)
+			rgbPixels = append(rgbPixels, RGB{R: row[x], G: row[x+1], B: row[x+2]})
 		}
 	}
 
-	// Convert color histogram to RGB slice
-	colorList := make([]RGB, 0, len(allColors))
-	for colorKey := range allColors {
-		r := byte(colorKey >> 16)
-		g := byte(colorKey >> 8)
-		b := byte(colorKey)
-		colorList = append(colorList, RGB{R: r, G: g, B: b})
-	}
-
-	// Use Octree to quantize to 256 colors
-	// Use reasonable dimensions for dithering (not needed for palette generation)
-	_, globalPalette := QuantizeImageOctreeWithDimensions(colorList, 256, width, height)
+	// Quantize frame to 256 colors using Octree (like Pillow does)
+	// Use simple Octree without dithering to match Python/Pillow behavior
+	return QuantizeImageOctreeWithDimensions(rgbPixels, 256, frameWidth, frameHeight)
+}
 
-	return globalPalette, nil
+// gifDelay converts a frame duration in milliseconds to the centiseconds of
+// a GIF graphics control extension
+func gifDelay(duration int) int {
+	duration /= 10 // Convert ms to centiseconds
+	if duration < 1 {
+		duration = 10 // Default 100ms
+	}
+	return duration
 }
 
 // mapPixelsToGlobalPalette maps RGB pixels to the global palette without dithering
@@ -338,4 +193,3 @@ func findClosestColorInPalette(palette []RGB, r, g, b byte) byte {
 
 	return closest
 }
-
@@ -0,0 +1,97 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"syscall"
+	"testing"
+)
+
+// failingWriter accepts n bytes and then fails
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, syscall.ENOSPC
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestEncodeGIF tests GIF output to a writer, from decoded frames and from a stream
+func TestEncodeGIF(t *testing.T) {
+	anim, err := DecodeAnimated(bytes.NewReader(animatedSolid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var encoded, streamed bytes.Buffer
+	if err := EncodeGIF(&encoded, anim); err != nil {
+		t.Fatalf("EncodeGIF failed: %v", err)
+	}
+	if err := ConvertWebPToGIFStream(bytes.NewReader(animatedSolid()), &streamed, Limits{}); err != nil {
+		t.Fatalf("ConvertWebPToGIFStream failed: %v", err)
+	}
+
+	for name, buf := range map[string]*bytes.Buffer{"EncodeGIF": &encoded, "ConvertWebPToGIFStream": &streamed} {
+		g, err := gif.DecodeAll(buf)
+		if err != nil {
+			t.Fatalf("%s: invalid GIF: %v", name, err)
+		}
+		if len(g.Image) != 2 || g.Config.Width != 8 || g.Config.Height != 8 || g.LoopCount != 0 {
+			t.Fatalf("%s: %d frame(s) on %dx%d, loop %d", name, len(g.Image), g.Config.Width, g.Config.Height, g.LoopCount)
+		}
+		if g.Delay[0] != 10 || g.Delay[1] != 25 {
+			t.Errorf("%s: delays = %v, want [10 25]", name, g.Delay)
+		}
+		if b := g.Image[1].Bounds(); b != image.Rect(4, 2, 8, 6) {
+			t.Errorf("%s: frame 2 bounds = %v", name, b)
+		}
+		r, _, b, _ := g.Image[1].At(5, 3).RGBA()
+		if r>>8 != 0 || b>>8 != 255 {
+			t.Errorf("%s: frame 2 color = %v", name, g.Image[1].At(5, 3))
+		}
+	}
+	// A frame whose data spans many sub-blocks
+	noisy := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for i := range noisy.Pix {
+		noisy.Pix[i] = byte(i * 7)
+	}
+	for i := 3; i < len(noisy.Pix); i += 4 {
+		noisy.Pix[i] = 0xff
+	}
+	var large bytes.Buffer
+	if err := EncodeGIF(&large, &Animation{Width: 64, Height: 48, Frames: []Frame{{Image: noisy, Duration: 50}}}); err != nil {
+		t.Fatalf("EncodeGIF failed: %v", err)
+	}
+	g, err := gif.DecodeAll(&large)
+	if err != nil || len(g.Image) != 1 || g.Image[0].Bounds() != noisy.Bounds() || g.Delay[0] != 5 {
+		t.Errorf("large frame: %v", err)
+	}
+}
+
+// TestEncodeGIFErrors tests that a failing writer fails the encoding with its error
+func TestEncodeGIFErrors(t *testing.T) {
+	anim := &Animation{Width: 64, Height: 64, Frames: []Frame{{Image: image.NewNRGBA(image.Rect(0, 0, 64, 64)), Duration: 100}}}
+	for i := range anim.Frames[0].Image.Pix {
+		anim.Frames[0].Image.Pix[i] = byte(i)
+	}
+
+	err := EncodeGIF(&failingWriter{n: 100}, anim)
+	if !errors.Is(err, ErrEncode) || !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("EncodeGIF = %v, want ErrEncode wrapping ENOSPC", err)
+	}
+	if ErrorCause(err) != "encode" {
+		t.Errorf("ErrorCause = %q, want encode", ErrorCause(err))
+	}
+
+	if err := EncodeGIF(&bytes.Buffer{}, &Animation{Width: 1, Height: 1}); !errors.Is(err, ErrEncode) {
+		t.Errorf("no frames: err = %v, want ErrEncode", err)
+	}
+}
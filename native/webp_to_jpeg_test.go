@@ -0,0 +1,68 @@
+package native
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"syscall"
+	"testing"
+)
+
+// TestEncodeJPEG tests JPEG output to a writer, from an image and from a stream
+func TestEncodeJPEG(t *testing.T) {
+	// Half-transparent green is composited on white
+	src := image.NewRGBA(image.Rect(10, 10, 26, 26))
+	for y := 10; y < 26; y++ {
+		for x := 10; x < 26; x++ {
+			src.Set(x, y, color.NRGBA{G: 255, A: 128})
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := EncodeJPEG(&encoded, src, 90); err != nil {
+		t.Fatalf("EncodeJPEG failed: %v", err)
+	}
+	img, err := jpeg.Decode(&encoded)
+	if err != nil {
+		t.Fatalf("invalid JPEG: %v", err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+		t.Errorf("size = %v, want 16x16", img.Bounds())
+	}
+	r, g, b, _ := img.At(8, 8).RGBA()
+	if r>>8 < 110 || r>>8 > 145 || g>>8 < 240 || b>>8 < 110 || b>>8 > 145 {
+		t.Errorf("composited color = %d,%d,%d, want about 127,255,127", r>>8, g>>8, b>>8)
+	}
+
+	var streamed bytes.Buffer
+	webp := riff(chunk("VP8L", solidVP8L(20, 12, color.NRGBA{R: 255, A: 255})))
+	if err := ConvertWebPToJPEGStream(bytes.NewReader(webp), &streamed, 90, Limits{}); err != nil {
+		t.Fatalf("ConvertWebPToJPEGStream failed: %v", err)
+	}
+	cfg, err := jpeg.DecodeConfig(&streamed)
+	if err != nil || cfg.Width != 20 || cfg.Height != 12 {
+		t.Errorf("DecodeConfig = %+v, %v, want 20x12", cfg, err)
+	}
+}
+
+// TestEncodeJPEGErrors tests invalid arguments and a failing writer
+func TestEncodeJPEGErrors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	if err := EncodeJPEG(&bytes.Buffer{}, img, 0); err == nil {
+		t.Error("quality 0: err = nil")
+	}
+	if err := EncodeJPEG(&bytes.Buffer{}, image.NewNRGBA(image.Rectangle{}), 90); !errors.Is(err, ErrEncode) {
+		t.Errorf("empty image: err = %v, want ErrEncode", err)
+	}
+	err := EncodeJPEG(&failingWriter{n: 10}, img, 90)
+	if !errors.Is(err, ErrEncode) || !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("failing writer: err = %v, want ErrEncode wrapping ENOSPC", err)
+	}
+	webp := riff(chunk("VP8L", solidVP8L(20, 12, color.NRGBA{A: 255})))
+	err = ConvertWebPToJPEGStream(bytes.NewReader(webp), &bytes.Buffer{}, 90, Limits{MaxCanvasPixels: 100})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("canvas limit: err = %v, want ErrLimitExceeded", err)
+	}
+}
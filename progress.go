@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robsonalvesdevbr/webpconvert/converter"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRedrawDelay = 100 * time.Millisecond
+)
+
+// progressBar is an observer drawing a single-line progress bar with ETA on a
+// terminal. The batch summary is delegated to another observer once done.
+type progressBar struct {
+	w        io.Writer
+	summary  converter.Observer
+	start    time.Time
+	lastDraw time.Time
+	total    int
+	scanDone bool
+	done     int
+	failed   int
+}
+
+// newProgressBar returns a progress bar drawn on w; summary receives the
+// batch finished event after the bar is cleared
+func newProgressBar(w io.Writer, summary converter.Observer) *progressBar {
+	return &progressBar{w: w, summary: summary, start: time.Now()}
+}
+
+func (p *progressBar) OnEvent(event converter.Event) {
+	switch event.Type {
+	case converter.EventScanStarted:
+		p.start = event.Time
+	case converter.EventFileDiscovered:
+		p.total = event.Total
+		p.draw(event.Time, false)
+	case converter.EventScanFinished:
+		p.total = event.Total
+		p.scanDone = true
+		p.draw(event.Time, true)
+	case converter.EventFileFinished:
+		p.done++
+		if r := event.Result; r != nil && !r.Success && !r.Skipped {
+			p.failed++
+		}
+		p.draw(event.Time, p.done == p.total && p.scanDone)
+	case converter.EventBatchFinished:
+		// Clear the bar before the summary
+		fmt.Fprint(p.w, "\r\x1b[K")
+		p.summary.OnEvent(event)
+	}
+}
+
+// draw redraws the bar, at most every progressRedrawDelay unless forced
+func (p *progressBar) draw(now time.Time, force bool) {
+	if !force && now.Sub(p.lastDraw) < progressRedrawDelay {
+		return
+	}
+	p.lastDraw = now
+
+	elapsed := now.Sub(p.start)
+	if !p.scanDone && p.done == 0 {
+		fmt.Fprintf(p.w, "\r\x1b[KScanning... %d file(s) found", p.total)
+		return
+	}
+
+	total := p.total
+	if total < p.done {
+		total = p.done
+	}
+	fraction := 0.0
+	if total > 0 {
+		fraction = float64(p.done) / float64(total)
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	if filled > 0 && filled < progressBarWidth {
+		bar = bar[:filled-1] + ">" + bar[filled:]
+	}
+
+	// The total is only an estimate until the scan is done
+	totalText := fmt.Sprintf("%d", total)
+	if !p.scanDone {
+		totalText += "+"
+	}
+
+	eta := "--"
+	if p.done > 0 && p.done < total {
+		remaining := time.Duration(float64(elapsed) / float64(p.done) * float64(total-p.done))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	fmt.Fprintf(p.w, "\r\x1b[K[%s] %d/%s (%3.0f%%) failed: %d elapsed: %s ETA: %s",
+		bar, p.done, totalText, fraction*100, p.failed, elapsed.Round(time.Second), eta)
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,7 @@
+package main
+
+// runWatch implements "webpconvert watch [flags] [dir]": the convert flags,
+// applied to files as they appear
+func runWatch(args []string) int {
+	return convertCommand("watch", args, modeWatch)
+}